@@ -0,0 +1,254 @@
+package main
+
+// abuse.go
+//
+// temporary cooldowns of users who flood requests, or repeatedly cause errors or trigger content filters
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	abuseWindowMinutesDefault   = 10
+	abuseMaxRequestsDefault     = 30
+	abuseMaxErrorsDefault       = 5
+	abuseMaxFlaggedDefault      = 3
+	abuseCooldownMinutesDefault = 30
+
+	msgCoolingDown     = "You are sending too many requests, or too many of them have failed or been flagged. Please try again in %d minute(s)."
+	msgCooldownStarted = "🚫 <b>%s</b> is cooling down for %d minutes: %s in the last %d minutes. (lift it with /unblock %s)"
+	msgCooldownLifted  = "Lifted the cooldown of: <b>%s</b>"
+
+	abuseReasonRequests = "%d requests"
+	abuseReasonErrors   = "%d failed requests"
+	abuseReasonFlagged  = "%d flagged requests or answers"
+)
+
+// abuseCooldownConfig struct for applying cooldowns automatically
+type abuseCooldownConfig struct {
+	WindowMinutes   int `json:"window_minutes,omitempty"`   // events are counted in this sliding window (default: 10)
+	MaxRequests     int `json:"max_requests,omitempty"`     // max number of requests in the window (default: 30)
+	MaxErrors       int `json:"max_errors,omitempty"`       // max number of requests failed because of the users' requests in the window (default: 5)
+	MaxFlagged      int `json:"max_flagged,omitempty"`      // max number of requests blocked by content filters, or answers flagged by moderation, in the window (default: 3)
+	CooldownMinutes int `json:"cooldown_minutes,omitempty"` // duration of cooldowns (default: 30)
+}
+
+// abuseEvent type for the kinds of counted events
+type abuseEvent int
+
+// abuseEvent constants
+const (
+	abuseEventRequest abuseEvent = iota // a request (message) from the user
+	abuseEventError                     // a request failed because of its content (eg. too long)
+	abuseEventFlagged                   // a request blocked by content filters, or an answer flagged by moderation
+)
+
+// abuseRecord struct for recent events and the cooldown of a user
+type abuseRecord struct {
+	username string // (without @, empty if none)
+	events   map[abuseEvent][]time.Time
+
+	until    time.Time // end of the cooldown (zero if not cooling down)
+	notified bool      // whether the user was told about the cooldown
+}
+
+// recent events and cooldowns of users, keyed by user ids
+var _abuseRecords = map[int64]*abuseRecord{}
+var _abuseRecordsLock sync.Mutex
+
+// get the window, thresholds, and the duration of cooldowns from config (or the default ones)
+func abuseLimits(conf abuseCooldownConfig) (window time.Duration, maxima map[abuseEvent]int, cooldown time.Duration) {
+	orDefault := func(value, def int) int {
+		if value > 0 {
+			return value
+		}
+		return def
+	}
+
+	return time.Duration(orDefault(conf.WindowMinutes, abuseWindowMinutesDefault)) * time.Minute,
+		map[abuseEvent]int{
+			abuseEventRequest: orDefault(conf.MaxRequests, abuseMaxRequestsDefault),
+			abuseEventError:   orDefault(conf.MaxErrors, abuseMaxErrorsDefault),
+			abuseEventFlagged: orDefault(conf.MaxFlagged, abuseMaxFlaggedDefault),
+		},
+		time.Duration(orDefault(conf.CooldownMinutes, abuseCooldownMinutesDefault)) * time.Minute
+}
+
+// get the kind of event counted for given error of chat completion (false if not caused by the user)
+func abuseEventOfError(err error) (abuseEvent, bool) {
+	switch classifyError(err) {
+	case errorCategoryContentFiltered:
+		return abuseEventFlagged, true
+	case errorCategoryContextTooLong:
+		return abuseEventError, true
+	}
+
+	// (rate limits, outages, and misconfigurations are not the users' fault)
+	return 0, false
+}
+
+// count an event of given user, and start a cooldown (with a notification to the admin chat) when it exceeds its threshold
+//
+// (does nothing if `abuse_cooldown` is not configured)
+func recordAbuseEvent(bot *tg.Bot, conf config, userID int64, username string, event abuseEvent) {
+	if conf.AbuseCooldown == nil {
+		return
+	}
+
+	window, maxima, cooldown := abuseLimits(*conf.AbuseCooldown)
+	now := time.Now()
+
+	_abuseRecordsLock.Lock()
+	record, exists := _abuseRecords[userID]
+	if !exists {
+		record = &abuseRecord{events: map[abuseEvent][]time.Time{}}
+		_abuseRecords[userID] = record
+	}
+	if username != "" {
+		record.username = username
+	}
+
+	// keep events in the window only
+	var events []time.Time
+	for _, t := range append(record.events[event], now) {
+		if now.Sub(t) < window {
+			events = append(events, t)
+		}
+	}
+	record.events[event] = events
+
+	started := false
+	if len(events) > maxima[event] && now.After(record.until) {
+		record.until = now.Add(cooldown)
+		record.notified = false
+		record.events = map[abuseEvent][]time.Time{}
+		started = true
+	}
+	name := record.username
+	_abuseRecordsLock.Unlock()
+
+	if started {
+		var reason string
+		switch event {
+		case abuseEventRequest:
+			reason = fmt.Sprintf(abuseReasonRequests, len(events))
+		case abuseEventError:
+			reason = fmt.Sprintf(abuseReasonErrors, len(events))
+		case abuseEventFlagged:
+			reason = fmt.Sprintf(abuseReasonFlagged, len(events))
+		}
+		if name == "" {
+			name = strconv.FormatInt(userID, 10)
+		}
+
+		logInfo("cooldown started for %s (%d): %s", name, userID, reason)
+
+		notifyAdmin(bot, conf, fmt.Sprintf(msgCooldownStarted,
+			html.EscapeString(name),
+			int(cooldown.Minutes()),
+			reason,
+			int(window.Minutes()),
+			html.EscapeString(name)))
+	}
+}
+
+// count a request of the sender of given message, and check if the sender is cooling down
+//
+// (the sender is told about the cooldown only once; admins never cool down)
+func isCoolingDown(bot *tg.Bot, conf config, update tg.Update, message tg.Message) bool {
+	if conf.AbuseCooldown == nil || message.From == nil || isAdmin(update, conf) {
+		return false
+	}
+	userID := message.From.ID
+
+	// (requests during cooldowns are not counted)
+	if cooldownRemaining(userID) <= 0 {
+		recordAbuseEvent(bot, conf, userID, usernameOf(message.From), abuseEventRequest)
+	}
+
+	remaining := cooldownRemaining(userID)
+	if remaining <= 0 {
+		return false
+	}
+
+	_abuseRecordsLock.Lock()
+	record := _abuseRecords[userID]
+	notify := !record.notified
+	record.notified = true
+	_abuseRecordsLock.Unlock()
+
+	if notify {
+		send(bot, conf, fmt.Sprintf(msgCoolingDown, int(math.Ceil(remaining.Minutes()))), message.Chat.ID, &message.MessageID)
+	}
+
+	return true
+}
+
+// get the number of requests which given user can still send in the current window, and its max number
+//
+// (`limited` is false if `abuse_cooldown` is not configured; 0 remains while cooling down)
+func remainingRequests(conf config, userID int64, now time.Time) (remaining, maximum int, limited bool) {
+	if conf.AbuseCooldown == nil {
+		return 0, 0, false
+	}
+
+	window, maxima, _ := abuseLimits(*conf.AbuseCooldown)
+	maximum = maxima[abuseEventRequest]
+
+	_abuseRecordsLock.Lock()
+	defer _abuseRecordsLock.Unlock()
+
+	record, exists := _abuseRecords[userID]
+	if !exists {
+		return maximum, maximum, true
+	}
+	if now.Before(record.until) {
+		return 0, maximum, true
+	}
+
+	sent := 0
+	for _, t := range record.events[abuseEventRequest] {
+		if now.Sub(t) < window {
+			sent++
+		}
+	}
+
+	return max(maximum-sent, 0), maximum, true
+}
+
+// get the remaining duration of the cooldown of given user (0 if not cooling down)
+func cooldownRemaining(userID int64) time.Duration {
+	_abuseRecordsLock.Lock()
+	defer _abuseRecordsLock.Unlock()
+
+	if record, exists := _abuseRecords[userID]; exists {
+		return max(time.Until(record.until), 0)
+	}
+
+	return 0
+}
+
+// lift the cooldown of given username (or user id, for users without usernames) (false if not cooling down)
+func liftCooldown(username string) (lifted bool) {
+	_abuseRecordsLock.Lock()
+	defer _abuseRecordsLock.Unlock()
+
+	now := time.Now()
+	for userID, record := range _abuseRecords {
+		if (strings.EqualFold(record.username, username) || strconv.FormatInt(userID, 10) == username) && now.Before(record.until) {
+			record.until = time.Time{}
+			record.events = map[abuseEvent][]time.Time{}
+			lifted = true
+		}
+	}
+
+	return lifted
+}
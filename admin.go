@@ -0,0 +1,326 @@
+package main
+
+// admin.go
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	broadcastInterval = 100 * time.Millisecond // throttle broadcasting (telegram's limit: 30 messages per second)
+
+	broadcastArgSend = "send"
+
+	maintenanceArgOn  = "on"
+	maintenanceArgOff = "off"
+
+	settingKeyMaintenance = "maintenance"
+
+	maintenanceMessageDefault = "This bot is under maintenance. Please try again later."
+
+	msgNotAdmin            = "This command is only for admins."
+	msgBroadcastUsage      = "Usage: /broadcast [send] [message]\n\n(without `send`, it will only show a preview)"
+	msgBroadcastNoChats    = "No chats to broadcast to."
+	msgBroadcastPreview    = "<b>[Preview]</b> will be sent to <b>%d</b> chat(s):\n\n%s\n\n<i>Send again with: /broadcast send [message]</i>"
+	msgBroadcastInProgress = "Broadcasting to <b>%d</b> chat(s)..."
+	msgBroadcastDone       = "Broadcasted: <b>%d</b> sent, <b>%d</b> failed."
+	msgMaintenanceUsage    = "Usage: /maintenance [on|off]\n\n(currently: <b>%s</b>)"
+	msgMaintenanceChanged  = "Maintenance mode is now: <b>%s</b>"
+	msgLogLevelUsage       = "Usage: /loglevel [debug|info|warn]\n\n(currently: <b>%s</b>)"
+	msgLogLevelChanged     = "Log level is now: <b>%s</b>"
+	msgReloaded            = "Reloaded config."
+)
+
+// maintenance mode flag
+var _maintenance atomic.Bool
+
+// load persisted maintenance mode from database
+func loadMaintenanceMode(db Storage) {
+	if db == nil {
+		return
+	}
+
+	if value, err := db.GetSetting(settingKeyMaintenance); err == nil {
+		_maintenance.Store(value == maintenanceArgOn)
+	}
+
+	if _maintenance.Load() {
+		logInfo("maintenance mode is on")
+	}
+}
+
+// set maintenance mode, and persist it in database
+func setMaintenanceMode(db Storage, on bool) {
+	_maintenance.Store(on)
+
+	if db != nil {
+		if err := db.SetSetting(settingKeyMaintenance, onOff(on)); err != nil {
+			log.Printf("failed to save maintenance mode: %s", err)
+		}
+	}
+}
+
+// checks if given update should be blocked due to maintenance mode
+func isUnderMaintenance(update tg.Update, conf config) bool {
+	return _maintenance.Load() && !isAdmin(update, conf)
+}
+
+// checks if a request to the API with given message should be refused (in maintenance mode, or from a user cooling down),
+// and tells the sender why
+//
+// (every handler which calls the API checks this first, so it also counts the request for cooldowns)
+func isRefusingAPIRequest(bot *tg.Bot, conf config, update tg.Update, message tg.Message) bool {
+	if isUnderMaintenance(update, conf) {
+		send(bot, conf, maintenanceMessage(conf), message.Chat.ID, &message.MessageID)
+		return true
+	}
+
+	if isCoolingDown(bot, conf, update, message) {
+		log.Printf("request from user cooling down: %s", userNameFromUpdate(update))
+		return true
+	}
+
+	return false
+}
+
+// get the message for maintenance mode
+func maintenanceMessage(conf config) string {
+	if conf.MaintenanceMessage != "" {
+		return conf.MaintenanceMessage
+	}
+
+	return maintenanceMessageDefault
+}
+
+// convert given bool to on/off string
+func onOff(on bool) string {
+	if on {
+		return maintenanceArgOn
+	}
+
+	return maintenanceArgOff
+}
+
+// return a /broadcast command handler
+func broadcastCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("broadcast command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if !isAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		dryRun := true
+		if first, rest, _ := strings.Cut(args, " "); first == broadcastArgSend {
+			dryRun = false
+			args = strings.TrimSpace(rest)
+		}
+		if args == "" {
+			send(b, conf, msgBroadcastUsage, chatID, &messageID)
+			return
+		}
+
+		chatIDs, err := db.ChatIDs()
+		if err != nil {
+			log.Printf("failed to get chat ids for broadcasting: %s", err)
+
+			send(b, conf, "Failed to get chats for broadcasting. See the server logs for more information.", chatID, &messageID)
+			return
+		}
+		if len(chatIDs) <= 0 {
+			send(b, conf, msgBroadcastNoChats, chatID, &messageID)
+			return
+		}
+
+		if dryRun {
+			send(b, conf, fmt.Sprintf(msgBroadcastPreview, len(chatIDs), html.EscapeString(args)), chatID, &messageID)
+			return
+		}
+
+		send(b, conf, fmt.Sprintf(msgBroadcastInProgress, len(chatIDs)), chatID, &messageID)
+
+		sent, failed := broadcast(b, db, chatIDs, args)
+
+		logInfo("broadcasted by %s: %d sent, %d failed", userNameFromUpdate(update), sent, failed)
+
+		send(b, conf, fmt.Sprintf(msgBroadcastDone, sent, failed), chatID, &messageID)
+	}
+}
+
+// send given text to all chats, with throttling
+//
+// (chats in their quiet hours will receive it without notifications, and chats in digest mode will receive it in their next digests)
+func broadcast(bot *tg.Bot, db Storage, chatIDs []int64, text string) (sent, failed int) {
+	for _, chatID := range chatIDs {
+		if isDigestModeOn(db, chatID) {
+			if err := queueDigest(db, chatID, text); err == nil {
+				sent++
+			} else {
+				log.Printf("failed to queue broadcast for the digest of chat(%d): %s", chatID, err)
+
+				failed++
+			}
+			continue
+		}
+
+		if res := bot.SendMessage(chatID, text, tg.OptionsSendMessage{}.
+			SetDisableNotification(isQuietHours(db, chatID))); res.Ok {
+			sent++
+		} else {
+			log.Printf("failed to broadcast to chat(%d): %s", chatID, *res.Description)
+
+			failed++
+
+			// wait more if rate limited
+			if res.Parameters != nil && res.Parameters.RetryAfter > 0 {
+				time.Sleep(time.Duration(res.Parameters.RetryAfter) * time.Second)
+			}
+		}
+
+		time.Sleep(broadcastInterval)
+	}
+
+	return sent, failed
+}
+
+// return a /maintenance command handler
+func maintenanceCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("maintenance command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if !isAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		switch args {
+		case maintenanceArgOn, maintenanceArgOff:
+			setMaintenanceMode(db, args == maintenanceArgOn)
+
+			logInfo("maintenance mode changed by %s: %s", userNameFromUpdate(update), args)
+
+			msg = fmt.Sprintf(msgMaintenanceChanged, args)
+		default:
+			msg = fmt.Sprintf(msgMaintenanceUsage, onOff(_maintenance.Load()))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
+
+// return a /loglevel command handler
+func logLevelCommandHandler() func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("loglevel command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if !isAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		if level, err := parseLogLevel(args); err == nil {
+			setLogLevel(level)
+
+			log.Printf("log level changed by %s: %s", userNameFromUpdate(update), level)
+
+			msg = fmt.Sprintf(msgLogLevelChanged, level)
+		} else {
+			msg = fmt.Sprintf(msgLogLevelUsage, currentLogLevel())
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
+
+// return a /reload command handler
+func reloadCommandHandler() func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, _ string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("reload command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if !isAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		if err := reloadConfig(); err == nil {
+			msg = msgReloaded
+		} else {
+			log.Printf("failed to reload config: %s", err)
+
+			msg = "Failed to reload config. See the server logs for more information."
+		}
+
+		send(b, currentConfig(), msg, chatID, &messageID)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+// alt.go
+//
+// generating alt texts of photos for accessibility
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdAlt = "/alt"
+
+	altTextVisionModel = "gpt-4o-mini"
+
+	systemPromptAltText = `Write alt text for the given image, for people using screen readers.
+
+Describe what matters in the image in one or two concise sentences (under 250 characters), without starting with "image of" or "picture of". Transcribe short texts in the image if they are important. Answer with the alt text only.`
+
+	msgAltUsage  = "Reply to a photo with /alt [notes] for generating its alt text."
+	msgAltFailed = "Failed to generate alt text. See the server logs for more information."
+)
+
+// generate alt text of given photo with a vision model
+//
+// (`notes` are hints about the context of the photo, eg. "for a blog post about hiking")
+func generateAltText(bot *tg.Bot, client *openai.Client, conf config, db Storage, chatID int64, photo tg.PhotoSize, notes string) (altText string, err error) {
+	res := bot.GetFile(photo.FileID)
+	if !res.Ok {
+		return "", fmt.Errorf("failed to get photo: %s", *res.Description)
+	}
+
+	var data []byte
+	if data, err = readBinaryContentAtURL(bot.GetFileURL(*res.Result), maxPhotoBytes); err != nil {
+		return "", err
+	}
+
+	contents := []openai.ChatMessageContent{
+		openai.NewChatMessageContentWithBytes(data),
+	}
+	if notes != "" {
+		contents = append(contents, openai.NewChatMessageContentWithText(notes))
+	}
+
+	var response openai.ChatCompletion
+	if response, err = archivedChatCompletion(client, altTextVisionModel, withResponseLanguage(conf, db, chatID, []openai.ChatMessage{
+		openai.NewChatSystemMessage(systemPromptAltText),
+		openai.NewChatUserMessage(contents),
+	}), openai.ChatCompletionOptions{}); err != nil {
+		return "", err
+	}
+	if len(response.Choices) <= 0 {
+		return "", fmt.Errorf("no alt text in response")
+	}
+
+	if altText, err = response.Choices[0].Message.ContentString(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(altText), nil
+}
+
+// return an /alt command handler
+func altCommandHandler(client *openai.Client, db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("alt command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		if isRefusingAPIRequest(b, conf, update, *message) {
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		replyTo := repliedToMessage(*message)
+		if replyTo == nil || !replyTo.HasPhoto() {
+			send(b, conf, msgAltUsage, chatID, &messageID)
+			return
+		}
+
+		// (the largest one)
+		photo := replyTo.Photo[len(replyTo.Photo)-1]
+
+		stopTyping := keepChatAction(b, chatID, responseText)
+		altText, err := generateAltText(b, client, conf, db, chatID, photo, strings.TrimSpace(args))
+		stopTyping()
+		if err != nil {
+			log.Printf("failed to generate alt text: %s", err)
+
+			send(b, conf, msgAltFailed, chatID, &messageID)
+			return
+		}
+
+		// (in a code tag, for copying it with a tap)
+		send(b, conf, fmt.Sprintf("<code>%s</code>", html.EscapeString(altText)), chatID, &replyTo.MessageID)
+	}
+}
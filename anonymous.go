@@ -0,0 +1,143 @@
+package main
+
+// anonymous.go
+//
+// anonymizing users in stored rows, while keeping aggregates intact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+)
+
+const (
+	settingKeyAnonymousSalt = "anonymous_salt"
+
+	anonymousSaltBytes   = 32
+	anonymousUsernameFmt = "anon-%s"
+	anonymousUsernameLen = 8 // length of the hex hash in anonymized usernames
+)
+
+// anonymizedStorage struct which replaces user ids and usernames in stored rows with their salted hashes
+// (when `anonymous_logs` is set)
+type anonymizedStorage struct {
+	Storage
+
+	saltOnce sync.Once
+	salt     []byte
+}
+
+// wrap given storage for anonymizing users
+func newAnonymizedStorage(storage Storage) Storage {
+	return &anonymizedStorage{Storage: storage}
+}
+
+// get the per-install salt, generating and persisting it on the first use
+func (s *anonymizedStorage) installSalt() []byte {
+	s.saltOnce.Do(func() {
+		if value, err := s.Storage.GetSetting(settingKeyAnonymousSalt); err == nil && value != "" {
+			if salt, err := hex.DecodeString(value); err == nil {
+				s.salt = salt
+				return
+			}
+		}
+
+		salt := make([]byte, anonymousSaltBytes)
+		if _, err := rand.Read(salt); err != nil {
+			log.Printf("failed to generate salt for anonymous logs: %s", err)
+			return
+		}
+		if err := s.Storage.SetSetting(settingKeyAnonymousSalt, hex.EncodeToString(salt)); err != nil {
+			log.Printf("failed to save salt for anonymous logs: %s", err)
+			return
+		}
+		s.salt = salt
+	})
+
+	return s.salt
+}
+
+// checks if users should be anonymized now (`anonymous_logs` can be changed on reload)
+func (s *anonymizedStorage) anonymous() bool {
+	return currentConfig().AnonymousLogs
+}
+
+// hash given user id with the salt (keeps it a positive integer, so it can be stored in the same column)
+//
+// (returns 0 if the salt is not available, so users are never stored as they are)
+func (s *anonymizedStorage) userID(userID int64) int64 {
+	salt := s.installSalt()
+	if salt == nil {
+		return 0
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	_ = binary.Write(mac, binary.BigEndian, userID)
+	return int64(binary.BigEndian.Uint64(mac.Sum(nil)) & math.MaxInt64)
+}
+
+// hash given username with the salt
+func (s *anonymizedStorage) username(username string) string {
+	salt := s.installSalt()
+	if salt == nil || username == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	_, _ = mac.Write([]byte(username))
+	return fmt.Sprintf(anonymousUsernameFmt, hex.EncodeToString(mac.Sum(nil))[:anonymousUsernameLen])
+}
+
+// SavePrompt saves `prompt` with its user anonymized.
+func (s *anonymizedStorage) SavePrompt(prompt Prompt) (err error) {
+	if s.anonymous() {
+		prompt.UserID, prompt.Username = s.userID(prompt.UserID), s.username(prompt.Username)
+	}
+	return s.Storage.SavePrompt(prompt)
+}
+
+// SaveAnswerVersion saves `prompt` as a new version with its user anonymized.
+func (s *anonymizedStorage) SaveAnswerVersion(prompt Prompt) (err error) {
+	if s.anonymous() {
+		prompt.UserID, prompt.Username = s.userID(prompt.UserID), s.username(prompt.Username)
+	}
+	return s.Storage.SaveAnswerVersion(prompt)
+}
+
+// SaveFeedback saves `feedback` with its user anonymized.
+func (s *anonymizedStorage) SaveFeedback(feedback Feedback) (err error) {
+	if s.anonymous() {
+		feedback.UserID, feedback.Username = s.userID(feedback.UserID), s.username(feedback.Username)
+	}
+	return s.Storage.SaveFeedback(feedback)
+}
+
+// DeleteFeedback deletes a feedback of an (anonymized) user.
+func (s *anonymizedStorage) DeleteFeedback(chatID, messageID, userID int64) (err error) {
+	if s.anonymous() {
+		userID = s.userID(userID)
+	}
+	return s.Storage.DeleteFeedback(chatID, messageID, userID)
+}
+
+// SaveReferral saves `referral` with its user anonymized.
+func (s *anonymizedStorage) SaveReferral(referral Referral) (err error) {
+	if s.anonymous() {
+		referral.UserID, referral.Username = s.userID(referral.UserID), s.username(referral.Username)
+	}
+	return s.Storage.SaveReferral(referral)
+}
+
+// SaveArchivedImage saves `image` with its user anonymized.
+func (s *anonymizedStorage) SaveArchivedImage(image ArchivedImage) (err error) {
+	if s.anonymous() {
+		image.UserID = s.userID(image.UserID)
+	}
+	return s.Storage.SaveArchivedImage(image)
+}
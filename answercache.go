@@ -0,0 +1,95 @@
+package main
+
+// answercache.go
+//
+// caching answers of identical requests (same model, options, and conversation)
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+)
+
+const (
+	answerCacheMax = 1000 // max number of cached answers
+
+	msgCachedAnswer = "\n\n(cached answer from %s)"
+)
+
+// cachedAnswer struct for a cached chat completion
+type cachedAnswer struct {
+	response openai.ChatCompletion
+	cachedAt time.Time
+}
+
+// cached answers, keyed by the hashes of their requests
+var _answerCache = map[string]cachedAnswer{}
+var _answerCacheLock sync.Mutex
+
+// request a chat completion, or reuse a cached one of the identical request
+//
+// (`cachedAt` is non-nil when a cached one was served, `!fresh` bypasses the cache)
+func cachedChatCompletion(client *openai.Client, conf config, model string, directives messageDirectives, messages []openai.ChatMessage, userID int64) (response openai.ChatCompletion, cachedAt *time.Time, err error) {
+	ttl := time.Duration(conf.AnswerCacheMinutes) * time.Minute
+	if ttl <= 0 {
+		response, err = createChatCompletion(client, model, directives, messages, userID)
+		return response, nil, err
+	}
+
+	key, err := answerCacheKey(model, directives, messages)
+	if err != nil {
+		response, err = createChatCompletion(client, model, directives, messages, userID)
+		return response, nil, err
+	}
+
+	_answerCacheLock.Lock()
+	now := time.Now()
+	for k, cached := range _answerCache {
+		if now.Sub(cached.cachedAt) > ttl {
+			delete(_answerCache, k)
+		}
+	}
+	cached, exists := _answerCache[key]
+	_answerCacheLock.Unlock()
+
+	if exists && !directives.Fresh {
+		logInfo("serving cached answer from %s", cached.cachedAt.Format(time.RFC3339))
+
+		response = cached.response
+		response.Usage = openai.Usage{} // (no tokens were used for this one)
+		return response, &cached.cachedAt, nil
+	}
+
+	if response, err = createChatCompletion(client, model, directives, messages, userID); err == nil && len(response.Choices) > 0 {
+		_answerCacheLock.Lock()
+		if len(_answerCache) < answerCacheMax {
+			_answerCache[key] = cachedAnswer{response: response, cachedAt: time.Now()}
+		}
+		_answerCacheLock.Unlock()
+	}
+
+	return response, nil, err
+}
+
+// generate a cache key of given request
+func answerCacheKey(model string, directives messageDirectives, messages []openai.ChatMessage) (string, error) {
+	bytes, err := json.Marshal(struct {
+		Model       string               `json:"model"`
+		Temperature *float64             `json:"temperature,omitempty"`
+		Messages    []openai.ChatMessage `json:"messages"`
+	}{
+		Model:       model,
+		Temperature: directives.Temperature,
+		Messages:    messages,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(bytes)
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+// archive.go
+//
+// archiving generated images and received photos, in a directory or an S3 bucket
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	imageSourceReceived  = "received"
+	imageSourceGenerated = "generated"
+
+	maxPhotoBytes = 20 * 1024 * 1024 // 20MB (telegram bot api's limit for downloading files)
+)
+
+// file extensions of images, by content types
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// imageArchiveConfig struct for archiving images
+type imageArchiveConfig struct {
+	Directory string    `json:"directory,omitempty"` // local directory for saving images
+	S3        *s3Config `json:"s3,omitempty"`        // S3 bucket for uploading images (takes precedence over `directory`)
+}
+
+// archive given image, and save its reference in database
+//
+// (does nothing if `image_archive` is not configured)
+func archiveImage(conf config, db Storage, source string, chatID, userID, messageID int64, data []byte) {
+	if conf.ImageArchive == nil {
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, exists := imageExtensions[contentType]
+	if !exists {
+		ext = ".bin"
+	}
+
+	// eg. "received/-1001234567890/20240102T150405-123.jpg"
+	key := fmt.Sprintf("%s/%d/%s-%d%s", source, chatID, time.Now().UTC().Format("20060102T150405"), messageID, ext)
+
+	var location string
+	var err error
+	if conf.ImageArchive.S3 != nil {
+		location, err = putS3Object(*conf.ImageArchive.S3, key, data, contentType)
+	} else if conf.ImageArchive.Directory != "" {
+		location, err = saveImageFile(conf.ImageArchive.Directory, key, data)
+	} else {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to archive %s image from chat %d: %s", source, chatID, err)
+		return
+	}
+
+	logInfo("archived %s image from chat %d: %s", source, chatID, location)
+
+	if db != nil {
+		if err := db.SaveArchivedImage(ArchivedImage{
+			ChatID:      chatID,
+			UserID:      userID,
+			MessageID:   messageID,
+			Source:      source,
+			Location:    location,
+			ContentType: contentType,
+			Size:        int64(len(data)),
+		}); err != nil {
+			log.Printf("failed to save archived image: %s", err)
+		}
+	}
+}
+
+// archive the largest one of photos in given message
+func archiveReceivedPhoto(bot *tg.Bot, conf config, db Storage, message tg.Message) {
+	if conf.ImageArchive == nil || !message.HasPhoto() {
+		return
+	}
+
+	photo := message.Photo[len(message.Photo)-1]
+
+	res := bot.GetFile(photo.FileID)
+	if !res.Ok {
+		log.Printf("failed to get photo for archiving: %s", *res.Description)
+		return
+	}
+
+	data, err := readBinaryContentAtURL(bot.GetFileURL(*res.Result), maxPhotoBytes)
+	if err != nil {
+		log.Printf("failed to download photo for archiving: %s", err)
+		return
+	}
+
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+
+	archiveImage(conf, db, imageSourceReceived, message.Chat.ID, userID, message.MessageID, data)
+}
+
+// save given data as a file at `key` under the directory
+func saveImageFile(dir, key string, data []byte) (path string, err error) {
+	path = filepath.Join(dir, filepath.FromSlash(key))
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
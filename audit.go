@@ -0,0 +1,68 @@
+package main
+
+// audit.go
+//
+// mirroring prompts and answers to an audit chat
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	auditTextMaxLen = 1800 // max length of each prompt and answer in an audit message (telegram's limit: 4096)
+
+	msgAudit = `<b>chat</b>: %d, <b>user</b>: %s, <b>model</b>: %s%s
+
+🙋 %s
+
+🤖 %s`
+	msgAuditFailed = " <i>(failed)</i>"
+
+	redacted = "[REDACTED]"
+)
+
+// patterns of sensitive information to be redacted
+var redactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[\w.+\-]+@[\w\-]+\.[\w.\-]+`),                                // email addresses
+	regexp.MustCompile(`\b(?:sk|pk|rk)-[A-Za-z0-9_\-]{16,}\b`),                       // api keys
+	regexp.MustCompile(`\b(?:\d[ \-]?){13,19}\b`),                                    // card numbers
+	regexp.MustCompile(`\+?\d{1,3}[ \-.]?\(?\d{2,4}\)?[ \-.]?\d{3,4}[ \-.]?\d{4}\b`), // phone numbers
+}
+
+// mirror given prompt (and its result) to the audit chat (if configured)
+func mirrorToAuditChat(bot *tg.Bot, conf config, prompt Prompt) {
+	if conf.AuditChatID == 0 {
+		return
+	}
+
+	promptText, answerText := prompt.Text, prompt.Result.Text
+	if conf.AuditRedacted {
+		promptText, answerText = redact(promptText), redact(answerText)
+	}
+
+	var failed string
+	if !prompt.Result.Successful {
+		failed = msgAuditFailed
+	}
+
+	send(bot, conf, fmt.Sprintf(msgAudit,
+		prompt.ChatID,
+		html.EscapeString(prompt.Username),
+		html.EscapeString(prompt.Result.ChatModel),
+		failed,
+		html.EscapeString(ellipsize(promptText, auditTextMaxLen)),
+		html.EscapeString(ellipsize(answerText, auditTextMaxLen)),
+	), conf.AuditChatID, nil)
+}
+
+// redact sensitive information in given text
+func redact(text string) string {
+	for _, pattern := range redactPatterns {
+		text = pattern.ReplaceAllString(text, redacted)
+	}
+	return text
+}
@@ -0,0 +1,186 @@
+package main
+
+// batch.go
+//
+// processing prompts in a file offline, in the format of OpenAI Batch API
+//
+// https://platform.openai.com/docs/guides/batch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+)
+
+const (
+	batchUsername = "batch" // username of logged prompts
+
+	batchMaxLineBytes = 10 * 1024 * 1024 // 10MB
+)
+
+// batchRequest struct for a line of the input file
+//
+// eg. {"custom_id": "request-1", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "gpt-4o", "messages": [{"role": "user", "content": "Hello"}]}}
+type batchRequest struct {
+	CustomID string `json:"custom_id"`
+	Method   string `json:"method,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Body     struct {
+		Model    string `json:"model,omitempty"` // model or alias (default: `openai_model`)
+		Messages []struct {
+			Role    openai.ChatMessageRole `json:"role"`
+			Content string                 `json:"content"`
+		} `json:"messages"`
+		Temperature *float64 `json:"temperature,omitempty"`
+	} `json:"body"`
+}
+
+// batchResponse struct for a line of the output file
+type batchResponse struct {
+	ID       string             `json:"id"`
+	CustomID string             `json:"custom_id"`
+	Response *batchResponseBody `json:"response"`
+	Error    *batchError        `json:"error"`
+}
+
+// batchResponseBody struct for successful responses of batch requests
+type batchResponseBody struct {
+	StatusCode int                   `json:"status_code"`
+	Body       openai.ChatCompletion `json:"body"`
+}
+
+// batchError struct for errors of batch requests
+type batchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// process requests in the input file, and write their results to the output file
+//
+// (prompts and answers will be logged in the database too, if `db_filepath` is set)
+func runBatch(conf config, inputPath, outputPath string) (succeeded, failed int, err error) {
+	var input, output *os.File
+	if input, err = os.Open(inputPath); err != nil {
+		return 0, 0, err
+	}
+	defer input.Close()
+	if output, err = os.Create(outputPath); err != nil {
+		return 0, 0, err
+	}
+	defer output.Close()
+
+	if err = setupTLSConfig(conf); err != nil {
+		return 0, 0, err
+	}
+	if err = setupRawArchive(conf); err != nil {
+		return 0, 0, err
+	}
+	client := openai.NewClient(conf.OpenAIAPIKey, conf.OpenAIOrganizationID)
+	client.Verbose = conf.Verbose
+	setLogLevelFromConfig(conf)
+
+	var db Storage = nil
+	if conf.RequestLogsDBFilepath != "" {
+		if db, err = OpenStorage(conf.DBDriver, conf.RequestLogsDBFilepath); err != nil {
+			return 0, 0, fmt.Errorf("failed to open database: %w", err)
+		}
+	}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), batchMaxLineBytes)
+	encoder := json.NewEncoder(output)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		result := batchResponse{ID: fmt.Sprintf("batch_req_%d", n)}
+
+		var req batchRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			result.Error = &batchError{Code: "invalid_request", Message: fmt.Sprintf("line %d: %s", n, err)}
+		} else {
+			result.CustomID = req.CustomID
+
+			if completion, err := processBatchRequest(client, conf, db, req); err == nil {
+				result.Response = &batchResponseBody{StatusCode: http.StatusOK, Body: completion}
+			} else {
+				result.Error = &batchError{Code: "completion_failed", Message: err.Error()}
+			}
+		}
+
+		if result.Error == nil {
+			succeeded++
+		} else {
+			log.Printf("batch request %s (line %d) failed: %s", result.CustomID, n, result.Error.Message)
+			failed++
+		}
+
+		if err = encoder.Encode(result); err != nil {
+			return succeeded, failed, err
+		}
+	}
+
+	return succeeded, failed, scanner.Err()
+}
+
+// process a batch request through the completion and logging pipeline
+func processBatchRequest(client *openai.Client, conf config, db Storage, req batchRequest) (completion openai.ChatCompletion, err error) {
+	if len(req.Body.Messages) == 0 {
+		return completion, fmt.Errorf("no messages in request")
+	}
+
+	model := chatCompletionModel(conf)
+	if req.Body.Model != "" {
+		model = resolveModelAlias(conf, req.Body.Model)
+	}
+
+	messages := []openai.ChatMessage{}
+	for _, message := range req.Body.Messages {
+		messages = append(messages, openai.ChatMessage{Role: message.Role, Content: message.Content})
+	}
+	messages = withResponseLanguage(conf, nil, 0, messages)
+
+	prompt := Prompt{
+		Username: fmt.Sprintf("%s:%s", batchUsername, req.CustomID),
+		Text:     messagesToPrompt(messages),
+	}
+	if count, err := countRequestTokens(model, messages); err == nil {
+		prompt.RequestTokens = uint(count)
+	}
+
+	if completion, err = createChatCompletion(client, model, messageDirectives{Temperature: req.Body.Temperature}, messages, 0); err != nil {
+		savePromptAndResult(db, false, &prompt, prompt.RequestTokens, Generated{
+			ChatModel:  model,
+			Successful: false,
+			Text:       err.Error(),
+		})
+
+		return completion, err
+	}
+
+	var answer, finishReason string
+	if len(completion.Choices) > 0 {
+		finishReason = completion.Choices[0].FinishReason
+		answer, _ = completion.Choices[0].Message.ContentString()
+	}
+
+	savePromptAndResult(db, false, &prompt, uint(completion.Usage.PromptTokens), Generated{
+		ChatModel:    model,
+		Successful:   true,
+		Text:         answer,
+		Tokens:       uint(completion.Usage.CompletionTokens),
+		CompletionID: completion.ID,
+		FinishReason: finishReason,
+	})
+
+	return completion, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+// blocked.go
+//
+// blocked users, excluded even when they are in allowed users or groups
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdBlock   = "/block"
+	cmdUnblock = "/unblock"
+
+	settingKeyBlockedUsers = "blocked_users" // comma-separated usernames
+
+	msgBlockUsage   = "Usage: /block [username]\n\n(currently blocked: %s)"
+	msgUnblockUsage = "Usage: /unblock [username]"
+	msgBlocked      = "Blocked: <b>%s</b>"
+	msgUnblocked    = "Unblocked: <b>%s</b>"
+	msgNotBlocked   = "Not blocked: <b>%s</b>"
+)
+
+// usernames blocked with /block command (persisted in database)
+var _blockedUsers = map[string]bool{}
+var _blockedUsersLock sync.RWMutex
+
+// load persisted blocked users from database
+func loadBlockedUsers(db Storage) {
+	if db == nil {
+		return
+	}
+
+	if value, err := db.GetSetting(settingKeyBlockedUsers); err == nil && value != "" {
+		_blockedUsersLock.Lock()
+		for _, username := range strings.Split(value, ",") {
+			_blockedUsers[username] = true
+		}
+		_blockedUsersLock.Unlock()
+	}
+}
+
+// block or unblock a user, and persist blocked users in database
+func setBlocked(db Storage, username string, blocked bool) {
+	_blockedUsersLock.Lock()
+	if blocked {
+		_blockedUsers[username] = true
+	} else {
+		delete(_blockedUsers, username)
+	}
+	value := strings.Join(blockedUsernames(), ",")
+	_blockedUsersLock.Unlock()
+
+	if db != nil {
+		if err := db.SetSetting(settingKeyBlockedUsers, value); err != nil {
+			log.Printf("failed to save blocked users: %s", err)
+		}
+	}
+}
+
+// get sorted usernames blocked with /block command
+//
+// (should be called with the lock held)
+func blockedUsernames() (usernames []string) {
+	for username := range _blockedUsers {
+		usernames = append(usernames, username)
+	}
+	slices.Sort(usernames)
+	return usernames
+}
+
+// checks if given update is from a blocked user (in config or database)
+func isBlocked(update tg.Update, conf config) bool {
+	from := fromUser(update)
+	if from == nil || from.Username == nil {
+		return false
+	}
+	username := *from.Username
+
+	if slices.Contains(conf.BlockedTelegramUsers, username) {
+		return true
+	}
+
+	_blockedUsersLock.RLock()
+	defer _blockedUsersLock.RUnlock()
+
+	return _blockedUsers[username]
+}
+
+// return a /block or /unblock command handler
+func blockCommandHandler(db Storage, block bool) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("block command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if !isAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		username := strings.TrimPrefix(strings.TrimSpace(args), "@")
+
+		var msg string
+		if username == "" {
+			if block {
+				_blockedUsersLock.RLock()
+				usernames := append(blockedUsernames(), conf.BlockedTelegramUsers...)
+				_blockedUsersLock.RUnlock()
+
+				blocked := "<i>(none)</i>"
+				if len(usernames) > 0 {
+					blocked = html.EscapeString(strings.Join(usernames, ", "))
+				}
+				msg = fmt.Sprintf(msgBlockUsage, blocked)
+			} else {
+				msg = msgUnblockUsage
+			}
+		} else if block {
+			setBlocked(db, username, true)
+
+			logInfo("user blocked by %s: %s", userNameFromUpdate(update), username)
+
+			msg = fmt.Sprintf(msgBlocked, html.EscapeString(username))
+		} else {
+			_blockedUsersLock.RLock()
+			blocked := _blockedUsers[username]
+			_blockedUsersLock.RUnlock()
+
+			if blocked {
+				setBlocked(db, username, false)
+
+				logInfo("user unblocked by %s: %s", userNameFromUpdate(update), username)
+
+				msg = fmt.Sprintf(msgUnblocked, html.EscapeString(username))
+			} else if liftCooldown(username) {
+				logInfo("cooldown lifted by %s: %s", userNameFromUpdate(update), username)
+
+				msg = fmt.Sprintf(msgCooldownLifted, html.EscapeString(username))
+			} else {
+				msg = fmt.Sprintf(msgNotBlocked, html.EscapeString(username))
+			}
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
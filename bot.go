@@ -5,14 +5,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io"
+	"html"
 	"log"
-	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/meinside/geektoken"
 	"github.com/meinside/infisical-go"
 	"github.com/meinside/infisical-go/helper"
 	"github.com/meinside/openai-go"
@@ -24,26 +24,103 @@ import (
 
 const (
 	chatCompletionModelDefault = "gpt-3.5-turbo"
+
+	systemPromptSummarize = "Summarize the following post concisely, in the language of the post."
+
+	reactionThumbsUp   = "👍"
+	reactionThumbsDown = "👎"
+
+	// NOTE: only some emojis are available for reactions (✅ and ❌ are not)
+	reactionProcessing = "👀"
+	reactionDone       = "👌"
+	reactionFailed     = "😢"
+)
+
+// channelBehavior type for handling channel posts
+type channelBehavior string
+
+// channelBehavior constants
+const (
+	channelBehaviorAnswer    channelBehavior = "answer"
+	channelBehaviorSummarize channelBehavior = "summarize"
 )
 
 const (
 	intervalSeconds = 1
 
-	cmdStart = "/start"
-	cmdCount = "/count"
-	cmdStats = "/stats"
-	cmdHelp  = "/help"
+	historyCountDefault = 5
+	historyCountMax     = 20
+	historyMaxLen       = 3600 // total length of texts in a history message (telegram's limit: 4096)
+
+	cmdStart   = "/start"
+	cmdCount   = "/count"
+	cmdStats   = "/stats"
+	cmdModels  = "/models"
+	cmdHistory = "/history"
+	cmdPrompt  = "/prompt"
+	cmdWhoAmI  = "/whoami"
+	cmdExport  = "/export-chat"
+
+	// admin commands
+	cmdBroadcast   = "/broadcast"
+	cmdMaintenance = "/maintenance"
+	cmdLogLevel    = "/loglevel"
+	cmdReload      = "/reload"
+	cmdHelp        = "/help"
 
 	msgStart                 = "This bot will answer your messages with ChatGPT API :-)"
 	msgCmdNotSupported       = "Not a supported bot command: %s"
 	msgTypeNotSupported      = "Not a supported message type."
 	msgDatabaseNotConfigured = "Database not configured. Set `db_filepath` in your config file."
 	msgDatabaseEmpty         = "Database is empty."
-	msgTokenCount            = "<b>%d</b> tokens in <b>%d</b> chars <i>(cl100k_base)</i>"
+	msgTokenCount            = "<b>%d</b> tokens in <b>%d</b> chars <i>(%s)</i>"
+	msgCountUsage            = "Usage: /count [some_text] (or reply to a message or a document with /count)"
+	msgCountEmpty            = "Failed to read the replied message."
+	msgNoChatModels          = "No available chat models."
+	msgHistoryEmpty          = "No history for this chat."
+	msgPollingRestarted      = "Polling updates got stuck, so it was restarted with a new client."
+	msgNoUsableMessages      = "Failed to get usable chat messages from your input. See the server logs for more information."
 	msgHelp                  = `Help message here:
 
-/count [some_text] : count the number of tokens in a given text.
+/count [some_text] : count the number of tokens in a given text (or the replied message or document).
+/explainerror [notes] : diagnose the replied stack trace or log snippet.
+/summarize-from : summarize the conversations in this chat since the replied message.
+/alt [notes] : generate alt text of the replied photo.
+/image [prompt] : generate an image with the prompt.
+/save [name] : save the replied message as your prompt.
+/use [name] [input] : run your saved prompt (with optional input).
+/saved : list your saved prompts.
 /stats : show stats of this bot.
+/models : list available chat models.
+/model [model|alias|reset] : change the model of this chat.
+/history [n] : show the last n prompts and answers in this chat.
+/new : start a new conversation in this chat.
+/prompt : show the context which will be attached to your next message.
+/whoami : show your telegram account, settings, and remaining quotas.
+/voice [on|off] : turn voice mode (answers with voices too) on/off.
+/dictate [on|off] : turn dictation mode (voices transcribed, not answered) on/off.
+/private [on|off] : turn private mode (prompts and answers not saved) on/off.
+/language [language|reset] : force the language of answers in this chat.
+/system [prompt|reset] : set the system prompt of this chat.
+/glossary [add term: definition|remove term|clear] : manage the glossary of this chat.
+/depth [turns [max_tokens]|reset] : set the depth of history attached to requests in this chat.
+/export-chat [notion] : export the current conversation of this chat.
+/share : share the current conversation of this chat as a read-only page.
+/pin : pin the replied answer in this chat.
+/quiet [HH:MM-HH:MM [timezone]|off] : set quiet hours of this chat.
+/timezone [timezone|reset] : set your timezone for schedules and dates.
+/digest [on|off] : turn on/off digest mode (non-urgent messages delivered once a day) of this chat.
+
+(for admins)
+/broadcast [send] [message] : send a message to all chats.
+/maintenance [on|off] : turn maintenance mode on/off.
+/block [username] : block a user (or list blocked users).
+/unblock [username] : unblock a user (or lift the cooldown of a user).
+/loglevel [debug|info|warn] : change the log level.
+/reload : reload the config file.
+/trace [chat_id] [on|off] : send verbose traces of requests in a chat to the admin chat.
+/query [select statement] : run a read-only SQL query on the logs database.
+/finetune [liked] [upload] : export logged prompts and answers as fine-tuning data, and upload it to OpenAI.
 /help : show this help message.
 
 <i>version: %s</i>
@@ -53,10 +130,116 @@ const (
 // config struct for loading a configuration file
 type config struct {
 	// configurations
-	AllowedTelegramUsers  []string `json:"allowed_telegram_users"`
-	OpenAIModel           string   `json:"openai_model,omitempty"`
-	RequestLogsDBFilepath string   `json:"db_filepath,omitempty"`
-	Verbose               bool     `json:"verbose,omitempty"`
+	AllowedTelegramUsers      []string           `json:"allowed_telegram_users"`
+	AdminTelegramUsers        []string           `json:"admin_telegram_users,omitempty"`
+	GroupAdminsAsBotAdmins    bool               `json:"group_admins_as_bot_admins,omitempty"` // treat administrators of groups as admins within the groups
+	BlockedTelegramUsers      []string           `json:"blocked_telegram_users,omitempty"`     // excluded even when allowed
+	AdminChatID               int64              `json:"admin_chat_id,omitempty"`              // for notifications to admins
+	AuditChatID               int64              `json:"audit_chat_id,omitempty"`              // for mirroring all prompts and answers
+	AuditRedacted             bool               `json:"audit_redacted,omitempty"`
+	CompletionWebhookURL      string             `json:"completion_webhook_url,omitempty"` // for posting every answer as json
+	OpenAIModel               string             `json:"openai_model,omitempty"`
+	ModelAliases              map[string]string  `json:"model_aliases,omitempty"`     // eg. {"smart": "gpt-4o", "fast": "gpt-4o-mini"}
+	SelectableModels          []string           `json:"selectable_models,omitempty"` // models (or aliases) selectable with /model (all available ones if not set)
+	ResponseLanguage          string             `json:"response_language,omitempty"` // eg. "Korean", can be overridden per chat with /language
+	RequestLogsDBFilepath     string             `json:"db_filepath,omitempty"`
+	DBDriver                  storageDriver      `json:"db_driver,omitempty"`                   // "gorm" (default) or "sql"
+	ReadReplicaDBFilepath     string             `json:"read_replica_db_filepath,omitempty"`    // read-only replica (path or `file:` uri) for /stats, /query, and token budgets
+	ContextCacheSize          int                `json:"context_cache_size,omitempty"`          // number of chats to cache in memory (default: 100, negative for no cache)
+	MaxDocumentBytes          int64              `json:"max_document_bytes,omitempty"`          // max size of documents to read (default: 1MB)
+	AllowedDocumentTypes      []string           `json:"allowed_document_types,omitempty"`      // accepted content types of documents (eg. "text/*", "application/json")
+	AllowedDocumentExtensions []string           `json:"allowed_document_extensions,omitempty"` // accepted extensions of documents (eg. ".md", ".go")
+	DocumentChunkRunes        int                `json:"document_chunk_runes,omitempty"`        // size of chunks for condensing large documents (default: 8000)
+	DocumentChunkWorkers      int                `json:"document_chunk_workers,omitempty"`      // number of concurrent workers for condensing large documents (default: 4)
+	YouTubeTranscripts        bool               `json:"youtube_transcripts,omitempty"`         // fetch transcripts of linked youtube videos
+	DescribeStickers          bool               `json:"describe_stickers,omitempty"`           // describe images of stickers with a vision model
+	ScreenshotPreprocess      bool               `json:"screenshot_preprocess,omitempty"`       // crop and upscale texts of screenshots in photos for vision models
+	SpoilerSensitiveAnswers   bool               `json:"spoiler_sensitive_answers,omitempty"`   // hide answers flagged by the moderation api in spoilers
+	ConfirmTokensThreshold    int                `json:"confirm_tokens_threshold,omitempty"`    // ask for confirmation when a request exceeds this number of tokens (0 for never)
+	AnswerWorkers             int                `json:"answer_workers,omitempty"`              // max number of answers generated concurrently (0 for no limit)
+	AnswerQueueSize           int                `json:"answer_queue_size,omitempty"`           // max number of messages waiting for a worker (default: 100)
+	AnswerCacheMinutes        int                `json:"answer_cache_minutes,omitempty"`        // reuse answers of identical requests for this many minutes (0 for no cache)
+	DuplicateWindowMinutes    int                `json:"duplicate_window_minutes,omitempty"`    // offer answers of near-identical questions asked within this many minutes (0 for never)
+	ConversationTitles        bool               `json:"conversation_titles,omitempty"`         // generate titles of new conversations
+	TitleModel                string             `json:"title_model,omitempty"`                 // (cheap) model or alias for generating titles (default: "gpt-4o-mini")
+	Embeddings                *embeddingsConfig  `json:"embeddings,omitempty"`                  // embedding model, dimensions, and vector store for finding similar questions
+	SpeechVoice               openai.SpeechVoice `json:"speech_voice,omitempty"`                // voice for answers in voice mode (default: "alloy")
+	CostFooter                bool               `json:"cost_footer,omitempty"`                 // append estimated costs, tokens, models, and elapsed times to answers
+	ModelPrices               modelPrices        `json:"model_prices,omitempty"`                // prices of models in USD per 1M tokens (overrides built-in and fetched ones)
+	PricingURL                string             `json:"pricing_url,omitempty"`                 // url of a json file with prices (and context windows) of models, in the format of `model_prices`
+	PricingRefreshHours       int                `json:"pricing_refresh_hours,omitempty"`       // refresh prices from `pricing_url` every this many hours (default: 24)
+	QuoteExcerpts             bool               `json:"quote_excerpts,omitempty"`              // quote excerpts of long documents which answers relied on
+	HTMLAnswers               bool               `json:"html_answers,omitempty"`                // let models format answers with HTML tags (sanitized for telegram)
+	Pipelines                 pipelines          `json:"pipelines,omitempty"`                   // multi-stage prompt pipelines, exposed as custom commands
+	AnonymousLogs             bool               `json:"anonymous_logs,omitempty"`              // store salted hashes of user ids and usernames, instead of them
+	DisableLocalization       bool               `json:"disable_localization,omitempty"`        // do not localize bot messages in the languages of users
+	DigestHour                *int               `json:"digest_hour,omitempty"`                 // hour of a day (0-23, in chats' timezones) for delivering digests (default: 9)
+	Timezone                  string             `json:"timezone,omitempty"`                    // default timezone for schedules and dates, eg. "Asia/Seoul" (default: the server's local one)
+	HistoryDepth              int                `json:"history_depth,omitempty"`               // max number of previous prompts & answers attached to requests (default: 10)
+	HistoryMaxTokens          int                `json:"history_max_tokens,omitempty"`          // max number of tokens of previous prompts & answers attached to requests (0 for no limit)
+	SessionTurns              int                `json:"session_turns,omitempty"`               // attach this many latest turns of the current conversation to messages which are not replies (0 for replies only)
+	ContextTTLMinutes         int                `json:"context_ttl_minutes,omitempty"`         // begin a new conversation after this many minutes of inactivity in the chat (0 for never)
+	ContextTTLNotice          bool               `json:"context_ttl_notice,omitempty"`          // let the user know when a new conversation began after `context_ttl_minutes`
+	CompletionNoticeSeconds   int                `json:"completion_notice_seconds,omitempty"`   // send a "still working on it" notice when a completion takes longer than this (0 for never)
+	CompletionTimeoutSeconds  int                `json:"completion_timeout_seconds,omitempty"`  // give up waiting for a completion after this many seconds (0 for never)
+	Verbose                   bool               `json:"verbose,omitempty"`
+
+	// custom TLS configurations for http clients (eg. behind TLS-intercepting proxies)
+	CABundleFilepath      string `json:"ca_bundle_filepath,omitempty"`       // PEM file of CA certificates to trust, in addition to the system ones
+	TLSInsecureSkipVerify bool   `json:"tls_insecure_skip_verify,omitempty"` // DANGEROUS: do not verify TLS certificates
+
+	// long-poll timeout (max: 9) and update types for polling updates
+	PollingTimeoutSeconds int                `json:"polling_timeout_seconds,omitempty"`
+	AllowedUpdates        []tg.AllowedUpdate `json:"allowed_updates,omitempty"`
+
+	// thresholds for restarting stuck polling
+	WatchdogMaxPollErrors   int `json:"watchdog_max_poll_errors,omitempty"`
+	WatchdogIntervalMinutes int `json:"watchdog_interval_minutes,omitempty"`
+
+	// message for non-admin users in maintenance mode
+	MaintenanceMessage string `json:"maintenance_message,omitempty"`
+
+	// for emailing usage reports
+	SMTP *smtpConfig `json:"smtp,omitempty"`
+
+	// for setting up the profile (descriptions and command lists) of the bot on startup
+	BotProfile *botProfileConfig `json:"bot_profile,omitempty"`
+
+	// for alerting admins on monthly token spend
+	TokenBudget *tokenBudgetConfig `json:"token_budget,omitempty"`
+
+	// for exporting conversations to a Notion database
+	Notion *notionConfig `json:"notion,omitempty"`
+
+	// for sharing conversations as Telegraph pages
+	Telegraph *telegraphConfig `json:"telegraph,omitempty"`
+
+	// for archiving generated images and received photos
+	ImageArchive *imageArchiveConfig `json:"image_archive,omitempty"`
+
+	// for routing requests between multiple providers
+	Routing *routingConfig `json:"routing,omitempty"`
+
+	// for archiving raw requests to and responses from the API
+	RawArchive *rawArchiveConfig `json:"raw_archive,omitempty"`
+
+	// for receiving updates with a webhook, instead of polling them
+	Webhook *webhookConfig `json:"webhook,omitempty"`
+
+	// for generating images with /image
+	Image *imageConfig `json:"image,omitempty"`
+
+	// for applying temporary cooldowns to users who flood requests, or repeatedly cause errors or trigger content filters
+	AbuseCooldown *abuseCooldownConfig `json:"abuse_cooldown,omitempty"`
+
+	// for answering messages of other bots, with guards against bot-to-bot loops
+	BotMessages *botMessagesConfig `json:"bot_messages,omitempty"`
+
+	// responses to frequently asked questions, sent without calling the API
+	CannedResponses []cannedResponse `json:"canned_responses,omitempty"`
+
+	// behaviors for channel posts, keyed by channel id (eg. {"-1001234567890": "summarize"})
+	ChannelBehaviors map[string]channelBehavior `json:"channel_behaviors,omitempty"`
 
 	// telegram bot and openai api tokens
 	TelegramBotToken     string `json:"telegram_bot_token,omitempty"`
@@ -132,103 +315,531 @@ func standardizeJSON(b []byte) ([]byte, error) {
 }
 
 // launch bot with given parameters
-func runBot(conf config) {
+func runBot(confFilepath string, conf config) {
+	setConfig(confFilepath, conf)
+
 	token := conf.TelegramBotToken
 	apiKey := conf.OpenAIAPIKey
 	orgID := conf.OpenAIOrganizationID
 
-	allowedUsers := map[string]bool{}
-	for _, user := range conf.AllowedTelegramUsers {
-		allowedUsers[user] = true
+	// custom TLS configuration for http clients
+	if err := setupTLSConfig(conf); err != nil {
+		log.Printf("failed to set up TLS config: %s", err)
+		return
+	}
+
+	// raw archive of api requests and responses
+	if err := setupRawArchive(conf); err != nil {
+		log.Printf("failed to set up raw archive: %s", err)
+		return
 	}
 
 	bot := tg.NewClient(token)
 	client := openai.NewClient(apiKey, orgID)
-
-	// set verbosity
 	client.Verbose = conf.Verbose
 
-	_ = bot.DeleteWebhook(false) // delete webhook before polling updates
+	// set log level
+	setLogLevelFromConfig(conf)
+
+	if conf.Webhook == nil {
+		_ = bot.DeleteWebhook(false) // delete webhook before polling updates
+	}
 	if b := bot.GetMe(); b.Ok {
 		log.Printf("launching bot: %s", userName(b.Result))
 
-		var db *Database = nil
+		// descriptions and command lists of the bot
+		setupBotProfile(bot, conf)
+
+		var db Storage = nil
 		if conf.RequestLogsDBFilepath != "" {
 			var err error
-			if db, err = OpenDatabase(conf.RequestLogsDBFilepath); err != nil {
+			if db, err = OpenStorage(conf.DBDriver, conf.RequestLogsDBFilepath); err != nil {
 				log.Printf("failed to open request logs db: %s", err)
+			} else {
+				// anonymize users in stored rows (with `anonymous_logs`), serve statistics from the read replica (with `read_replica_db_filepath`),
+				// and cache contexts of active chats in memory
+				db = newCachedStorage(newReplicaStorage(newAnonymizedStorage(db), openReadReplica(conf)), conf.ContextCacheSize)
 			}
 		}
 
-		// set message handler
-		bot.SetMessageHandler(func(b *tg.Bot, update tg.Update, message tg.Message, edited bool) {
-			if !isAllowed(update, allowedUsers) {
-				log.Printf("message not allowed: %s", userNameFromUpdate(update))
-				return
+		loadMaintenanceMode(db)
+		loadBlockedUsers(db)
+
+		// vector store for embeddings of questions
+		setupVectorStore(conf)
+
+		// guard against processing the same updates twice
+		loadProcessedUpdates(db)
+		persistProcessedUpdatesPeriodically(db)
+
+		// email usage reports to the operator
+		emailUsageReportsPeriodically(db)
+
+		// alert admins on monthly token spend
+		alertTokenBudgetPeriodically(bot, db)
+
+		// deliver daily digests to chats in digest mode
+		deliverDigestsPeriodically(bot, db)
+
+		// refresh prices of models from `pricing_url`
+		loadFetchedModelPrices(db)
+		refreshModelPricesPeriodically(bot, db)
+
+		// reload config on SIGHUP
+		reloadConfigOnSignal()
+
+		dispatcher := newUpdateDispatcher()
+		setHandlers(dispatcher, client, db)
+
+		// receive updates with a webhook (with `webhook`)
+		if conf.Webhook != nil {
+			if err := serveWebhook(bot, dispatcher, token, conf); err != nil {
+				log.Printf("failed to serve webhook: %s", err)
 			}
+			return
+		}
 
-			handleMessage(b, client, conf, db, update, message)
-		})
+		// poll updates, and restart polling with a new client when it gets stuck
+		for {
+			quit := make(chan struct{})
 
-		// set command handlers
-		bot.AddCommandHandler(cmdStart, startCommandHandler(conf, allowedUsers))
-		bot.AddCommandHandler(cmdStats, statsCommandHandler(conf, db, allowedUsers))
-		bot.AddCommandHandler(cmdHelp, helpCommandHandler(conf, allowedUsers))
-		bot.AddCommandHandler(cmdCount, countCommandHandler(conf, allowedUsers))
-		bot.SetNoMatchingCommandHandler(noSuchCommandHandler(conf, allowedUsers))
-
-		// poll updates
-		bot.StartPollingUpdates(0, intervalSeconds, func(b *tg.Bot, update tg.Update, err error) {
-			if err == nil {
-				if !isAllowed(update, allowedUsers) {
-					log.Printf("not allowed: %s", userNameFromUpdate(update))
-					return
-				}
+			watchdog := startPollingWatchdog(token, currentConfig(), func() { close(quit) })
+			pollUpdates(bot, dispatcher, currentConfig(), quit, func(err error) {
+				watchdog.pollFailed()
+			})
+			watchdog.stop()
 
-				// type not supported
-				message := usableMessageFromUpdate(update)
-				if message != nil {
-					send(b, conf, msgTypeNotSupported, message.Chat.ID, &message.MessageID)
-				}
-			} else {
-				log.Printf("failed to poll updates: %s", err)
+			if !watchdog.restartRequested() {
+				break
 			}
-		})
+
+			bot = tg.NewClient(token)
+			notifyAdmin(bot, currentConfig(), msgPollingRestarted)
+		}
 	} else {
 		log.Printf("failed to get bot info: %s", *b.Description)
 	}
 }
 
+// set update handlers of given dispatcher
+func setHandlers(d *updateDispatcher, client *openai.Client, db Storage) {
+	// set message handler
+	d.SetMessageHandler(func(b *tg.Bot, update tg.Update, message tg.Message, edited bool) {
+		conf := currentConfig()
+
+		// (messages of all users in groups are kept for resolving mentions)
+		rememberGroupMessage(message)
+
+		if !isAllowed(update, conf) {
+			log.Printf("message not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		if isBotLoopGuarded(b, conf, message) {
+			return
+		}
+
+		if isRefusingAPIRequest(b, conf, update, message) {
+			return
+		}
+
+		handleMessage(b, client, conf, db, update, message)
+	})
+
+	// set channel post handler
+	d.SetChannelPostHandler(func(b *tg.Bot, update tg.Update, channelPost tg.Message, edited bool) {
+		if edited {
+			return
+		}
+
+		if _maintenance.Load() {
+			return
+		}
+
+		handleChannelPost(b, client, currentConfig(), db, channelPost)
+	})
+
+	// set command handlers
+	d.AddCommandHandler(cmdStart, startCommandHandler(db))
+	d.AddCommandHandler(cmdStats, statsCommandHandler(db))
+	d.AddCommandHandler(cmdHistory, historyCommandHandler(db))
+	d.AddCommandHandler(cmdNew, newCommandHandler(db))
+	d.AddCommandHandler(cmdPrompt, promptCommandHandler(db))
+	d.AddCommandHandler(cmdWhoAmI, whoAmICommandHandler(db))
+	d.AddCommandHandler(cmdVoice, voiceCommandHandler(db))
+	d.AddCommandHandler(cmdDictate, dictateCommandHandler(db))
+	d.AddCommandHandler(cmdPrivate, privateCommandHandler(db))
+	d.AddCommandHandler(cmdLanguage, languageCommandHandler(db))
+	d.AddCommandHandler(cmdSystem, systemCommandHandler(db))
+	d.AddCommandHandler(cmdGlossary, glossaryCommandHandler(db))
+	d.AddCommandHandler(cmdDepth, depthCommandHandler(db))
+	d.AddCommandHandler(cmdPin, pinCommandHandler(db))
+	d.AddCommandHandler(cmdQuiet, quietCommandHandler(db))
+	d.AddCommandHandler(cmdTimezone, timezoneCommandHandler(db))
+	d.AddCommandHandler(cmdDigest, digestCommandHandler(db))
+	d.AddCommandHandler(cmdExport, exportChatCommandHandler(db))
+	d.AddCommandHandler(cmdShare, shareCommandHandler(db))
+	d.AddCommandHandler(cmdModels, modelsCommandHandler(client, db))
+	d.AddCommandHandler(cmdModel, modelCommandHandler(client, db))
+	d.AddCommandHandler(cmdHelp, helpCommandHandler())
+	d.AddCommandHandler(cmdCount, countCommandHandler(db))
+	d.AddCommandHandler(cmdExplainError, explainErrorCommandHandler(client, db))
+	d.AddCommandHandler(cmdAlt, altCommandHandler(client, db))
+	d.AddCommandHandler(cmdImage, imageCommandHandler(client, db))
+	d.AddCommandHandler(cmdSummarizeFrom, summarizeFromCommandHandler(client, db))
+	d.AddCommandHandler(cmdSave, saveCommandHandler(db))
+	d.AddCommandHandler(cmdUse, useCommandHandler(client, db))
+	d.AddCommandHandler(cmdSaved, savedCommandHandler(db))
+	d.AddCommandHandler(cmdBroadcast, broadcastCommandHandler(db))
+	d.AddCommandHandler(cmdMaintenance, maintenanceCommandHandler(db))
+	d.AddCommandHandler(cmdBlock, blockCommandHandler(db, true))
+	d.AddCommandHandler(cmdUnblock, blockCommandHandler(db, false))
+	d.AddCommandHandler(cmdLogLevel, logLevelCommandHandler())
+	d.AddCommandHandler(cmdReload, reloadCommandHandler())
+	d.AddCommandHandler(cmdTrace, traceCommandHandler())
+	d.AddCommandHandler(cmdQuery, queryCommandHandler(db))
+	d.AddCommandHandler(cmdFineTune, fineTuneCommandHandler(client, db))
+	d.SetNoMatchingCommandHandler(noSuchCommandHandler(client, db))
+
+	// set handler for other updates
+	d.SetUpdateHandler(func(b *tg.Bot, update tg.Update) {
+		handleUnhandledUpdate(b, db, update)
+	})
+}
+
+// handle updates which were not handled by any other handler
+func handleUnhandledUpdate(bot *tg.Bot, db Storage, update tg.Update) {
+	conf := currentConfig()
+
+	// the bot's own membership changes (checks permission by itself)
+	if update.MyChatMember != nil {
+		handleMyChatMember(bot, conf, update)
+		return
+	}
+
+	if !isAllowed(update, conf) {
+		log.Printf("not allowed: %s", userNameFromUpdate(update))
+		return
+	}
+
+	// reactions on messages
+	if update.MessageReaction != nil {
+		handleMessageReaction(db, *update.MessageReaction)
+		return
+	}
+
+	// callback queries from inline keyboards
+	if update.CallbackQuery != nil {
+		handleCallbackQuery(bot, db, *update.CallbackQuery)
+		return
+	}
+
+	// type not supported
+	message := usableMessageFromUpdate(update)
+	if message != nil {
+		send(bot, conf, localize(conf, *message, msgTypeNotSupported), message.Chat.ID, &message.MessageID)
+	}
+}
+
+// send given message to the admin chat (if configured)
+func notifyAdmin(bot *tg.Bot, conf config, message string) {
+	if conf.AdminChatID != 0 {
+		send(bot, conf, message, conf.AdminChatID, nil)
+	}
+}
+
 // checks if given update is allowed or not
-func isAllowed(update tg.Update, allowedUsers map[string]bool) bool {
-	var username string
-	if update.HasMessage() && update.Message.From.Username != nil {
-		username = *update.Message.From.Username
-	} else if update.HasEditedMessage() && update.EditedMessage.From.Username != nil {
-		username = *update.EditedMessage.From.Username
+//
+// (admins, and admins of groups with `group_admins_as_bot_admins`, are also allowed)
+func isAllowed(update tg.Update, conf config) bool {
+	if isBlocked(update, conf) {
+		return false
+	}
+
+	if from := fromUser(update); from != nil && from.Username != nil {
+		for _, user := range conf.AllowedTelegramUsers {
+			if user == *from.Username {
+				return true
+			}
+		}
 	}
 
-	if _, exists := allowedUsers[username]; exists {
-		return true
+	return isChatAdmin(update, conf)
+}
+
+// checks if given update is from an admin of the bot, or of the group where it was sent
+//
+// (for commands which affect only the chat, eg. changing its settings)
+func isChatAdmin(update tg.Update, conf config) bool {
+	return isAdmin(update, conf) || isGroupAdmin(update, conf)
+}
+
+// checks if given update is from an admin
+func isAdmin(update tg.Update, conf config) bool {
+	if from := fromUser(update); from != nil && from.Username != nil {
+		for _, admin := range conf.AdminTelegramUsers {
+			if admin == *from.Username {
+				return true
+			}
+		}
 	}
 
 	return false
 }
 
+// get the sender of given update
+func fromUser(update tg.Update) *tg.User {
+	if update.MessageReaction != nil {
+		return update.MessageReaction.User
+	}
+
+	return update.GetFrom()
+}
+
+// handle reactions on messages, and save thumbs up/down ones as feedbacks
+func handleMessageReaction(db Storage, reaction tg.MessageReactionUpdated) {
+	if db == nil || reaction.User == nil {
+		return
+	}
+
+	chatID := reaction.Chat.ID
+	messageID := reaction.MessageID
+	userID := reaction.User.ID
+
+	// ignore reactions on messages which are not answers of the bot
+	if _, err := db.PromptByAnswerMessageID(chatID, messageID); err != nil {
+		if isVerbose() {
+			log.Printf("[verbose] ignoring reaction on message %d in chat %d which is not an answer: %s", messageID, chatID, err)
+		}
+		return
+	}
+
+	// remove previous feedback
+	if err := db.DeleteFeedback(chatID, messageID, userID); err != nil {
+		log.Printf("failed to delete feedback: %s", err)
+	}
+
+	for _, r := range reaction.NewReaction {
+		if r.Emoji == nil {
+			continue
+		}
+
+		emoji := *r.Emoji
+		if emoji != reactionThumbsUp && emoji != reactionThumbsDown {
+			continue
+		}
+
+		if err := db.SaveFeedback(Feedback{
+			ChatID:    chatID,
+			MessageID: messageID,
+			UserID:    userID,
+			Username:  userName(reaction.User),
+			Reaction:  emoji,
+			Positive:  emoji == reactionThumbsUp,
+		}); err != nil {
+			log.Printf("failed to save feedback: %s", err)
+		}
+	}
+}
+
 // handle allowed message update from telegram bot api
-func handleMessage(bot *tg.Bot, client *openai.Client, conf config, db *Database, update tg.Update, message tg.Message) {
+func handleMessage(bot *tg.Bot, client *openai.Client, conf config, db Storage, update tg.Update, message tg.Message) {
 	chatID := message.Chat.ID
 	userID := message.From.ID
 	messageID := message.MessageID
 
-	messages := chatMessagesFromTGMessage(bot, message)
+	// return transcriptions of voices as they are, in dictation mode
+	if message.Voice != nil && isDictateModeOn(db, userID) {
+		dictate(bot, client, conf, db, chatID, messageID, *message.Voice)
+		return
+	}
+
+	// transcribe voice into text
+	if message.Voice != nil {
+		_ = bot.SendChatAction(chatID, tg.ChatActionTyping, nil)
+
+		if text, err := transcribeVoice(bot, client, *message.Voice, nil); err == nil {
+			message.Text = &text
+		} else {
+			log.Printf("failed to transcribe voice: %s", err)
+
+			send(bot, conf, msgTranscriptionFailed, chatID, &messageID)
+			return
+		}
+	}
+
+	// convert stickers into text
+	if message.Sticker != nil {
+		_ = bot.SendChatAction(chatID, tg.ChatActionTyping, nil)
+
+		text := describeSticker(bot, client, conf, *message.Sticker)
+		message.Text = &text
+	}
+
+	// reject documents which are not acceptable, before downloading them
+	if message.Document != nil {
+		if err := checkDocument(conf, *message.Document); err != nil {
+			send(bot, conf, documentNotSupportedMessage(conf, err), chatID, &messageID)
+			return
+		}
+	}
+
+	// captions of photos are their questions
+	if message.HasPhoto() && message.HasCaption() && !message.HasText() {
+		message.Text = message.Caption
+	}
+
+	// check for directives (eg. `!fast !t=1.2 explain X`)
+	model := chatModel(conf, db, chatID)
+	var directives messageDirectives
+	if message.HasText() {
+		var stripped string
+		directives, stripped = parseDirectives(conf, *message.Text)
+		if nothingButDirectives(message, stripped) {
+			send(bot, conf, msgDirectivesOnly, chatID, &messageID)
+			return
+		}
+		if directives.Model != "" {
+			model = directives.Model
+		}
+		message.Text = &stripped
+	}
+	directives.Edited = update.HasEditedMessage()
+
+	// not to save anything of the prompt with `!nolog`, or in private mode
+	directives.NoLog = directives.NoLog || isPrivateModeOn(db, userID)
+
+	// archive received photos
+	if message.HasPhoto() && !directives.NoLog {
+		go archiveReceivedPhoto(bot, conf, db, message)
+	}
+
+	// reject photos (or replies to them) when the model cannot see them
+	if hasPhotoInput(message) && !isVisionModel(model) {
+		send(bot, conf, fmt.Sprintf(msgPhotoNotSupported, model), chatID, &messageID)
+		return
+	}
+
+	// answer frequently asked questions with canned responses, without the API
+	if message.HasText() && !message.HasPhoto() && !message.HasDocument() {
+		if response, found := findCannedResponse(conf, *message.Text, model); found {
+			send(bot, conf, response, chatID, &messageID)
+			return
+		}
+	}
+
+	// append transcripts of linked youtube videos
+	if conf.YouTubeTranscripts && message.HasText() {
+		text := withYouTubeTranscripts(*message.Text)
+		message.Text = &text
+	}
+
+	// replies to answers are continued (or branched) from their threads
+	thread := threadFor(conf, db, chatID, repliedToMessage(message))
+	if thread.Expired && conf.ContextTTLNotice {
+		send(bot, conf, msgContextExpired, chatID, &messageID)
+	}
+
+	// with a quote, only the quoted excerpt is used as the context
+	if hasQuote(message) {
+		thread.History = nil
+	}
+
+	var messages []openai.ChatMessage
+	if len(thread.History) > 0 {
+		messages = []openai.ChatMessage{}
+		if chatMessage := convertMessage(bot, message); chatMessage != nil {
+			messages = append(messages, *chatMessage)
+		}
+	} else {
+		messages = chatMessagesFromTGMessage(bot, message)
+	}
 	if len(messages) > 0 {
-		answer(bot, client, conf, db, messages, chatID, userID, userNameFromUpdate(update), messageID)
+		// keep the long document (before condensed) for quoting its excerpts in the answer
+		if conf.QuoteExcerpts {
+			thread.Source = excerptSource(messages)
+		}
+
+		// recent messages of users mentioned in groups
+		if mentioned := mentionedChatMessage(db, message); mentioned != nil {
+			messages = append([]openai.ChatMessage{*mentioned}, messages...)
+		}
+
+		run := func() {
+			queueAnswer(bot, conf, chatID, messageID, func() {
+				// (condensed only when it is answered, not to spend tokens on requests which are not confirmed)
+				messages := condenseLargeMessages(bot, client, conf, db, model, directives, messages, chatID, userID, userNameFromUpdate(update), messageID)
+
+				answer(bot, client, conf, db, model, directives, messages, chatID, userID, userNameFromUpdate(update), messageID, thread)
+			})
+		}
+
+		// ask for confirmation before sending an expensive request (with the tokens of the original one, before condensed)
+		run = withConfirmation(conf, model, thread.request(conf, db, chatID, model, messages), run, func(tokens int, confirmed func()) {
+			askConfirmation(bot, chatID, messageID, userID, tokens, confirmed)
+		})
+
+		// offer the answer of a near-identical question asked recently (not for follow-ups in threads)
+		if len(thread.History) == 0 && !directives.Fresh {
+			if previous := findDuplicateQuestion(client, conf, db, chatID, lastUserContent(messages)); previous != nil {
+				offerPreviousAnswer(bot, db, chatID, userID, *previous, run)
+				return
+			}
+		}
+
+		run()
 	} else {
 		log.Printf("no converted chat messages from update: %+v", update)
 
-		msg := "Failed to get usable chat messages from your input. See the server logs for more information."
-		send(bot, conf, msg, chatID, &messageID)
+		send(bot, conf, localize(conf, message, msgNoUsableMessages), chatID, &messageID)
+	}
+}
+
+// handle channel post update from telegram bot api
+func handleChannelPost(bot *tg.Bot, client *openai.Client, conf config, db Storage, post tg.Message) {
+	chatID := post.Chat.ID
+	messageID := post.MessageID
+
+	behavior, exists := conf.ChannelBehaviors[strconv.FormatInt(chatID, 10)]
+	if !exists {
+		if isVerbose() {
+			log.Printf("[verbose] ignoring post from channel not configured: %s", post.Chat.String())
+		}
+		return
+	}
+
+	messages := []openai.ChatMessage{}
+	switch behavior {
+	case channelBehaviorAnswer:
+		// do nothing
+	case channelBehaviorSummarize:
+		messages = append(messages, openai.NewChatSystemMessage(systemPromptSummarize))
+	default:
+		log.Printf("unknown behavior '%s' for channel: %s", behavior, post.Chat.String())
+		return
+	}
+
+	if post.HasCaption() && !post.HasText() {
+		post.Text = post.Caption
+	}
+	if chatMessage := convertMessage(bot, post); chatMessage != nil {
+		model := chatModel(conf, db, chatID)
+
+		messages = append(messages, *chatMessage)
+
+		var title string
+		if post.Chat.Title != nil {
+			title = *post.Chat.Title
+		}
+
+		thread := threadFor(conf, db, chatID, nil)
+		queueAnswer(bot, conf, chatID, messageID, func() {
+			messages := condenseLargeMessages(bot, client, conf, db, model, messageDirectives{}, messages, chatID, chatID, title, messageID)
+
+			answer(bot, client, conf, db, model, messageDirectives{}, messages, chatID, chatID, title, messageID, thread)
+		})
+	} else {
+		log.Printf("no converted chat message from channel post: %+v", post)
 	}
 }
 
@@ -238,6 +849,8 @@ func usableMessageFromUpdate(update tg.Update) (message *tg.Message) {
 		message = update.Message
 	} else if update.HasMessage() && update.Message.HasDocument() {
 		message = update.Message
+	} else if update.HasMessage() && update.Message.HasPhoto() {
+		message = update.Message
 	} else if update.HasEditedMessage() && update.EditedMessage.HasText() {
 		message = update.EditedMessage
 	}
@@ -251,8 +864,10 @@ func chatMessagesFromTGMessage(bot *tg.Bot, message tg.Message) (chatMessages []
 
 	replyTo := repliedToMessage(message)
 
-	// chat message 1
-	if replyTo != nil {
+	// chat message 1 (only the quoted excerpt of it, if quoted)
+	if quoted := quotedChatMessage(bot, message); quoted != nil {
+		chatMessages = append(chatMessages, *quoted)
+	} else if replyTo != nil {
 		if chatMessage := convertMessage(bot, *replyTo); chatMessage != nil {
 			chatMessages = append(chatMessages, *chatMessage)
 		}
@@ -268,9 +883,9 @@ func chatMessagesFromTGMessage(bot *tg.Bot, message tg.Message) (chatMessages []
 
 // send given message to the chat
 func send(bot *tg.Bot, conf config, message string, chatID int64, messageID *int64) {
-	_ = bot.SendChatAction(chatID, tg.ChatActionTyping, nil)
+	sendChatAction(bot, chatID, responseText)
 
-	if conf.Verbose {
+	if isVerbose() {
 		log.Printf("[verbose] sending message to chat(%d): '%s'", chatID, message)
 	}
 
@@ -282,31 +897,119 @@ func send(bot *tg.Bot, conf config, message string, chatID int64, messageID *int
 		})
 	}
 	if res := bot.SendMessage(chatID, message, options); !res.Ok {
-		log.Printf("failed to send message: %s", *res.Description)
+		log.Printf("failed to send message, sending it as a plain text: %s", failureDescription(res))
+
+		// (eg. broken HTML in messages with model outputs or errors)
+		delete(options, "parse_mode")
+		if res := bot.SendMessage(chatID, htmlToPlainText(message), options); !res.Ok {
+			log.Printf("failed to send message: %s", failureDescription(res))
+		}
 	}
 }
 
-// generate an answer to given message and send it to the chat
-func answer(bot *tg.Bot, client *openai.Client, conf config, db *Database, messages []openai.ChatMessage, chatID, userID int64, username string, messageID int64) {
-	_ = bot.SendChatAction(chatID, tg.ChatActionTyping, nil)
+// generate an answer to given messages (in the thread) and send it to the chat
+func answer(bot *tg.Bot, client *openai.Client, conf config, db Storage, model string, directives messageDirectives, messages []openai.ChatMessage, chatID, userID int64, username string, messageID int64, thread conversationThread) {
+	// not to save the prompt and its answer with `!nolog`, or in private mode
+	directives.NoLog = directives.NoLog || isPrivateModeOn(db, userID)
+	logDB := db
+	if directives.NoLog {
+		logDB = nil
+	}
+
+	// prompt to be logged
+	prompt := Prompt{
+		ChatID:          chatID,
+		UserID:          userID,
+		Username:        username,
+		Question:        lastUserContent(messages),
+		Text:            messagesToPrompt(messages),
+		ConversationID:  thread.ConversationID,
+		MessageID:       messageID,
+		ParentMessageID: thread.ParentMessageID,
+	}
+
+	// previous prompts & answers of the thread, and the new messages
+	messages = withPersona(db, userID, thread.request(conf, db, chatID, model, messages))
+
+	// verbose trace for the admin (if this chat is being traced)
+	trace := startTrace(conf, chatID, messageID, model, directives, messages)
+
+	// acknowledge receipt, and mark the result when done
+	react(bot, chatID, messageID, reactionProcessing)
+	successful := false
+	started := time.Now()
+	defer func() {
+		trace.mark("send answer")
+
+		if successful {
+			react(bot, chatID, messageID, reactionDone)
+
+			// answer with voice too, if voice mode is on
+			if isVoiceModeOn(db, userID) {
+				sendVoiceAnswer(bot, client, conf, chatID, prompt.Result.MessageID, prompt.Result.Text)
+			}
+
+			if !directives.NoLog {
+				autoExportToNotion(conf, prompt)
+
+				// give a title to the new conversation
+				if thread.New && thread.ConversationID != nil {
+					go generateConversationTitle(client, conf, db, *thread.ConversationID, prompt.Question)
+				}
+
+				// keep the embedding of the question for finding similar ones later
+				go indexQuestion(client, conf, chatID, prompt.Result.MessageID, prompt.Question)
+			}
+		} else {
+			react(bot, chatID, messageID, reactionFailed)
+		}
+
+		// (private prompts and their answers are not sent anywhere either)
+		if !directives.NoLog {
+			mirrorToAuditChat(bot, conf, prompt)
+			postCompletionWebhook(conf, prompt, time.Since(started))
+		}
+		trace.finish(bot, conf, prompt)
+	}()
+
+	// keep typing until the completion is done (it may take long with reasoning models or large documents)
+	stopTyping := keepChatAction(bot, chatID, responseText)
 
-	model := conf.OpenAIModel
-	if model == "" {
-		model = chatCompletionModelDefault
+	// count tokens of the whole request
+	if count, err := countRequestTokens(model, messages); err == nil {
+		prompt.RequestTokens = uint(count)
+
+		logInfo("request tokens: %d (model: %s, encoding: %s)", count, model, encodingForModel(model))
+	} else {
+		log.Printf("failed to count request tokens: %s", err)
 	}
+	trace.mark("count tokens")
 
-	if response, err := client.CreateChatCompletion(model,
-		messages,
-		openai.ChatCompletionOptions{}.
-			SetUser(userAgent(userID))); err == nil {
-		if conf.Verbose {
+	// (with `routing`, the route which served the request; only read after the completion is done)
+	var route routeCandidate
+	response, cachedAt, err := completeWithDeadline(bot, conf, chatID, messageID, model, func() (openai.ChatCompletion, *time.Time, error) {
+		if conf.Routing != nil {
+			return routedChatCompletion(client, conf, model, directives, messages, userID, int(prompt.RequestTokens), &route)
+		}
+		return cachedChatCompletion(client, conf, model, directives, messages, userID)
+	})
+	stopTyping()
+	if err == nil && route.model != "" {
+		model = route.model
+		prompt.Route = route.String()
+	}
+	trace.completed(response, err)
+	if err == nil {
+		if isVerbose() {
 			log.Printf("[verbose] %+v ===> %+v", messages, response.Choices)
 		}
 
-		_ = bot.SendChatAction(chatID, tg.ChatActionTyping, nil)
+		logInfo("chat completion: %s (model: %s, finish reason: %s)", response.ID, model, finishReasonOf(response))
 
-		var answer string
+		var answer, finishReason string
 		if len(response.Choices) > 0 {
+			finishReason = response.Choices[0].FinishReason
+
 			var contentErr error
 			if answer, contentErr = response.Choices[0].Message.ContentString(); contentErr != nil {
 				answer = contentErr.Error()
@@ -315,56 +1018,200 @@ func answer(bot *tg.Bot, client *openai.Client, conf config, db *Database, messa
 			answer = "There was no response from OpenAI API."
 		}
 
-		if conf.Verbose {
-			log.Printf("[verbose] sending answer to chat(%d): '%s'", chatID, answer)
+		// let the user know that it is a cached one
+		text := answer
+		if cachedAt != nil {
+			text += fmt.Sprintf(msgCachedAnswer, cachedAt.Format("2006-01-02 15:04:05"))
+		} else if conf.CostFooter {
+			text += costFooter(conf, model, response.Usage, time.Since(started))
+		}
+
+		if isVerbose() {
+			log.Printf("[verbose] sending answer to chat(%d): '%s'", chatID, text)
 		}
 
+		// let the user know what kind of reply is coming
+		sendChatAction(bot, chatID, responseKindOf(text))
+
+		// hide answers about sensitive content in spoilers
+		sensitive := isSensitive(client, conf, answer)
+		if sensitive {
+			recordAbuseEvent(bot, conf, userID, "", abuseEventFlagged)
+		}
+
+		// send answers without notifications during quiet hours
+		silent := isQuietHours(db, chatID)
+
 		// if answer is too long for telegram api, send it as a text document
-		if len(answer) > 4096 {
-			file := tg.InputFileFromBytes([]byte(answer))
+		if responseKindOf(text) == responseDocument {
+			file := tg.InputFileFromBytes([]byte(text))
+			caption := strings.ToValidUTF8(text[:128], "") + "..."
+			options := tg.OptionsSendDocument{}.
+				SetReplyParameters(tg.ReplyParameters{MessageID: messageID}).
+				SetCaption(caption).
+				SetDisableNotification(silent)
+			if sensitive {
+				options = options.SetCaptionEntities(spoilerEntities(caption))
+			}
 			if res := bot.SendDocument(
 				chatID,
 				file,
-				tg.OptionsSendDocument{}.
-					SetReplyParameters(tg.ReplyParameters{MessageID: messageID}).
-					SetCaption(strings.ToValidUTF8(answer[:128], "")+"...")); res.Ok {
+				options); res.Ok {
+				successful = true
+
 				// save to database (successful)
-				savePromptAndResult(db, chatID, userID, username, messagesToPrompt(messages), uint(response.Usage.PromptTokens), answer, uint(response.Usage.CompletionTokens), true)
+				savePromptAndResult(logDB, directives.Edited, &prompt, uint(response.Usage.PromptTokens), Generated{
+					ChatModel:    model,
+					Successful:   true,
+					Text:         answer,
+					Tokens:       uint(response.Usage.CompletionTokens),
+					CompletionID: response.ID,
+					FinishReason: finishReason,
+					MessageID:    res.Result.MessageID,
+				})
 			} else {
-				log.Printf("failed to answer messages '%+v' with '%s' as file: %s", messages, answer, err)
+				log.Printf("failed to answer messages '%+v' with '%s' as file: %s", messages, answer, failureDescription(res))
 
 				msg := "Failed to send you the answer as a text file. See the server logs for more information."
 				send(bot, conf, msg, chatID, &messageID)
 
 				// save to database (error)
-				savePromptAndResult(db, chatID, userID, username, messagesToPrompt(messages), uint(response.Usage.PromptTokens), err.Error(), 0, false)
+				savePromptAndResult(logDB, directives.Edited, &prompt, uint(response.Usage.PromptTokens), Generated{
+					ChatModel:    model,
+					Successful:   false,
+					Text:         failureDescription(res),
+					CompletionID: response.ID,
+					FinishReason: finishReason,
+				})
 			}
 		} else {
-			if res := bot.SendMessage(
-				chatID,
-				answer,
-				tg.OptionsSendMessage{}.
-					SetReplyParameters(tg.ReplyParameters{MessageID: messageID})); res.Ok {
+			options := tg.OptionsSendMessage{}.
+				SetReplyParameters(tg.ReplyParameters{MessageID: messageID}).
+				SetDisableNotification(silent)
+
+			// quote the excerpt of the long document which the answer relied on
+			var entities []tg.MessageEntity
+			if thread.Source != "" && !directives.CodeBlocks {
+				if excerpt := relevantExcerpt(thread.Source, answer); excerpt != "" && responseKindOf(excerpt+"\n\n"+text) == responseText {
+					text, entities = quoteExcerpt(conf, text, excerpt, sensitive)
+				}
+			}
+
+			var res tg.APIResponse[tg.Message]
+			if sensitive {
+				res = bot.SendMessage(chatID, text, options.SetEntities(append(entities, spoilerEntities(text)...)))
+			} else if directives.CodeBlocks {
+				res = sendFormatted(bot, chatID, text, options)
+			} else if conf.HTMLAnswers {
+				res = sendHTML(bot, chatID, text, options)
+			} else {
+				if len(entities) > 0 {
+					options = options.SetEntities(entities)
+				}
+				res = bot.SendMessage(chatID, text, options)
+			}
+			if res.Ok {
+				successful = true
+
 				// save to database (successful)
-				savePromptAndResult(db, chatID, userID, username, messagesToPrompt(messages), uint(response.Usage.PromptTokens), answer, uint(response.Usage.CompletionTokens), true)
+				savePromptAndResult(logDB, directives.Edited, &prompt, uint(response.Usage.PromptTokens), Generated{
+					ChatModel:    model,
+					Successful:   true,
+					Text:         answer,
+					Tokens:       uint(response.Usage.CompletionTokens),
+					CompletionID: response.ID,
+					FinishReason: finishReason,
+					MessageID:    res.Result.MessageID,
+				})
 			} else {
-				log.Printf("failed to answer messages '%+v' with '%s': %s", messages, answer, err)
+				log.Printf("failed to answer messages '%+v' with '%s': %s", messages, answer, failureDescription(res))
 
 				msg := "Failed to send you the answer as a text. See the server logs for more information."
 				send(bot, conf, msg, chatID, &messageID)
 
 				// save to database (error)
-				savePromptAndResult(db, chatID, userID, username, messagesToPrompt(messages), uint(response.Usage.PromptTokens), err.Error(), 0, false)
+				savePromptAndResult(logDB, directives.Edited, &prompt, uint(response.Usage.PromptTokens), Generated{
+					ChatModel:    model,
+					Successful:   false,
+					Text:         failureDescription(res),
+					CompletionID: response.ID,
+					FinishReason: finishReason,
+				})
 			}
 		}
 	} else {
-		log.Printf("failed to create chat completion: %s", err)
+		log.Printf("failed to create chat completion (%s): %s", classifyError(err), err)
 
-		msg := "Failed to generate an answer from OpenAI. See the server logs for more information."
-		send(bot, conf, msg, chatID, &messageID)
+		send(bot, conf, completionErrorMessage(err), chatID, &messageID)
 
-		// save to database (error)
-		savePromptAndResult(db, chatID, userID, username, messagesToPrompt(messages), 0, err.Error(), 0, false)
+		if event, caused := abuseEventOfError(err); caused {
+			recordAbuseEvent(bot, conf, userID, "", event)
+		}
+
+		// save to database (error, with locally counted tokens)
+		savePromptAndResult(logDB, directives.Edited, &prompt, prompt.RequestTokens, Generated{
+			ChatModel:  model,
+			Successful: false,
+			Text:       err.Error(),
+		})
+	}
+}
+
+// get the chat completion model from config, or the default one
+func chatCompletionModel(conf config) string {
+	if conf.OpenAIModel != "" {
+		return resolveModelAlias(conf, conf.OpenAIModel)
+	}
+
+	return chatCompletionModelDefault
+}
+
+// resolve given model name with the configured aliases
+//
+// (returns the name as it is if there is no such alias)
+func resolveModelAlias(conf config, name string) string {
+	if model, exists := conf.ModelAliases[name]; exists {
+		return model
+	}
+
+	return name
+}
+
+// checks if given model id is for chat completions
+func isChatModel(modelID string) bool {
+	if strings.Contains(modelID, "instruct") ||
+		strings.Contains(modelID, "realtime") ||
+		strings.Contains(modelID, "audio") {
+		return false
+	}
+
+	return strings.HasPrefix(modelID, "gpt-") ||
+		strings.HasPrefix(modelID, "chatgpt-") ||
+		strings.HasPrefix(modelID, "o1") ||
+		strings.HasPrefix(modelID, "o3") ||
+		strings.HasPrefix(modelID, "ft:gpt-")
+}
+
+// list available chat models (sorted by id)
+func listChatModels(client *openai.Client) (models []string, err error) {
+	var response openai.ModelsList
+	if response, err = client.ListModels(); err == nil {
+		models = []string{}
+		for _, model := range response.Data {
+			if isChatModel(model.ID) {
+				models = append(models, model.ID)
+			}
+		}
+		sort.Strings(models)
+	}
+
+	return models, err
+}
+
+// set a reaction on given message
+func react(bot *tg.Bot, chatID, messageID int64, emoji string) {
+	if res := bot.SetMessageReaction(chatID, messageID, tg.NewMessageReactionWithEmoji(emoji)); !res.Ok {
+		log.Printf("failed to set reaction '%s' on message: %s", emoji, failureDescription(res))
 	}
 }
 
@@ -384,7 +1231,7 @@ func userName(user *tg.User) string {
 
 // generate user's name from update
 func userNameFromUpdate(update tg.Update) string {
-	if from := update.GetFrom(); from != nil {
+	if from := fromUser(update); from != nil {
 		return userName(from)
 	} else {
 		return "unknown"
@@ -404,6 +1251,10 @@ func repliedToMessage(message tg.Message) *tg.Message {
 // nil if there was any error.
 //
 // (if it was sent from bot, make it an assistant's message)
+//
+// (if it was forwarded, its original sender and date are prepended)
+//
+// (if it has a photo, it becomes a multimodal message with the photo and its caption)
 func convertMessage(bot *tg.Bot, message tg.Message) *openai.ChatMessage {
 	if message.ViaBot != nil &&
 		message.ViaBot.IsBot {
@@ -421,13 +1272,19 @@ func convertMessage(bot *tg.Bot, message tg.Message) *openai.ChatMessage {
 		}
 	}
 
-	if message.HasText() {
-		chatMessage := openai.NewChatUserMessage(*message.Text)
+	if message.HasPhoto() {
+		if chatMessage, err := photoChatMessage(bot, message); err == nil {
+			return &chatMessage
+		} else {
+			log.Printf("failed to read photo for user message: %s", err)
+		}
+	} else if message.HasText() {
+		chatMessage := openai.NewChatUserMessage(withForwardAttribution(message, *message.Text))
 		return &chatMessage
 	} else if message.HasDocument() {
 		if bytes, err := documentText(bot, message.Document); err == nil {
 			str := strings.TrimSpace(strings.ToValidUTF8(string(bytes), "?"))
-			chatMessage := openai.NewChatUserMessage(str)
+			chatMessage := openai.NewChatUserMessage(withForwardAttribution(message, str))
 			return &chatMessage
 		} else {
 			log.Printf("failed to read document content for user message: %s", err)
@@ -437,151 +1294,216 @@ func convertMessage(bot *tg.Bot, message tg.Message) *openai.ChatMessage {
 	return nil
 }
 
-// read bytes from given document
-func documentText(bot *tg.Bot, document *tg.Document) (result []byte, err error) {
-	if res := bot.GetFile(document.FileID); !res.Ok {
-		err = fmt.Errorf("Failed to get document: %s", *res.Description)
-	} else {
-		fileURL := bot.GetFileURL(*res.Result)
-		result, err = readFileContentAtURL(fileURL)
+// convert chat messages to a prompt for logging
+func messagesToPrompt(messages []openai.ChatMessage) string {
+	lines := []string{}
+
+	for _, message := range messages {
+		if content, err := message.ContentString(); err == nil {
+			lines = append(lines, fmt.Sprintf("[%s] %s", message.Role, content))
+		} else if contents, err := message.ContentArray(); err == nil {
+			lines = append(lines, fmt.Sprintf("[%s] %s", message.Role, describeContents(contents)))
+		}
 	}
 
-	return result, err
+	return strings.Join(lines, "\n--------\n")
 }
 
-var _tokenizer *geektoken.Tokenizer = nil
-
-// count BPE tokens for given `text`
-func countTokens(text string) (result int, err error) {
-	result = 0
-
-	// lazy-load the tokenizer
-	if _tokenizer == nil {
-		var tokenizer geektoken.Tokenizer
-		tokenizer, err = geektoken.GetTokenizerWithEncoding(geektoken.EncodingCl100kBase)
-
-		if err == nil {
-			_tokenizer = &tokenizer
-		}
+// retrieve stats from database, with dates in given timezone
+func retrieveStats(db Storage, location *time.Location) string {
+	if db == nil {
+		return msgDatabaseNotConfigured
 	}
 
-	if _tokenizer == nil {
-		return 0, fmt.Errorf("tokenizer is not initialized.")
+	stats, err := db.Stats()
+	if err != nil {
+		log.Printf("failed to retrieve stats: %s", err)
+		return msgDatabaseEmpty
 	}
 
-	var tokens []int
-	tokens, err = _tokenizer.Encode(text, nil, nil)
+	lines := []string{}
+	if stats.Since != nil {
+		lines = append(lines, fmt.Sprintf("Since <i>%s (%s)</i>", stats.Since.In(location).Format("2006-01-02 15:04:05"), location))
+		lines = append(lines, "")
+	}
+	lines = append(lines, fmt.Sprintf("* Chats: <b>%d</b>", stats.Chats))
+	lines = append(lines, fmt.Sprintf("* Prompts: <b>%d</b> (Total tokens: <b>%d</b>)", stats.Prompts, stats.PromptTokens))
+	lines = append(lines, fmt.Sprintf("* Completions: <b>%d</b> (Total tokens: <b>%d</b>)", stats.Completions, stats.CompletionTokens))
+	lines = append(lines, fmt.Sprintf("* Errors: <b>%d</b>", stats.Errors))
+	lines = append(lines, fmt.Sprintf("* In-flight completions: <b>%s</b>", describeInflightCompletions()))
+	lines = append(lines, fmt.Sprintf("* Feedbacks: %s <b>%d</b> / %s <b>%d</b>", reactionThumbsUp, stats.PositiveFeedbacks, reactionThumbsDown, stats.NegativeFeedbacks))
+
+	return strings.Join(lines, "\n")
+}
 
-	if err == nil {
-		return len(tokens), nil
+// get the finish reason of the first choice in given chat completion
+func finishReasonOf(response openai.ChatCompletion) string {
+	if len(response.Choices) > 0 {
+		return response.Choices[0].FinishReason
 	}
 
-	return result, err
+	return "none"
 }
 
-// read file content at given url, will timeout in 60 seconds
-func readFileContentAtURL(url string) (content []byte, err error) {
-	httpClient := http.Client{
-		Timeout: time.Second * 60,
+// describe the effective context which will be sent with the next message
+//
+// (`replyTo` is the message which the next message will reply to)
+func describePrompt(bot *tg.Bot, conf config, db Storage, chatID int64, replyTo *tg.Message) string {
+	instructions := []string{}
+	if prompt := chatSystemPrompt(db, chatID); prompt != "" {
+		instructions = append(instructions, prompt)
+	}
+	if language := responseLanguage(conf, db, chatID); language != "" {
+		instructions = append(instructions, fmt.Sprintf(systemPromptResponseLanguage, language))
+	}
+	systemPrompt := "<i>(none)</i>"
+	if len(instructions) > 0 {
+		systemPrompt = html.EscapeString(strings.Join(instructions, "\n\n"))
 	}
 
-	var resp *http.Response
-	resp, err = httpClient.Get(url)
-	if err != nil {
-		return nil, err
+	lines := []string{
+		fmt.Sprintf("* Model: <b>%s</b>", chatModel(conf, db, chatID)),
+		fmt.Sprintf("* System prompt: %s", systemPrompt),
 	}
-	defer resp.Body.Close()
 
-	content, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	// previous prompts & answers of the replied answer, or the replied message itself
+	// (without a reply, the latest turns of the session)
+	var messages []openai.ChatMessage
+	if replyTo != nil {
+		messages = replyHistory(conf, db, chatID, replyTo)
+		if len(messages) == 0 {
+			if message := convertMessage(bot, *replyTo); message != nil {
+				messages = append(messages, *message)
+			}
+		}
+	} else {
+		messages = currentSessionHistory(conf, db, chatID)
 	}
 
-	return content, nil
-}
+	if len(messages) == 0 {
+		lines = append(lines, "* Context: <i>(none, reply to a message for keeping the context)</i>")
+	} else {
+		lines = append(lines, "* Context:")
 
-// convert chat messages to a prompt for logging
-func messagesToPrompt(messages []openai.ChatMessage) string {
-	lines := []string{}
+		encoding := encodingForModel(chatModel(conf, db, chatID))
 
-	for _, message := range messages {
-		if content, err := message.ContentString(); err == nil {
-			lines = append(lines, fmt.Sprintf("[%s] %s", message.Role, content))
+		total := 0
+		for _, message := range messages {
+			content, _ := message.ContentString()
+
+			tokens, err := countTokensWithEncoding(content, encoding)
+			if err != nil {
+				log.Printf("failed to count tokens: %s", err)
+			}
+			total += tokens
+
+			lines = append(lines, fmt.Sprintf("  [%s] %s <i>(%d tokens)</i>", message.Role, html.EscapeString(ellipsize(content, 100)), tokens))
 		}
+
+		lines = append(lines, fmt.Sprintf("* Context tokens: <b>%d</b> <i>(%s)</i>", total, encoding))
 	}
 
-	return strings.Join(lines, "\n--------\n")
+	return strings.Join(lines, "\n")
 }
 
-// retrieve stats from database
-func retrieveStats(db *Database) string {
+// retrieve recent history of given chat from database
+func retrieveHistory(db Storage, chatID int64, n int) string {
 	if db == nil {
 		return msgDatabaseNotConfigured
-	} else {
-		lines := []string{}
+	}
 
-		var prompt Prompt
-		if tx := db.db.First(&prompt); tx.Error == nil {
-			lines = append(lines, fmt.Sprintf("Since <i>%s</i>", prompt.CreatedAt.Format("2006-01-02 15:04:05")))
-			lines = append(lines, "")
-		}
+	prompts, err := db.RecentPrompts(chatID, n)
+	if err != nil {
+		log.Printf("failed to retrieve history: %s", err)
 
-		var count int64
-		if tx := db.db.Table("prompts").Select("count(distinct chat_id) as count").Scan(&count); tx.Error == nil {
-			lines = append(lines, fmt.Sprintf("* Chats: <b>%d</b>", count))
-		}
+		return "Failed to retrieve history. See the server logs for more information."
+	}
+	if len(prompts) <= 0 {
+		return msgHistoryEmpty
+	}
 
-		var sumAndCount struct {
-			Sum   int64
-			Count int64
-		}
-		if tx := db.db.Table("prompts").Select("sum(tokens) as sum, count(id) as count").Where("tokens > 0").Scan(&sumAndCount); tx.Error == nil {
-			lines = append(lines, fmt.Sprintf("* Prompts: <b>%d</b> (Total tokens: <b>%d</b>)", sumAndCount.Count, sumAndCount.Sum))
-		}
-		if tx := db.db.Table("generateds").Select("sum(tokens) as sum, count(id) as count").Where("successful = 1").Scan(&sumAndCount); tx.Error == nil {
-			lines = append(lines, fmt.Sprintf("* Completions: <b>%d</b> (Total tokens: <b>%d</b>)", sumAndCount.Count, sumAndCount.Sum))
-		}
-		if tx := db.db.Table("generateds").Select("count(id) as count").Where("successful = 0").Scan(&count); tx.Error == nil {
-			lines = append(lines, fmt.Sprintf("* Errors: <b>%d</b>", count))
+	maxLen := historyMaxLen / len(prompts) / 2 // for each prompt and answer
+	entries := []string{}
+	for i, prompt := range prompts {
+		// separate conversations (with their titles)
+		if i == 0 || !sameConversation(prompts[i-1], prompt) {
+			if i > 0 {
+				entries = append(entries, "⋯")
+			}
+			if title := conversationTitle(db, prompt.ConversationID); title != "" {
+				entries = append(entries, fmt.Sprintf("📝 <b>%s</b>", html.EscapeString(title)))
+			}
 		}
 
-		if len(lines) > 0 {
-			return strings.Join(lines, "\n")
+		entry := fmt.Sprintf("<i>%s</i>\n<b>Q:</b> %s", prompt.CreatedAt.Format("2006-01-02 15:04:05"), html.EscapeString(ellipsize(prompt.Text, maxLen)))
+		if prompt.Result.Successful {
+			entry += fmt.Sprintf("\n<b>A:</b> %s", html.EscapeString(ellipsize(prompt.Result.Text, maxLen)))
+		} else {
+			entry += "\n<b>A:</b> <i>(failed)</i>"
 		}
+		entries = append(entries, entry)
+	}
 
-		return msgDatabaseEmpty
+	return strings.Join(entries, "\n\n")
+}
+
+// checks if given prompts are in the same conversation
+func sameConversation(p1, p2 Prompt) bool {
+	return p1.ConversationID != nil && p2.ConversationID != nil && *p1.ConversationID == *p2.ConversationID
+}
+
+// shorten given text to `maxLen` runes with an ellipsis
+func ellipsize(text string, maxLen int) string {
+	text = strings.TrimSpace(text)
+
+	runes := []rune(text)
+	if len(runes) > maxLen {
+		return string(runes[:maxLen]) + "…"
 	}
+
+	return text
+}
+
+// request a chat completion of given messages, with options from directives
+func createChatCompletion(client *openai.Client, model string, directives messageDirectives, messages []openai.ChatMessage, userID int64) (openai.ChatCompletion, error) {
+	options := openai.ChatCompletionOptions{}.
+		SetUser(userAgent(userID))
+	if directives.Temperature != nil {
+		options = options.SetTemperature(*directives.Temperature)
+	}
+
+	return archivedChatCompletion(client, model, messages, options)
 }
 
 // save prompt and its result to logs database
-func savePromptAndResult(db *Database, chatID, userID int64, username string, prompt string, promptTokens uint, result string, resultTokens uint, resultSuccessful bool) {
+//
+// (as a new version of the previous one with the same message id, if `newVersion` is set)
+func savePromptAndResult(db Storage, newVersion bool, prompt *Prompt, promptTokens uint, result Generated) {
+	prompt.Tokens = promptTokens
+	prompt.Result = result
+
 	if db != nil {
-		if err := db.SavePrompt(Prompt{
-			ChatID:   chatID,
-			UserID:   userID,
-			Username: username,
-			Text:     prompt,
-			Tokens:   promptTokens,
-			Result: Generated{
-				Successful: resultSuccessful,
-				Text:       result,
-				Tokens:     resultTokens,
-			},
-		}); err != nil {
+		if newVersion {
+			if err := db.SaveAnswerVersion(*prompt); err != nil {
+				log.Printf("failed to save new version of prompt & result to database: %s", err)
+			}
+		} else if err := db.SavePrompt(*prompt); err != nil {
 			log.Printf("failed to save prompt & result to database: %s", err)
 		}
 	}
 }
 
-// generate a help message with version info
-func helpMessage() string {
-	return fmt.Sprintf(msgHelp, version.Build(version.OS|version.Architecture|version.Revision))
+// generate a help message with version info (and pipelines), in the language of the sender of given message
+func helpMessage(conf config, message tg.Message) string {
+	return fmt.Sprintf(localize(conf, message, msgHelp), version.Build(version.OS|version.Architecture|version.Revision)) + describePipelines(conf)
 }
 
 // return a /start command handler
-func startCommandHandler(conf config, allowedUsers map[string]bool) func(b *tg.Bot, update tg.Update, args string) {
-	return func(b *tg.Bot, update tg.Update, _ string) {
-		if !isAllowed(update, allowedUsers) {
+func startCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
 			log.Printf("start command not allowed: %s", userNameFromUpdate(update))
 			return
 		}
@@ -594,14 +1516,29 @@ func startCommandHandler(conf config, allowedUsers map[string]bool) func(b *tg.B
 
 		chatID := message.Chat.ID
 
-		send(b, conf, msgStart, chatID, nil)
+		// deep link payload
+		if args != "" {
+			if msg := handleStartPayload(db, *message, args); msg != "" {
+				send(b, conf, msg, chatID, nil)
+			}
+		}
+
+		// settings wizard in private chats
+		if db != nil && message.Chat.Type == tg.ChatTypePrivate {
+			startWizard(b, conf, chatID, localize(conf, *message, msgStart))
+			return
+		}
+
+		send(b, conf, localize(conf, *message, msgStart), chatID, nil)
 	}
 }
 
 // return a /stats command handler
-func statsCommandHandler(conf config, db *Database, allowedUsers map[string]bool) func(b *tg.Bot, update tg.Update, args string) {
+func statsCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
 	return func(b *tg.Bot, update tg.Update, args string) {
-		if !isAllowed(update, allowedUsers) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
 			log.Printf("stats command not allowed: %s", userNameFromUpdate(update))
 			return
 		}
@@ -615,14 +1552,240 @@ func statsCommandHandler(conf config, db *Database, allowedUsers map[string]bool
 		chatID := message.Chat.ID
 		messageID := message.MessageID
 
-		send(b, conf, retrieveStats(db), chatID, &messageID)
+		location := botTimezone(conf)
+		if message.From != nil {
+			location = userTimezone(conf, db, message.From.ID)
+		}
+
+		send(b, conf, retrieveStats(db, location), chatID, &messageID)
+	}
+}
+
+// return a /history command handler
+func historyCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("history command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		n := historyCountDefault
+		if args != "" {
+			if parsed, err := strconv.Atoi(args); err == nil && parsed > 0 {
+				n = parsed
+			}
+		}
+		if n > historyCountMax {
+			n = historyCountMax
+		}
+
+		send(b, conf, retrieveHistory(db, chatID, n), chatID, &messageID)
+	}
+}
+
+// return a /prompt command handler
+func promptCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, _ string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("prompt command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		send(b, conf, describePrompt(b, conf, db, chatID, repliedToMessage(*message)), chatID, &messageID)
+	}
+}
+
+// return a /whoami command handler
+func whoAmICommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, _ string) {
+		conf := currentConfig()
+
+		// (users who are not allowed can see their ids too, for asking to be allowed)
+		if isBlocked(update, conf) {
+			log.Printf("whoami command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil || message.From == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		username := "<i>(none)</i>"
+		if message.From.Username != nil {
+			username = "@" + html.EscapeString(*message.From.Username)
+		}
+
+		lines := []string{
+			fmt.Sprintf("* ID: <code>%d</code>", message.From.ID),
+			fmt.Sprintf("* Username: %s", username),
+			fmt.Sprintf("* Name: %s", html.EscapeString(message.From.FirstName)),
+			fmt.Sprintf("* Chat ID: <code>%d</code>", chatID),
+			"",
+		}
+		lines = append(lines, whoAmIStatus(conf, db, update, message.From.ID, time.Now().In(botTimezone(conf)))...)
+		if !isAllowed(update, conf) {
+			send(b, conf, strings.Join(lines, "\n"), chatID, &messageID)
+			return
+		}
+
+		lines = append(lines,
+			"",
+			fmt.Sprintf("* Model: <b>%s</b>", chatModel(conf, db, chatID)),
+			fmt.Sprintf("* Voice mode: <b>%s</b>", onOff(isVoiceModeOn(db, message.From.ID))),
+			fmt.Sprintf("* Dictation mode: <b>%s</b>", onOff(isDictateModeOn(db, message.From.ID))),
+			fmt.Sprintf("* Private mode: <b>%s</b>", onOff(isPrivateModeOn(db, message.From.ID))),
+			fmt.Sprintf("* Persona: <b>%s</b>", html.EscapeString(describePersona(db, message.From.ID))),
+			fmt.Sprintf("* Timezone: <b>%s</b>", html.EscapeString(userTimezone(conf, db, message.From.ID).String())),
+			fmt.Sprintf("* Response language: %s", describeResponseLanguage(conf, db, chatID)),
+			fmt.Sprintf("* History depth: %s", describeHistoryDepth(conf, db, chatID)),
+			fmt.Sprintf("* Quiet hours: %s", describeQuietHours(db, chatID)),
+		)
+
+		send(b, conf, strings.Join(lines, "\n"), chatID, &messageID)
+	}
+}
+
+// generate lines of the permissions and remaining quotas of the sender of given update (for /whoami)
+//
+// (only whether allowed or not, for users who are not allowed)
+func whoAmIStatus(conf config, db Storage, update tg.Update, userID int64, now time.Time) []string {
+	if !isAllowed(update, conf) {
+		return []string{fmt.Sprintf("* Allowed: <b>%t</b>", false)}
+	}
+
+	return []string{
+		fmt.Sprintf("* Allowed: <b>%t</b>", true),
+		fmt.Sprintf("* Admin: <b>%t</b>", isAdmin(update, conf)),
+		fmt.Sprintf("* Admin of this chat: <b>%t</b>", isChatAdmin(update, conf)),
+		fmt.Sprintf("* Remaining tokens of this month: %s", describeRemainingTokens(conf, db, now)),
+		fmt.Sprintf("* Remaining requests: %s", describeRemainingRequests(conf, update, userID, now)),
+	}
+}
+
+// describe the remaining tokens in the budget of this month (shared by all users)
+func describeRemainingTokens(conf config, db Storage, now time.Time) string {
+	remaining, limited, err := remainingTokens(conf, db, now)
+	if !limited {
+		return "<i>(no limit)</i>"
+	}
+	if err != nil {
+		log.Printf("failed to get remaining tokens: %s", err)
+
+		return "<i>(unknown)</i>"
+	}
+
+	return fmt.Sprintf("<b>%d</b> / %d", max(remaining, 0), conf.TokenBudget.MonthlyTokens)
+}
+
+// describe the remaining requests of given user in the window of cooldowns (admins never cool down)
+func describeRemainingRequests(conf config, update tg.Update, userID int64, now time.Time) string {
+	if isAdmin(update, conf) {
+		return "<i>(no limit)</i>"
+	}
+
+	remaining, maximum, limited := remainingRequests(conf, userID, now)
+	if !limited {
+		return "<i>(no limit)</i>"
+	}
+
+	window, _, _ := abuseLimits(*conf.AbuseCooldown)
+	return fmt.Sprintf("<b>%d</b> / %d (in %d minutes)", remaining, maximum, int(window.Minutes()))
+}
+
+// return a /models command handler
+func modelsCommandHandler(client *openai.Client, db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, _ string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("models command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		if isRefusingAPIRequest(b, conf, update, *message) {
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		_ = b.SendChatAction(chatID, tg.ChatActionTyping, nil)
+
+		var msg string
+		if models, err := listChatModels(client); err == nil {
+			if len(models) > 0 {
+				current := chatModel(conf, db, chatID)
+
+				aliases := map[string][]string{}
+				for alias, model := range conf.ModelAliases {
+					aliases[model] = append(aliases[model], "!"+alias)
+				}
+
+				lines := []string{}
+				for _, model := range models {
+					line := fmt.Sprintf("* %s", model)
+					if model == current {
+						line = fmt.Sprintf("* <b>%s</b> (current)", model)
+					}
+					if as, exists := aliases[model]; exists {
+						sort.Strings(as)
+						line += fmt.Sprintf(" <i>%s</i>", strings.Join(as, ", "))
+					}
+					lines = append(lines, line)
+				}
+				msg = strings.Join(lines, "\n")
+			} else {
+				msg = msgNoChatModels
+			}
+		} else {
+			log.Printf("failed to list models: %s", err)
+
+			msg = "Failed to list models from OpenAI. See the server logs for more information."
+		}
+
+		send(b, conf, msg, chatID, &messageID)
 	}
 }
 
 // return a /help command handler
-func helpCommandHandler(conf config, allowedUsers map[string]bool) func(b *tg.Bot, update tg.Update, args string) {
+func helpCommandHandler() func(b *tg.Bot, update tg.Update, args string) {
 	return func(b *tg.Bot, update tg.Update, _ string) {
-		if !isAllowed(update, allowedUsers) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
 			log.Printf("help command not allowed: %s", userNameFromUpdate(update))
 			return
 		}
@@ -636,14 +1799,16 @@ func helpCommandHandler(conf config, allowedUsers map[string]bool) func(b *tg.Bo
 		chatID := message.Chat.ID
 		messageID := message.MessageID
 
-		send(b, conf, helpMessage(), chatID, &messageID)
+		send(b, conf, helpMessage(conf, *message), chatID, &messageID)
 	}
 }
 
 // return a /count command handler
-func countCommandHandler(conf config, allowedUsers map[string]bool) func(b *tg.Bot, update tg.Update, args string) {
+func countCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
 	return func(b *tg.Bot, update tg.Update, args string) {
-		if !isAllowed(update, allowedUsers) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
 			log.Printf("count command not allowed: %s", userNameFromUpdate(update))
 			return
 		}
@@ -657,9 +1822,36 @@ func countCommandHandler(conf config, allowedUsers map[string]bool) func(b *tg.B
 		chatID := message.Chat.ID
 		messageID := message.MessageID
 
+		// count the replied message (or document) if there is no text given
+		text := args
+		if text == "" {
+			replyTo := repliedToMessage(*message)
+			if replyTo == nil || (!replyTo.HasText() && !replyTo.HasDocument()) {
+				send(b, conf, msgCountUsage, chatID, &messageID)
+				return
+			}
+
+			// reject documents which are not acceptable, before downloading them
+			if replyTo.Document != nil {
+				if err := checkDocument(conf, *replyTo.Document); err != nil {
+					send(b, conf, documentNotSupportedMessage(conf, err), chatID, &messageID)
+					return
+				}
+			}
+
+			if chatMessage := convertMessage(b, *replyTo); chatMessage != nil {
+				text, _ = chatMessage.ContentString()
+			}
+			if text == "" {
+				send(b, conf, msgCountEmpty, chatID, &messageID)
+				return
+			}
+		}
+
 		var msg string
-		if count, err := countTokens(args); err == nil {
-			msg = fmt.Sprintf(msgTokenCount, count, len(args))
+		encoding := encodingForModel(chatModel(conf, db, chatID))
+		if count, err := countTokensWithEncoding(text, encoding); err == nil {
+			msg = fmt.Sprintf(msgTokenCount, count, len(text), encoding)
 		} else {
 			msg = err.Error()
 		}
@@ -669,9 +1861,13 @@ func countCommandHandler(conf config, allowedUsers map[string]bool) func(b *tg.B
 }
 
 // return a 'no such command' handler
-func noSuchCommandHandler(conf config, allowedUsers map[string]bool) func(b *tg.Bot, update tg.Update, cmd, args string) {
+//
+// (commands of pipelines are handled here, as they can be changed on reload)
+func noSuchCommandHandler(client *openai.Client, db Storage) func(b *tg.Bot, update tg.Update, cmd, args string) {
 	return func(b *tg.Bot, update tg.Update, cmd, args string) {
-		if !isAllowed(update, allowedUsers) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
 			log.Printf("command not allowed: %s", userNameFromUpdate(update))
 			return
 		}
@@ -682,10 +1878,20 @@ func noSuchCommandHandler(conf config, allowedUsers map[string]bool) func(b *tg.
 			return
 		}
 
+		// custom commands of pipelines
+		if name, pipeline, exists := pipelineFor(conf, cmd); exists {
+			if isRefusingAPIRequest(b, conf, update, *message) {
+				return
+			}
+
+			runPipeline(b, client, conf, db, update, *message, name, pipeline, args)
+			return
+		}
+
 		chatID := message.Chat.ID
 		messageID := message.MessageID
 
-		msg := fmt.Sprintf(msgCmdNotSupported, cmd)
+		msg := fmt.Sprintf(localize(conf, *message, msgCmdNotSupported), cmd)
 		send(b, conf, msg, chatID, &messageID)
 	}
 }
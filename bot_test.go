@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// storage which only reports the number of tokens spent
+type tokensSpentStorage struct {
+	Storage
+
+	spent int64
+}
+
+// TokensSince returns the number of tokens spent.
+func (s tokensSpentStorage) TokensSince(since time.Time) (int64, error) {
+	return s.spent, nil
+}
+
+func TestWhoAmIStatus(t *testing.T) {
+	const userID = 12345
+	username := "user1"
+	update := tg.Update{
+		Message: &tg.Message{
+			From: &tg.User{ID: userID, Username: &username},
+			Chat: tg.Chat{ID: userID},
+		},
+	}
+	now := time.Now()
+	db := tokensSpentStorage{spent: 300}
+
+	// not allowed: only reported so
+	status := strings.Join(whoAmIStatus(config{}, db, update, userID, now), "\n")
+	if !strings.Contains(status, "Allowed: <b>false</b>") {
+		t.Errorf("expected not to be allowed, got: %s", status)
+	}
+	if strings.Contains(status, "Remaining") {
+		t.Errorf("expected no quota for users not allowed, got: %s", status)
+	}
+
+	// allowed, without limits
+	conf := config{AllowedTelegramUsers: []string{username}}
+	status = strings.Join(whoAmIStatus(conf, db, update, userID, now), "\n")
+	if !strings.Contains(status, "Allowed: <b>true</b>") {
+		t.Errorf("expected to be allowed, got: %s", status)
+	}
+	if !strings.Contains(status, "Remaining tokens of this month: <i>(no limit)</i>") ||
+		!strings.Contains(status, "Remaining requests: <i>(no limit)</i>") {
+		t.Errorf("expected no limits, got: %s", status)
+	}
+
+	// allowed, with a token budget and cooldowns
+	conf.TokenBudget = &tokenBudgetConfig{MonthlyTokens: 1000}
+	conf.AbuseCooldown = &abuseCooldownConfig{WindowMinutes: 10, MaxRequests: 5}
+	recordAbuseEvent(nil, conf, userID, username, abuseEventRequest)
+	recordAbuseEvent(nil, conf, userID, username, abuseEventRequest)
+	defer func() {
+		_abuseRecordsLock.Lock()
+		delete(_abuseRecords, userID)
+		_abuseRecordsLock.Unlock()
+	}()
+
+	status = strings.Join(whoAmIStatus(conf, db, update, userID, time.Now()), "\n")
+	if !strings.Contains(status, "Remaining tokens of this month: <b>700</b> / 1000") {
+		t.Errorf("expected 700 remaining tokens, got: %s", status)
+	}
+	if !strings.Contains(status, "Remaining requests: <b>3</b> / 5 (in 10 minutes)") {
+		t.Errorf("expected 3 remaining requests, got: %s", status)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+// botrelay.go
+//
+// guarding against runaway conversations with other bots
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	botMessagesMaxTurnsDefault      = 5
+	botMessagesWindowMinutesDefault = 10
+	botMessagesPauseMinutesDefault  = 30
+
+	msgBotLoopDetected = "🔁 Answered <b>%d</b> messages of bots in a row in chat <b>%s</b> within %d minutes, so answering bots there is paused for %d minutes (or until someone else sends a message)."
+)
+
+// botMessagesConfig struct for answering messages authored by other bots
+type botMessagesConfig struct {
+	Answer        bool `json:"answer,omitempty"`         // answer messages of other bots (ignored if false)
+	MaxTurns      int  `json:"max_turns,omitempty"`      // max number of answers to bots in a chat without messages of users in the window (default: 5)
+	WindowMinutes int  `json:"window_minutes,omitempty"` // answers are counted in this sliding window (default: 10)
+	PauseMinutes  int  `json:"pause_minutes,omitempty"`  // duration of pauses when a loop is detected (default: 30)
+}
+
+// botTurnsRecord struct for recent answers to bots in a chat
+type botTurnsRecord struct {
+	answered    []time.Time
+	pausedUntil time.Time
+}
+
+// recent answers to bots, keyed by chat ids
+var _botTurns = map[int64]*botTurnsRecord{}
+var _botTurnsLock sync.Mutex
+
+// get the max number of turns, the window, and the duration of pauses from config (or the default ones)
+func botMessagesLimits(conf botMessagesConfig) (maxTurns int, window, pause time.Duration) {
+	orDefault := func(value, def int) int {
+		if value > 0 {
+			return value
+		}
+		return def
+	}
+
+	return orDefault(conf.MaxTurns, botMessagesMaxTurnsDefault),
+		time.Duration(orDefault(conf.WindowMinutes, botMessagesWindowMinutesDefault)) * time.Minute,
+		time.Duration(orDefault(conf.PauseMinutes, botMessagesPauseMinutesDefault)) * time.Minute
+}
+
+// checks if given message was authored by a bot
+//
+// (messages sent by users via inline bots are not)
+func isBotAuthored(message tg.Message) bool {
+	return message.From != nil && message.From.IsBot
+}
+
+// checks if given message is a post of a channel which the bot answers (with `channel_behaviors`),
+// automatically forwarded to its linked discussion group
+//
+// (the post, or the bot's answer to it, would be answered again in the group)
+func isForwardedChannelPost(conf config, message tg.Message) bool {
+	if !message.IsAutomaticForward || message.SenderChat == nil {
+		return false
+	}
+
+	_, exists := conf.ChannelBehaviors[strconv.FormatInt(message.SenderChat.ID, 10)]
+	return exists
+}
+
+// checks if given message should be ignored for preventing bot-to-bot loops
+//
+// (messages of users reset the count of answers to bots in the chat)
+func isBotLoopGuarded(bot *tg.Bot, conf config, message tg.Message) bool {
+	chatID := message.Chat.ID
+
+	if isForwardedChannelPost(conf, message) {
+		if isVerbose() {
+			log.Printf("[verbose] ignoring channel post forwarded to chat %d", chatID)
+		}
+		return true
+	}
+
+	if !isBotAuthored(message) {
+		_botTurnsLock.Lock()
+		if record, exists := _botTurns[chatID]; exists {
+			record.answered = nil
+			record.pausedUntil = time.Time{}
+		}
+		_botTurnsLock.Unlock()
+
+		return false
+	}
+
+	if conf.BotMessages == nil || !conf.BotMessages.Answer {
+		if isVerbose() {
+			log.Printf("[verbose] ignoring message of bot %s in chat %d", userName(message.From), chatID)
+		}
+		return true
+	}
+
+	maxTurns, window, pause := botMessagesLimits(*conf.BotMessages)
+	now := time.Now()
+
+	_botTurnsLock.Lock()
+	record, exists := _botTurns[chatID]
+	if !exists {
+		record = &botTurnsRecord{}
+		_botTurns[chatID] = record
+	}
+	if now.Before(record.pausedUntil) {
+		_botTurnsLock.Unlock()
+		return true
+	}
+
+	// keep answers in the window only
+	var answered []time.Time
+	for _, t := range record.answered {
+		if now.Sub(t) < window {
+			answered = append(answered, t)
+		}
+	}
+
+	detected := len(answered) >= maxTurns
+	if detected {
+		record.answered = nil
+		record.pausedUntil = now.Add(pause)
+	} else {
+		record.answered = append(answered, now)
+	}
+	_botTurnsLock.Unlock()
+
+	if detected {
+		logInfo("bot loop detected in chat %d, pausing answers to bots for %s", chatID, pause)
+
+		notifyAdmin(bot, conf, fmt.Sprintf(msgBotLoopDetected,
+			maxTurns,
+			html.EscapeString(message.Chat.String()),
+			int(window.Minutes()),
+			int(pause.Minutes())))
+	}
+
+	return detected
+}
@@ -0,0 +1,142 @@
+package main
+
+// budget.go
+//
+// alerting admins when monthly token spend crosses thresholds of a budget
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	budgetCheckInterval = 10 * time.Minute
+
+	settingKeyTokenBudgetAlerted = "token_budget_alerted" // eg. "2024-01:80"
+
+	budgetMonthFormat = "2006-01"
+
+	msgTokenBudgetAlert = "⚠️ Token spend of this month (%s) reached <b>%d%%</b> of the budget: <b>%d</b> / %d tokens."
+)
+
+// default thresholds (in percents) of token budget alerts
+var tokenBudgetAlertPercentsDefault = []int{50, 80, 100}
+
+// tokenBudgetConfig struct for alerting admins on monthly token spend
+type tokenBudgetConfig struct {
+	MonthlyTokens int64 `json:"monthly_tokens"`           // budget of tokens (prompts + completions) for each month
+	AlertPercents []int `json:"alert_percents,omitempty"` // thresholds of the budget in percents (default: [50, 80, 100])
+}
+
+// alert the admin chat when the monthly token spend crosses thresholds of `token_budget`
+//
+// (the last alerted threshold of the month is persisted, so each threshold is alerted only once a month)
+func alertTokenBudgetPeriodically(bot *tg.Bot, db Storage) {
+	if db == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(budgetCheckInterval)
+		defer ticker.Stop()
+
+		for ; true; <-ticker.C {
+			conf := currentConfig()
+			if conf.TokenBudget == nil || conf.TokenBudget.MonthlyTokens <= 0 || conf.AdminChatID == 0 {
+				continue
+			}
+
+			checkTokenBudget(bot, conf, db, time.Now().In(botTimezone(conf)))
+		}
+	}()
+}
+
+// check the token spend of the month of `now`, and alert the admin chat if it crossed a new threshold
+func checkTokenBudget(bot *tg.Bot, conf config, db Storage, now time.Time) {
+	budget := *conf.TokenBudget
+
+	monthStart := startOfMonth(now)
+	month := monthStart.Format(budgetMonthFormat)
+
+	spent, err := db.TokensSince(monthStart)
+	if err != nil {
+		log.Printf("failed to get tokens of this month: %s", err)
+		return
+	}
+
+	// the highest threshold crossed
+	crossed := 0
+	for _, percent := range tokenBudgetAlertPercents(budget) {
+		if percent > crossed && spent*100 >= budget.MonthlyTokens*int64(percent) {
+			crossed = percent
+		}
+	}
+	if crossed == 0 || crossed <= lastAlertedPercent(db, month) {
+		return
+	}
+
+	notifyAdmin(bot, conf, fmt.Sprintf(msgTokenBudgetAlert, month, crossed, spent, budget.MonthlyTokens))
+
+	logInfo("token budget alert: %d%% of %d tokens in %s", crossed, budget.MonthlyTokens, month)
+
+	if err := db.SetSetting(settingKeyTokenBudgetAlerted, fmt.Sprintf("%s:%d", month, crossed)); err != nil {
+		log.Printf("failed to save the last token budget alert: %s", err)
+	}
+}
+
+// get the number of tokens remaining in the budget of the month of `now`
+//
+// (`limited` is false if `token_budget` is not configured; can be negative when the budget is overspent)
+func remainingTokens(conf config, db Storage, now time.Time) (remaining int64, limited bool, err error) {
+	if conf.TokenBudget == nil || conf.TokenBudget.MonthlyTokens <= 0 {
+		return 0, false, nil
+	}
+	if db == nil {
+		return 0, true, fmt.Errorf("database not configured")
+	}
+
+	var spent int64
+	if spent, err = db.TokensSince(startOfMonth(now)); err != nil {
+		return 0, true, err
+	}
+
+	return conf.TokenBudget.MonthlyTokens - spent, true, nil
+}
+
+// get the start of the month of given time
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// get the thresholds of token budget alerts from config, or the default ones
+func tokenBudgetAlertPercents(budget tokenBudgetConfig) []int {
+	if len(budget.AlertPercents) > 0 {
+		return budget.AlertPercents
+	}
+
+	return tokenBudgetAlertPercentsDefault
+}
+
+// get the threshold last alerted in given month (0 if none)
+func lastAlertedPercent(db Storage, month string) int {
+	value, err := db.GetSetting(settingKeyTokenBudgetAlerted)
+	if err != nil {
+		return 0
+	}
+
+	alertedMonth, percent, found := strings.Cut(value, ":")
+	if !found || alertedMonth != month {
+		return 0
+	}
+
+	if alerted, err := strconv.Atoi(percent); err == nil {
+		return alerted
+	}
+
+	return 0
+}
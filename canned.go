@@ -0,0 +1,48 @@
+package main
+
+// canned.go
+//
+// operator-defined canned responses to frequently asked questions, answered without the API
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+const (
+	cannedPlaceholderModel = "{model}" // replaced with the model of the chat
+)
+
+// cannedResponse struct for a trigger and its response
+type cannedResponse struct {
+	Trigger  string `json:"trigger"`         // the whole message (case-insensitively, trimmed), or a regular expression
+	Regex    bool   `json:"regex,omitempty"` // whether `trigger` is a regular expression (eg. "(?i)who (runs|made) this bot")
+	Response string `json:"response"`        // in HTML, `{model}` is replaced with the model of the chat
+}
+
+// find the canned response for given text (false if no trigger matches)
+//
+// (triggers are tried in the order of config, and invalid regular expressions are skipped)
+func findCannedResponse(conf config, text, model string) (response string, found bool) {
+	text = strings.TrimSpace(text)
+
+	for _, canned := range conf.CannedResponses {
+		matched := false
+		if canned.Regex {
+			if re, err := regexp.Compile(canned.Trigger); err == nil {
+				matched = re.MatchString(text)
+			} else {
+				log.Printf("invalid trigger of canned response '%s': %s", canned.Trigger, err)
+			}
+		} else {
+			matched = strings.EqualFold(strings.TrimSpace(canned.Trigger), text)
+		}
+
+		if matched {
+			return strings.ReplaceAll(canned.Response, cannedPlaceholderModel, model), true
+		}
+	}
+
+	return "", false
+}
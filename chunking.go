@@ -0,0 +1,275 @@
+package main
+
+// chunking.go
+//
+// condensing large documents by extracting their chunks concurrently
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	documentChunkRunesDefault   = 8000
+	documentChunkWorkersDefault = 4
+
+	chunkProgressInterval = 2 * time.Second // min interval between progress updates
+
+	promptKindExtraction = "extraction" // kind of logged prompts for extracting chunks of large documents
+
+	systemPromptExtractChunk = `Extract all the key information from the following part of a document, concisely, keeping facts, numbers, and names as they are.`
+
+	msgChunkProgress = "Processing chunk %d/%d…"
+	msgChunkDone     = "Processed %d chunks of the document."
+	msgChunkedPrompt = "(The following is extracted from a large document in %d parts.)\n\n%s"
+)
+
+// condense user messages which are too large (eg. from big documents) by extracting their chunks concurrently
+//
+// (progress updates will be sent to the chat, and extractions are logged as the user's prompts of kind `extraction`)
+//
+// (should be called in the queued answer path, eg. in the function passed to `queueAnswer`)
+func condenseLargeMessages(bot *tg.Bot, client *openai.Client, conf config, db Storage, model string, directives messageDirectives, messages []openai.ChatMessage, chatID, userID int64, username string, messageID int64) []openai.ChatMessage {
+	// not to save extractions with `!nolog`, or in private mode (same as answers)
+	logDB := db
+	if directives.NoLog || isPrivateModeOn(db, userID) {
+		logDB = nil
+	}
+
+	// prompt of extractions to be logged
+	//
+	// (without the message id, not to be taken for the answer of the message)
+	logged := Prompt{
+		Kind:     promptKindExtraction,
+		ChatID:   chatID,
+		UserID:   userID,
+		Username: username,
+	}
+
+	chunkRunes := conf.DocumentChunkRunes
+	if chunkRunes <= 0 {
+		chunkRunes = documentChunkRunesDefault
+	}
+
+	for i, message := range messages {
+		if message.Role != openai.ChatMessageRoleUser {
+			continue
+		}
+
+		content, err := message.ContentString()
+		if err != nil || len([]rune(content)) <= chunkRunes*2 {
+			continue
+		}
+
+		if condensed, err := condenseText(bot, client, conf, logDB, model, content, chunkRunes, logged, messageID); err == nil {
+			messages[i] = openai.NewChatUserMessage(condensed)
+		} else {
+			log.Printf("failed to condense a large message, using it as it is: %s", err)
+		}
+	}
+
+	return messages
+}
+
+// split given text into chunks, extract them concurrently with a bounded worker pool, and join the results
+func condenseText(bot *tg.Bot, client *openai.Client, conf config, logDB Storage, model, text string, chunkRunes int, logged Prompt, messageID int64) (condensed string, err error) {
+	chatID := logged.ChatID
+
+	workers := conf.DocumentChunkWorkers
+	if workers <= 0 {
+		workers = documentChunkWorkersDefault
+	}
+
+	chunks := splitIntoChunks(text, chunkRunes)
+	results := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+
+	progress := newChunkProgress(bot, chatID, messageID, len(chunks))
+
+	stopTyping := keepChatAction(bot, chatID, responseText)
+	defer stopTyping()
+
+	var wg sync.WaitGroup
+	indices := make(chan int)
+	for w := 0; w < min(workers, len(chunks)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indices {
+				results[i], errs[i] = extractChunk(bot, client, conf, logDB, model, chunks[i], logged)
+				progress.done()
+			}
+		}()
+	}
+	for i := range chunks {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	progress.finish()
+
+	parts := []string{}
+	for i, result := range results {
+		if errs[i] != nil {
+			return "", fmt.Errorf("failed to extract chunk %d/%d: %w", i+1, len(chunks), errs[i])
+		}
+		parts = append(parts, fmt.Sprintf("[part %d/%d]\n%s", i+1, len(chunks), result))
+	}
+
+	return fmt.Sprintf(msgChunkedPrompt, len(chunks), strings.Join(parts, "\n\n")), nil
+}
+
+// extract key information from a chunk, and save it to logs database (as given prompt)
+func extractChunk(bot *tg.Bot, client *openai.Client, conf config, logDB Storage, model, chunk string, logged Prompt) (string, error) {
+	messages := []openai.ChatMessage{
+		openai.NewChatSystemMessage(systemPromptExtractChunk),
+		openai.NewChatUserMessage(chunk),
+	}
+
+	logged.Question = chunk
+	logged.Text = messagesToPrompt(messages)
+
+	response, err := createChatCompletion(client, model, messageDirectives{}, messages, logged.UserID)
+	if err != nil {
+		log.Printf("failed to extract chunk (%s): %s", classifyError(err), err)
+
+		if event, caused := abuseEventOfError(err); caused {
+			recordAbuseEvent(bot, conf, logged.UserID, "", event)
+		}
+
+		// save to database (error)
+		savePromptAndResult(logDB, false, &logged, 0, Generated{
+			ChatModel:  model,
+			Successful: false,
+			Text:       err.Error(),
+		})
+
+		return "", err
+	}
+
+	logInfo("chat completion for extraction: %s (model: %s, finish reason: %s)", response.ID, model, finishReasonOf(response))
+
+	var extracted, finishReason string
+	if len(response.Choices) > 0 {
+		finishReason = response.Choices[0].FinishReason
+		extracted, err = response.Choices[0].Message.ContentString()
+	} else {
+		err = fmt.Errorf("no choice in response")
+	}
+
+	// save to database (with the tokens used, even if it failed)
+	result := Generated{
+		ChatModel:    model,
+		Successful:   err == nil,
+		Text:         extracted,
+		Tokens:       uint(response.Usage.CompletionTokens),
+		CompletionID: response.ID,
+		FinishReason: finishReason,
+	}
+	if err != nil {
+		result.Text = err.Error()
+	}
+	savePromptAndResult(logDB, false, &logged, uint(response.Usage.PromptTokens), result)
+
+	return extracted, err
+}
+
+// split given text into chunks of at most `chunkRunes` runes, preferably on line breaks
+func splitIntoChunks(text string, chunkRunes int) (chunks []string) {
+	var current []rune
+	for _, line := range strings.SplitAfter(text, "\n") {
+		runes := []rune(line)
+
+		if len(current)+len(runes) > chunkRunes && len(current) > 0 {
+			chunks = append(chunks, string(current))
+			current = nil
+		}
+
+		// split lines which are too long by themselves
+		for len(runes) > chunkRunes {
+			chunks = append(chunks, string(runes[:chunkRunes]))
+			runes = runes[chunkRunes:]
+		}
+
+		current = append(current, runes...)
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, string(current))
+	}
+
+	return chunks
+}
+
+// chunkProgress struct for sending progress updates of chunk processing to the chat
+type chunkProgress struct {
+	sync.Mutex
+
+	bot       *tg.Bot
+	chatID    int64
+	messageID *int64 // id of the progress message
+
+	total   int
+	count   int
+	updated time.Time
+}
+
+// send a progress message to the chat, and return a new progress
+func newChunkProgress(bot *tg.Bot, chatID, replyTo int64, total int) *chunkProgress {
+	p := &chunkProgress{
+		bot:     bot,
+		chatID:  chatID,
+		total:   total,
+		updated: time.Now(),
+	}
+
+	if res := bot.SendMessage(chatID, fmt.Sprintf(msgChunkProgress, 0, total), tg.OptionsSendMessage{}.
+		SetReplyParameters(tg.ReplyParameters{MessageID: replyTo})); res.Ok {
+		p.messageID = &res.Result.MessageID
+	} else {
+		log.Printf("failed to send progress message: %s", *res.Description)
+	}
+
+	return p
+}
+
+// mark a chunk as done, and update the progress message (throttled)
+func (p *chunkProgress) done() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.count++
+	if time.Since(p.updated) >= chunkProgressInterval {
+		p.edit(fmt.Sprintf(msgChunkProgress, p.count, p.total))
+		p.updated = time.Now()
+	}
+}
+
+// mark all chunks as done
+func (p *chunkProgress) finish() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.edit(fmt.Sprintf(msgChunkDone, p.total))
+}
+
+// edit the progress message
+//
+// (should be called with the lock held)
+func (p *chunkProgress) edit(text string) {
+	if p.messageID == nil {
+		return
+	}
+
+	if res := p.bot.EditMessageText(text, tg.OptionsEditMessageText{}.
+		SetIDs(p.chatID, *p.messageID)); !res.Ok {
+		log.Printf("failed to update progress message: %s", *res.Description)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+// completion_webhook.go
+//
+// posting results of chat completions to an outbound webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// completionWebhookPayload struct for payloads posted to the completion webhook
+type completionWebhookPayload struct {
+	ChatID   int64  `json:"chat_id"`
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+
+	Prompt       string `json:"prompt"`
+	PromptTokens uint   `json:"prompt_tokens"`
+
+	Answer       string `json:"answer"`
+	AnswerTokens uint   `json:"answer_tokens"`
+	Successful   bool   `json:"successful"`
+	Model        string `json:"model,omitempty"`
+	CompletionID string `json:"completion_id,omitempty"`
+
+	LatencyMillis int64     `json:"latency_ms"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// post given prompt (and its result) to the completion webhook (if configured), will timeout in 10 seconds
+func postCompletionWebhook(conf config, prompt Prompt, latency time.Duration) {
+	if conf.CompletionWebhookURL == "" {
+		return
+	}
+
+	data, err := json.Marshal(completionWebhookPayload{
+		ChatID:        prompt.ChatID,
+		UserID:        prompt.UserID,
+		Username:      prompt.Username,
+		Prompt:        prompt.Text,
+		PromptTokens:  prompt.Tokens,
+		Answer:        prompt.Result.Text,
+		AnswerTokens:  prompt.Result.Tokens,
+		Successful:    prompt.Result.Successful,
+		Model:         prompt.Result.ChatModel,
+		CompletionID:  prompt.Result.CompletionID,
+		LatencyMillis: latency.Milliseconds(),
+		Timestamp:     time.Now(),
+	})
+	if err != nil {
+		log.Printf("failed to marshal completion webhook payload: %s", err)
+		return
+	}
+
+	httpClient := newHTTPClient(time.Second * 10)
+
+	var resp *http.Response
+	if resp, err = httpClient.Post(conf.CompletionWebhookURL, "application/json", bytes.NewReader(data)); err != nil {
+		log.Printf("failed to post to completion webhook: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Printf("failed to post to completion webhook: http %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+// confirm.go
+//
+// asking for confirmation before sending requests (eg. expensive ones)
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	pendingRequestTTL = 10 * time.Minute // how long requests wait for confirmation
+
+	callbackDataPrefixConfirm = "confirm:" // + pending request id
+	callbackDataPrefixCancel  = "cancel:"  // + pending request id
+
+	msgConfirmTokens       = "This will use ~%s tokens, continue?"
+	msgConfirmButtonOK     = "✅ Continue"
+	msgConfirmButtonCancel = "❌ Cancel"
+	msgConfirmContinued    = "Continuing with ~%s tokens..."
+	msgConfirmCancelled    = "Cancelled."
+	msgConfirmExpired      = "This request has expired. Please send it again."
+	msgConfirmNotRequester = "Only the requester can answer this."
+	msgConfirmUnknown      = "Unknown callback."
+)
+
+// pendingRequest struct for requests waiting for confirmation
+type pendingRequest struct {
+	userID    int64
+	continued string // message shown when confirmed
+	run       func()
+	expires   time.Time
+}
+
+// requests waiting for confirmation, keyed by their ids
+var _pendingRequests = map[string]pendingRequest{}
+var _pendingRequestsLock sync.Mutex
+var _pendingRequestsSeq int64
+
+// checks if a request with given number of tokens needs confirmation
+func needsConfirmation(conf config, tokens int) bool {
+	return conf.ConfirmTokensThreshold > 0 && tokens > conf.ConfirmTokensThreshold
+}
+
+// wrap given function which sends `request`, for asking confirmation (with `ask`) before running it if the request needs one
+//
+// (nothing should be sent to the API before `run` is called)
+func withConfirmation(conf config, model string, request []openai.ChatMessage, run func(), ask func(tokens int, confirmed func())) func() {
+	if tokens, err := countRequestTokens(model, request); err == nil && needsConfirmation(conf, tokens) {
+		return func() {
+			ask(tokens, run)
+		}
+	}
+
+	return run
+}
+
+// ask the user for confirmation of a request with given number of tokens,
+// `run` will be called when confirmed
+func askConfirmation(bot *tg.Bot, chatID, messageID, userID int64, tokens int, run func()) {
+	askToContinue(bot, chatID, messageID, userID,
+		fmt.Sprintf(msgConfirmTokens, formatNumber(tokens)),
+		msgConfirmButtonOK,
+		fmt.Sprintf(msgConfirmContinued, formatNumber(tokens)),
+		run)
+}
+
+// ask the user with `question` (as a reply to given message) and buttons for continuing or cancelling,
+// `run` will be called when continued
+func askToContinue(bot *tg.Bot, chatID, replyTo, userID int64, question, button, continued string, run func()) {
+	_pendingRequestsLock.Lock()
+	now := time.Now()
+	for id, pending := range _pendingRequests {
+		if now.After(pending.expires) {
+			delete(_pendingRequests, id)
+		}
+	}
+	_pendingRequestsSeq++
+	id := strconv.FormatInt(_pendingRequestsSeq, 10)
+	_pendingRequests[id] = pendingRequest{
+		userID:    userID,
+		continued: continued,
+		run:       run,
+		expires:   now.Add(pendingRequestTTL),
+	}
+	_pendingRequestsLock.Unlock()
+
+	confirm, cancel := callbackDataPrefixConfirm+id, callbackDataPrefixCancel+id
+	if res := bot.SendMessage(chatID, question, tg.OptionsSendMessage{}.
+		SetReplyParameters(tg.ReplyParameters{MessageID: replyTo}).
+		SetReplyMarkup(tg.InlineKeyboardMarkup{
+			InlineKeyboard: [][]tg.InlineKeyboardButton{{
+				{Text: button, CallbackData: &confirm},
+				{Text: msgConfirmButtonCancel, CallbackData: &cancel},
+			}},
+		})); !res.Ok {
+		log.Printf("failed to ask for confirmation: %s", *res.Description)
+	}
+}
+
+// handle a callback query from the inline keyboard of confirmation (or of the wizard)
+func handleCallbackQuery(bot *tg.Bot, db Storage, query tg.CallbackQuery) {
+	var data string
+	if query.Data != nil {
+		data = *query.Data
+	}
+
+	if rest, ok := strings.CutPrefix(data, callbackDataPrefixWizard); ok {
+		handleWizardCallback(bot, db, query, rest)
+		return
+	}
+	if rest, ok := strings.CutPrefix(data, callbackDataPrefixModel); ok {
+		handleModelCallback(bot, db, query, rest)
+		return
+	}
+
+	var id string
+	var confirmed bool
+	if rest, ok := strings.CutPrefix(data, callbackDataPrefixConfirm); ok {
+		id, confirmed = rest, true
+	} else if rest, ok := strings.CutPrefix(data, callbackDataPrefixCancel); ok {
+		id = rest
+	} else {
+		answerCallbackQuery(bot, query, msgConfirmUnknown)
+		return
+	}
+
+	_pendingRequestsLock.Lock()
+	pending, exists := _pendingRequests[id]
+	if exists && pending.userID != query.From.ID {
+		_pendingRequestsLock.Unlock()
+
+		answerCallbackQuery(bot, query, msgConfirmNotRequester)
+		return
+	}
+	delete(_pendingRequests, id)
+	_pendingRequestsLock.Unlock()
+
+	exists = exists && time.Now().Before(pending.expires)
+
+	var msg string
+	if !exists {
+		msg = msgConfirmExpired
+	} else if confirmed {
+		msg = pending.continued
+	} else {
+		msg = msgConfirmCancelled
+	}
+
+	answerCallbackQuery(bot, query, "")
+
+	// replace the confirmation message (and remove its keyboard)
+	if query.Message != nil {
+		if res := bot.EditMessageText(msg, tg.OptionsEditMessageText{}.
+			SetIDs(query.Message.Chat.ID, query.Message.MessageID)); !res.Ok {
+			log.Printf("failed to edit confirmation message: %s", *res.Description)
+		}
+	}
+
+	if exists && confirmed {
+		pending.run()
+	}
+}
+
+// answer a callback query, with an optional text
+func answerCallbackQuery(bot *tg.Bot, query tg.CallbackQuery, text string) {
+	options := tg.OptionsAnswerCallbackQuery{}
+	if text != "" {
+		options = options.SetText(text)
+	}
+
+	if res := bot.AnswerCallbackQuery(query.ID, options); !res.Ok {
+		log.Printf("failed to answer callback query: %s", *res.Description)
+	}
+}
+
+// format given number with thousands separators (eg. 12,000)
+func formatNumber(n int) string {
+	str := strconv.Itoa(n)
+
+	var sb strings.Builder
+	for i, r := range str {
+		if i > 0 && (len(str)-i)%3 == 0 {
+			sb.WriteRune(',')
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
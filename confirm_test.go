@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	openai "github.com/meinside/openai-go"
+)
+
+func TestWithConfirmation(t *testing.T) {
+	// a large document, which would be condensed with completions when answered
+	request := []openai.ChatMessage{
+		openai.NewChatUserMessage(strings.Repeat("a large document. ", 10000)),
+	}
+
+	completions := 0
+	run := func() { completions++ }
+
+	// expensive request: asked first, and no completion is made while it awaits confirmation
+	var askedTokens int
+	var confirmed func()
+	wrapped := withConfirmation(config{ConfirmTokensThreshold: 1}, "gpt-4o", request, run, func(tokens int, run func()) {
+		askedTokens, confirmed = tokens, run
+	})
+	wrapped()
+	if completions != 0 {
+		t.Errorf("expected no completion while awaiting confirmation, got %d", completions)
+	}
+	if confirmed == nil {
+		t.Fatalf("expected confirmation to be asked")
+	}
+	if expected, err := countRequestTokens("gpt-4o", request); err != nil || askedTokens != expected {
+		t.Errorf("expected tokens of the original request (%d) to be asked, got %d", expected, askedTokens)
+	}
+	confirmed()
+	if completions != 1 {
+		t.Errorf("expected a completion after confirmed, got %d", completions)
+	}
+
+	// cheap request (or no threshold): run without asking
+	for _, conf := range []config{{ConfirmTokensThreshold: 1_000_000_000}, {}} {
+		completions = 0
+		withConfirmation(conf, "gpt-4o", request, run, func(int, func()) {
+			t.Errorf("expected no confirmation with threshold %d", conf.ConfirmTokensThreshold)
+		})()
+		if completions != 1 {
+			t.Errorf("expected a completion without confirmation, got %d", completions)
+		}
+	}
+}
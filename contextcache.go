@@ -0,0 +1,364 @@
+package main
+
+// contextcache.go
+//
+// in-memory cache of active chats' contexts, writing through to the storage asynchronously
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	contextCacheSizeDefault = 100 // number of chats to cache
+	contextCacheMaxPrompts  = historyCountMax
+
+	contextCacheWriteQueueSize = 1000
+)
+
+// cachedContext struct for the cached context of a chat
+type cachedContext struct {
+	chatID int64
+
+	prompts      []Prompt // recent prompts, in chronological order
+	complete     bool     // whether all prompts of the chat are cached (none was left out, nor dropped)
+	conversation *Conversation
+}
+
+// get cached prompts of a conversation of this chat (false if some of them may not be cached)
+//
+// (all of them are cached when all prompts of the chat are, or it is the latest conversation
+// which began after the oldest cached prompt. cached ones are matched with their conversation ids,
+// as the ones which are not written yet have no ids of their own)
+func (c *cachedContext) conversationPrompts(conversationID uint) (prompts []Prompt, ok bool) {
+	isLatest := c.conversation != nil && c.conversation.ID == conversationID
+	for _, prompt := range c.prompts {
+		if prompt.ConversationID != nil && *prompt.ConversationID == conversationID {
+			prompts = append(prompts, prompt)
+		}
+	}
+	if !isLatest && len(prompts) <= 0 {
+		return nil, false // (a conversation of another chat)
+	}
+
+	if c.complete || (isLatest && (len(c.prompts) <= 0 || !c.conversation.CreatedAt.Before(c.prompts[0].CreatedAt))) {
+		return prompts, true
+	}
+
+	return nil, false
+}
+
+// cachedStorage struct which caches recent prompts and conversations of chats in a LRU,
+// and writes prompts through to the underlying storage asynchronously
+type cachedStorage struct {
+	Storage
+
+	sync.Mutex
+
+	size   int
+	chats  map[int64]*list.Element
+	recent *list.List // most recently used at front
+
+	writes  chan func()
+	sending sync.WaitGroup // writes being queued without the lock
+}
+
+// wrap given storage with a context cache of `size` chats
+//
+// (0 for the default size, negative for no cache)
+func newCachedStorage(storage Storage, size int) Storage {
+	if size < 0 {
+		return storage
+	} else if size == 0 {
+		size = contextCacheSizeDefault
+	}
+
+	s := &cachedStorage{
+		Storage: storage,
+		size:    size,
+		chats:   map[int64]*list.Element{},
+		recent:  list.New(),
+		writes:  make(chan func(), contextCacheWriteQueueSize),
+	}
+
+	// write through to the storage, in order
+	go func() {
+		for write := range s.writes {
+			write()
+		}
+	}()
+
+	return s
+}
+
+// wait for all queued writes to be done
+func (s *cachedStorage) flush() {
+	done := make(chan struct{})
+	s.writes <- func() { close(done) }
+	<-done
+}
+
+// queue given write after releasing the lock (which should be held)
+//
+// (not holding the lock while queueing, as it may block when the queue is full)
+func (s *cachedStorage) queueAndUnlock(write func()) {
+	s.sending.Add(1)
+	s.Unlock()
+
+	s.writes <- write
+	s.sending.Done()
+}
+
+// get the cached context of a chat, loading it from the storage if needed
+//
+// (should be called with the lock held)
+func (s *cachedStorage) context(chatID int64) (*cachedContext, error) {
+	if elem, exists := s.chats[chatID]; exists {
+		s.recent.MoveToFront(elem)
+		return elem.Value.(*cachedContext), nil
+	}
+
+	// wait for writes being queued, and then for all queued ones
+	s.sending.Wait()
+	s.flush()
+
+	prompts, err := s.Storage.RecentPrompts(chatID, contextCacheMaxPrompts)
+	if err != nil {
+		return nil, err
+	}
+	cached := &cachedContext{
+		chatID:   chatID,
+		prompts:  prompts,
+		complete: len(prompts) < contextCacheMaxPrompts,
+	}
+	if conversation, err := s.Storage.LatestConversation(chatID); err == nil {
+		cached.conversation = &conversation
+	}
+
+	s.chats[chatID] = s.recent.PushFront(cached)
+
+	// evict the least recently used one
+	if s.recent.Len() > s.size {
+		oldest := s.recent.Back()
+		s.recent.Remove(oldest)
+		delete(s.chats, oldest.Value.(*cachedContext).chatID)
+	}
+
+	return cached, nil
+}
+
+// SavePrompt caches `prompt` and saves it asynchronously.
+func (s *cachedStorage) SavePrompt(prompt Prompt) (err error) {
+	s.Lock()
+
+	// (for cached ones to have their times too)
+	if prompt.CreatedAt.IsZero() {
+		prompt.CreatedAt = time.Now()
+	}
+
+	if elem, exists := s.chats[prompt.ChatID]; exists {
+		cached := elem.Value.(*cachedContext)
+		cached.prompts = append(cached.prompts, prompt)
+		if len(cached.prompts) > contextCacheMaxPrompts {
+			cached.prompts = cached.prompts[len(cached.prompts)-contextCacheMaxPrompts:]
+			cached.complete = false
+		}
+	}
+
+	s.queueAndUnlock(func() {
+		if err := s.Storage.SavePrompt(prompt); err != nil {
+			log.Printf("failed to save prompt to database: %s", err)
+		}
+	})
+
+	return nil
+}
+
+// SaveAnswerVersion replaces the cached prompt with the same message id with `prompt`, and saves it as a new version asynchronously.
+func (s *cachedStorage) SaveAnswerVersion(prompt Prompt) (err error) {
+	s.Lock()
+
+	if prompt.CreatedAt.IsZero() {
+		prompt.CreatedAt = time.Now()
+	}
+
+	if elem, exists := s.chats[prompt.ChatID]; exists {
+		cached := elem.Value.(*cachedContext)
+
+		replaced := false
+		for i := len(cached.prompts) - 1; i >= 0; i-- {
+			if cached.prompts[i].MessageID == prompt.MessageID {
+				prompt.CreatedAt = cached.prompts[i].CreatedAt
+				cached.prompts[i] = prompt
+				replaced = true
+				break
+			}
+		}
+
+		// (not in the cache, so evict the chat for reloading it from the storage later)
+		if !replaced {
+			s.recent.Remove(elem)
+			delete(s.chats, prompt.ChatID)
+		}
+	}
+
+	s.queueAndUnlock(func() {
+		if err := s.Storage.SaveAnswerVersion(prompt); err != nil {
+			log.Printf("failed to save answer version to database: %s", err)
+		}
+	})
+
+	return nil
+}
+
+// RecentPrompts returns the last `n` prompts (with their results) of a chat, in chronological order.
+func (s *cachedStorage) RecentPrompts(chatID int64, n int) (prompts []Prompt, err error) {
+	if n > contextCacheMaxPrompts {
+		s.flush()
+		return s.Storage.RecentPrompts(chatID, n)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	var cached *cachedContext
+	if cached, err = s.context(chatID); err != nil {
+		return nil, err
+	}
+
+	start := max(len(cached.prompts)-n, 0)
+	return append([]Prompt{}, cached.prompts[start:]...), nil
+}
+
+// PromptByAnswerMessageID returns a prompt whose answer was sent as given telegram message.
+func (s *cachedStorage) PromptByAnswerMessageID(chatID, messageID int64) (prompt Prompt, err error) {
+	s.Lock()
+	if cached, err := s.context(chatID); err == nil {
+		for i := len(cached.prompts) - 1; i >= 0; i-- {
+			if cached.prompts[i].Result.MessageID == messageID {
+				s.Unlock()
+				return cached.prompts[i], nil
+			}
+		}
+	}
+	s.Unlock()
+
+	// not in the cache, maybe an old one
+	return s.Storage.PromptByAnswerMessageID(chatID, messageID)
+}
+
+// PinAnswer marks the answer sent as given telegram message as pinned.
+func (s *cachedStorage) PinAnswer(chatID, messageID int64) (err error) {
+	s.flush()
+	if err = s.Storage.PinAnswer(chatID, messageID); err != nil {
+		return err
+	}
+
+	s.Lock()
+	if elem, exists := s.chats[chatID]; exists {
+		cached := elem.Value.(*cachedContext)
+		for i := range cached.prompts {
+			if cached.prompts[i].Result.MessageID == messageID {
+				cached.prompts[i].Result.Pinned = true
+			}
+		}
+	}
+	s.Unlock()
+
+	return nil
+}
+
+// NewConversation creates a new conversation in a chat, branched from `parentID` if given.
+func (s *cachedStorage) NewConversation(chatID int64, parentID *uint) (conversation Conversation, err error) {
+	if conversation, err = s.Storage.NewConversation(chatID, parentID); err != nil {
+		return conversation, err
+	}
+
+	s.Lock()
+	if elem, exists := s.chats[chatID]; exists {
+		elem.Value.(*cachedContext).conversation = &conversation
+	}
+	s.Unlock()
+
+	return conversation, nil
+}
+
+// LatestConversation returns the latest conversation of a chat.
+func (s *cachedStorage) LatestConversation(chatID int64) (conversation Conversation, err error) {
+	s.Lock()
+	if cached, err := s.context(chatID); err == nil && cached.conversation != nil {
+		s.Unlock()
+		return *cached.conversation, nil
+	}
+	s.Unlock()
+
+	return s.Storage.LatestConversation(chatID)
+}
+
+// SetConversationTitle saves `title` of a conversation.
+func (s *cachedStorage) SetConversationTitle(conversationID uint, title string) (err error) {
+	if err = s.Storage.SetConversationTitle(conversationID, title); err != nil {
+		return err
+	}
+
+	s.Lock()
+	for _, elem := range s.chats {
+		if cached := elem.Value.(*cachedContext); cached.conversation != nil && cached.conversation.ID == conversationID {
+			cached.conversation.Title = title
+		}
+	}
+	s.Unlock()
+
+	return nil
+}
+
+// AllPrompts returns all prompts (with their results), in chronological order.
+func (s *cachedStorage) AllPrompts() (prompts []Prompt, err error) {
+	s.flush()
+	return s.Storage.AllPrompts()
+}
+
+// ConversationPrompts returns all prompts (with their results) of a conversation, in chronological order.
+//
+// (served from the cache without waiting for queued writes if all of them are cached,
+// so cached ones may not have ids nor previous versions of their answers yet)
+func (s *cachedStorage) ConversationPrompts(conversationID uint) (prompts []Prompt, err error) {
+	s.Lock()
+	for _, elem := range s.chats {
+		if prompts, ok := elem.Value.(*cachedContext).conversationPrompts(conversationID); ok {
+			s.Unlock()
+			return prompts, nil
+		}
+	}
+	s.Unlock()
+
+	s.flush()
+	return s.Storage.ConversationPrompts(conversationID)
+}
+
+// ChatIDs returns all distinct chat ids which have interacted with the bot.
+func (s *cachedStorage) ChatIDs() (chatIDs []int64, err error) {
+	s.flush()
+	return s.Storage.ChatIDs()
+}
+
+// Stats returns usage statistics.
+func (s *cachedStorage) Stats() (stats Stats, err error) {
+	s.flush()
+	return s.Storage.Stats()
+}
+
+// Query runs a read-only `query`, returning at most `maxRows` rows.
+func (s *cachedStorage) Query(ctx context.Context, query string, maxRows int) (result QueryResult, err error) {
+	s.flush()
+	return s.Storage.Query(ctx, query, maxRows)
+}
+
+// TokensSince returns the number of tokens generated since given time.
+func (s *cachedStorage) TokensSince(since time.Time) (tokens int64, err error) {
+	s.flush()
+	return s.Storage.TokensSince(since)
+}
@@ -0,0 +1,170 @@
+package main
+
+// conversation.go
+//
+// conversations and their branches, built from replies to answers
+
+import (
+	"log"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	threadHistoryTurnsDefault = 10 // max number of previous prompts & answers in the context of a reply (without `history_depth`)
+)
+
+// conversationThread struct for the context of a new prompt
+type conversationThread struct {
+	ConversationID  *uint                // conversation which the new prompt belongs to
+	ParentMessageID int64                // telegram message id of the answer which the new prompt replies to
+	History         []openai.ChatMessage // previous prompts & answers leading to the replied answer, in chronological order
+	New             bool                 // whether the conversation has just begun (or branched)
+	Expired         bool                 // whether the latest conversation was not continued, as the chat has been inactive for `context_ttl_minutes`
+	Source          string               // text of a long document which the new prompt is about (for quoting excerpts)
+}
+
+// get the thread of a new prompt in given chat
+//
+// (a reply to the latest answer of a conversation continues the conversation,
+// a reply to an older answer branches a new conversation from that point,
+// other replies continue the latest conversation of the chat,
+// and with `session_turns`, so do other messages with its latest turns
+// (unless the chat has been inactive for `context_ttl_minutes`);
+// otherwise a new conversation begins)
+func threadFor(conf config, db Storage, chatID int64, replyTo *tg.Message) (thread conversationThread) {
+	if db == nil {
+		return thread
+	}
+
+	if replyTo != nil {
+		if prompt, err := db.PromptByAnswerMessageID(chatID, replyTo.MessageID); err == nil {
+			thread.ParentMessageID = replyTo.MessageID
+			thread.History = threadHistory(conf, db, chatID, prompt)
+
+			if prompt.ConversationID != nil && isLatestAnswer(db, *prompt.ConversationID, replyTo.MessageID) {
+				thread.ConversationID = prompt.ConversationID
+				return thread
+			}
+
+			if conversation, err := db.NewConversation(chatID, prompt.ConversationID); err == nil {
+				thread.ConversationID = &conversation.ID
+				thread.New = true
+			} else {
+				log.Printf("failed to branch a conversation: %s", err)
+			}
+			return thread
+		}
+	}
+
+	if replyTo != nil || sessionTurns(conf, db, chatID) > 0 {
+		if isContextExpired(conf, db, chatID) {
+			thread.Expired = true
+		} else if conversation, err := db.LatestConversation(chatID); err == nil {
+			thread.ConversationID = &conversation.ID
+			if sessionTurns(conf, db, chatID) > 0 {
+				thread.History, thread.ParentMessageID, thread.New = sessionHistory(conf, db, chatID, conversation.ID)
+			}
+			return thread
+		}
+	}
+
+	if conversation, err := db.NewConversation(chatID, nil); err == nil {
+		thread.ConversationID = &conversation.ID
+		thread.New = true
+	} else {
+		log.Printf("failed to create a new conversation: %s", err)
+	}
+
+	return thread
+}
+
+// build chat messages of given prompt and its ancestors (up to the history depth of the chat), in chronological order
+func threadHistory(conf config, db Storage, chatID int64, prompt Prompt) (history []openai.ChatMessage) {
+	depth := historyDepth(conf, db, chatID)
+	for turns := 0; turns < depth; turns++ {
+		history = append([]openai.ChatMessage{
+			openai.NewChatUserMessage(questionOf(prompt)),
+			openai.NewChatAssistantMessage(prompt.Result.Text),
+		}, history...)
+
+		if prompt.ParentMessageID == 0 {
+			break
+		}
+
+		var err error
+		if prompt, err = db.PromptByAnswerMessageID(chatID, prompt.ParentMessageID); err != nil {
+			break
+		}
+	}
+
+	return history
+}
+
+// get the history of the thread which a reply to given message will belong to,
+// without creating any conversation (nil if it is not an answer)
+func replyHistory(conf config, db Storage, chatID int64, replyTo *tg.Message) []openai.ChatMessage {
+	if db == nil || replyTo == nil {
+		return nil
+	}
+
+	if prompt, err := db.PromptByAnswerMessageID(chatID, replyTo.MessageID); err == nil {
+		return threadHistory(conf, db, chatID, prompt)
+	}
+
+	return nil
+}
+
+// checks if the answer with given message id is the latest one in the conversation
+func isLatestAnswer(db Storage, conversationID uint, messageID int64) bool {
+	prompts, err := db.ConversationPrompts(conversationID)
+	if err != nil {
+		log.Printf("failed to retrieve prompts of conversation %d: %s", conversationID, err)
+		return true
+	}
+
+	var latest int64
+	for _, prompt := range prompts {
+		if prompt.Result.MessageID != 0 {
+			latest = prompt.Result.MessageID
+		}
+	}
+
+	return latest == messageID
+}
+
+// get the user's question of given prompt
+//
+// (prompts logged before questions were saved have only their whole texts)
+func questionOf(prompt Prompt) string {
+	if prompt.Question != "" {
+		return prompt.Question
+	}
+
+	return prompt.Text
+}
+
+// get the content of the last user message in given messages
+func lastUserContent(messages []openai.ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == openai.ChatMessageRoleUser {
+			if content, err := messages[i].ContentString(); err == nil {
+				return content
+			}
+		}
+	}
+
+	return ""
+}
+
+// build the whole request of new messages in this thread
+//
+// (the oldest history is dropped if it exceeds the max tokens of history in the chat,
+// or the request does not fit in the context window of given model)
+func (t conversationThread) request(conf config, db Storage, chatID int64, model string, messages []openai.ChatMessage) []openai.ChatMessage {
+	history := limitHistoryTokens(model, t.History, historyMaxTokens(conf, db, chatID))
+	request := append(append([]openai.ChatMessage{}, fitContextWindow(conf, model, history, messages)...), messages...)
+
+	return withHTMLAnswers(conf, withGlossary(db, chatID, withResponseLanguage(conf, db, chatID, withChatSystemPrompt(db, chatID, request))))
+}
@@ -1,12 +1,26 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"log"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+// Conversation struct for linking prompts into threads
+type Conversation struct {
+	gorm.Model
+
+	ChatID   int64  `gorm:"index"`
+	ParentID *uint  `gorm:"index"` // conversation which this one was branched from
+	Title    string // short title, generated from the first prompt
+
+	Prompts []Prompt
+}
+
 // Prompt struct
 type Prompt struct {
 	gorm.Model
@@ -15,8 +29,17 @@ type Prompt struct {
 	UserID   int64
 	Username string
 
-	Text   string
-	Tokens uint `gorm:"index"`
+	ConversationID *uint `gorm:"index"` // foreign key
+
+	MessageID       int64 `gorm:"index"` // telegram message id of the prompt
+	ParentMessageID int64 `gorm:"index"` // telegram message id of the answer which the prompt replied to
+
+	Question      string // text of the user's message (for rebuilding contexts)
+	Text          string
+	Tokens        uint   `gorm:"index"`
+	RequestTokens uint   // tokens of the whole request, counted locally before the api call
+	Route         string // provider and model which served the request (with `routing`)
+	Kind          string `gorm:"index"` // kind of the request (empty for chat completions, "image" for generated images)
 
 	Result Generated
 }
@@ -29,7 +52,116 @@ type Generated struct {
 	Text       string
 	Tokens     uint `gorm:"index"`
 
+	ChatModel    string `gorm:"index"` // chat completion model used for the answer
+	CompletionID string `gorm:"index"` // `id` of the chat completion response
+	FinishReason string
+
+	MessageID int64 `gorm:"index"` // telegram message id of the answer
+	Pinned    bool  `gorm:"index"` // pinned with /pin command
+
 	PromptID int64 // foreign key
+
+	Versions []GeneratedVersion // previous versions of the answer
+}
+
+// GeneratedVersion struct for previous versions of answers (eg. answers to edited messages)
+type GeneratedVersion struct {
+	gorm.Model
+
+	GeneratedID uint `gorm:"index"` // foreign key
+	Version     int  // 1 for the first answer, 2 for the next one, ...
+
+	Question   string // text of the user's message which was answered
+	Successful bool
+	Text       string
+	Tokens     uint
+
+	ChatModel    string
+	CompletionID string
+	FinishReason string
+
+	MessageID int64 `gorm:"index"` // telegram message id of the answer
+	Pinned    bool
+}
+
+// Feedback struct
+type Feedback struct {
+	gorm.Model
+
+	ChatID    int64 `gorm:"index"`
+	MessageID int64 `gorm:"index"` // telegram message id of the answer
+	UserID    int64
+	Username  string
+
+	Reaction string // eg. "👍", "👎"
+	Positive bool   `gorm:"index"`
+}
+
+// Referral struct for attributions of deep links
+type Referral struct {
+	gorm.Model
+
+	UserID   int64 `gorm:"index"`
+	Username string
+	Source   string `gorm:"index"`
+}
+
+// Setting struct for persisting bot-wide settings
+type Setting struct {
+	gorm.Model
+
+	Key   string `gorm:"uniqueIndex"`
+	Value string
+}
+
+// ArchivedImage struct for references of archived images
+type ArchivedImage struct {
+	gorm.Model
+
+	ChatID    int64 `gorm:"index"`
+	UserID    int64
+	MessageID int64  `gorm:"index"` // telegram message id of the received or generated image
+	Source    string `gorm:"index"` // "received" or "generated"
+
+	Location    string // file path, or s3 url
+	ContentType string
+	Size        int64
+}
+
+// ChatSetting struct for persisting per-chat settings
+type ChatSetting struct {
+	gorm.Model
+
+	ChatID int64  `gorm:"uniqueIndex:idx_chat_settings_chat_id_key"`
+	Key    string `gorm:"uniqueIndex:idx_chat_settings_chat_id_key"`
+	Value  string
+}
+
+// SavedPrompt struct for named prompts saved by users
+type SavedPrompt struct {
+	gorm.Model
+
+	UserID int64  `gorm:"uniqueIndex:idx_saved_prompts_user_id_name"`
+	Name   string `gorm:"uniqueIndex:idx_saved_prompts_user_id_name"`
+	Text   string
+}
+
+// models of all tables, in the order of their dependencies
+//
+// (new tables should be added here for being auto-migrated and copied with `migrate-db`)
+func databaseModels() []any {
+	return []any{
+		&Conversation{},
+		&Prompt{},
+		&Generated{},
+		&GeneratedVersion{},
+		&Feedback{},
+		&Setting{},
+		&ChatSetting{},
+		&Referral{},
+		&ArchivedImage{},
+		&SavedPrompt{},
+	}
 }
 
 // Database struct
@@ -46,10 +178,7 @@ func OpenDatabase(dbPath string) (database *Database, err error) {
 
 	if err == nil {
 		// migrate tables
-		if err := db.AutoMigrate(
-			&Prompt{},
-			&Generated{},
-		); err != nil {
+		if err := db.AutoMigrate(databaseModels()...); err != nil {
 			log.Printf("failed to migrate databases: %s", err)
 		}
 
@@ -59,8 +188,292 @@ func OpenDatabase(dbPath string) (database *Database, err error) {
 	return nil, err
 }
 
+// OpenReadOnlyDatabase opens and returns a database at given path: `dbPath` in read-only mode,
+// without migrating its tables (eg. for a replica).
+func OpenReadOnlyDatabase(dbPath string) (database *Database, err error) {
+	var db *gorm.DB
+	if db, err = gorm.Open(sqlite.Open(readOnlyDSN(dbPath)), &gorm.Config{
+		PrepareStmt: true,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &Database{db: db}, nil
+}
+
 // SavePrompt saves `prompt`.
 func (d *Database) SavePrompt(prompt Prompt) (err error) {
 	tx := d.db.Save(&prompt)
 	return tx.Error
 }
+
+// SaveAnswerVersion saves `prompt` as a new version of the prompt with the same chat id and message id,
+// keeping its previous answer in generated versions (or saves it as a new prompt if there is none).
+func (d *Database) SaveAnswerVersion(prompt Prompt) (err error) {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var existing Prompt
+		found := tx.Preload("Result").Where("chat_id = ? and message_id = ?", prompt.ChatID, prompt.MessageID).Order("id desc").Limit(1).Find(&existing)
+		if found.Error != nil {
+			return found.Error
+		}
+		if found.RowsAffected == 0 {
+			return tx.Save(&prompt).Error
+		}
+
+		// keep the previous answer
+		var versions int64
+		if err := tx.Model(&GeneratedVersion{}).Where("generated_id = ?", existing.Result.ID).Count(&versions).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&GeneratedVersion{
+			GeneratedID:  existing.Result.ID,
+			Version:      int(versions) + 1,
+			Question:     existing.Question,
+			Successful:   existing.Result.Successful,
+			Text:         existing.Result.Text,
+			Tokens:       existing.Result.Tokens,
+			ChatModel:    existing.Result.ChatModel,
+			CompletionID: existing.Result.CompletionID,
+			FinishReason: existing.Result.FinishReason,
+			MessageID:    existing.Result.MessageID,
+			Pinned:       existing.Result.Pinned,
+		}).Error; err != nil {
+			return err
+		}
+
+		// and replace it with the new one
+		if err := tx.Model(&existing).Updates(map[string]any{
+			"question":       prompt.Question,
+			"text":           prompt.Text,
+			"tokens":         prompt.Tokens,
+			"request_tokens": prompt.RequestTokens,
+			"route":          prompt.Route,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&existing.Result).Updates(map[string]any{
+			"successful":    prompt.Result.Successful,
+			"text":          prompt.Result.Text,
+			"tokens":        prompt.Result.Tokens,
+			"chat_model":    prompt.Result.ChatModel,
+			"completion_id": prompt.Result.CompletionID,
+			"finish_reason": prompt.Result.FinishReason,
+			"message_id":    prompt.Result.MessageID,
+			"pinned":        false,
+		}).Error
+	})
+}
+
+// SaveFeedback saves `feedback`.
+func (d *Database) SaveFeedback(feedback Feedback) (err error) {
+	tx := d.db.Save(&feedback)
+	return tx.Error
+}
+
+// DeleteFeedback deletes feedback of a user on a message.
+func (d *Database) DeleteFeedback(chatID, messageID, userID int64) (err error) {
+	tx := d.db.Where("chat_id = ? and message_id = ? and user_id = ?", chatID, messageID, userID).Delete(&Feedback{})
+	return tx.Error
+}
+
+// SaveArchivedImage saves a reference of an archived image.
+func (d *Database) SaveArchivedImage(image ArchivedImage) (err error) {
+	tx := d.db.Save(&image)
+	return tx.Error
+}
+
+// PinAnswer marks the answer sent as given telegram message as pinned.
+func (d *Database) PinAnswer(chatID, messageID int64) (err error) {
+	tx := d.db.Model(&Generated{}).
+		Where("message_id = ? and prompt_id in (?)", messageID, d.db.Model(&Prompt{}).Select("id").Where("chat_id = ?", chatID)).
+		Update("pinned", true)
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return tx.Error
+}
+
+// RecentPrompts returns the last `n` prompts (with their results) of a chat, in chronological order.
+func (d *Database) RecentPrompts(chatID int64, n int) (prompts []Prompt, err error) {
+	tx := d.db.Preload("Result").Where("chat_id = ?", chatID).Order("id desc").Limit(n).Find(&prompts)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	// reverse the order
+	for i, j := 0, len(prompts)-1; i < j; i, j = i+1, j-1 {
+		prompts[i], prompts[j] = prompts[j], prompts[i]
+	}
+
+	return prompts, nil
+}
+
+// AllPrompts returns all prompts (with their results), in chronological order.
+func (d *Database) AllPrompts() (prompts []Prompt, err error) {
+	tx := d.db.Preload("Result.Versions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("version asc")
+	}).Order("id asc").Find(&prompts)
+	return prompts, tx.Error
+}
+
+// AllFeedbacks returns all feedbacks, in chronological order.
+func (d *Database) AllFeedbacks() (feedbacks []Feedback, err error) {
+	tx := d.db.Order("id asc").Find(&feedbacks)
+	return feedbacks, tx.Error
+}
+
+// ChatIDs returns all distinct chat ids which have interacted with the bot.
+func (d *Database) ChatIDs() (chatIDs []int64, err error) {
+	tx := d.db.Model(&Prompt{}).Distinct("chat_id").Pluck("chat_id", &chatIDs)
+	return chatIDs, tx.Error
+}
+
+// GetSetting returns the value of a setting with given `key`.
+func (d *Database) GetSetting(key string) (value string, err error) {
+	var setting Setting
+	tx := d.db.Where("key = ?", key).First(&setting)
+	return setting.Value, tx.Error
+}
+
+// SetSetting saves `value` for a setting with given `key`.
+func (d *Database) SetSetting(key, value string) (err error) {
+	var setting Setting
+	tx := d.db.Where(Setting{Key: key}).Assign(Setting{Value: value}).FirstOrCreate(&setting)
+	return tx.Error
+}
+
+// GetChatSetting returns the value of a setting with given `key` for a chat.
+func (d *Database) GetChatSetting(chatID int64, key string) (value string, err error) {
+	var setting ChatSetting
+	tx := d.db.Where("chat_id = ? and key = ?", chatID, key).First(&setting)
+	return setting.Value, tx.Error
+}
+
+// SetChatSetting saves `value` for a setting with given `key` for a chat.
+func (d *Database) SetChatSetting(chatID int64, key, value string) (err error) {
+	var setting ChatSetting
+	tx := d.db.Where(ChatSetting{ChatID: chatID, Key: key}).Assign(ChatSetting{Value: value}).FirstOrCreate(&setting)
+	return tx.Error
+}
+
+// SaveUserPrompt saves `text` as a prompt of a user with given `name`, overwriting the existing one.
+func (d *Database) SaveUserPrompt(userID int64, name, text string) (err error) {
+	var saved SavedPrompt
+	tx := d.db.Where(SavedPrompt{UserID: userID, Name: name}).Assign(SavedPrompt{Text: text}).FirstOrCreate(&saved)
+	return tx.Error
+}
+
+// UserPrompt returns the text of a prompt of a user with given `name`.
+func (d *Database) UserPrompt(userID int64, name string) (text string, err error) {
+	var saved SavedPrompt
+	tx := d.db.Where("user_id = ? and name = ?", userID, name).First(&saved)
+	return saved.Text, tx.Error
+}
+
+// UserPromptNames returns the names of all prompts of a user.
+func (d *Database) UserPromptNames(userID int64) (names []string, err error) {
+	tx := d.db.Model(&SavedPrompt{}).Where("user_id = ?", userID).Order("name asc").Pluck("name", &names)
+	return names, tx.Error
+}
+
+// NewConversation creates a new conversation in a chat, branched from `parentID` if given.
+func (d *Database) NewConversation(chatID int64, parentID *uint) (conversation Conversation, err error) {
+	conversation = Conversation{ChatID: chatID, ParentID: parentID}
+	tx := d.db.Create(&conversation)
+	return conversation, tx.Error
+}
+
+// LatestConversation returns the latest conversation of a chat.
+func (d *Database) LatestConversation(chatID int64) (conversation Conversation, err error) {
+	tx := d.db.Where("chat_id = ?", chatID).Order("id desc").First(&conversation)
+	return conversation, tx.Error
+}
+
+// ConversationByID returns a conversation with given id.
+func (d *Database) ConversationByID(conversationID uint) (conversation Conversation, err error) {
+	tx := d.db.First(&conversation, conversationID)
+	return conversation, tx.Error
+}
+
+// SetConversationTitle saves `title` of a conversation.
+func (d *Database) SetConversationTitle(conversationID uint, title string) (err error) {
+	tx := d.db.Model(&Conversation{}).Where("id = ?", conversationID).Update("title", title)
+	return tx.Error
+}
+
+// ConversationPrompts returns all prompts (with their results) of a conversation, in chronological order.
+func (d *Database) ConversationPrompts(conversationID uint) (prompts []Prompt, err error) {
+	tx := d.db.Preload("Result.Versions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("version asc")
+	}).Where("conversation_id = ?", conversationID).Order("id asc").Find(&prompts)
+	return prompts, tx.Error
+}
+
+// PromptByAnswerMessageID returns a prompt whose answer was sent as given telegram message.
+func (d *Database) PromptByAnswerMessageID(chatID, messageID int64) (prompt Prompt, err error) {
+	tx := d.db.Joins("Result").Where("prompts.chat_id = ? and Result.message_id = ?", chatID, messageID).First(&prompt)
+	return prompt, tx.Error
+}
+
+// SaveReferral saves `referral`.
+func (d *Database) SaveReferral(referral Referral) (err error) {
+	tx := d.db.Save(&referral)
+	return tx.Error
+}
+
+// Stats returns usage statistics.
+func (d *Database) Stats() (stats Stats, err error) {
+	var prompt Prompt
+	if tx := d.db.First(&prompt); tx.Error == nil {
+		stats.Since = &prompt.CreatedAt
+	}
+
+	var sumAndCount struct {
+		Sum   int64
+		Count int64
+	}
+	if tx := d.db.Table("prompts").Select("count(distinct chat_id) as count").Scan(&stats.Chats); tx.Error != nil {
+		return stats, tx.Error
+	}
+	if tx := d.db.Table("prompts").Select("sum(tokens) as sum, count(id) as count").Where("tokens > 0").Scan(&sumAndCount); tx.Error != nil {
+		return stats, tx.Error
+	}
+	stats.Prompts, stats.PromptTokens = sumAndCount.Count, sumAndCount.Sum
+	if tx := d.db.Table("generateds").Select("sum(tokens) as sum, count(id) as count").Where("successful = 1").Scan(&sumAndCount); tx.Error != nil {
+		return stats, tx.Error
+	}
+	stats.Completions, stats.CompletionTokens = sumAndCount.Count, sumAndCount.Sum
+	if tx := d.db.Table("generateds").Select("count(id) as count").Where("successful = 0").Scan(&stats.Errors); tx.Error != nil {
+		return stats, tx.Error
+	}
+
+	var feedbacks struct {
+		Positive int64
+		Negative int64
+	}
+	if tx := d.db.Table("feedbacks").Select("sum(case when positive then 1 else 0 end) as positive, sum(case when positive then 0 else 1 end) as negative").Where("deleted_at is null").Scan(&feedbacks); tx.Error != nil {
+		return stats, tx.Error
+	}
+	stats.PositiveFeedbacks, stats.NegativeFeedbacks = feedbacks.Positive, feedbacks.Negative
+
+	return stats, nil
+}
+
+// Query runs a read-only `query`, returning at most `maxRows` rows.
+func (d *Database) Query(ctx context.Context, query string, maxRows int) (result QueryResult, err error) {
+	var db *sql.DB
+	if db, err = d.db.DB(); err != nil {
+		return result, err
+	}
+	return queryReadOnly(ctx, db, query, maxRows)
+}
+
+// TokensSince returns the number of tokens (of prompts and their completions) generated since given time.
+func (d *Database) TokensSince(since time.Time) (tokens int64, err error) {
+	tx := d.db.Table("generateds").
+		Select("coalesce(sum(prompts.tokens + generateds.tokens), 0)").
+		Joins("join prompts on prompts.id = generateds.prompt_id").
+		Where("generateds.deleted_at is null and prompts.deleted_at is null and generateds.created_at >= ?", since).
+		Scan(&tokens)
+	return tokens, tx.Error
+}
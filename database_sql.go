@@ -0,0 +1,617 @@
+package main
+
+// database_sql.go
+//
+// storage implementation with hand-written statements on database/sql
+//
+// (uses the same schema as the gorm one, so both can share a database file)
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// schema, compatible with the auto-migrated one of gorm
+var sqlSchema = []string{
+	`create table if not exists conversations (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, chat_id integer, parent_id integer, title text)`,
+	`create index if not exists idx_conversations_deleted_at on conversations(deleted_at)`,
+	`create index if not exists idx_conversations_chat_id on conversations(chat_id)`,
+
+	`create table if not exists prompts (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, chat_id integer, user_id integer, username text, conversation_id integer, message_id integer, parent_message_id integer, question text, text text, tokens integer, request_tokens integer, route text, kind text)`,
+	`create index if not exists idx_prompts_deleted_at on prompts(deleted_at)`,
+	`create index if not exists idx_prompts_chat_id on prompts(chat_id)`,
+	`create index if not exists idx_prompts_conversation_id on prompts(conversation_id)`,
+	`create index if not exists idx_prompts_message_id on prompts(message_id)`,
+	`create index if not exists idx_prompts_tokens on prompts(tokens)`,
+
+	`create table if not exists generateds (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, successful numeric, text text, tokens integer, chat_model text, completion_id text, finish_reason text, message_id integer, pinned numeric, prompt_id integer)`,
+	`create index if not exists idx_generateds_deleted_at on generateds(deleted_at)`,
+	`create index if not exists idx_generateds_successful on generateds(successful)`,
+	`create index if not exists idx_generateds_tokens on generateds(tokens)`,
+	`create index if not exists idx_generateds_chat_model on generateds(chat_model)`,
+	`create index if not exists idx_generateds_completion_id on generateds(completion_id)`,
+	`create index if not exists idx_generateds_message_id on generateds(message_id)`,
+
+	`create table if not exists generated_versions (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, generated_id integer, version integer, question text, successful numeric, text text, tokens integer, chat_model text, completion_id text, finish_reason text, message_id integer, pinned numeric)`,
+	`create index if not exists idx_generated_versions_deleted_at on generated_versions(deleted_at)`,
+	`create index if not exists idx_generated_versions_generated_id on generated_versions(generated_id)`,
+	`create index if not exists idx_generated_versions_message_id on generated_versions(message_id)`,
+
+	`create table if not exists feedbacks (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, chat_id integer, message_id integer, user_id integer, username text, reaction text, positive numeric)`,
+	`create index if not exists idx_feedbacks_deleted_at on feedbacks(deleted_at)`,
+	`create index if not exists idx_feedbacks_chat_id on feedbacks(chat_id)`,
+	`create index if not exists idx_feedbacks_message_id on feedbacks(message_id)`,
+	`create index if not exists idx_feedbacks_positive on feedbacks(positive)`,
+
+	`create table if not exists settings (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, key text, value text)`,
+	`create index if not exists idx_settings_deleted_at on settings(deleted_at)`,
+	`create unique index if not exists idx_settings_key on settings(key)`,
+
+	`create table if not exists chat_settings (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, chat_id integer, key text, value text)`,
+	`create index if not exists idx_chat_settings_deleted_at on chat_settings(deleted_at)`,
+	`create unique index if not exists idx_chat_settings_chat_id_key on chat_settings(chat_id, key)`,
+
+	`create table if not exists referrals (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, user_id integer, username text, source text)`,
+	`create index if not exists idx_referrals_deleted_at on referrals(deleted_at)`,
+	`create index if not exists idx_referrals_user_id on referrals(user_id)`,
+	`create index if not exists idx_referrals_source on referrals(source)`,
+
+	`create table if not exists archived_images (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, chat_id integer, user_id integer, message_id integer, source text, location text, content_type text, size integer)`,
+	`create index if not exists idx_archived_images_deleted_at on archived_images(deleted_at)`,
+	`create index if not exists idx_archived_images_chat_id on archived_images(chat_id)`,
+	`create index if not exists idx_archived_images_message_id on archived_images(message_id)`,
+	`create index if not exists idx_archived_images_source on archived_images(source)`,
+
+	`create table if not exists saved_prompts (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, user_id integer, name text, text text)`,
+	`create index if not exists idx_saved_prompts_deleted_at on saved_prompts(deleted_at)`,
+	`create unique index if not exists idx_saved_prompts_user_id_name on saved_prompts(user_id, name)`,
+}
+
+// migrations of columns (and their indexes) added later (errors of existing columns are ignored)
+var sqlMigrations = []string{
+	`alter table prompts add column request_tokens integer`,
+	`alter table generateds add column pinned numeric`,
+	`create index if not exists idx_generateds_pinned on generateds(pinned)`,
+	`alter table conversations add column parent_id integer`,
+	`create index if not exists idx_conversations_parent_id on conversations(parent_id)`,
+	`alter table conversations add column title text`,
+	`alter table prompts add column parent_message_id integer`,
+	`alter table prompts add column question text`,
+	`create index if not exists idx_prompts_parent_message_id on prompts(parent_message_id)`,
+	`alter table prompts add column route text`,
+	`alter table prompts add column kind text`,
+	`create index if not exists idx_prompts_kind on prompts(kind)`,
+}
+
+// statements
+const (
+	sqlInsertConversation  = `insert into conversations (created_at, updated_at, chat_id, parent_id) values (?, ?, ?, ?)`
+	sqlInsertPrompt        = `insert into prompts (created_at, updated_at, chat_id, user_id, username, conversation_id, message_id, parent_message_id, question, text, tokens, request_tokens, route, kind) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	sqlInsertGenerated     = `insert into generateds (created_at, updated_at, successful, text, tokens, chat_model, completion_id, finish_reason, message_id, pinned, prompt_id) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	sqlLatestAnswer        = `select p.id, coalesce(p.question, ''), g.id, coalesce(g.successful, 0), coalesce(g.text, ''), coalesce(g.tokens, 0), coalesce(g.chat_model, ''), coalesce(g.completion_id, ''), coalesce(g.finish_reason, ''), coalesce(g.message_id, 0), coalesce(g.pinned, 0) from prompts p join generateds g on g.prompt_id = p.id and g.deleted_at is null where p.deleted_at is null and p.chat_id = ? and p.message_id = ? order by p.id desc limit 1`
+	sqlInsertVersion       = `insert into generated_versions (created_at, updated_at, generated_id, version, question, successful, text, tokens, chat_model, completion_id, finish_reason, message_id, pinned) values (?, ?, ?, (select count(*) + 1 from generated_versions where generated_id = ?), ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	sqlUpdatePrompt        = `update prompts set updated_at = ?, question = ?, text = ?, tokens = ?, request_tokens = ?, route = ? where id = ?`
+	sqlUpdateGenerated     = `update generateds set updated_at = ?, successful = ?, text = ?, tokens = ?, chat_model = ?, completion_id = ?, finish_reason = ?, message_id = ?, pinned = 0 where id = ?`
+	sqlVersionsPrefix      = `select id, created_at, updated_at, generated_id, version, coalesce(question, ''), coalesce(successful, 0), coalesce(text, ''), coalesce(tokens, 0), coalesce(chat_model, ''), coalesce(completion_id, ''), coalesce(finish_reason, ''), coalesce(message_id, 0), coalesce(pinned, 0) from generated_versions where deleted_at is null`
+	sqlAllVersions         = sqlVersionsPrefix + ` order by generated_id asc, version asc`
+	sqlConversationVersion = sqlVersionsPrefix + ` and generated_id in (select g.id from generateds g join prompts p on p.id = g.prompt_id where p.conversation_id = ?) order by generated_id asc, version asc`
+	sqlInsertFeedback      = `insert into feedbacks (created_at, updated_at, chat_id, message_id, user_id, username, reaction, positive) values (?, ?, ?, ?, ?, ?, ?, ?)`
+	sqlDeleteFeedback      = `update feedbacks set deleted_at = ? where chat_id = ? and message_id = ? and user_id = ? and deleted_at is null`
+	sqlInsertReferral      = `insert into referrals (created_at, updated_at, user_id, username, source) values (?, ?, ?, ?, ?)`
+	sqlInsertArchivedImage = `insert into archived_images (created_at, updated_at, chat_id, user_id, message_id, source, location, content_type, size) values (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	sqlPinAnswer           = `update generateds set pinned = 1, updated_at = ? where message_id = ? and deleted_at is null and prompt_id in (select id from prompts where chat_id = ?)`
+	sqlUpsertSetting       = `insert into settings (created_at, updated_at, key, value) values (?, ?, ?, ?) on conflict(key) do update set value = excluded.value, updated_at = excluded.updated_at`
+	sqlSelectSetting       = `select value from settings where key = ? and deleted_at is null`
+	sqlUpsertChatSetting   = `insert into chat_settings (created_at, updated_at, chat_id, key, value) values (?, ?, ?, ?, ?) on conflict(chat_id, key) do update set value = excluded.value, updated_at = excluded.updated_at`
+	sqlSelectChatSetting   = `select value from chat_settings where chat_id = ? and key = ? and deleted_at is null`
+	sqlSelectChatIDs       = `select distinct chat_id from prompts where deleted_at is null`
+	sqlAllFeedbacks        = `select id, created_at, updated_at, chat_id, message_id, user_id, coalesce(username, ''), coalesce(reaction, ''), coalesce(positive, 0) from feedbacks where deleted_at is null order by id asc`
+	sqlUpsertSavedPrompt   = `insert into saved_prompts (created_at, updated_at, user_id, name, text) values (?, ?, ?, ?, ?) on conflict(user_id, name) do update set text = excluded.text, updated_at = excluded.updated_at`
+	sqlSelectSavedPrompt   = `select text from saved_prompts where user_id = ? and name = ? and deleted_at is null`
+	sqlSavedPromptNames    = `select name from saved_prompts where user_id = ? and deleted_at is null order by name asc`
+	sqlConversationTitle   = `update conversations set title = ?, updated_at = ? where id = ?`
+	sqlConversationsPrefix = `select id, created_at, updated_at, chat_id, parent_id, coalesce(title, '') from conversations where deleted_at is null`
+	sqlLatestConversation  = sqlConversationsPrefix + ` and chat_id = ? order by id desc limit 1`
+	sqlConversationByID    = sqlConversationsPrefix + ` and id = ?`
+	sqlSelectPromptsPrefix = `select p.id, p.created_at, p.updated_at, p.chat_id, p.user_id, p.username, p.conversation_id, p.message_id, coalesce(p.parent_message_id, 0), coalesce(p.question, ''), p.text, p.tokens, coalesce(p.request_tokens, 0), coalesce(p.route, ''), coalesce(p.kind, ''),
+	coalesce(g.id, 0), g.created_at, g.updated_at, coalesce(g.successful, 0), coalesce(g.text, ''), coalesce(g.tokens, 0), coalesce(g.chat_model, ''), coalesce(g.completion_id, ''), coalesce(g.finish_reason, ''), coalesce(g.message_id, 0), coalesce(g.pinned, 0)
+	from prompts p left join generateds g on g.prompt_id = p.id and g.deleted_at is null
+	where p.deleted_at is null`
+	sqlRecentPrompts           = sqlSelectPromptsPrefix + ` and p.chat_id = ? order by p.id desc limit ?`
+	sqlAllPrompts              = sqlSelectPromptsPrefix + ` order by p.id asc`
+	sqlConversationPrompts     = sqlSelectPromptsPrefix + ` and p.conversation_id = ? order by p.id asc`
+	sqlPromptByAnswerMessageID = sqlSelectPromptsPrefix + ` and p.chat_id = ? and g.message_id = ? order by p.id asc limit 1`
+	sqlStatsFirstPrompt        = `select created_at from prompts where deleted_at is null order by id asc limit 1`
+	sqlStatsPrompts            = `select count(distinct chat_id), coalesce(sum(case when tokens > 0 then tokens else 0 end), 0), count(case when tokens > 0 then 1 end) from prompts where deleted_at is null`
+	sqlStatsGenerateds         = `select coalesce(sum(case when successful = 1 then tokens else 0 end), 0), count(case when successful = 1 then 1 end), count(case when successful = 0 then 1 end) from generateds where deleted_at is null`
+	sqlStatsFeedbacks          = `select count(case when positive then 1 end), count(case when not positive then 1 end) from feedbacks where deleted_at is null`
+	sqlTokensSince             = `select coalesce(sum(p.tokens + g.tokens), 0) from generateds g join prompts p on p.id = g.prompt_id where g.deleted_at is null and p.deleted_at is null and g.created_at >= ?`
+)
+
+// SQLDatabase struct
+type SQLDatabase struct {
+	db *sql.DB
+
+	stmts map[string]*sql.Stmt // prepared statements, keyed by their queries
+}
+
+// OpenSQLDatabase opens and returns a database at given path: `dbPath`.
+func OpenSQLDatabase(dbPath string) (database *SQLDatabase, err error) {
+	var db *sql.DB
+	if db, err = sql.Open("sqlite3", dbPath); err != nil {
+		return nil, err
+	}
+
+	// create tables
+	for _, query := range sqlSchema {
+		if _, err = db.Exec(query); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	// add columns
+	for _, query := range sqlMigrations {
+		_, _ = db.Exec(query)
+	}
+
+	return prepareSQLDatabase(db)
+}
+
+// OpenReadOnlySQLDatabase opens and returns a database at given path: `dbPath` in read-only mode,
+// without creating or migrating its schema (eg. for a replica).
+func OpenReadOnlySQLDatabase(dbPath string) (database *SQLDatabase, err error) {
+	var db *sql.DB
+	if db, err = sql.Open("sqlite3", readOnlyDSN(dbPath)); err != nil {
+		return nil, err
+	}
+
+	return prepareSQLDatabase(db)
+}
+
+// prepare statements on given database
+func prepareSQLDatabase(db *sql.DB) (database *SQLDatabase, err error) {
+	stmts := map[string]*sql.Stmt{}
+	for _, query := range []string{
+		sqlInsertConversation,
+		sqlInsertPrompt,
+		sqlInsertGenerated,
+		sqlLatestAnswer,
+		sqlInsertVersion,
+		sqlUpdatePrompt,
+		sqlUpdateGenerated,
+		sqlAllVersions,
+		sqlConversationVersion,
+		sqlInsertFeedback,
+		sqlDeleteFeedback,
+		sqlInsertReferral,
+		sqlInsertArchivedImage,
+		sqlPinAnswer,
+		sqlUpsertSetting,
+		sqlSelectSetting,
+		sqlUpsertChatSetting,
+		sqlSelectChatSetting,
+		sqlSelectChatIDs,
+		sqlAllFeedbacks,
+		sqlUpsertSavedPrompt,
+		sqlSelectSavedPrompt,
+		sqlSavedPromptNames,
+		sqlConversationTitle,
+		sqlLatestConversation,
+		sqlConversationByID,
+		sqlRecentPrompts,
+		sqlAllPrompts,
+		sqlConversationPrompts,
+		sqlPromptByAnswerMessageID,
+		sqlStatsFirstPrompt,
+		sqlStatsPrompts,
+		sqlStatsGenerateds,
+		sqlStatsFeedbacks,
+		sqlTokensSince,
+	} {
+		if stmts[query], err = db.Prepare(query); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to prepare statement: %w", err)
+		}
+	}
+
+	return &SQLDatabase{db: db, stmts: stmts}, nil
+}
+
+// SavePrompt saves `prompt` and its result.
+func (d *SQLDatabase) SavePrompt(prompt Prompt) (err error) {
+	var tx *sql.Tx
+	if tx, err = d.db.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	now := time.Now()
+
+	var res sql.Result
+	if res, err = tx.Stmt(d.stmts[sqlInsertPrompt]).Exec(now, now, prompt.ChatID, prompt.UserID, prompt.Username, prompt.ConversationID, prompt.MessageID, prompt.ParentMessageID, prompt.Question, prompt.Text, prompt.Tokens, prompt.RequestTokens, prompt.Route, prompt.Kind); err != nil {
+		return err
+	}
+	var promptID int64
+	if promptID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+
+	result := prompt.Result
+	if _, err = tx.Stmt(d.stmts[sqlInsertGenerated]).Exec(now, now, result.Successful, result.Text, result.Tokens, result.ChatModel, result.CompletionID, result.FinishReason, result.MessageID, result.Pinned, promptID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveAnswerVersion saves `prompt` as a new version of the prompt with the same chat id and message id,
+// keeping its previous answer in generated versions (or saves it as a new prompt if there is none).
+func (d *SQLDatabase) SaveAnswerVersion(prompt Prompt) (err error) {
+	var tx *sql.Tx
+	if tx, err = d.db.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var promptID int64
+	var previous GeneratedVersion
+	if err = tx.Stmt(d.stmts[sqlLatestAnswer]).QueryRow(prompt.ChatID, prompt.MessageID).Scan(
+		&promptID, &previous.Question, &previous.GeneratedID, &previous.Successful, &previous.Text, &previous.Tokens, &previous.ChatModel, &previous.CompletionID, &previous.FinishReason, &previous.MessageID, &previous.Pinned,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = tx.Rollback()
+			return d.SavePrompt(prompt)
+		}
+		return err
+	}
+
+	now := time.Now()
+
+	// keep the previous answer
+	if _, err = tx.Stmt(d.stmts[sqlInsertVersion]).Exec(now, now, previous.GeneratedID, previous.GeneratedID, previous.Question, previous.Successful, previous.Text, previous.Tokens, previous.ChatModel, previous.CompletionID, previous.FinishReason, previous.MessageID, previous.Pinned); err != nil {
+		return err
+	}
+
+	// and replace it with the new one
+	if _, err = tx.Stmt(d.stmts[sqlUpdatePrompt]).Exec(now, prompt.Question, prompt.Text, prompt.Tokens, prompt.RequestTokens, prompt.Route, promptID); err != nil {
+		return err
+	}
+	result := prompt.Result
+	if _, err = tx.Stmt(d.stmts[sqlUpdateGenerated]).Exec(now, result.Successful, result.Text, result.Tokens, result.ChatModel, result.CompletionID, result.FinishReason, result.MessageID, previous.GeneratedID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveFeedback saves `feedback`.
+func (d *SQLDatabase) SaveFeedback(feedback Feedback) (err error) {
+	now := time.Now()
+	_, err = d.stmts[sqlInsertFeedback].Exec(now, now, feedback.ChatID, feedback.MessageID, feedback.UserID, feedback.Username, feedback.Reaction, feedback.Positive)
+	return err
+}
+
+// DeleteFeedback deletes feedback of a user on a message.
+func (d *SQLDatabase) DeleteFeedback(chatID, messageID, userID int64) (err error) {
+	_, err = d.stmts[sqlDeleteFeedback].Exec(time.Now(), chatID, messageID, userID)
+	return err
+}
+
+// SaveReferral saves `referral`.
+func (d *SQLDatabase) SaveReferral(referral Referral) (err error) {
+	now := time.Now()
+	_, err = d.stmts[sqlInsertReferral].Exec(now, now, referral.UserID, referral.Username, referral.Source)
+	return err
+}
+
+// SaveArchivedImage saves a reference of an archived image.
+func (d *SQLDatabase) SaveArchivedImage(image ArchivedImage) (err error) {
+	now := time.Now()
+	_, err = d.stmts[sqlInsertArchivedImage].Exec(now, now, image.ChatID, image.UserID, image.MessageID, image.Source, image.Location, image.ContentType, image.Size)
+	return err
+}
+
+// PinAnswer marks the answer sent as given telegram message as pinned.
+func (d *SQLDatabase) PinAnswer(chatID, messageID int64) (err error) {
+	var res sql.Result
+	if res, err = d.stmts[sqlPinAnswer].Exec(time.Now(), messageID, chatID); err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RecentPrompts returns the last `n` prompts (with their results) of a chat, in chronological order.
+func (d *SQLDatabase) RecentPrompts(chatID int64, n int) (prompts []Prompt, err error) {
+	if prompts, err = d.queryPrompts(sqlRecentPrompts, chatID, n); err != nil {
+		return nil, err
+	}
+
+	// reverse the order
+	for i, j := 0, len(prompts)-1; i < j; i, j = i+1, j-1 {
+		prompts[i], prompts[j] = prompts[j], prompts[i]
+	}
+
+	return prompts, nil
+}
+
+// AllPrompts returns all prompts (with their results), in chronological order.
+func (d *SQLDatabase) AllPrompts() (prompts []Prompt, err error) {
+	if prompts, err = d.queryPrompts(sqlAllPrompts); err != nil {
+		return nil, err
+	}
+	return d.withVersions(prompts, sqlAllVersions)
+}
+
+// PromptByAnswerMessageID returns a prompt whose answer was sent as given telegram message.
+func (d *SQLDatabase) PromptByAnswerMessageID(chatID, messageID int64) (prompt Prompt, err error) {
+	var prompts []Prompt
+	if prompts, err = d.queryPrompts(sqlPromptByAnswerMessageID, chatID, messageID); err != nil {
+		return prompt, err
+	}
+	if len(prompts) <= 0 {
+		return prompt, sql.ErrNoRows
+	}
+
+	return prompts[0], nil
+}
+
+// AllFeedbacks returns all feedbacks, in chronological order.
+func (d *SQLDatabase) AllFeedbacks() (feedbacks []Feedback, err error) {
+	var rows *sql.Rows
+	if rows, err = d.stmts[sqlAllFeedbacks].Query(); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var feedback Feedback
+		if err = rows.Scan(&feedback.ID, &feedback.CreatedAt, &feedback.UpdatedAt, &feedback.ChatID, &feedback.MessageID, &feedback.UserID, &feedback.Username, &feedback.Reaction, &feedback.Positive); err != nil {
+			return nil, err
+		}
+		feedbacks = append(feedbacks, feedback)
+	}
+
+	return feedbacks, rows.Err()
+}
+
+// ChatIDs returns all distinct chat ids which have interacted with the bot.
+func (d *SQLDatabase) ChatIDs() (chatIDs []int64, err error) {
+	var rows *sql.Rows
+	if rows, err = d.stmts[sqlSelectChatIDs].Query(); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatID int64
+		if err = rows.Scan(&chatID); err != nil {
+			return nil, err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	return chatIDs, rows.Err()
+}
+
+// SaveUserPrompt saves `text` as a prompt of a user with given `name`, overwriting the existing one.
+func (d *SQLDatabase) SaveUserPrompt(userID int64, name, text string) (err error) {
+	now := time.Now()
+	_, err = d.stmts[sqlUpsertSavedPrompt].Exec(now, now, userID, name, text)
+	return err
+}
+
+// UserPrompt returns the text of a prompt of a user with given `name`.
+func (d *SQLDatabase) UserPrompt(userID int64, name string) (text string, err error) {
+	err = d.stmts[sqlSelectSavedPrompt].QueryRow(userID, name).Scan(&text)
+	return text, err
+}
+
+// UserPromptNames returns the names of all prompts of a user.
+func (d *SQLDatabase) UserPromptNames(userID int64) (names []string, err error) {
+	var rows *sql.Rows
+	if rows, err = d.stmts[sqlSavedPromptNames].Query(userID); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// NewConversation creates a new conversation in a chat, branched from `parentID` if given.
+func (d *SQLDatabase) NewConversation(chatID int64, parentID *uint) (conversation Conversation, err error) {
+	now := time.Now()
+
+	var res sql.Result
+	if res, err = d.stmts[sqlInsertConversation].Exec(now, now, chatID, parentID); err != nil {
+		return conversation, err
+	}
+	var id int64
+	if id, err = res.LastInsertId(); err != nil {
+		return conversation, err
+	}
+
+	conversation.ID = uint(id)
+	conversation.CreatedAt, conversation.UpdatedAt = now, now
+	conversation.ChatID = chatID
+	conversation.ParentID = parentID
+
+	return conversation, nil
+}
+
+// LatestConversation returns the latest conversation of a chat.
+func (d *SQLDatabase) LatestConversation(chatID int64) (conversation Conversation, err error) {
+	return d.queryConversation(sqlLatestConversation, chatID)
+}
+
+// ConversationByID returns a conversation with given id.
+func (d *SQLDatabase) ConversationByID(conversationID uint) (conversation Conversation, err error) {
+	return d.queryConversation(sqlConversationByID, conversationID)
+}
+
+// SetConversationTitle saves `title` of a conversation.
+func (d *SQLDatabase) SetConversationTitle(conversationID uint, title string) (err error) {
+	_, err = d.stmts[sqlConversationTitle].Exec(title, time.Now(), conversationID)
+	return err
+}
+
+// query a conversation with given statement and arguments
+func (d *SQLDatabase) queryConversation(query string, args ...any) (conversation Conversation, err error) {
+	var parentID sql.NullInt64
+	if err = d.stmts[query].QueryRow(args...).Scan(&conversation.ID, &conversation.CreatedAt, &conversation.UpdatedAt, &conversation.ChatID, &parentID, &conversation.Title); err == nil && parentID.Valid {
+		id := uint(parentID.Int64)
+		conversation.ParentID = &id
+	}
+	return conversation, err
+}
+
+// ConversationPrompts returns all prompts (with their results) of a conversation, in chronological order.
+func (d *SQLDatabase) ConversationPrompts(conversationID uint) (prompts []Prompt, err error) {
+	if prompts, err = d.queryPrompts(sqlConversationPrompts, conversationID); err != nil {
+		return nil, err
+	}
+	return d.withVersions(prompts, sqlConversationVersion, conversationID)
+}
+
+// GetSetting returns the value of a setting with given `key`.
+func (d *SQLDatabase) GetSetting(key string) (value string, err error) {
+	err = d.stmts[sqlSelectSetting].QueryRow(key).Scan(&value)
+	return value, err
+}
+
+// SetSetting saves `value` for a setting with given `key`.
+func (d *SQLDatabase) SetSetting(key, value string) (err error) {
+	now := time.Now()
+	_, err = d.stmts[sqlUpsertSetting].Exec(now, now, key, value)
+	return err
+}
+
+// GetChatSetting returns the value of a setting with given `key` for a chat.
+func (d *SQLDatabase) GetChatSetting(chatID int64, key string) (value string, err error) {
+	err = d.stmts[sqlSelectChatSetting].QueryRow(chatID, key).Scan(&value)
+	return value, err
+}
+
+// SetChatSetting saves `value` for a setting with given `key` for a chat.
+func (d *SQLDatabase) SetChatSetting(chatID int64, key, value string) (err error) {
+	now := time.Now()
+	_, err = d.stmts[sqlUpsertChatSetting].Exec(now, now, chatID, key, value)
+	return err
+}
+
+// Stats returns usage statistics.
+func (d *SQLDatabase) Stats() (stats Stats, err error) {
+	var since time.Time
+	if err = d.stmts[sqlStatsFirstPrompt].QueryRow().Scan(&since); err == nil {
+		stats.Since = &since
+	}
+
+	if err = d.stmts[sqlStatsPrompts].QueryRow().Scan(&stats.Chats, &stats.PromptTokens, &stats.Prompts); err != nil {
+		return stats, err
+	}
+	if err = d.stmts[sqlStatsGenerateds].QueryRow().Scan(&stats.CompletionTokens, &stats.Completions, &stats.Errors); err != nil {
+		return stats, err
+	}
+	if err = d.stmts[sqlStatsFeedbacks].QueryRow().Scan(&stats.PositiveFeedbacks, &stats.NegativeFeedbacks); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// Query runs a read-only `query`, returning at most `maxRows` rows.
+func (d *SQLDatabase) Query(ctx context.Context, query string, maxRows int) (result QueryResult, err error) {
+	return queryReadOnly(ctx, d.db, query, maxRows)
+}
+
+// TokensSince returns the number of tokens (of prompts and their completions) generated since given time.
+func (d *SQLDatabase) TokensSince(since time.Time) (tokens int64, err error) {
+	err = d.stmts[sqlTokensSince].QueryRow(since).Scan(&tokens)
+	return tokens, err
+}
+
+// attach previous versions of answers, queried with given `query`, to given prompts
+func (d *SQLDatabase) withVersions(prompts []Prompt, query string, args ...any) ([]Prompt, error) {
+	rows, err := d.stmts[query].Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := map[uint][]GeneratedVersion{}
+	for rows.Next() {
+		var version GeneratedVersion
+		if err = rows.Scan(
+			&version.ID, &version.CreatedAt, &version.UpdatedAt, &version.GeneratedID, &version.Version, &version.Question, &version.Successful, &version.Text, &version.Tokens, &version.ChatModel, &version.CompletionID, &version.FinishReason, &version.MessageID, &version.Pinned,
+		); err != nil {
+			return nil, err
+		}
+		versions[version.GeneratedID] = append(versions[version.GeneratedID], version)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range prompts {
+		prompts[i].Result.Versions = versions[prompts[i].Result.ID]
+	}
+
+	return prompts, nil
+}
+
+// query prompts (with their results) with given prepared statement and arguments
+func (d *SQLDatabase) queryPrompts(query string, args ...any) (prompts []Prompt, err error) {
+	var rows *sql.Rows
+	if rows, err = d.stmts[query].Query(args...); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var prompt Prompt
+		var conversationID sql.NullInt64
+		var resultCreatedAt, resultUpdatedAt sql.NullTime
+
+		if err = rows.Scan(
+			&prompt.ID, &prompt.CreatedAt, &prompt.UpdatedAt, &prompt.ChatID, &prompt.UserID, &prompt.Username, &conversationID, &prompt.MessageID, &prompt.ParentMessageID, &prompt.Question, &prompt.Text, &prompt.Tokens, &prompt.RequestTokens, &prompt.Route, &prompt.Kind,
+			&prompt.Result.ID, &resultCreatedAt, &resultUpdatedAt, &prompt.Result.Successful, &prompt.Result.Text, &prompt.Result.Tokens, &prompt.Result.ChatModel, &prompt.Result.CompletionID, &prompt.Result.FinishReason, &prompt.Result.MessageID, &prompt.Result.Pinned,
+		); err != nil {
+			return nil, err
+		}
+
+		if conversationID.Valid {
+			id := uint(conversationID.Int64)
+			prompt.ConversationID = &id
+		}
+		prompt.Result.CreatedAt, prompt.Result.UpdatedAt = resultCreatedAt.Time, resultUpdatedAt.Time
+		prompt.Result.PromptID = int64(prompt.ID)
+
+		prompts = append(prompts, prompt)
+	}
+
+	return prompts, rows.Err()
+}
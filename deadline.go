@@ -0,0 +1,141 @@
+package main
+
+// deadline.go
+//
+// tracking in-flight completions, with interim notices and timeouts for slow ones
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	msgStillWorking = "Still working on it…"
+)
+
+// error for completions which exceeded `completion_timeout_seconds`
+var errCompletionTimeout = errors.New("completion timed out")
+
+// inflightCompletion struct for a completion in progress
+type inflightCompletion struct {
+	chatID, messageID int64
+	model             string
+	started           time.Time
+}
+
+// completions in progress, keyed by their ids
+var _inflight struct {
+	sync.Mutex
+
+	completions map[uint64]inflightCompletion
+	lastID      uint64
+}
+
+// start tracking an in-flight completion, and return a function for untracking it
+func trackCompletion(chatID, messageID int64, model string) (untrack func()) {
+	_inflight.Lock()
+	defer _inflight.Unlock()
+
+	if _inflight.completions == nil {
+		_inflight.completions = map[uint64]inflightCompletion{}
+	}
+	_inflight.lastID++
+	id := _inflight.lastID
+	_inflight.completions[id] = inflightCompletion{
+		chatID:    chatID,
+		messageID: messageID,
+		model:     model,
+		started:   time.Now(),
+	}
+
+	return func() {
+		_inflight.Lock()
+		defer _inflight.Unlock()
+
+		delete(_inflight.completions, id)
+	}
+}
+
+// describe completions in progress, eg. "2 (oldest: 12s)"
+func describeInflightCompletions() string {
+	_inflight.Lock()
+	defer _inflight.Unlock()
+
+	if len(_inflight.completions) == 0 {
+		return "0"
+	}
+
+	var oldest time.Time
+	for _, completion := range _inflight.completions {
+		if oldest.IsZero() || completion.started.Before(oldest) {
+			oldest = completion.started
+		}
+	}
+
+	return fmt.Sprintf("%d (oldest: %s)", len(_inflight.completions), time.Since(oldest).Round(time.Second))
+}
+
+// run given completion with `completion_notice_seconds` and `completion_timeout_seconds`
+//
+// (an interim notice is sent when it takes long, and deleted when it is done;
+// on timeout, it returns `errCompletionTimeout` while the completion keeps running and its result is discarded)
+func completeWithDeadline(bot *tg.Bot, conf config, chatID, messageID int64, model string, complete func() (openai.ChatCompletion, *time.Time, error)) (response openai.ChatCompletion, cachedAt *time.Time, err error) {
+	type result struct {
+		response openai.ChatCompletion
+		cachedAt *time.Time
+		err      error
+	}
+
+	untrack := trackCompletion(chatID, messageID, model)
+	started := time.Now()
+
+	done := make(chan result, 1)
+	go func() {
+		defer untrack()
+
+		response, cachedAt, err := complete()
+		done <- result{response, cachedAt, err}
+	}()
+
+	var notice, timeout <-chan time.Time
+	if conf.CompletionNoticeSeconds > 0 {
+		timer := time.NewTimer(time.Duration(conf.CompletionNoticeSeconds) * time.Second)
+		defer timer.Stop()
+		notice = timer.C
+	}
+	if conf.CompletionTimeoutSeconds > 0 {
+		timer := time.NewTimer(time.Duration(conf.CompletionTimeoutSeconds) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var noticeMessageID int64
+	defer func() {
+		if noticeMessageID != 0 {
+			_ = bot.DeleteMessage(chatID, noticeMessageID)
+		}
+	}()
+
+	for {
+		select {
+		case r := <-done:
+			return r.response, r.cachedAt, r.err
+		case <-notice:
+			if res := bot.SendMessage(chatID, msgStillWorking, tg.OptionsSendMessage{}.
+				SetReplyParameters(tg.ReplyParameters{MessageID: messageID}).
+				SetDisableNotification(true)); res.Ok {
+				noticeMessageID = res.Result.MessageID
+			}
+		case <-timeout:
+			log.Printf("completion for message %d in chat %d timed out after %s (model: %s)", messageID, chatID, time.Since(started).Round(time.Second), model)
+
+			return response, nil, errCompletionTimeout
+		}
+	}
+}
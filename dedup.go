@@ -0,0 +1,116 @@
+package main
+
+// dedup.go
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	processedUpdatesMax = 1000
+
+	processedUpdatesPersistInterval = 10 * time.Second
+
+	settingKeyProcessedUpdates = "processed_update_ids"
+)
+
+// updateIDs struct for remembering recently processed update ids (LRU)
+type updateIDs struct {
+	ids   []int64 // ring buffer
+	next  int
+	index map[int64]bool
+	dirty bool
+
+	sync.Mutex
+}
+
+// recently processed update ids
+var _processedUpdates = newUpdateIDs(processedUpdatesMax)
+
+// create a new update ids with given capacity
+func newUpdateIDs(capacity int) *updateIDs {
+	return &updateIDs{
+		ids:   make([]int64, 0, capacity),
+		index: map[int64]bool{},
+	}
+}
+
+// check if given update id was already processed, and mark it as processed if not
+func (u *updateIDs) checkAndMark(id int64) (processed bool) {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.index[id] {
+		return true
+	}
+
+	if len(u.ids) < cap(u.ids) {
+		u.ids = append(u.ids, id)
+	} else {
+		// evict the oldest one
+		delete(u.index, u.ids[u.next])
+		u.ids[u.next] = id
+		u.next = (u.next + 1) % len(u.ids)
+	}
+	u.index[id] = true
+	u.dirty = true
+
+	return false
+}
+
+// serialize ids in order (oldest first)
+func (u *updateIDs) serialize() string {
+	u.Lock()
+	defer u.Unlock()
+
+	strs := []string{}
+	for i := 0; i < len(u.ids); i++ {
+		strs = append(strs, strconv.FormatInt(u.ids[(u.next+i)%len(u.ids)], 10))
+	}
+
+	return strings.Join(strs, ",")
+}
+
+// load processed update ids from database
+func loadProcessedUpdates(db Storage) {
+	if db == nil {
+		return
+	}
+
+	if value, err := db.GetSetting(settingKeyProcessedUpdates); err == nil && value != "" {
+		for _, str := range strings.Split(value, ",") {
+			if id, err := strconv.ParseInt(str, 10, 64); err == nil {
+				_processedUpdates.checkAndMark(id)
+			}
+		}
+	}
+}
+
+// persist processed update ids to database periodically
+func persistProcessedUpdatesPeriodically(db Storage) {
+	if db == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(processedUpdatesPersistInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			_processedUpdates.Lock()
+			dirty := _processedUpdates.dirty
+			_processedUpdates.dirty = false
+			_processedUpdates.Unlock()
+
+			if dirty {
+				if err := db.SetSetting(settingKeyProcessedUpdates, _processedUpdates.serialize()); err != nil {
+					log.Printf("failed to persist processed update ids: %s", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,70 @@
+package main
+
+// deeplink.go
+//
+// handling payloads of deep links (https://t.me/your_bot?start=PAYLOAD)
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	payloadTypeReferral = "ref" // eg. `ref_some-campaign`
+
+	msgReferralSaved = "Welcome! (referred from: %s)"
+)
+
+// startPayloadHandler type for handling deep link payloads with `value`,
+// returns a message for the user (or an empty string for none)
+type startPayloadHandler func(db Storage, message tg.Message, value string) string
+
+// handlers for deep link payloads, keyed by payload types
+var startPayloadHandlers = map[string]startPayloadHandler{
+	payloadTypeReferral: handleReferralPayload,
+}
+
+// parse given `/start` payload into its type and value
+//
+// (payloads are formatted as `TYPE_VALUE`)
+func parseStartPayload(payload string) (typ3, value string) {
+	typ3, value, _ = strings.Cut(payload, "_")
+	return typ3, value
+}
+
+// route given `/start` payload to its handler,
+// returns a message for the user (or an empty string for none)
+func handleStartPayload(db Storage, message tg.Message, payload string) string {
+	typ3, value := parseStartPayload(payload)
+
+	if handler, exists := startPayloadHandlers[typ3]; exists {
+		return handler(db, message, value)
+	}
+
+	log.Printf("no handler for deep link payload: %s", payload)
+
+	return ""
+}
+
+// save referral attribution
+func handleReferralPayload(db Storage, message tg.Message, source string) string {
+	if source == "" || message.From == nil {
+		return ""
+	}
+
+	if db != nil {
+		if err := db.SaveReferral(Referral{
+			UserID:   message.From.ID,
+			Username: userName(message.From),
+			Source:   source,
+		}); err != nil {
+			log.Printf("failed to save referral: %s", err)
+		}
+	}
+
+	return fmt.Sprintf(msgReferralSaved, html.EscapeString(source))
+}
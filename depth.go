@@ -0,0 +1,177 @@
+package main
+
+// depth.go
+//
+// depth of conversation history attached to requests, bot-wide or per chat
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdDepth = "/depth"
+
+	depthArgReset = "reset"
+
+	chatSettingKeyHistoryDepth     = "history_depth"      // eg. "5"
+	chatSettingKeyHistoryMaxTokens = "history_max_tokens" // eg. "2000"
+
+	historyDepthMax = 50 // max number of previous prompts & answers which can be configured
+
+	msgDepthUsage   = "Usage: /depth [turns [max_tokens]|reset]\n\n(currently: <b>%s</b>)"
+	msgDepthInvalid = "Invalid depth: turns should be 1-%d, and max tokens should be 0 or more (0 for the default)."
+	msgDepthChanged = "Depth of history in this chat is now: <b>%s</b>"
+	msgDepthReset   = "Depth of history in this chat is reset to: <b>%s</b>"
+)
+
+// get the max number of previous prompts & answers attached to requests in given chat
+//
+// (per-chat override takes precedence over `history_depth` of config)
+func historyDepth(conf config, db Storage, chatID int64) int {
+	if value := intChatSetting(db, chatID, chatSettingKeyHistoryDepth); value > 0 {
+		return min(value, historyDepthMax)
+	}
+	if conf.HistoryDepth > 0 {
+		return min(conf.HistoryDepth, historyDepthMax)
+	}
+
+	return threadHistoryTurnsDefault
+}
+
+// get the max number of tokens of previous prompts & answers attached to requests in given chat (0 for no limit)
+//
+// (per-chat override takes precedence over `history_max_tokens` of config)
+func historyMaxTokens(conf config, db Storage, chatID int64) int {
+	if value := intChatSetting(db, chatID, chatSettingKeyHistoryMaxTokens); value > 0 {
+		return value
+	}
+
+	return max(conf.HistoryMaxTokens, 0)
+}
+
+// get an integer chat setting (0 if not set or invalid)
+func intChatSetting(db Storage, chatID int64, key string) int {
+	if db == nil {
+		return 0
+	}
+
+	if value, err := db.GetChatSetting(chatID, key); err == nil && value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+
+	return 0
+}
+
+// drop the oldest messages of given history until it fits in given number of tokens (0 for no limit)
+func limitHistoryTokens(model string, history []openai.ChatMessage, maxTokens int) []openai.ChatMessage {
+	if maxTokens <= 0 {
+		return history
+	}
+
+	for len(history) > 0 {
+		tokens, err := countRequestTokens(model, history)
+		if err != nil || tokens <= maxTokens {
+			break
+		}
+
+		// drop the oldest question and answer
+		history = history[min(2, len(history)):]
+	}
+
+	return history
+}
+
+// describe the depth of history in given chat
+func describeHistoryDepth(conf config, db Storage, chatID int64) string {
+	description := fmt.Sprintf("%d turns", historyDepth(conf, db, chatID))
+	if maxTokens := historyMaxTokens(conf, db, chatID); maxTokens > 0 {
+		description += fmt.Sprintf(", up to %s tokens", formatThousands(maxTokens))
+	}
+
+	return description
+}
+
+// return a /depth command handler
+func depthCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("depth command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		fields := strings.Fields(args)
+		if len(fields) == 0 || len(fields) > 2 {
+			send(b, conf, fmt.Sprintf(msgDepthUsage, describeHistoryDepth(conf, db, chatID)), chatID, &messageID)
+			return
+		}
+
+		// only admins can change settings of group chats
+		if message.Chat.Type != tg.ChatTypePrivate && !isChatAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		// values to save (empty for resetting them)
+		var depth, maxTokens string
+		if fields[0] != depthArgReset {
+			turns, err := strconv.Atoi(fields[0])
+			if err != nil || turns < 1 || turns > historyDepthMax {
+				send(b, conf, fmt.Sprintf(msgDepthInvalid, historyDepthMax), chatID, &messageID)
+				return
+			}
+			depth = strconv.Itoa(turns)
+
+			if len(fields) == 2 {
+				tokens, err := strconv.Atoi(fields[1])
+				if err != nil || tokens < 0 {
+					send(b, conf, fmt.Sprintf(msgDepthInvalid, historyDepthMax), chatID, &messageID)
+					return
+				}
+				if tokens > 0 {
+					maxTokens = strconv.Itoa(tokens)
+				}
+			}
+		}
+
+		var msg string
+		if err := db.SetChatSetting(chatID, chatSettingKeyHistoryDepth, depth); err != nil {
+			log.Printf("failed to change history depth: %s", err)
+
+			msg = err.Error()
+		} else if err := db.SetChatSetting(chatID, chatSettingKeyHistoryMaxTokens, maxTokens); err != nil {
+			log.Printf("failed to change history max tokens: %s", err)
+
+			msg = err.Error()
+		} else if depth == "" {
+			msg = fmt.Sprintf(msgDepthReset, describeHistoryDepth(conf, db, chatID))
+		} else {
+			msg = fmt.Sprintf(msgDepthChanged, describeHistoryDepth(conf, db, chatID))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
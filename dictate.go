@@ -0,0 +1,124 @@
+package main
+
+// dictate.go
+//
+// dictation mode: returning transcriptions of voices verbatim, without answering them
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdDictate = "/dictate"
+
+	settingKeyPrefixDictateMode = "dictate_mode/" // + user id
+
+	// (a well-punctuated prompt makes whisper punctuate transcriptions in the same way)
+	transcriptionPromptPunctuated = "Hello, welcome. Let's get started: first, the agenda; then, questions and answers."
+
+	msgDictateUsage   = "Usage: /dictate [on|off] (currently: <b>%s</b>)"
+	msgDictateChanged = "Dictation mode is turned <b>%s</b>.\n\n(while it is on, voices will be transcribed and returned as they are, without being answered)"
+	msgDictateEmpty   = "<i>(nothing was transcribed)</i>"
+)
+
+// checks if dictation mode is on for given user
+func isDictateModeOn(db Storage, userID int64) bool {
+	if db == nil {
+		return false
+	}
+
+	value, err := db.GetSetting(settingKeyPrefixDictateMode + strconv.FormatInt(userID, 10))
+	return err == nil && value == voiceArgOn
+}
+
+// turn dictation mode on/off for given user
+func setDictateMode(db Storage, userID int64, on bool) error {
+	return db.SetSetting(settingKeyPrefixDictateMode+strconv.FormatInt(userID, 10), onOff(on))
+}
+
+// transcribe given voice with punctuations, and send the transcription back as it is
+//
+// (as a text document if it is too long for a message)
+func dictate(bot *tg.Bot, client *openai.Client, conf config, db Storage, chatID, messageID int64, voice tg.Voice) {
+	stopTyping := keepChatAction(bot, chatID, responseText)
+	text, err := transcribeVoice(bot, client, voice, openai.TranscriptionOptions{}.
+		SetPrompt(transcriptionPromptPunctuated))
+	stopTyping()
+	if err != nil {
+		log.Printf("failed to transcribe voice for dictation: %s", err)
+
+		send(bot, conf, msgTranscriptionFailed, chatID, &messageID)
+		return
+	}
+
+	// clean up whitespaces
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		send(bot, conf, msgDictateEmpty, chatID, &messageID)
+		return
+	}
+
+	if responseKindOf(text) == responseDocument {
+		sendChatAction(bot, chatID, responseDocument)
+
+		if res := bot.SendDocument(chatID, tg.InputFileFromBytes([]byte(text)), tg.OptionsSendDocument{}.
+			SetReplyParameters(tg.ReplyParameters{MessageID: messageID}).
+			SetCaption(ellipsize(text, 128)).
+			SetDisableNotification(isQuietHours(db, chatID))); !res.Ok {
+			log.Printf("failed to send dictation as a document: %s", *res.Description)
+		}
+		return
+	}
+
+	send(bot, conf, html.EscapeString(text), chatID, &messageID)
+}
+
+// return a /dictate command handler
+func dictateCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("dictate command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil || message.From == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+		userID := message.From.ID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		switch args {
+		case voiceArgOn, voiceArgOff:
+			if err := setDictateMode(db, userID, args == voiceArgOn); err != nil {
+				log.Printf("failed to change dictation mode: %s", err)
+
+				msg = err.Error()
+			} else {
+				msg = fmt.Sprintf(msgDictateChanged, args)
+			}
+		default:
+			msg = fmt.Sprintf(msgDictateUsage, onOff(isDictateModeOn(db, userID)))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
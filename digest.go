@@ -0,0 +1,235 @@
+package main
+
+// digest.go
+//
+// digest mode of chats, where non-urgent messages (eg. broadcasts) are delivered once a day as a combined message
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdDigest = "/digest"
+
+	digestArgOn  = "on"
+	digestArgOff = "off"
+
+	chatSettingKeyDigest          = "digest"           // "on" or "off"
+	chatSettingKeyDigestPending   = "digest_pending"   // json array of pending messages
+	chatSettingKeyDigestDelivered = "digest_delivered" // date of the last delivery, eg. "2024-01-31"
+
+	digestHourDefault    = 9 // 09:00 in the chat's timezone
+	digestCheckInterval  = 10 * time.Minute
+	digestDateFormat     = "2006-01-02"
+	digestTimeFormat     = "15:04"
+	digestMessageMaxLen  = 4000 // keep combined messages under telegram's limit (4096)
+	digestPendingMaxSize = 50   // max number of pending messages for each chat
+
+	msgDigestUsage   = "Usage: /digest [on|off]\n\n(currently: <b>%s</b>)"
+	msgDigestChanged = "Digest mode of this chat is now: <b>%s</b>\n\n(non-urgent messages like broadcasts will be delivered once a day, at %02d:00 in %s)"
+	msgDigestHeader  = "📰 <b>Daily digest</b> (%d message(s))"
+	msgDigestOmitted = "<i>(%d more message(s) omitted)</i>"
+)
+
+// for serializing read-modify-writes of pending messages
+var _digestLock sync.Mutex
+
+// digestItem struct for a pending message of a digest
+type digestItem struct {
+	Text     string    `json:"text"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// checks if digest mode is on for a chat
+func isDigestModeOn(db Storage, chatID int64) bool {
+	if db == nil {
+		return false
+	}
+
+	value, err := db.GetChatSetting(chatID, chatSettingKeyDigest)
+	return err == nil && value == digestArgOn
+}
+
+// get the hour of a day for delivering digests from config, or the default one
+func digestHour(conf config) int {
+	if conf.DigestHour != nil && *conf.DigestHour >= 0 && *conf.DigestHour < 24 {
+		return *conf.DigestHour
+	}
+
+	return digestHourDefault
+}
+
+// load pending messages of a chat
+func pendingDigestItems(db Storage, chatID int64) (items []digestItem) {
+	if value, err := db.GetChatSetting(chatID, chatSettingKeyDigestPending); err == nil && value != "" {
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			log.Printf("failed to parse pending digest of chat %d: %s", chatID, err)
+		}
+	}
+
+	return items
+}
+
+// queue a non-urgent message for the next digest of a chat
+//
+// (the oldest ones are dropped when there are too many pending messages)
+func queueDigest(db Storage, chatID int64, text string) error {
+	_digestLock.Lock()
+	defer _digestLock.Unlock()
+
+	items := append(pendingDigestItems(db, chatID), digestItem{
+		Text:     text,
+		QueuedAt: time.Now(),
+	})
+	if len(items) > digestPendingMaxSize {
+		items = items[len(items)-digestPendingMaxSize:]
+	}
+
+	bytes, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+
+	return db.SetChatSetting(chatID, chatSettingKeyDigestPending, string(bytes))
+}
+
+// deliver digests of chats periodically, once a day at `digest_hour` of each chat's timezone
+//
+// (date of the last delivery is persisted, so restarts will not deliver them twice a day)
+func deliverDigestsPeriodically(bot *tg.Bot, db Storage) {
+	if db == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(digestCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			conf := currentConfig()
+
+			chatIDs, err := db.ChatIDs()
+			if err != nil {
+				log.Printf("failed to get chat ids for delivering digests: %s", err)
+				continue
+			}
+
+			for _, chatID := range chatIDs {
+				deliverDigest(bot, conf, db, chatID, time.Now())
+			}
+		}
+	}()
+}
+
+// deliver the pending messages of a chat as a combined message, if it is time for it
+//
+// (digests are held back during quiet hours, and delivered when they are over)
+func deliverDigest(bot *tg.Bot, conf config, db Storage, chatID int64, now time.Time) {
+	_digestLock.Lock()
+	defer _digestLock.Unlock()
+
+	now = now.In(chatTimezone(db, chatID))
+	today := now.Format(digestDateFormat)
+
+	if now.Hour() < digestHour(conf) || isQuietHours(db, chatID) {
+		return
+	}
+	if delivered, err := db.GetChatSetting(chatID, chatSettingKeyDigestDelivered); err == nil && delivered == today {
+		return
+	}
+
+	items := pendingDigestItems(db, chatID)
+	if len(items) == 0 {
+		return
+	}
+
+	if res := bot.SendMessage(chatID, combineDigest(items, now.Location()), tg.OptionsSendMessage{}.
+		SetParseMode(tg.ParseModeHTML)); !res.Ok {
+		log.Printf("failed to deliver digest to chat(%d): %s", chatID, *res.Description)
+		return
+	}
+
+	logInfo("delivered digest of %d message(s) to chat(%d)", len(items), chatID)
+
+	if err := db.SetChatSetting(chatID, chatSettingKeyDigestPending, ""); err != nil {
+		log.Printf("failed to clear pending digest of chat %d: %s", chatID, err)
+	}
+	if err := db.SetChatSetting(chatID, chatSettingKeyDigestDelivered, today); err != nil {
+		log.Printf("failed to save the date of the last digest of chat %d: %s", chatID, err)
+	}
+}
+
+// combine given pending messages into a message (in telegram html)
+//
+// (messages which do not fit in a message are omitted)
+func combineDigest(items []digestItem, location *time.Location) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(msgDigestHeader, len(items)))
+
+	for i, item := range items {
+		entry := fmt.Sprintf("\n\n<i>%s</i>\n%s", item.QueuedAt.In(location).Format(digestTimeFormat), html.EscapeString(item.Text))
+		if sb.Len()+len(entry) > digestMessageMaxLen {
+			sb.WriteString("\n\n" + fmt.Sprintf(msgDigestOmitted, len(items)-i))
+			break
+		}
+		sb.WriteString(entry)
+	}
+
+	return sb.String()
+}
+
+// return a /digest command handler
+func digestCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("digest command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		if args != digestArgOn && args != digestArgOff {
+			send(b, conf, fmt.Sprintf(msgDigestUsage, onOff(isDigestModeOn(db, chatID))), chatID, &messageID)
+			return
+		}
+
+		// only admins can change settings of group chats
+		if message.Chat.Type != tg.ChatTypePrivate && !isChatAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		if err := db.SetChatSetting(chatID, chatSettingKeyDigest, args); err != nil {
+			log.Printf("failed to change digest mode: %s", err)
+
+			msg = err.Error()
+		} else {
+			msg = fmt.Sprintf(msgDigestChanged, args, digestHour(conf), html.EscapeString(chatTimezone(db, chatID).String()))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+// directives.go
+//
+// directives at the start of messages, for overriding options of a single request
+// (eg. `!fast !t=1.2 !nolog !fresh explain X`)
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	directivePrefix            = "!"
+	directiveTemperaturePrefix = "t="
+	directiveNoLog             = "nolog"
+	directivePrivate           = "private" // (same as `!nolog`)
+	directiveFresh             = "fresh"
+
+	temperatureMin = 0.0
+	temperatureMax = 2.0
+
+	msgDirectivesOnly = "Directives should be followed by a message, eg. <code>!fast !t=1.2 explain X</code>."
+)
+
+// messageDirectives struct for options of a single request
+type messageDirectives struct {
+	Model       string   // from a model alias (eg. `!fast`) or name (eg. `!gpt-4o`, or `!gpt4o`)
+	Temperature *float64 // from `!t=1.2`
+	NoLog       bool     // from `!nolog` or `!private`: do not save the prompt and its answer
+	Fresh       bool     // from `!fresh`: do not serve a cached answer
+
+	CodeBlocks bool // (not a directive) format markdown code blocks of the answer, eg. for /explainerror
+	Edited     bool // (not a directive) answering an edited message, so the answer is saved as a new version of the previous one
+}
+
+// parse directives at the start of given text, and return the text with them stripped
+//
+// (parsing stops at the first word which is not a directive, so `!important` remains as it is)
+func parseDirectives(conf config, text string) (directives messageDirectives, stripped string) {
+	stripped = strings.TrimSpace(text)
+
+	for strings.HasPrefix(stripped, directivePrefix) {
+		word, rest, _ := strings.Cut(stripped, " ")
+		if !applyDirective(conf, &directives, strings.TrimPrefix(word, directivePrefix)) {
+			break
+		}
+		stripped = strings.TrimSpace(rest)
+	}
+
+	return directives, stripped
+}
+
+// checks if nothing is left to be answered in given message, after its directives are stripped (eg. `!fast` only)
+func nothingButDirectives(message tg.Message, stripped string) bool {
+	return stripped == "" && !message.HasPhoto() && !message.HasDocument()
+}
+
+// apply given directive (without the prefix), returns false if it is not a valid one
+func applyDirective(conf config, directives *messageDirectives, directive string) bool {
+	if directive == directiveNoLog || directive == directivePrivate {
+		directives.NoLog = true
+		return true
+	}
+	if directive == directiveFresh {
+		directives.Fresh = true
+		return true
+	}
+
+	if value, isTemperature := strings.CutPrefix(directive, directiveTemperaturePrefix); isTemperature {
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil || temperature < temperatureMin || temperature > temperatureMax {
+			return false
+		}
+		directives.Temperature = &temperature
+		return true
+	}
+
+	// (with `selectable_models`, only the selectable ones, same as /model)
+	if model, exists := conf.ModelAliases[directive]; exists && isSelectableModel(conf, directive) {
+		directives.Model = model
+		return true
+	}
+	if model := modelOfDirective(directive); isChatModel(model) && isSelectableModel(conf, model) {
+		directives.Model = model
+		return true
+	}
+
+	return false
+}
+
+// get the model name of given directive, with the hyphen after `gpt` which is often omitted (eg. `gpt4o` => `gpt-4o`)
+func modelOfDirective(directive string) string {
+	if rest, found := strings.CutPrefix(directive, "gpt"); found && len(rest) > 0 && unicode.IsDigit(rune(rest[0])) {
+		return "gpt-" + rest
+	}
+
+	return directive
+}
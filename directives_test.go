@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+func TestParseDirectives(t *testing.T) {
+	conf := config{ModelAliases: map[string]string{"fast": "gpt-4o-mini"}}
+
+	for _, test := range []struct {
+		text          string
+		expectedModel string
+		expectedText  string
+		nothingLeft   bool
+	}{
+		{"!fast explain X", "gpt-4o-mini", "explain X", false},
+		{"!gpt4o !t=1.2 explain X", "gpt-4o", "explain X", false},
+		{"!important explain X", "", "!important explain X", false},
+		{"!fast", "gpt-4o-mini", "", true},
+		{"!fast !nolog  ", "gpt-4o-mini", "", true},
+	} {
+		directives, stripped := parseDirectives(conf, test.text)
+		if directives.Model != test.expectedModel {
+			t.Errorf("expected model '%s' from '%s', got '%s'", test.expectedModel, test.text, directives.Model)
+		}
+		if stripped != test.expectedText {
+			t.Errorf("expected text '%s' from '%s', got '%s'", test.expectedText, test.text, stripped)
+		}
+
+		message := tg.Message{Text: &test.text}
+		if nothing := nothingButDirectives(message, stripped); nothing != test.nothingLeft {
+			t.Errorf("expected nothing left (%t) from '%s', got %t", test.nothingLeft, test.text, nothing)
+		}
+	}
+
+	// photos are answered even without any text left
+	caption := "!fast"
+	_, stripped := parseDirectives(conf, caption)
+	photo := tg.Message{Caption: &caption, Photo: []tg.PhotoSize{{FileID: "photo"}}}
+	if nothingButDirectives(photo, stripped) {
+		t.Errorf("expected a photo with only directives to be answered")
+	}
+}
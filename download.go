@@ -0,0 +1,238 @@
+package main
+
+// download.go
+//
+// downloading files with size limits and content-type checks
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	maxDocumentBytesDefault = 1024 * 1024 // 1MB
+
+	sniffLen = 512 // number of bytes for sniffing content types
+
+	msgDocumentNotSupported = `Sorry, your document was not accepted: %s
+
+* Supported types: %s
+* Supported extensions: %s
+* Max size: <b>%d</b> bytes`
+)
+
+// non-`text/*` content types which can be read as texts
+var textContentTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/yaml",
+	"application/x-yaml",
+	"application/javascript",
+	"application/x-sh",
+	"application/sql",
+}
+
+// extensions of documents accepted by default
+var documentExtensionsDefault = []string{
+	".txt", ".md", ".csv", ".tsv", ".log",
+	".json", ".xml", ".yaml", ".yml", ".toml", ".html",
+	".go", ".py", ".js", ".ts", ".java", ".c", ".h", ".cpp", ".rs", ".rb", ".sh", ".sql",
+}
+
+// get the max size of documents in config, or the default one
+func maxDocumentBytes(conf config) int64 {
+	if conf.MaxDocumentBytes > 0 {
+		return conf.MaxDocumentBytes
+	}
+	return maxDocumentBytesDefault
+}
+
+// get accepted content types of documents in config, or the default ones
+func allowedDocumentTypes(conf config) []string {
+	if len(conf.AllowedDocumentTypes) > 0 {
+		return conf.AllowedDocumentTypes
+	}
+	return append([]string{"text/*"}, textContentTypes...)
+}
+
+// get accepted extensions of documents in config, or the default ones
+func allowedDocumentExtensions(conf config) []string {
+	if len(conf.AllowedDocumentExtensions) > 0 {
+		return conf.AllowedDocumentExtensions
+	}
+	return documentExtensionsDefault
+}
+
+// check if given document is acceptable by its size, content type, or extension
+func checkDocument(conf config, document tg.Document) error {
+	if maxBytes := maxDocumentBytes(conf); int64(document.FileSize) > maxBytes {
+		return fmt.Errorf("document is too large: %d bytes (max: %d bytes)", document.FileSize, maxBytes)
+	}
+
+	if document.MimeType != nil {
+		if mediaType, _, err := mime.ParseMediaType(*document.MimeType); err == nil {
+			for _, allowed := range allowedDocumentTypes(conf) {
+				if mediaType == allowed ||
+					(strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+					return nil
+				}
+			}
+		}
+	}
+	if document.FileName != nil {
+		ext := strings.ToLower(filepath.Ext(*document.FileName))
+		for _, allowed := range allowedDocumentExtensions(conf) {
+			if ext == strings.ToLower(allowed) {
+				return nil
+			}
+		}
+	}
+
+	mimeType := "unknown"
+	if document.MimeType != nil {
+		mimeType = *document.MimeType
+	}
+	return fmt.Errorf("not a supported document type: %s", mimeType)
+}
+
+// generate a message for rejected documents, listing supported ones
+func documentNotSupportedMessage(conf config, err error) string {
+	return fmt.Sprintf(msgDocumentNotSupported,
+		html.EscapeString(err.Error()),
+		html.EscapeString(strings.Join(allowedDocumentTypes(conf), ", ")),
+		html.EscapeString(strings.Join(allowedDocumentExtensions(conf), ", ")),
+		maxDocumentBytes(conf),
+	)
+}
+
+// read bytes from given document
+func documentText(bot *tg.Bot, document *tg.Document) (result []byte, err error) {
+	conf := currentConfig()
+
+	// check the size and type before downloading
+	if err = checkDocument(conf, *document); err != nil {
+		return nil, err
+	}
+
+	if res := bot.GetFile(document.FileID); !res.Ok {
+		err = fmt.Errorf("Failed to get document: %s", *res.Description)
+	} else {
+		fileURL := bot.GetFileURL(*res.Result)
+		result, err = readFileContentAtURL(fileURL, maxDocumentBytes(conf))
+	}
+
+	return result, err
+}
+
+// read text file content at given url, up to `maxBytes`, will timeout in 60 seconds
+//
+// (content is streamed to a temporary file first, so large files will not be read into memory)
+func readFileContentAtURL(url string, maxBytes int64) (content []byte, err error) {
+	var fpath string
+	if fpath, err = downloadToTempFile(url, maxBytes, isTextContentType); err != nil {
+		return nil, err
+	}
+	defer os.Remove(fpath)
+
+	return os.ReadFile(fpath)
+}
+
+// read file content at given url, up to `maxBytes`, will timeout in 60 seconds
+//
+// (content is streamed to a temporary file first, so large files will not be read into memory)
+func readBinaryContentAtURL(url string, maxBytes int64) (content []byte, err error) {
+	var fpath string
+	if fpath, err = downloadToTempFile(url, maxBytes, nil); err != nil {
+		return nil, err
+	}
+	defer os.Remove(fpath)
+
+	return os.ReadFile(fpath)
+}
+
+// download a file at given url to a temporary file, up to `maxBytes`, will timeout in 60 seconds
+//
+// `accept` checks the sniffed content type (nil for accepting all types),
+// returns the path of the temporary file, which should be removed by the caller
+func downloadToTempFile(url string, maxBytes int64, accept func(contentType string) bool) (fpath string, err error) {
+	httpClient := newHTTPClient(time.Second * 60)
+
+	var resp *http.Response
+	resp, err = httpClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download file: http %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxBytes {
+		return "", fmt.Errorf("file is too large: %d bytes (max: %d bytes)", resp.ContentLength, maxBytes)
+	}
+
+	var file *os.File
+	if file, err = os.CreateTemp("", "telegram-chatgpt-bot-*"); err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+		if err != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	// check the content type with its first bytes
+	head := make([]byte, sniffLen)
+	var n int
+	if n, err = io.ReadFull(resp.Body, head); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+	if contentType := http.DetectContentType(head); accept != nil && !accept(contentType) {
+		return "", fmt.Errorf("not an acceptable file: %s", contentType)
+	}
+
+	// stream the rest, up to `maxBytes`
+	var written int64
+	if _, err = file.Write(head); err != nil {
+		return "", err
+	}
+	if written, err = io.Copy(file, io.LimitReader(resp.Body, maxBytes-int64(n)+1)); err != nil {
+		return "", err
+	}
+	if int64(n)+written > maxBytes {
+		err = fmt.Errorf("file is too large (max: %d bytes)", maxBytes)
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// check if given content type can be read as a text
+func isTextContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	for _, t := range textContentTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+
+	return false
+}
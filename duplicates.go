@@ -0,0 +1,115 @@
+package main
+
+// duplicates.go
+//
+// detecting near-identical questions recently asked in the same chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	duplicateQuestionMinRunes = 20  // shorter questions (eg. "continue") are not checked
+	duplicateSimilarityMin    = 0.9 // min jaccard similarity of words for near-identical questions
+	duplicateCandidatesMax    = historyCountMax
+	duplicateTimeFormat       = "2006-01-02 15:04"
+
+	msgDuplicateQuestion        = "A near-identical question was asked at %s, and this is its answer. Ask anyway?"
+	msgDuplicateButtonAskAnyway = "🔁 Ask anyway"
+	msgDuplicateAskedAnyway     = "Asking anyway..."
+)
+
+// find a successfully answered prompt with a near-identical question, asked recently in the chat
+//
+// (with `embeddings`, questions with similar embeddings are also regarded as near-identical)
+//
+// (returns nil if `duplicate_window_minutes` is not set, or there is no such prompt)
+func findDuplicateQuestion(client *openai.Client, conf config, db Storage, chatID int64, question string) *Prompt {
+	if conf.DuplicateWindowMinutes <= 0 || db == nil || len([]rune(strings.TrimSpace(question))) < duplicateQuestionMinRunes {
+		return nil
+	}
+
+	prompts, err := db.RecentPrompts(chatID, duplicateCandidatesMax)
+	if err != nil {
+		return nil
+	}
+
+	since := time.Now().Add(-time.Duration(conf.DuplicateWindowMinutes) * time.Minute)
+	candidates := []Prompt{}
+	for i := len(prompts) - 1; i >= 0; i-- {
+		prompt := prompts[i]
+		if prompt.CreatedAt.Before(since) {
+			break
+		}
+		if !prompt.Result.Successful || prompt.Result.MessageID == 0 {
+			continue
+		}
+		candidates = append(candidates, prompt)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	words := questionWords(question)
+	for _, prompt := range candidates {
+		if similarity(words, questionWords(questionOf(prompt))) >= duplicateSimilarityMin {
+			return &prompt
+		}
+	}
+
+	// search with embeddings, if no question has similar words
+	if messageID, found := searchSimilarQuestion(client, conf, chatID, question); found {
+		for _, prompt := range candidates {
+			if prompt.Result.MessageID == messageID {
+				return &prompt
+			}
+		}
+	}
+
+	return nil
+}
+
+// offer the answer of a duplicate question, with a button for asking anyway
+//
+// (the offer is sent as a reply to the previous answer, so it can be followed)
+func offerPreviousAnswer(bot *tg.Bot, db Storage, chatID, userID int64, previous Prompt, run func()) {
+	askToContinue(bot, chatID, previous.Result.MessageID, userID,
+		fmt.Sprintf(msgDuplicateQuestion, previous.CreatedAt.In(chatTimezone(db, chatID)).Format(duplicateTimeFormat)),
+		msgDuplicateButtonAskAnyway,
+		msgDuplicateAskedAnyway,
+		run)
+}
+
+// normalize given question into a set of lowercased words (without punctuations)
+func questionWords(question string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		words[word] = true
+	}
+
+	return words
+}
+
+// calculate the jaccard similarity of given sets of words
+func similarity(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+
+	intersection := 0
+	for word := range a {
+		if b[word] {
+			intersection++
+		}
+	}
+
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
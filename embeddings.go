@@ -0,0 +1,201 @@
+package main
+
+// embeddings.go
+//
+// embeddings of questions and a vector store for searching similar ones
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+
+	openai "github.com/meinside/openai-go"
+)
+
+const (
+	embeddingModelDefault = "text-embedding-3-small"
+
+	vectorStoreMemory    = "memory"
+	vectorStoreSQLiteVec = "sqlite-vec"
+	vectorStorePGVector  = "pgvector"
+
+	memoryVectorStoreMaxPerChat = 1000 // max number of vectors kept for each chat
+
+	similarQuestionSimilarityMin = 0.95 // min cosine similarity of embeddings for near-identical questions
+)
+
+// embeddingsConfig struct for embeddings and their vector store
+type embeddingsConfig struct {
+	Model      string `json:"model,omitempty"`      // embedding model (default: "text-embedding-3-small")
+	Dimensions int    `json:"dimensions,omitempty"` // dimensionality of vectors (default: 0 for the model's default)
+	Store      string `json:"store,omitempty"`      // backend of the vector store (default: "memory")
+}
+
+// vectorStore interface for storing and searching vectors of questions
+type vectorStore interface {
+	// Add stores `vector` of a question whose answer was sent as given telegram message.
+	Add(chatID, messageID int64, vector []float64)
+
+	// Search returns the telegram message id of the answer to the most similar question in a chat.
+	Search(chatID int64, vector []float64) (messageID int64, similarity float64, found bool)
+}
+
+// vector store for embeddings of questions (nil if `embeddings` is not configured)
+var _vectors vectorStore
+
+// set up the vector store with given config
+func setupVectorStore(conf config) {
+	if conf.Embeddings == nil {
+		return
+	}
+
+	if store, err := newVectorStore(*conf.Embeddings); err == nil {
+		_vectors = store
+
+		logInfo("using %s vector store for embeddings (model: %s)", storeName(*conf.Embeddings), embeddingModel(*conf.Embeddings))
+	} else {
+		log.Printf("failed to set up vector store, not using embeddings: %s", err)
+	}
+}
+
+// create a vector store with given config
+func newVectorStore(conf embeddingsConfig) (vectorStore, error) {
+	switch name := storeName(conf); name {
+	case vectorStoreMemory:
+		return &memoryVectorStore{vectors: map[int64][]storedVector{}}, nil
+	case vectorStoreSQLiteVec, vectorStorePGVector:
+		return nil, fmt.Errorf("vector store '%s' is not supported in this build", name)
+	default:
+		return nil, fmt.Errorf("unknown vector store: '%s'", name)
+	}
+}
+
+// get the name of the vector store backend from config, or the default one
+func storeName(conf embeddingsConfig) string {
+	if conf.Store != "" {
+		return conf.Store
+	}
+
+	return vectorStoreMemory
+}
+
+// get the embedding model from config, or the default one
+func embeddingModel(conf embeddingsConfig) string {
+	if conf.Model != "" {
+		return conf.Model
+	}
+
+	return embeddingModelDefault
+}
+
+// generate an embedding of given text
+func embed(client *openai.Client, conf embeddingsConfig, text string) (vector []float64, err error) {
+	options := openai.EmbeddingOptions{}
+	if conf.Dimensions > 0 {
+		options["dimensions"] = conf.Dimensions
+	}
+
+	var response openai.Embeddings
+	if response, err = client.CreateEmbedding(embeddingModel(conf), text, options); err != nil {
+		return nil, err
+	}
+	if len(response.Data) <= 0 {
+		return nil, fmt.Errorf("no embedding in response")
+	}
+
+	return response.Data[0].Embedding, nil
+}
+
+// store the embedding of a question whose answer was sent as given telegram message
+//
+// (only when duplicate questions are checked, which is the only user of the embeddings)
+func indexQuestion(client *openai.Client, conf config, chatID, messageID int64, question string) {
+	if _vectors == nil || conf.Embeddings == nil || conf.DuplicateWindowMinutes <= 0 || question == "" {
+		return
+	}
+
+	if vector, err := embed(client, *conf.Embeddings, question); err == nil {
+		_vectors.Add(chatID, messageID, vector)
+	} else {
+		log.Printf("failed to embed question: %s", err)
+	}
+}
+
+// search the telegram message id of the answer to a question similar to given one in a chat
+func searchSimilarQuestion(client *openai.Client, conf config, chatID int64, question string) (messageID int64, found bool) {
+	if _vectors == nil || conf.Embeddings == nil {
+		return 0, false
+	}
+
+	vector, err := embed(client, *conf.Embeddings, question)
+	if err != nil {
+		log.Printf("failed to embed question: %s", err)
+		return 0, false
+	}
+
+	var similarity float64
+	if messageID, similarity, found = _vectors.Search(chatID, vector); found && similarity >= similarQuestionSimilarityMin {
+		return messageID, true
+	}
+
+	return 0, false
+}
+
+// storedVector struct for a vector in the memory vector store
+type storedVector struct {
+	messageID int64
+	vector    []float64
+}
+
+// memoryVectorStore struct for storing vectors in memory (lost on restart)
+type memoryVectorStore struct {
+	sync.RWMutex
+
+	vectors map[int64][]storedVector // keyed by chat ids
+}
+
+// Add stores `vector` of a question whose answer was sent as given telegram message.
+func (s *memoryVectorStore) Add(chatID, messageID int64, vector []float64) {
+	s.Lock()
+	defer s.Unlock()
+
+	vectors := append(s.vectors[chatID], storedVector{messageID: messageID, vector: vector})
+	if len(vectors) > memoryVectorStoreMaxPerChat {
+		vectors = vectors[len(vectors)-memoryVectorStoreMaxPerChat:]
+	}
+	s.vectors[chatID] = vectors
+}
+
+// Search returns the telegram message id of the answer to the most similar question in a chat.
+func (s *memoryVectorStore) Search(chatID int64, vector []float64) (messageID int64, similarity float64, found bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, stored := range s.vectors[chatID] {
+		if sim := cosineSimilarity(vector, stored.vector); !found || sim > similarity {
+			messageID, similarity, found = stored.messageID, sim, true
+		}
+	}
+
+	return messageID, similarity, found
+}
+
+// calculate the cosine similarity of given vectors
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
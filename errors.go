@@ -0,0 +1,139 @@
+package main
+
+// errors.go
+//
+// classifying errors from OpenAI API into categories, with actionable messages for users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+)
+
+// errorCategory type for categories of errors from OpenAI API
+type errorCategory string
+
+const (
+	errorCategoryRateLimited     errorCategory = "rate_limited"
+	errorCategoryQuotaExceeded   errorCategory = "quota_exceeded"
+	errorCategoryContentFiltered errorCategory = "content_filtered"
+	errorCategoryContextTooLong  errorCategory = "context_too_long"
+	errorCategoryOutage          errorCategory = "outage"
+	errorCategoryMisconfigured   errorCategory = "misconfigured"
+	errorCategoryTimeout         errorCategory = "timeout"
+	errorCategoryUnknown         errorCategory = "unknown"
+
+	msgErrorRateLimited       = "OpenAI is rate limiting this bot right now. Please retry after %s."
+	msgErrorRateLimitedSoon   = "OpenAI is rate limiting this bot right now. Please retry in a minute."
+	msgErrorQuotaExceeded     = "This bot has run out of its OpenAI quota. Please let the admin know."
+	msgErrorContentFiltered   = "Your request was blocked by OpenAI's content filter. Please rephrase it and try again."
+	msgErrorContextTooLong    = "This conversation is too long for the model. Send a new message (not as a reply) to start a new conversation, or shorten your message."
+	msgErrorOutage            = "OpenAI seems to be having problems right now. Please try again later."
+	msgErrorMisconfigured     = "This bot is not configured correctly for OpenAI. Please let the admin know."
+	msgErrorTimeout           = "Sorry, it took too long to generate an answer, so I gave up. Please try again, or with a shorter request."
+	msgErrorUnknownCompletion = "Failed to generate an answer from OpenAI. See the server logs for more information."
+)
+
+var (
+	httpStatusRegex = regexp.MustCompile(`http status (\d{3})`)
+	retryAfterRegex = regexp.MustCompile(`(?i)try again in ((?:\d+(?:\.\d+)?(?:ms|h|m|s))+)`)
+)
+
+// classify given error of OpenAI API
+//
+// (errors of openai-go have http statuses and the `error` objects of responses only in their messages, eg.
+// `http status 429: {"code":"rate_limit_exceeded","message":"... Please try again in 20s. ...","type":"tokens"}`)
+func classifyError(err error) errorCategory {
+	if err == nil {
+		return errorCategoryUnknown
+	}
+
+	// completions which exceeded `completion_timeout_seconds`
+	if errors.Is(err, errCompletionTimeout) {
+		return errorCategoryTimeout
+	}
+
+	// timeouts and network errors
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return errorCategoryOutage
+	}
+
+	status, apiErr := parseAPIError(err)
+
+	var code string
+	if apiErr.Code != nil {
+		code = *apiErr.Code
+	}
+
+	switch {
+	case code == "insufficient_quota":
+		return errorCategoryQuotaExceeded
+	case code == "rate_limit_exceeded" || status == 429:
+		return errorCategoryRateLimited
+	case code == "content_filter" || code == "content_policy_violation":
+		return errorCategoryContentFiltered
+	case code == "context_length_exceeded" || code == "string_above_max_length":
+		return errorCategoryContextTooLong
+	case code == "invalid_api_key" || code == "model_not_found" || status == 401 || status == 403:
+		return errorCategoryMisconfigured
+	case apiErr.Type == "server_error" || status >= 500:
+		return errorCategoryOutage
+	}
+
+	return errorCategoryUnknown
+}
+
+// parse the http status and the `error` object from given error of OpenAI API (if any)
+func parseAPIError(err error) (status int, apiErr openai.Error) {
+	message := err.Error()
+
+	if matches := httpStatusRegex.FindStringSubmatch(message); len(matches) > 1 {
+		status, _ = strconv.Atoi(matches[1])
+	}
+	if i := strings.Index(message, "{"); i >= 0 {
+		_ = json.Unmarshal([]byte(message[i:]), &apiErr)
+	}
+
+	return status, apiErr
+}
+
+// get the time to retry after from given error of OpenAI API, eg. "20s" (empty if not known)
+func retryAfter(err error) string {
+	if matches := retryAfterRegex.FindStringSubmatch(err.Error()); len(matches) > 1 {
+		return matches[1]
+	}
+
+	return ""
+}
+
+// generate an actionable message for users from given error of chat completion
+func completionErrorMessage(err error) string {
+	switch classifyError(err) {
+	case errorCategoryRateLimited:
+		if after := retryAfter(err); after != "" {
+			return fmt.Sprintf(msgErrorRateLimited, after)
+		}
+		return msgErrorRateLimitedSoon
+	case errorCategoryQuotaExceeded:
+		return msgErrorQuotaExceeded
+	case errorCategoryContentFiltered:
+		return msgErrorContentFiltered
+	case errorCategoryContextTooLong:
+		return msgErrorContextTooLong
+	case errorCategoryOutage:
+		return msgErrorOutage
+	case errorCategoryMisconfigured:
+		return msgErrorMisconfigured
+	case errorCategoryTimeout:
+		return msgErrorTimeout
+	default:
+		return msgErrorUnknownCompletion
+	}
+}
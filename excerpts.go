@@ -0,0 +1,113 @@
+package main
+
+// excerpts.go
+//
+// quoting excerpts of long documents which answers relied on
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	excerptSourceMinRunes = 2000 // min length of documents for quoting excerpts
+	excerptRunesMax       = 300  // max length of an excerpt
+	excerptWordRunesMin   = 4    // shorter words (eg. "the", "and") are not counted
+	excerptSharedWordsMin = 3    // min number of words shared with the answer
+)
+
+var sentenceRegex = regexp.MustCompile(`[^.!?。！？\n]+[.!?。！？]*`)
+
+// get the longest content of user messages, if it is long enough to be a source of excerpts
+func excerptSource(messages []openai.ChatMessage) (source string) {
+	for _, message := range messages {
+		if message.Role != openai.ChatMessageRoleUser {
+			continue
+		}
+		if content, err := message.ContentString(); err == nil && utf8.RuneCountInString(content) > utf8.RuneCountInString(source) {
+			source = content
+		}
+	}
+
+	if utf8.RuneCountInString(source) < excerptSourceMinRunes {
+		return ""
+	}
+	return source
+}
+
+// find the passage of `source` which given answer relied on the most (empty if there is no such passage)
+//
+// (passages of one or two sentences are scored by the words shared with the answer)
+func relevantExcerpt(source, answer string) string {
+	words := excerptWords(answer)
+
+	sentences := []string{}
+	for _, sentence := range sentenceRegex.FindAllString(source, -1) {
+		if sentence = strings.TrimSpace(sentence); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+
+	var best string
+	var bestScore float64
+	for i := range sentences {
+		for _, passage := range []string{sentences[i], strings.Join(sentences[i:min(i+2, len(sentences))], " ")} {
+			if utf8.RuneCountInString(passage) > excerptRunesMax {
+				continue
+			}
+
+			passageWords := excerptWords(passage)
+			shared := 0
+			for word := range passageWords {
+				if words[word] {
+					shared++
+				}
+			}
+			if shared < excerptSharedWordsMin {
+				continue
+			}
+
+			// (not to favor long passages)
+			if score := float64(shared) / math.Sqrt(float64(len(passageWords))); score > bestScore {
+				best, bestScore = passage, score
+			}
+		}
+	}
+
+	return best
+}
+
+// get the set of words of given text, without short ones
+func excerptWords(text string) map[string]bool {
+	words := map[string]bool{}
+	for word := range questionWords(text) {
+		if utf8.RuneCountInString(word) >= excerptWordRunesMin {
+			words[word] = true
+		}
+	}
+
+	return words
+}
+
+// prepend given excerpt to the answer as a quote
+//
+// (returns the HTML text for `html_answers`, or the text and its entities otherwise)
+func quoteExcerpt(conf config, text, excerpt string, sensitive bool) (quoted string, entities []tg.MessageEntity) {
+	if conf.HTMLAnswers && !sensitive {
+		return fmt.Sprintf("<blockquote>%s</blockquote>\n%s", html.EscapeString(excerpt), text), nil
+	}
+
+	return excerpt + "\n\n" + text, []tg.MessageEntity{{
+		Type:   tg.MessageEntityTypeBlockquote,
+		Offset: 0,
+		Length: len(utf16.Encode([]rune(excerpt))), // (offsets and lengths are in UTF-16 code units)
+	}}
+}
@@ -0,0 +1,186 @@
+package main
+
+// explainerror.go
+//
+// diagnosing pasted stack traces or log snippets
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdExplainError = "/explainerror"
+
+	systemPromptExplainError = `You are an expert software debugger. The user will give you a stack trace, an error message, or a log snippet (and optionally, some notes about it).
+
+Diagnose it, and answer in this format:
+
+**Summary**: what went wrong, in one or two sentences.
+**Root cause**: the most likely cause, quoting the relevant lines of the given text in a fenced code block.
+**Fix**: concrete steps or code changes for fixing it, with code in fenced code blocks (with languages).
+**Further checks**: what to check next, if the cause is not certain.
+
+Be concise, and do not use other markdown syntaxes than bold texts, inline codes, and fenced code blocks.`
+
+	msgExplainErrorUsage = "Reply to a message (or a document) with a stack trace or log snippet with /explainerror [notes]."
+	msgExplainErrorEmpty = "Failed to read the replied message."
+)
+
+var (
+	fenceLanguageRegex = regexp.MustCompile(`^[\w+\-]*`)
+	inlineCodeRegex    = regexp.MustCompile("`([^`\\n]+)`")
+	boldTextRegex      = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+)
+
+// return an /explainerror command handler
+func explainErrorCommandHandler(client *openai.Client, db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("explainerror command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		if isRefusingAPIRequest(b, conf, update, *message) {
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		replyTo := repliedToMessage(*message)
+		if replyTo == nil || (!replyTo.HasText() && !replyTo.HasDocument()) {
+			send(b, conf, msgExplainErrorUsage, chatID, &messageID)
+			return
+		}
+
+		// reject documents which are not acceptable, before downloading them
+		if replyTo.Document != nil {
+			if err := checkDocument(conf, *replyTo.Document); err != nil {
+				send(b, conf, documentNotSupportedMessage(conf, err), chatID, &messageID)
+				return
+			}
+		}
+
+		var snippet string
+		if chatMessage := convertMessage(b, *replyTo); chatMessage != nil {
+			snippet, _ = chatMessage.ContentString()
+		}
+		if strings.TrimSpace(snippet) == "" {
+			send(b, conf, msgExplainErrorEmpty, chatID, &messageID)
+			return
+		}
+
+		prompt := fmt.Sprintf("```\n%s\n```", strings.Trim(snippet, "\n"))
+		if notes := strings.TrimSpace(args); notes != "" {
+			prompt += "\n\n" + notes
+		}
+
+		model := chatModel(conf, db, chatID)
+		directives := messageDirectives{CodeBlocks: true}
+
+		thread := threadFor(conf, db, chatID, nil)
+		queueAnswer(b, conf, chatID, messageID, func() {
+			messages := condenseLargeMessages(b, client, conf, db, model, directives, []openai.ChatMessage{
+				openai.NewChatSystemMessage(systemPromptExplainError),
+				openai.NewChatUserMessage(prompt),
+			}, chatID, message.From.ID, userNameFromUpdate(update), messageID)
+
+			answer(b, client, conf, db, model, directives, messages, chatID, message.From.ID, userNameFromUpdate(update), messageID, thread)
+		})
+	}
+}
+
+// send given text (in markdown) as HTML, or as it is if it fails
+func sendFormatted(bot *tg.Bot, chatID int64, text string, options tg.OptionsSendMessage) (res tg.APIResponse[tg.Message]) {
+	if res = bot.SendMessage(chatID, markdownToHTML(text), options.SetParseMode(tg.ParseModeHTML)); res.Ok {
+		return res
+	}
+	log.Printf("failed to send formatted text, sending it as it is: %s", failureDescription(res))
+
+	delete(options, "parse_mode")
+	return bot.SendMessage(chatID, text, options)
+}
+
+// convert bold texts, inline codes, and fenced code blocks of given markdown text to telegram HTML
+func markdownToHTML(text string) string {
+	var sb strings.Builder
+
+	last := 0
+	for _, match := range findFencedCodeBlocks(text) {
+		sb.WriteString(inlineMarkdownToHTML(text[last:match[0]]))
+
+		lang, code := text[match[2]:match[3]], strings.TrimRight(text[match[4]:match[5]], "\n")
+		if lang != "" {
+			sb.WriteString(fmt.Sprintf(`<pre><code class="language-%s">%s</code></pre>`, lang, html.EscapeString(code)))
+		} else {
+			sb.WriteString(fmt.Sprintf("<pre>%s</pre>", html.EscapeString(code)))
+		}
+
+		last = match[1]
+	}
+	sb.WriteString(inlineMarkdownToHTML(text[last:]))
+
+	return sb.String()
+}
+
+// find fenced code blocks in given markdown text,
+// as indices of [start, end, language start, language end, code start, code end] (same as `FindAllStringSubmatchIndex`)
+//
+// (a block is closed only by a fence as long as its opening one, so blocks with longer fences can contain shorter ones)
+func findFencedCodeBlocks(text string) (blocks [][]int) {
+	for pos := 0; pos < len(text); {
+		start := strings.Index(text[pos:], "```")
+		if start < 0 {
+			break
+		}
+		start += pos
+
+		length := 3
+		for start+length < len(text) && text[start+length] == '`' {
+			length++
+		}
+		fence := text[start : start+length]
+
+		langStart := start + length
+		langEnd := langStart + len(fenceLanguageRegex.FindString(text[langStart:]))
+		codeStart := langEnd
+		if codeStart < len(text) && text[codeStart] == '\n' {
+			codeStart++
+		}
+
+		codeLength := strings.Index(text[codeStart:], fence)
+		if codeLength < 0 {
+			break
+		}
+		codeEnd := codeStart + codeLength
+
+		blocks = append(blocks, []int{start, codeEnd + length, langStart, langEnd, codeStart, codeEnd})
+		pos = codeEnd + length
+	}
+
+	return blocks
+}
+
+// convert bold texts and inline codes of given markdown text to telegram HTML
+func inlineMarkdownToHTML(text string) string {
+	text = html.EscapeString(text)
+	text = inlineCodeRegex.ReplaceAllString(text, "<code>$1</code>")
+	text = boldTextRegex.ReplaceAllString(text, "<b>$1</b>")
+
+	return text
+}
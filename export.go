@@ -0,0 +1,85 @@
+package main
+
+// export.go
+//
+// exporting conversations to external services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	exportTargetNotion = "notion"
+
+	msgExportUsage         = "Usage: /export-chat [notion]"
+	msgExportNotConfigured = "Export to %s is not configured."
+	msgExportEmpty         = "No conversation to export in this chat."
+	msgExportFailed        = "Failed to export the conversation. See the server logs for more information."
+	msgExported            = "Exported %d prompts of the conversation to %s."
+)
+
+// return a /export-chat command handler
+func exportChatCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("export-chat command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		switch target := strings.ToLower(strings.TrimSpace(args)); target {
+		case exportTargetNotion:
+			if conf.Notion == nil {
+				send(b, conf, fmt.Sprintf(msgExportNotConfigured, target), chatID, &messageID)
+				return
+			}
+
+			conversation, err := db.LatestConversation(chatID)
+			if err != nil {
+				send(b, conf, msgExportEmpty, chatID, &messageID)
+				return
+			}
+			prompts, err := db.ConversationPrompts(conversation.ID)
+			if err != nil || len(prompts) <= 0 {
+				send(b, conf, msgExportEmpty, chatID, &messageID)
+				return
+			}
+
+			title := conversation.Title
+			if title == "" {
+				title = ellipsize(prompts[0].Text, 50)
+			}
+			title = fmt.Sprintf("%s - %s", title, conversation.CreatedAt.Format(time.DateOnly))
+			if err := exportPromptsToNotion(*conf.Notion, title, prompts); err != nil {
+				log.Printf("failed to export conversation to notion: %s", err)
+
+				send(b, conf, msgExportFailed, chatID, &messageID)
+				return
+			}
+
+			send(b, conf, fmt.Sprintf(msgExported, len(prompts), target), chatID, &messageID)
+		default:
+			send(b, conf, msgExportUsage, chatID, &messageID)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+// finetune.go
+//
+// exporting logged prompts & answers as fine-tuning data, and uploading them to OpenAI
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdFineTune = "/finetune"
+
+	fineTuneArgLiked  = "liked"
+	fineTuneArgUpload = "upload"
+
+	fineTuneFilePurpose = "fine-tune"
+
+	msgFineTuneUsage    = "Usage: /finetune [liked] [upload]\n\n(<i>liked</i>: only answers with more 👍 than 👎, <i>upload</i>: upload the data to OpenAI files for fine-tuning)"
+	msgFineTuneEmpty    = "No prompt and answer to export."
+	msgFineTuneFailed   = "Failed to export fine-tuning data: %s"
+	msgFineTuneExported = "Exported <b>%d</b> example(s) for fine-tuning."
+	msgFineTuneUploaded = "Uploaded <b>%d</b> example(s) for fine-tuning: <code>%s</code>"
+)
+
+// fineTuneExample struct for a line of fine-tuning data in chat format
+type fineTuneExample struct {
+	Messages []fineTuneMessage `json:"messages"`
+}
+
+// fineTuneMessage struct for a message in a fine-tuning example
+type fineTuneMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// convert logged prompts & answers to fine-tuning data in JSONL
+//
+// (only successful answers are exported, and if `likedOnly` is set, only the ones with more positive feedbacks than negative ones)
+func exportFineTuneData(db Storage, likedOnly bool) (data []byte, count int, err error) {
+	var prompts []Prompt
+	if prompts, err = db.AllPrompts(); err != nil {
+		return nil, 0, err
+	}
+
+	// net feedbacks on answers, keyed by chat id and message id
+	var scores map[[2]int64]int
+	if likedOnly {
+		var feedbacks []Feedback
+		if feedbacks, err = db.AllFeedbacks(); err != nil {
+			return nil, 0, err
+		}
+
+		scores = map[[2]int64]int{}
+		for _, feedback := range feedbacks {
+			key := [2]int64{feedback.ChatID, feedback.MessageID}
+			if feedback.Positive {
+				scores[key]++
+			} else {
+				scores[key]--
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, prompt := range prompts {
+		// (only chat completions are exported)
+		if prompt.Kind != "" {
+			continue
+		}
+
+		question := strings.TrimSpace(prompt.Question)
+		if question == "" {
+			question = strings.TrimSpace(prompt.Text)
+		}
+		answer := strings.TrimSpace(prompt.Result.Text)
+		if !prompt.Result.Successful || question == "" || answer == "" {
+			continue
+		}
+		if likedOnly && scores[[2]int64{prompt.ChatID, prompt.Result.MessageID}] <= 0 {
+			continue
+		}
+
+		if err = encoder.Encode(fineTuneExample{
+			Messages: []fineTuneMessage{
+				{Role: string(openai.ChatMessageRoleUser), Content: question},
+				{Role: string(openai.ChatMessageRoleAssistant), Content: answer},
+			},
+		}); err != nil {
+			return nil, 0, err
+		}
+		count++
+	}
+
+	return buf.Bytes(), count, nil
+}
+
+// upload given fine-tuning data to OpenAI files, and return the id of the uploaded file
+func uploadFineTuneData(client *openai.Client, data []byte) (fileID string, err error) {
+	var uploaded openai.UploadedFile
+	if uploaded, err = client.UploadFile(openai.NewFileParamFromBytes(data), fineTuneFilePurpose); err != nil {
+		return "", err
+	}
+
+	return uploaded.ID, nil
+}
+
+// run `export-finetune` subcommand with its arguments, eg. ["out.jsonl", "--liked", "--upload"]
+func runExportFineTune(conf config, args []string) {
+	var output string
+	var likedOnly, upload bool
+	for _, arg := range args {
+		switch arg {
+		case "--" + fineTuneArgLiked:
+			likedOnly = true
+		case "--" + fineTuneArgUpload:
+			upload = true
+		default:
+			if output != "" || strings.HasPrefix(arg, "--") {
+				printUsage()
+				os.Exit(1)
+			}
+			output = arg
+		}
+	}
+	if output == "" {
+		printUsage()
+		os.Exit(1)
+	}
+
+	db := openDatabaseOrExit(conf)
+
+	data, count, err := exportFineTuneData(db, likedOnly)
+	if err != nil {
+		log.Printf("failed to export fine-tuning data: %s", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		log.Printf("failed to write fine-tuning data: %s", err)
+		os.Exit(1)
+	}
+	log.Printf("exported %d example(s) for fine-tuning to: %s", count, output)
+
+	if upload {
+		if count <= 0 {
+			log.Printf("nothing to upload")
+			os.Exit(1)
+		}
+
+		if err := setupTLSConfig(conf); err != nil {
+			log.Printf("failed to setup tls config: %s", err)
+			os.Exit(1)
+		}
+		client := openai.NewClient(conf.OpenAIAPIKey, conf.OpenAIOrganizationID)
+
+		fileID, err := uploadFineTuneData(client, data)
+		if err != nil {
+			log.Printf("failed to upload fine-tuning data: %s", err)
+			os.Exit(1)
+		}
+		log.Printf("uploaded fine-tuning data: %s", fileID)
+	}
+}
+
+// return a /finetune command handler
+func fineTuneCommandHandler(client *openai.Client, db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("finetune command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if !isAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		var likedOnly, upload bool
+		for _, arg := range strings.Fields(strings.ToLower(args)) {
+			switch arg {
+			case fineTuneArgLiked:
+				likedOnly = true
+			case fineTuneArgUpload:
+				upload = true
+			default:
+				send(b, conf, msgFineTuneUsage, chatID, &messageID)
+				return
+			}
+		}
+
+		data, count, err := exportFineTuneData(db, likedOnly)
+		if err != nil {
+			log.Printf("failed to export fine-tuning data: %s", err)
+
+			send(b, conf, fmt.Sprintf(msgFineTuneFailed, html.EscapeString(err.Error())), chatID, &messageID)
+			return
+		}
+		if count <= 0 {
+			send(b, conf, msgFineTuneEmpty, chatID, &messageID)
+			return
+		}
+
+		caption := fmt.Sprintf(msgFineTuneExported, count)
+		if upload {
+			stopTyping := keepChatAction(b, chatID, responseDocument)
+			fileID, err := uploadFineTuneData(client, data)
+			stopTyping()
+			if err != nil {
+				log.Printf("failed to upload fine-tuning data: %s", err)
+
+				send(b, conf, fmt.Sprintf(msgFineTuneFailed, html.EscapeString(err.Error())), chatID, &messageID)
+				return
+			}
+
+			logInfo("uploaded fine-tuning data by %s: %s (%d examples)", userNameFromUpdate(update), fileID, count)
+
+			caption = fmt.Sprintf(msgFineTuneUploaded, count, fileID)
+		}
+
+		sendChatAction(b, chatID, responseDocument)
+
+		if res := b.SendDocument(
+			chatID,
+			tg.InputFileFromBytes(data),
+			tg.OptionsSendDocument{}.
+				SetReplyParameters(tg.ReplyParameters{MessageID: messageID}).
+				SetCaption(caption).
+				SetParseMode(tg.ParseModeHTML)); !res.Ok {
+			log.Printf("failed to send fine-tuning data: %s", *res.Description)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+// forwarded.go
+//
+// attributing forwarded messages to their original senders
+
+import (
+	"fmt"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	forwardedDateFormat = "2006-01-02 15:04 MST"
+
+	msgForwardedFrom = "Forwarded from %s on %s:\n\n%s"
+)
+
+// prepend the original sender and date of given forwarded message to its content
+//
+// (the attribution comes from telegram, not from the content, so it can be trusted)
+func withForwardAttribution(message tg.Message, content string) string {
+	if message.ForwardOrigin == nil {
+		return content
+	}
+
+	origin := *message.ForwardOrigin
+	return fmt.Sprintf(msgForwardedFrom,
+		forwardedSender(origin),
+		time.Unix(int64(origin.Date), 0).UTC().Format(forwardedDateFormat),
+		content)
+}
+
+// describe the original sender of a forwarded message
+func forwardedSender(origin tg.MessageOrigin) string {
+	var sender string
+	switch {
+	case origin.SenderUser != nil:
+		sender = userName(origin.SenderUser)
+	case origin.SenderUserName != nil:
+		sender = *origin.SenderUserName
+	case origin.SenderChat != nil:
+		sender = chatTitle(*origin.SenderChat)
+	case origin.Chat != nil:
+		sender = fmt.Sprintf("channel %s", chatTitle(*origin.Chat))
+	default:
+		return "unknown"
+	}
+
+	if origin.AuthorSignature != nil {
+		sender += fmt.Sprintf(" (signed by %s)", *origin.AuthorSignature)
+	}
+
+	return sender
+}
+
+// get the title (or username) of given chat
+func chatTitle(chat tg.Chat) string {
+	if chat.Title != nil {
+		return fmt.Sprintf("%q", *chat.Title)
+	} else if chat.Username != nil {
+		return "@" + *chat.Username
+	}
+
+	return fmt.Sprintf("%d", chat.ID)
+}
@@ -0,0 +1,217 @@
+package main
+
+// glossary.go
+//
+// glossaries of chats, injected into system prompts for interpreting domain-specific terms correctly
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdGlossary = "/glossary"
+
+	glossaryArgAdd    = "add"
+	glossaryArgRemove = "remove"
+	glossaryArgClear  = "clear"
+
+	chatSettingKeyGlossary = "glossary" // json object of terms and their definitions
+
+	glossaryTermsMax           = 100 // max number of terms in a glossary
+	glossaryTermMaxRunes       = 100
+	glossaryDefinitionMaxRunes = 500
+
+	systemPromptGlossary = "The following glossary defines terms used in this chat. Always interpret these terms as defined here:\n\n%s"
+
+	msgGlossaryUsage    = "Usage: /glossary [add term: definition|remove term|clear]"
+	msgGlossaryEmpty    = "No terms in the glossary of this chat.\n\n" + msgGlossaryUsage
+	msgGlossaryList     = "Glossary of this chat (<b>%d</b> terms):\n\n%s"
+	msgGlossaryTooLong  = "Term or definition is too long (max: %d / %d chars)."
+	msgGlossaryTooMany  = "Too many terms in the glossary (max: %d)."
+	msgGlossaryAdded    = "Added to the glossary: <b>%s</b>"
+	msgGlossaryNotFound = "No such term in the glossary: <b>%s</b>"
+	msgGlossaryRemoved  = "Removed from the glossary: <b>%s</b>"
+	msgGlossaryCleared  = "Cleared the glossary of this chat."
+)
+
+// load the glossary (terms and their definitions) of a chat
+func chatGlossary(db Storage, chatID int64) (glossary map[string]string) {
+	glossary = map[string]string{}
+
+	if db == nil {
+		return glossary
+	}
+
+	if value, err := db.GetChatSetting(chatID, chatSettingKeyGlossary); err == nil && value != "" {
+		if err := json.Unmarshal([]byte(value), &glossary); err != nil {
+			log.Printf("failed to parse glossary of chat %d: %s", chatID, err)
+		}
+	}
+
+	return glossary
+}
+
+// save the glossary of a chat
+func saveChatGlossary(db Storage, chatID int64, glossary map[string]string) error {
+	var value string
+	if len(glossary) > 0 {
+		bytes, err := json.Marshal(glossary)
+		if err != nil {
+			return err
+		}
+		value = string(bytes)
+	}
+
+	return db.SetChatSetting(chatID, chatSettingKeyGlossary, value)
+}
+
+// get the terms of given glossary, sorted case-insensitively
+func glossaryTerms(glossary map[string]string) []string {
+	terms := make([]string, 0, len(glossary))
+	for term := range glossary {
+		terms = append(terms, term)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		return strings.ToLower(terms[i]) < strings.ToLower(terms[j])
+	})
+
+	return terms
+}
+
+// find the term of given glossary which matches given one case-insensitively
+func findGlossaryTerm(glossary map[string]string, term string) (found string, exists bool) {
+	for t := range glossary {
+		if strings.EqualFold(t, term) {
+			return t, true
+		}
+	}
+
+	return "", false
+}
+
+// append the glossary of a chat to the system prompt of given messages, if it has any term
+func withGlossary(db Storage, chatID int64, messages []openai.ChatMessage) []openai.ChatMessage {
+	glossary := chatGlossary(db, chatID)
+	if len(glossary) == 0 {
+		return messages
+	}
+
+	lines := []string{}
+	for _, term := range glossaryTerms(glossary) {
+		lines = append(lines, fmt.Sprintf("- %s: %s", term, glossary[term]))
+	}
+
+	return withSystemInstruction(messages, fmt.Sprintf(systemPromptGlossary, strings.Join(lines, "\n")))
+}
+
+// return a /glossary command handler
+func glossaryCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("glossary command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		glossary := chatGlossary(db, chatID)
+
+		action, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+		rest = strings.TrimSpace(rest)
+
+		// list terms
+		if action == "" {
+			if len(glossary) == 0 {
+				send(b, conf, msgGlossaryEmpty, chatID, &messageID)
+				return
+			}
+
+			lines := []string{}
+			for _, term := range glossaryTerms(glossary) {
+				lines = append(lines, fmt.Sprintf("• <b>%s</b>: %s", html.EscapeString(term), html.EscapeString(glossary[term])))
+			}
+			send(b, conf, fmt.Sprintf(msgGlossaryList, len(glossary), strings.Join(lines, "\n")), chatID, &messageID)
+			return
+		}
+
+		if action != glossaryArgAdd && action != glossaryArgRemove && action != glossaryArgClear {
+			send(b, conf, msgGlossaryUsage, chatID, &messageID)
+			return
+		}
+
+		// only admins can change settings of group chats
+		if message.Chat.Type != tg.ChatTypePrivate && !isChatAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		switch action {
+		case glossaryArgAdd:
+			term, definition, found := strings.Cut(rest, ":")
+			term, definition = strings.TrimSpace(term), strings.TrimSpace(definition)
+			if !found || term == "" || definition == "" {
+				send(b, conf, msgGlossaryUsage, chatID, &messageID)
+				return
+			}
+			if len([]rune(term)) > glossaryTermMaxRunes || len([]rune(definition)) > glossaryDefinitionMaxRunes {
+				send(b, conf, fmt.Sprintf(msgGlossaryTooLong, glossaryTermMaxRunes, glossaryDefinitionMaxRunes), chatID, &messageID)
+				return
+			}
+
+			// replace the existing definition (case-insensitively)
+			if existing, exists := findGlossaryTerm(glossary, term); exists {
+				delete(glossary, existing)
+			} else if len(glossary) >= glossaryTermsMax {
+				send(b, conf, fmt.Sprintf(msgGlossaryTooMany, glossaryTermsMax), chatID, &messageID)
+				return
+			}
+			glossary[term] = definition
+
+			msg = fmt.Sprintf(msgGlossaryAdded, html.EscapeString(term))
+		case glossaryArgRemove:
+			existing, exists := findGlossaryTerm(glossary, rest)
+			if !exists {
+				send(b, conf, fmt.Sprintf(msgGlossaryNotFound, html.EscapeString(rest)), chatID, &messageID)
+				return
+			}
+			delete(glossary, existing)
+
+			msg = fmt.Sprintf(msgGlossaryRemoved, html.EscapeString(existing))
+		case glossaryArgClear:
+			glossary = nil
+
+			msg = msgGlossaryCleared
+		}
+
+		if err := saveChatGlossary(db, chatID, glossary); err != nil {
+			log.Printf("failed to save glossary: %s", err)
+
+			msg = err.Error()
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
@@ -3,19 +3,25 @@ module github.com/meinside/telegram-chatgpt-bot
 go 1.21.3
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/meinside/geektoken v0.0.2
 	github.com/meinside/infisical-go v0.3.1
 	github.com/meinside/openai-go v0.4.5
 	github.com/meinside/telegram-bot-go v0.10.5
 	github.com/meinside/version-go v0.0.3
 	github.com/tailscale/hujson v0.0.0-20221223112325-20486734a56a
+	gorm.io/driver/postgres v1.5.7
 	gorm.io/driver/sqlite v1.5.5
 	gorm.io/gorm v1.25.7
 )
 
 require (
 	github.com/GRbit/go-pcre v1.0.1 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/pgx/v5 v5.4.3 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
-	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	golang.org/x/crypto v0.14.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
 )
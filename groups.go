@@ -0,0 +1,154 @@
+package main
+
+// groups.go
+//
+// handling the bot's own membership changes in groups
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	chatTypeSupergroup tg.ChatType = "supergroup" // NOTE: not defined in telegram-bot-go
+
+	groupAdminsTTL = 10 * time.Minute // how long fetched administrators of groups are cached
+
+	msgGroupWelcome = `Hello, I'm a ChatGPT bot.
+
+Mention or reply to me with your questions, and I will answer them.
+Send /help to see the list of available commands.`
+	msgGroupNotAllowed = `Sorry, I was added to this group by a user who is not allowed to use me, so I'm leaving now.`
+)
+
+// handle the bot's own membership change (`my_chat_member`)
+//
+// when added to a group by an allowed user, greets the group;
+// otherwise, explains why and leaves the group.
+func handleMyChatMember(bot *tg.Bot, conf config, update tg.Update) {
+	member := *update.MyChatMember
+
+	if !isGroup(member.Chat.Type) {
+		return
+	}
+	if !isJoined(member.OldChatMember.Status) && isJoined(member.NewChatMember.Status) {
+		chatID := member.Chat.ID
+
+		if isAllowed(update, conf) {
+			logInfo("added to group %d by %s", chatID, userName(&member.From))
+
+			send(bot, conf, msgGroupWelcome, chatID, nil)
+		} else {
+			log.Printf("added to group %d by a not allowed user: %s, leaving...", chatID, userName(&member.From))
+
+			send(bot, conf, msgGroupNotAllowed, chatID, nil)
+
+			if res := bot.LeaveChat(chatID); !res.Ok {
+				log.Printf("failed to leave group %d: %s", chatID, *res.Description)
+			}
+		}
+	}
+}
+
+// checks if given status means being a member of the chat
+func isJoined(status tg.ChatMemberStatus) bool {
+	switch status {
+	case tg.ChatMemberStatusCreator,
+		tg.ChatMemberStatusAdministrator,
+		tg.ChatMemberStatusMember,
+		tg.ChatMemberStatusRestricted:
+		return true
+	}
+	return false
+}
+
+// administrators of a group, fetched at `fetchedAt`
+type groupAdmins struct {
+	userIDs   map[int64]bool
+	fetchedAt time.Time
+}
+
+// cached administrators of groups, keyed by chat ids
+var _groupAdmins = map[int64]groupAdmins{}
+var _groupAdminsLock sync.RWMutex
+
+// checks if given chat type is a group
+func isGroup(chatType tg.ChatType) bool {
+	return chatType == tg.ChatTypeGroup || chatType == chatTypeSupergroup
+}
+
+// get the group chat of given update (nil if not from a group)
+func groupChat(update tg.Update) *tg.Chat {
+	var chat *tg.Chat
+	if message, _ := update.GetMessage(); message != nil {
+		chat = &message.Chat
+	} else if update.MessageReaction != nil {
+		chat = &update.MessageReaction.Chat
+	}
+
+	if chat != nil && isGroup(chat.Type) {
+		return chat
+	}
+	return nil
+}
+
+// fetch and cache administrators of the group of given update, if they are not cached or expired
+//
+// (does nothing when `group_admins_as_bot_admins` is not set)
+func cacheGroupAdmins(bot *tg.Bot, update tg.Update) {
+	if !currentConfig().GroupAdminsAsBotAdmins {
+		return
+	}
+
+	chat := groupChat(update)
+	if chat == nil {
+		return
+	}
+
+	_groupAdminsLock.RLock()
+	cached, exists := _groupAdmins[chat.ID]
+	_groupAdminsLock.RUnlock()
+	if exists && time.Since(cached.fetchedAt) < groupAdminsTTL {
+		return
+	}
+
+	// NOTE: failures are also cached, not to retry on every update
+	admins := groupAdmins{userIDs: map[int64]bool{}, fetchedAt: time.Now()}
+	if res := bot.GetChatAdministrators(chat.ID); res.Ok {
+		for _, member := range *res.Result {
+			if member.User.IsBot {
+				continue
+			}
+			admins.userIDs[member.User.ID] = true
+		}
+	} else {
+		log.Printf("failed to get administrators of group %d: %s", chat.ID, *res.Description)
+	}
+
+	_groupAdminsLock.Lock()
+	_groupAdmins[chat.ID] = admins
+	_groupAdminsLock.Unlock()
+}
+
+// checks if given update is from an administrator of the group where it was sent
+//
+// (groups are allowed ones, as the bot leaves groups it was added to by not allowed users)
+func isGroupAdmin(update tg.Update, conf config) bool {
+	if !conf.GroupAdminsAsBotAdmins {
+		return false
+	}
+
+	chat := groupChat(update)
+	from := fromUser(update)
+	if chat == nil || from == nil {
+		return false
+	}
+
+	_groupAdminsLock.RLock()
+	defer _groupAdminsLock.RUnlock()
+
+	return _groupAdmins[chat.ID].userIDs[from.ID]
+}
@@ -0,0 +1,179 @@
+package main
+
+// image.go
+//
+// generating images with /image
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdImage = "/image"
+
+	promptKindImage = "image" // kind of logged prompts for generated images
+
+	imageModelDefault   = "dall-e-3"
+	imageSizeDefault    = "1024x1024"
+	imageQualityDefault = "standard"
+
+	imageAnswerText = "(generated image)" // logged as the answer of a generated image
+
+	msgImageUsage  = "Usage: /image [prompt]"
+	msgImageFailed = "Failed to generate an image. See the server logs for more information."
+)
+
+// imageConfig struct for generating images
+type imageConfig struct {
+	Model   string `json:"model,omitempty"`   // (default: "dall-e-3")
+	Size    string `json:"size,omitempty"`    // eg. "1024x1024" (default), "1792x1024"
+	Quality string `json:"quality,omitempty"` // eg. "standard" (default), "hd"
+}
+
+// get the model, size, and quality of images from config (or the default ones)
+func imageOptions(conf config) (model, size, quality string) {
+	model, size, quality = imageModelDefault, imageSizeDefault, imageQualityDefault
+	if conf.Image != nil {
+		if conf.Image.Model != "" {
+			model = conf.Image.Model
+		}
+		if conf.Image.Size != "" {
+			size = conf.Image.Size
+		}
+		if conf.Image.Quality != "" {
+			quality = conf.Image.Quality
+		}
+	}
+
+	return model, size, quality
+}
+
+// generate an image with given prompt, and return its bytes
+func generateImage(client *openai.Client, conf config, prompt string, userID int64) (data []byte, err error) {
+	model, size, quality := imageOptions(conf)
+
+	options := openai.ImageOptions{}.
+		SetModel(model).
+		SetSize(openai.ImageSize(size)).
+		SetQuality(quality).
+		SetUser(userAgent(userID))
+	if strings.HasPrefix(model, "dall-e") {
+		// (newer models always respond with base64-encoded images)
+		options = options.SetResponseFormat(openai.IamgeResponseFormatBase64JSON)
+	}
+
+	var generated openai.GeneratedImages
+	if generated, err = client.CreateImage(prompt, options); err != nil {
+		return nil, err
+	}
+	if len(generated.Data) <= 0 {
+		return nil, fmt.Errorf("no image in response")
+	}
+
+	image := generated.Data[0]
+	if image.Base64JSON != nil {
+		return base64.StdEncoding.DecodeString(*image.Base64JSON)
+	} else if image.URL != nil {
+		return readBinaryContentAtURL(*image.URL, maxPhotoBytes)
+	}
+
+	return nil, fmt.Errorf("no data or url of image in response")
+}
+
+// return a /image command handler
+func imageCommandHandler(client *openai.Client, db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("image command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil || message.From == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		if isRefusingAPIRequest(b, conf, update, *message) {
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+		userID := message.From.ID
+
+		prompt := strings.TrimSpace(args)
+		if prompt == "" {
+			send(b, conf, msgImageUsage, chatID, &messageID)
+			return
+		}
+
+		// not to save the prompt in private mode
+		logDB := db
+		if isPrivateModeOn(db, userID) {
+			logDB = nil
+		}
+
+		model, _, _ := imageOptions(conf)
+		logged := Prompt{
+			Kind:      promptKindImage,
+			ChatID:    chatID,
+			UserID:    userID,
+			Username:  userNameFromUpdate(update),
+			MessageID: messageID,
+			Question:  prompt,
+			Text:      prompt,
+		}
+
+		stopUploading := keepChatAction(b, chatID, responsePhoto)
+		data, err := generateImage(client, conf, prompt, userID)
+		stopUploading()
+		if err != nil {
+			log.Printf("failed to generate image (%s): %s", classifyError(err), err)
+
+			send(b, conf, msgImageFailed, chatID, &messageID)
+
+			if event, caused := abuseEventOfError(err); caused {
+				recordAbuseEvent(b, conf, userID, usernameOf(message.From), event)
+			}
+
+			savePromptAndResult(logDB, false, &logged, 0, Generated{
+				ChatModel:  model,
+				Successful: false,
+				Text:       err.Error(),
+			})
+			return
+		}
+
+		if res := b.SendPhoto(chatID, tg.InputFileFromBytes(data), tg.OptionsSendPhoto{}.
+			SetReplyParameters(tg.ReplyParameters{MessageID: messageID}).
+			SetDisableNotification(isQuietHours(db, chatID))); res.Ok {
+			archiveImage(conf, logDB, imageSourceGenerated, chatID, userID, res.Result.MessageID, data)
+
+			savePromptAndResult(logDB, false, &logged, 0, Generated{
+				ChatModel:  model,
+				Successful: true,
+				Text:       imageAnswerText,
+				MessageID:  res.Result.MessageID,
+			})
+		} else {
+			log.Printf("failed to send generated image: %s", *res.Description)
+
+			send(b, conf, msgImageFailed, chatID, &messageID)
+
+			savePromptAndResult(logDB, false, &logged, 0, Generated{
+				ChatModel:  model,
+				Successful: false,
+				Text:       *res.Description,
+			})
+		}
+	}
+}
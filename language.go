@@ -0,0 +1,130 @@
+package main
+
+// language.go
+//
+// forcing the language of answers, bot-wide or per chat
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdLanguage = "/language"
+
+	languageArgReset = "reset"
+
+	chatSettingKeyResponseLanguage = "response_language"
+
+	systemPromptResponseLanguage = "Always answer in %s, regardless of the language of the question."
+
+	msgLanguageUsage   = "Usage: /language [language|reset]\n\n(currently: <b>%s</b>)"
+	msgLanguageChanged = "Answers in this chat will be in: <b>%s</b>"
+	msgLanguageReset   = "Language of answers in this chat is reset to: <b>%s</b>"
+)
+
+// get the forced language of answers for given chat (empty if not forced)
+//
+// (per-chat override takes precedence over `response_language` of config)
+func responseLanguage(conf config, db Storage, chatID int64) string {
+	if db != nil {
+		if value, err := db.GetChatSetting(chatID, chatSettingKeyResponseLanguage); err == nil && value != "" {
+			return value
+		}
+	}
+
+	return conf.ResponseLanguage
+}
+
+// append a language instruction to the system prompt of given messages, if a language is forced for given chat
+func withResponseLanguage(conf config, db Storage, chatID int64, messages []openai.ChatMessage) []openai.ChatMessage {
+	language := responseLanguage(conf, db, chatID)
+	if language == "" {
+		return messages
+	}
+
+	return withSystemInstruction(messages, fmt.Sprintf(systemPromptResponseLanguage, language))
+}
+
+// append given instruction to the system prompt of given messages (or prepend a new system prompt)
+func withSystemInstruction(messages []openai.ChatMessage, instruction string) []openai.ChatMessage {
+	// append to the existing system prompt,
+	if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+		if content, err := messages[0].ContentString(); err == nil {
+			messages[0] = openai.NewChatSystemMessage(content + "\n\n" + instruction)
+			return messages
+		}
+	}
+
+	// or prepend a new one
+	return append([]openai.ChatMessage{openai.NewChatSystemMessage(instruction)}, messages...)
+}
+
+// describe the forced language of answers for given chat
+func describeResponseLanguage(conf config, db Storage, chatID int64) string {
+	if language := responseLanguage(conf, db, chatID); language != "" {
+		return html.EscapeString(language)
+	}
+	return "<i>(not forced)</i>"
+}
+
+// return a /language command handler
+func languageCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("language command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		language := strings.TrimSpace(args)
+		if language == "" {
+			send(b, conf, fmt.Sprintf(msgLanguageUsage, describeResponseLanguage(conf, db, chatID)), chatID, &messageID)
+			return
+		}
+
+		// only admins can change settings of group chats
+		if message.Chat.Type != tg.ChatTypePrivate && !isChatAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		var value string
+		if language != languageArgReset {
+			value = language
+		}
+
+		var msg string
+		if err := db.SetChatSetting(chatID, chatSettingKeyResponseLanguage, value); err != nil {
+			log.Printf("failed to change response language: %s", err)
+
+			msg = err.Error()
+		} else if value == "" {
+			msg = fmt.Sprintf(msgLanguageReset, describeResponseLanguage(conf, db, chatID))
+		} else {
+			msg = fmt.Sprintf(msgLanguageChanged, html.EscapeString(value))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+// localize.go
+//
+// localizing bot messages in the languages of users
+
+import (
+	"strings"
+	"unicode"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	msgHelpKo = `도움말:
+
+/count [텍스트] : 주어진 텍스트(또는 답장한 메시지나 문서)의 토큰 수를 셉니다.
+/explainerror [메모] : 답장한 스택 트레이스나 로그를 진단합니다.
+/summarize-from : 답장한 메시지 이후 이 채팅의 대화를 요약합니다.
+/alt [메모] : 답장한 사진의 대체 텍스트를 생성합니다.
+/image [프롬프트] : 프롬프트로 이미지를 생성합니다.
+/save [name] : 답장한 메시지를 내 프롬프트로 저장합니다.
+/use [name] [input] : 저장한 프롬프트를 실행합니다(입력은 선택).
+/saved : 저장한 프롬프트 목록을 보여줍니다.
+/stats : 이 봇의 통계를 보여줍니다.
+/models : 사용 가능한 채팅 모델 목록을 보여줍니다.
+/model [model|alias|reset] : 이 채팅의 모델을 변경합니다.
+/history [n] : 이 채팅의 최근 n개 질문과 답변을 보여줍니다.
+/new : 이 채팅에서 새 대화를 시작합니다.
+/prompt : 다음 메시지에 첨부될 컨텍스트를 보여줍니다.
+/whoami : 텔레그램 계정과 설정, 남은 사용량을 보여줍니다.
+/voice [on|off] : 음성 모드(음성으로도 답변)를 켜거나 끕니다.
+/dictate [on|off] : 받아쓰기 모드(음성을 답변 없이 텍스트로 변환)를 켜거나 끕니다.
+/private [on|off] : 비공개 모드(프롬프트와 답변을 저장하지 않음)를 켜거나 끕니다.
+/language [language|reset] : 이 채팅의 답변 언어를 지정합니다.
+/system [prompt|reset] : 이 채팅의 시스템 프롬프트를 설정합니다.
+/glossary [add term: definition|remove term|clear] : 이 채팅의 용어집을 관리합니다.
+/depth [turns [max_tokens]|reset] : 이 채팅에서 요청에 첨부할 대화 기록의 깊이를 설정합니다.
+/export-chat [notion] : 이 채팅의 현재 대화를 내보냅니다.
+/share : 이 채팅의 현재 대화를 읽기 전용 페이지로 공유합니다.
+/pin : 답장한 답변을 이 채팅에 고정합니다.
+/quiet [HH:MM-HH:MM [timezone]|off] : 이 채팅의 방해 금지 시간을 설정합니다.
+/timezone [timezone|reset] : 일정과 날짜에 사용할 시간대를 설정합니다.
+/digest [on|off] : 이 채팅의 다이제스트 모드(급하지 않은 메시지를 하루에 한 번 전달)를 켜거나 끕니다.
+
+(관리자용)
+/broadcast [send] [message] : 모든 채팅에 메시지를 보냅니다.
+/maintenance [on|off] : 점검 모드를 켜거나 끕니다.
+/block [username] : 사용자를 차단합니다(또는 차단된 사용자 목록을 보여줍니다).
+/unblock [username] : 사용자의 차단(또는 쿨다운)을 해제합니다.
+/loglevel [debug|info|warn] : 로그 레벨을 변경합니다.
+/reload : 설정 파일을 다시 읽어옵니다.
+/trace [chat_id] [on|off] : 채팅의 요청 추적 정보를 관리자 채팅으로 보냅니다.
+/query [select statement] : 로그 데이터베이스에 읽기 전용 SQL 쿼리를 실행합니다.
+/finetune [liked] [upload] : 기록된 프롬프트와 답변을 파인튜닝 데이터로 내보내고, OpenAI에 업로드합니다.
+/help : 이 도움말을 보여줍니다.
+
+<i>version: %s</i>
+`
+	msgHelpJa = `ヘルプ:
+
+/count [テキスト] : テキスト(または返信したメッセージや文書)のトークン数を数えます。
+/explainerror [メモ] : 返信したスタックトレースやログを診断します。
+/summarize-from : 返信したメッセージ以降のこのチャットの会話を要約します。
+/alt [メモ] : 返信した写真の代替テキストを生成します。
+/image [プロンプト] : プロンプトから画像を生成します。
+/save [name] : 返信したメッセージを自分のプロンプトとして保存します。
+/use [name] [input] : 保存したプロンプトを実行します(入力は任意)。
+/saved : 保存したプロンプトを一覧表示します。
+/stats : このボットの統計を表示します。
+/models : 利用可能なチャットモデルを一覧表示します。
+/model [model|alias|reset] : このチャットのモデルを変更します。
+/history [n] : このチャットの直近n件の質問と回答を表示します。
+/new : このチャットで新しい会話を始めます。
+/prompt : 次のメッセージに添付されるコンテキストを表示します。
+/whoami : Telegramアカウントと設定、残りの利用枠を表示します。
+/voice [on|off] : 音声モード(音声でも回答)をオン/オフにします。
+/dictate [on|off] : 書き起こしモード(音声を回答せずにテキスト化)をオン/オフにします。
+/private [on|off] : プライベートモード(プロンプトと回答を保存しない)をオン/オフにします。
+/language [language|reset] : このチャットの回答言語を指定します。
+/system [prompt|reset] : このチャットのシステムプロンプトを設定します。
+/glossary [add term: definition|remove term|clear] : このチャットの用語集を管理します。
+/depth [turns [max_tokens]|reset] : このチャットでリクエストに添付する会話履歴の深さを設定します。
+/export-chat [notion] : このチャットの現在の会話をエクスポートします。
+/share : このチャットの現在の会話を読み取り専用ページとして共有します。
+/pin : 返信した回答をこのチャットにピン留めします。
+/quiet [HH:MM-HH:MM [timezone]|off] : このチャットのおやすみ時間を設定します。
+/timezone [timezone|reset] : スケジュールや日付に使うタイムゾーンを設定します。
+/digest [on|off] : このチャットのダイジェストモード(急ぎでないメッセージを1日1回まとめて配信)をオン/オフにします。
+
+(管理者向け)
+/broadcast [send] [message] : すべてのチャットにメッセージを送信します。
+/maintenance [on|off] : メンテナンスモードをオン/オフにします。
+/block [username] : ユーザーをブロックします(またはブロック中のユーザーを一覧表示します)。
+/unblock [username] : ユーザーのブロック(またはクールダウン)を解除します。
+/loglevel [debug|info|warn] : ログレベルを変更します。
+/reload : 設定ファイルを再読み込みします。
+/trace [chat_id] [on|off] : チャットのリクエストの詳細な追跡情報を管理者チャットに送信します。
+/query [select statement] : ログデータベースに読み取り専用のSQLクエリを実行します。
+/finetune [liked] [upload] : 記録されたプロンプトと回答をファインチューニング用データとしてエクスポートし、OpenAIにアップロードします。
+/help : このヘルプを表示します。
+
+<i>version: %s</i>
+`
+	msgHelpEs = `Ayuda:
+
+/count [texto] : cuenta el número de tokens de un texto (o del mensaje o documento respondido).
+/explainerror [notas] : diagnostica el stack trace o log respondido.
+/summarize-from : resume las conversaciones de este chat desde el mensaje respondido.
+/alt [notas] : genera el texto alternativo de la foto respondida.
+/image [prompt] : genera una imagen con el prompt.
+/save [name] : guarda el mensaje respondido como tu prompt.
+/use [name] [input] : ejecuta tu prompt guardado (con una entrada opcional).
+/saved : lista tus prompts guardados.
+/stats : muestra las estadísticas de este bot.
+/models : lista los modelos de chat disponibles.
+/model [model|alias|reset] : cambia el modelo de este chat.
+/history [n] : muestra las últimas n preguntas y respuestas de este chat.
+/new : inicia una nueva conversación en este chat.
+/prompt : muestra el contexto que se adjuntará a tu próximo mensaje.
+/whoami : muestra tu cuenta de telegram, tus ajustes y tus cuotas restantes.
+/voice [on|off] : activa/desactiva el modo de voz (respuestas también con voz).
+/dictate [on|off] : activa/desactiva el modo dictado (voces transcritas, sin respuesta).
+/private [on|off] : activa/desactiva el modo privado (prompts y respuestas no guardados).
+/language [language|reset] : fija el idioma de las respuestas en este chat.
+/system [prompt|reset] : establece el prompt del sistema de este chat.
+/glossary [add term: definition|remove term|clear] : gestiona el glosario de este chat.
+/depth [turns [max_tokens]|reset] : fija la profundidad del historial adjunto a las solicitudes en este chat.
+/export-chat [notion] : exporta la conversación actual de este chat.
+/share : comparte la conversación actual de este chat como una página de solo lectura.
+/pin : fija la respuesta respondida en este chat.
+/quiet [HH:MM-HH:MM [timezone]|off] : establece las horas de silencio de este chat.
+/timezone [timezone|reset] : establece tu zona horaria para horarios y fechas.
+/digest [on|off] : activa/desactiva el modo resumen (mensajes no urgentes entregados una vez al día) de este chat.
+
+(para administradores)
+/broadcast [send] [message] : envía un mensaje a todos los chats.
+/maintenance [on|off] : activa/desactiva el modo de mantenimiento.
+/block [username] : bloquea a un usuario (o lista los usuarios bloqueados).
+/unblock [username] : desbloquea a un usuario (o levanta su pausa temporal).
+/loglevel [debug|info|warn] : cambia el nivel de log.
+/reload : recarga el archivo de configuración.
+/trace [chat_id] [on|off] : envía trazas detalladas de las solicitudes de un chat al chat de administradores.
+/query [select statement] : ejecuta una consulta SQL de solo lectura en la base de datos de logs.
+/finetune [liked] [upload] : exporta los prompts y respuestas registrados como datos de fine-tuning, y los sube a OpenAI.
+/help : muestra este mensaje de ayuda.
+
+<i>version: %s</i>
+`
+)
+
+// translations of bot messages, keyed by (primary) language codes and the original messages
+var _translations = map[string]map[string]string{
+	"ko": {
+		msgStart:                 "이 봇은 ChatGPT API로 메시지에 답변합니다 :-)",
+		msgHelp:                  msgHelpKo,
+		msgCmdNotSupported:       "지원하지 않는 명령어입니다: %s",
+		msgTypeNotSupported:      "지원하지 않는 메시지 유형입니다.",
+		msgDatabaseNotConfigured: "데이터베이스가 설정되지 않았습니다. 설정 파일에 `db_filepath`를 지정하세요.",
+		msgNoUsableMessages:      "입력에서 사용할 수 있는 메시지를 얻지 못했습니다. 자세한 내용은 서버 로그를 확인하세요.",
+		msgNotAdmin:              "관리자 전용 명령어입니다.",
+	},
+	"ja": {
+		msgStart:                 "このボットはChatGPT APIであなたのメッセージに回答します :-)",
+		msgHelp:                  msgHelpJa,
+		msgCmdNotSupported:       "サポートされていないコマンドです: %s",
+		msgTypeNotSupported:      "サポートされていないメッセージの種類です。",
+		msgDatabaseNotConfigured: "データベースが設定されていません。設定ファイルで `db_filepath` を指定してください。",
+		msgNoUsableMessages:      "入力から利用可能なメッセージを取得できませんでした。詳しくはサーバーのログを確認してください。",
+		msgNotAdmin:              "このコマンドは管理者専用です。",
+	},
+	"es": {
+		msgStart:                 "Este bot responderá a tus mensajes con la API de ChatGPT :-)",
+		msgHelp:                  msgHelpEs,
+		msgCmdNotSupported:       "Comando no soportado: %s",
+		msgTypeNotSupported:      "Tipo de mensaje no soportado.",
+		msgDatabaseNotConfigured: "Base de datos no configurada. Establece `db_filepath` en tu archivo de configuración.",
+		msgNoUsableMessages:      "No se pudieron obtener mensajes utilizables de tu entrada. Consulta los logs del servidor para más información.",
+		msgNotAdmin:              "Este comando es solo para administradores.",
+	},
+}
+
+// localize given bot message in the language of the sender of given telegram message
+//
+// (returns the message as it is if it has no translation, or `disable_localization` is set)
+func localize(conf config, message tg.Message, msg string) string {
+	if conf.DisableLocalization {
+		return msg
+	}
+
+	if translated, exists := _translations[userLanguage(message)][msg]; exists {
+		return translated
+	}
+
+	return msg
+}
+
+// detect the language of the sender of given telegram message
+//
+// (scripts in the text of the message take precedence over `language_code` of the sender)
+func userLanguage(message tg.Message) string {
+	if message.Text != nil {
+		if language := languageOfScripts(*message.Text); language != "" {
+			return language
+		}
+	}
+
+	if message.From != nil && message.From.LanguageCode != nil {
+		// eg. "pt-br" => "pt"
+		language, _, _ := strings.Cut(strings.ToLower(*message.From.LanguageCode), "-")
+		return language
+	}
+
+	return ""
+}
+
+// detect a language from unambiguous scripts of given text (eg. hangul => korean)
+func languageOfScripts(text string) string {
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Hangul, r):
+			return "ko"
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			return "ja"
+		}
+	}
+
+	return ""
+}
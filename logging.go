@@ -0,0 +1,81 @@
+package main
+
+// logging.go
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// logLevel type for log levels
+type logLevel int32
+
+// logLevel constants
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarn
+)
+
+// current log level
+var _logLevel atomic.Int32
+
+// string representation of log level
+func (l logLevel) String() string {
+	switch l {
+	case logLevelDebug:
+		return "debug"
+	case logLevelInfo:
+		return "info"
+	case logLevelWarn:
+		return "warn"
+	}
+
+	return fmt.Sprintf("unknown(%d)", int32(l))
+}
+
+// parse given string as a log level
+func parseLogLevel(str string) (level logLevel, err error) {
+	for _, l := range []logLevel{logLevelDebug, logLevelInfo, logLevelWarn} {
+		if l.String() == str {
+			return l, nil
+		}
+	}
+
+	return logLevelInfo, fmt.Errorf("no such log level: %s", str)
+}
+
+// get current log level
+func currentLogLevel() logLevel {
+	return logLevel(_logLevel.Load())
+}
+
+// set log level
+//
+// NOTE: `Verbose` of openai api clients are set only when they are created (from the `verbose` value of config),
+// for they are read by concurrent requests without synchronization; changing it needs a restart.
+func setLogLevel(level logLevel) {
+	_logLevel.Store(int32(level))
+}
+
+// set log level with the `verbose` value of given config
+func setLogLevelFromConfig(conf config) {
+	if conf.Verbose {
+		setLogLevel(logLevelDebug)
+	} else {
+		setLogLevel(logLevelInfo)
+	}
+}
+
+// checks if verbose messages should be logged
+func isVerbose() bool {
+	return currentLogLevel() <= logLevelDebug
+}
+
+// log informational message (only when log level is debug or info)
+func logInfo(format string, v ...any) {
+	if currentLogLevel() <= logLevelInfo {
+		log.Printf(format, v...)
+	}
+}
@@ -8,6 +8,14 @@ import (
 	"os"
 )
 
+const (
+	subcmdExportObsidian = "export-obsidian"
+	subcmdBatch          = "batch"
+	subcmdMigrateDB      = "migrate-db"
+	subcmdExportFineTune = "export-finetune"
+	subcmdSelfTest       = "selftest"
+)
+
 func main() {
 	if len(os.Args) <= 1 {
 		printUsage()
@@ -15,16 +23,82 @@ func main() {
 		confFilepath := os.Args[1]
 
 		if conf, err := loadConfig(confFilepath); err == nil {
-			runBot(conf)
+			if len(os.Args) > 2 {
+				runSubcommand(conf, os.Args[2], os.Args[3:])
+			} else {
+				runBot(confFilepath, conf)
+			}
 		} else {
 			log.Printf("failed to load config: %s", err)
 		}
 	}
 }
 
+// run given subcommand with its arguments
+func runSubcommand(conf config, subcmd string, args []string) {
+	switch subcmd {
+	case subcmdExportObsidian:
+		if len(args) <= 0 {
+			printUsage()
+			os.Exit(1)
+		}
+
+		db := openDatabaseOrExit(conf)
+
+		if count, err := exportObsidianVault(db, args[0]); err == nil {
+			log.Printf("exported %d notes to: %s", count, args[0])
+		} else {
+			log.Printf("failed to export notes: %s", err)
+			os.Exit(1)
+		}
+	case subcmdBatch:
+		if len(args) < 2 {
+			printUsage()
+			os.Exit(1)
+		}
+
+		if succeeded, failed, err := runBatch(conf, args[0], args[1]); err == nil {
+			log.Printf("processed batch requests: %d succeeded, %d failed (results in: %s)", succeeded, failed, args[1])
+		} else {
+			log.Printf("failed to process batch requests: %s", err)
+			os.Exit(1)
+		}
+	case subcmdMigrateDB:
+		runMigrateDB(args)
+	case subcmdExportFineTune:
+		runExportFineTune(conf, args)
+	case subcmdSelfTest:
+		runSelfTestCommand(conf)
+	default:
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+// open the database in config, or exit with an error
+func openDatabaseOrExit(conf config) Storage {
+	if conf.RequestLogsDBFilepath == "" {
+		log.Printf("`db_filepath` is not set in the config file")
+		os.Exit(1)
+	}
+
+	db, err := OpenStorage(conf.DBDriver, conf.RequestLogsDBFilepath)
+	if err != nil {
+		log.Printf("failed to open database: %s", err)
+		os.Exit(1)
+	}
+
+	return db
+}
+
 // print usage string
 func printUsage() {
 	fmt.Printf(`
-Usage: %s [config_filepath]
-`, os.Args[0])
+Usage: %[1]s [config_filepath]
+       %[1]s [config_filepath] %[2]s [output_dir]
+       %[1]s [config_filepath] %[3]s [input_jsonl] [output_jsonl]
+       %[1]s [config_filepath] %[4]s --from [source_db] --to [destination_db]
+       %[1]s [config_filepath] %[5]s [output_jsonl] [--liked] [--upload]
+       %[1]s [config_filepath] %[6]s
+`, os.Args[0], subcmdExportObsidian, subcmdBatch, subcmdMigrateDB, subcmdExportFineTune, subcmdSelfTest)
 }
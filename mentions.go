@@ -0,0 +1,232 @@
+package main
+
+// mentions.go
+//
+// resolving users mentioned in group messages (eg. "answer @alice's question") to their recent messages, as contexts
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	groupMessagesMax         = 100 // max number of recent messages kept in memory for each group
+	mentionedMessagesMax     = 3   // max number of recent messages attached for each mentioned user
+	mentionedPromptsLookback = 50  // number of logged prompts searched when a mentioned user has no recent messages in memory
+	mentionNameMinRunes      = 3   // min length of first names matched in texts without @
+
+	msgMentionedMessages = "Recent messages of the mentioned users in this group chat:\n\n%s"
+)
+
+// groupMessage struct for a recent message in a group
+type groupMessage struct {
+	messageID int64
+	userID    int64
+	username  string // (without @, empty if none)
+	firstName string
+	text      string
+}
+
+// recent messages of groups, keyed by chat ids (oldest first)
+var _groupMessages = map[int64][]groupMessage{}
+var _groupMessagesLock sync.Mutex
+
+// keep given message in the recent messages of its group (if it is a text in a group)
+func rememberGroupMessage(message tg.Message) {
+	if !isGroup(message.Chat.Type) || message.From == nil || message.From.IsBot {
+		return
+	}
+
+	text := message.Text
+	if text == nil {
+		text = message.Caption
+	}
+	if text == nil || strings.TrimSpace(*text) == "" {
+		return
+	}
+
+	var username string
+	if message.From.Username != nil {
+		username = *message.From.Username
+	}
+
+	_groupMessagesLock.Lock()
+	defer _groupMessagesLock.Unlock()
+
+	chatID := message.Chat.ID
+	messages := append(_groupMessages[chatID], groupMessage{
+		messageID: message.MessageID,
+		userID:    message.From.ID,
+		username:  username,
+		firstName: message.From.FirstName,
+		text:      *text,
+	})
+	if len(messages) > groupMessagesMax {
+		messages = messages[len(messages)-groupMessagesMax:]
+	}
+	_groupMessages[chatID] = messages
+}
+
+// get recent messages of given group, sent before given message
+func recentGroupMessages(chatID, beforeMessageID int64) (messages []groupMessage) {
+	_groupMessagesLock.Lock()
+	defer _groupMessagesLock.Unlock()
+
+	for _, message := range _groupMessages[chatID] {
+		if message.messageID < beforeMessageID {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+// mentionedUser struct for a user mentioned in a message
+type mentionedUser struct {
+	userID   int64  // (0 if unknown)
+	username string // (without @, empty if unknown)
+}
+
+// get users mentioned in given message: with @usernames, text mentions, or first names of recent senders
+func mentionedUsers(message tg.Message, recent []groupMessage) (users []mentionedUser) {
+	if message.Text == nil {
+		return nil
+	}
+	text := *message.Text
+	encoded := utf16.Encode([]rune(text)) // (offsets and lengths of entities are in UTF-16 code units)
+
+	seen := map[string]bool{}
+	add := func(user mentionedUser) {
+		key := fmt.Sprintf("%d/%s", user.userID, strings.ToLower(user.username))
+		if !seen[key] && (user.userID != 0 || user.username != "") {
+			seen[key] = true
+			users = append(users, user)
+		}
+	}
+
+	for _, entity := range message.Entities {
+		switch entity.Type {
+		case tg.MessageEntityTypeMention:
+			if entity.Offset >= 0 && entity.Offset+entity.Length <= len(encoded) {
+				mention := string(utf16.Decode(encoded[entity.Offset : entity.Offset+entity.Length]))
+				add(mentionedUser{username: strings.TrimPrefix(mention, "@")})
+			}
+		case tg.MessageEntityTypeTextMention:
+			if entity.User != nil {
+				add(mentionedUser{userID: entity.User.ID})
+			}
+		}
+	}
+
+	// first names of recent senders, as whole words (eg. "answer Alice's question")
+	checked := map[int64]bool{}
+	for _, message := range recent {
+		if checked[message.userID] || len([]rune(message.firstName)) < mentionNameMinRunes {
+			continue
+		}
+		checked[message.userID] = true
+
+		if regexp.MustCompile(`(?i)(^|[^\pL\pN])` + regexp.QuoteMeta(message.firstName) + `($|[^\pL\pN])`).MatchString(text) {
+			add(mentionedUser{userID: message.userID})
+		}
+	}
+
+	return users
+}
+
+// checks if given message was sent by given mentioned user
+func (u mentionedUser) sent(message groupMessage) bool {
+	return (u.userID != 0 && u.userID == message.userID) ||
+		(u.username != "" && strings.EqualFold(u.username, message.username))
+}
+
+// checks if given logged prompt was sent by given mentioned user
+//
+// (usernames of prompts are logged like "@alice (Alice)")
+func (u mentionedUser) prompted(prompt Prompt) bool {
+	return (u.userID != 0 && u.userID == prompt.UserID) ||
+		(u.username != "" && strings.HasPrefix(strings.ToLower(prompt.Username), "@"+strings.ToLower(u.username)+" "))
+}
+
+// build a chat message with recent messages of users mentioned in given group message (nil if none)
+//
+// (recent messages kept in memory are used first, then logged prompts in the database)
+func mentionedChatMessage(db Storage, message tg.Message) *openai.ChatMessage {
+	if !isGroup(message.Chat.Type) {
+		return nil
+	}
+
+	chatID := message.Chat.ID
+	recent := recentGroupMessages(chatID, message.MessageID)
+	users := mentionedUsers(message, recent)
+	if len(users) == 0 {
+		return nil
+	}
+
+	var prompts []Prompt
+	if db != nil {
+		var err error
+		if prompts, err = db.RecentPrompts(chatID, mentionedPromptsLookback); err != nil {
+			log.Printf("failed to retrieve recent prompts for mentions: %s", err)
+		}
+	}
+
+	var lines []string
+	for _, user := range users {
+		// skip the sender
+		if message.From != nil && user.sent(groupMessage{userID: message.From.ID, username: usernameOf(message.From)}) {
+			continue
+		}
+
+		var texts []string
+		for i := len(recent) - 1; i >= 0 && len(texts) < mentionedMessagesMax; i-- {
+			if user.sent(recent[i]) {
+				texts = append([]string{fmt.Sprintf("%s: %s", displayName(recent[i]), recent[i].text)}, texts...)
+			}
+		}
+		if len(texts) == 0 {
+			// (RecentPrompts are in reverse chronological order)
+			for _, prompt := range prompts {
+				if len(texts) >= mentionedMessagesMax {
+					break
+				}
+				if user.prompted(prompt) {
+					texts = append([]string{fmt.Sprintf("%s: %s", prompt.Username, questionOf(prompt))}, texts...)
+				}
+			}
+		}
+
+		lines = append(lines, texts...)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	chatMessage := openai.NewChatUserMessage(fmt.Sprintf(msgMentionedMessages, strings.Join(lines, "\n\n")))
+	return &chatMessage
+}
+
+// get the username of given user (without @, empty if none)
+func usernameOf(user *tg.User) string {
+	if user.Username != nil {
+		return *user.Username
+	}
+
+	return ""
+}
+
+// get the name of the sender of given group message, like the usernames of logged prompts
+func displayName(message groupMessage) string {
+	if message.username != "" {
+		return fmt.Sprintf("@%s (%s)", message.username, message.firstName)
+	}
+
+	return message.firstName
+}
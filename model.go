@@ -0,0 +1,244 @@
+package main
+
+// model.go
+//
+// per-chat model overrides, selectable with an inline keyboard
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdModel = "/model"
+
+	modelArgReset = "reset"
+
+	chatSettingKeyModel = "model"
+
+	callbackDataPrefixModel = "model:" // + index of selectable models, or "reset"
+
+	msgModelUsage         = "Usage: /model [model|alias|reset]\n\n(currently: <b>%s</b>, see /models for available ones)"
+	msgModelChanged       = "Model of this chat is now: <b>%s</b>"
+	msgModelReset         = "Model of this chat is reset to: <b>%s</b>"
+	msgNoSuchModel        = "No such chat model: <b>%s</b> (see /models for available ones)"
+	msgModelNotSelectable = "Not a selectable model: <b>%s</b>\n\n(selectable ones: %s)"
+	msgModelButtonReset   = "Reset"
+)
+
+// get the chat completion model for given chat
+//
+// (per-chat override takes precedence over `openai_model` of config,
+// unless it was removed from `selectable_models` after being chosen)
+func chatModel(conf config, db Storage, chatID int64) string {
+	if db != nil {
+		if value, err := db.GetChatSetting(chatID, chatSettingKeyModel); err == nil && value != "" && isSelectableModel(conf, value) {
+			return resolveModelAlias(conf, value)
+		}
+	}
+
+	return chatCompletionModel(conf)
+}
+
+// get the models (or aliases) selectable with /model and in the wizard
+//
+// (`selectable_models` of config, or aliases of `model_aliases` if not set)
+func selectableModels(conf config) (models []string) {
+	if len(conf.SelectableModels) > 0 {
+		return conf.SelectableModels
+	}
+
+	models = make([]string, 0, len(conf.ModelAliases))
+	for alias := range conf.ModelAliases {
+		models = append(models, alias)
+	}
+	slices.Sort(models)
+
+	return models
+}
+
+// checks if given model (or alias) is selectable in chats
+//
+// (all available models are selectable when `selectable_models` is not set)
+func isSelectableModel(conf config, name string) bool {
+	if len(conf.SelectableModels) == 0 {
+		return true
+	}
+
+	model := resolveModelAlias(conf, name)
+	for _, selectable := range conf.SelectableModels {
+		if selectable == name || resolveModelAlias(conf, selectable) == model {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generate the inline keyboard of selectable models, with the current one marked
+func modelKeyboard(conf config, current string) (keyboard [][]tg.InlineKeyboardButton) {
+	var buttons []tg.InlineKeyboardButton
+	for i, name := range selectableModels(conf) {
+		text := name
+		if resolveModelAlias(conf, name) == current {
+			text = "✓ " + text
+		}
+
+		data := callbackDataPrefixModel + strconv.Itoa(i)
+		buttons = append(buttons, tg.InlineKeyboardButton{Text: text, CallbackData: &data})
+	}
+
+	// (2 buttons per row, as model names are long)
+	for i := 0; i < len(buttons); i += 2 {
+		keyboard = append(keyboard, buttons[i:min(i+2, len(buttons))])
+	}
+
+	reset := callbackDataPrefixModel + modelArgReset
+	keyboard = append(keyboard, []tg.InlineKeyboardButton{
+		{Text: msgModelButtonReset, CallbackData: &reset},
+	})
+
+	return keyboard
+}
+
+// change (or reset, with an empty `value`) the model of given chat, and return a message for it
+func changeChatModel(conf config, db Storage, chatID int64, value string) string {
+	if err := db.SetChatSetting(chatID, chatSettingKeyModel, value); err != nil {
+		log.Printf("failed to change model: %s", err)
+
+		return err.Error()
+	} else if value == "" {
+		return fmt.Sprintf(msgModelReset, chatModel(conf, db, chatID))
+	}
+
+	return fmt.Sprintf(msgModelChanged, html.EscapeString(chatModel(conf, db, chatID)))
+}
+
+// handle a callback query from the inline keyboard of /model
+func handleModelCallback(bot *tg.Bot, db Storage, query tg.CallbackQuery, data string) {
+	conf := currentConfig()
+
+	if query.Message == nil {
+		answerCallbackQuery(bot, query, msgConfirmExpired)
+		return
+	}
+	if db == nil {
+		answerCallbackQuery(bot, query, msgDatabaseNotConfigured)
+		return
+	}
+
+	// only admins can change settings of group chats
+	//
+	// (checked with the keyboard's message as if it was sent by the user who tapped the button)
+	message := tg.Message(*query.Message)
+	message.From = &query.From
+	if message.Chat.Type != tg.ChatTypePrivate && !isChatAdmin(tg.Update{Message: &message}, conf) {
+		answerCallbackQuery(bot, query, msgNotAdmin)
+		return
+	}
+
+	var value string
+	if data != modelArgReset {
+		models := selectableModels(conf)
+		i, err := strconv.Atoi(data)
+		if err != nil || i < 0 || i >= len(models) {
+			answerCallbackQuery(bot, query, msgConfirmExpired)
+			return
+		}
+		value = models[i]
+	}
+
+	chatID := message.Chat.ID
+	msg := changeChatModel(conf, db, chatID, value)
+	answerCallbackQuery(bot, query, "")
+
+	if res := bot.EditMessageText(msg, tg.OptionsEditMessageText{}.
+		SetIDs(chatID, message.MessageID).
+		SetParseMode(tg.ParseModeHTML)); !res.Ok {
+		log.Printf("failed to edit model message: %s", *res.Description)
+	}
+}
+
+// return a /model command handler
+func modelCommandHandler(client *openai.Client, db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("model command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		name := strings.TrimSpace(args)
+		if name == "" {
+			current := chatModel(conf, db, chatID)
+			usage := fmt.Sprintf(msgModelUsage, current)
+
+			// (with the inline keyboard of selectable models, if any)
+			if len(selectableModels(conf)) > 0 {
+				if res := b.SendMessage(chatID, usage, tg.OptionsSendMessage{}.
+					SetReplyParameters(tg.ReplyParameters{MessageID: messageID}).
+					SetParseMode(tg.ParseModeHTML).
+					SetReplyMarkup(tg.InlineKeyboardMarkup{InlineKeyboard: modelKeyboard(conf, current)})); res.Ok {
+					return
+				} else {
+					log.Printf("failed to send model keyboard: %s", *res.Description)
+				}
+			}
+
+			send(b, conf, usage, chatID, &messageID)
+			return
+		}
+
+		// only admins can change settings of group chats
+		if message.Chat.Type != tg.ChatTypePrivate && !isChatAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		var value string
+		if name != modelArgReset {
+			// check if the model is selectable
+			if !isSelectableModel(conf, name) {
+				send(b, conf, fmt.Sprintf(msgModelNotSelectable, html.EscapeString(name), html.EscapeString(strings.Join(selectableModels(conf), ", "))), chatID, &messageID)
+				return
+			}
+
+			// check if the model is available
+			model := resolveModelAlias(conf, name)
+			if models, err := listChatModels(client); err == nil {
+				if !slices.Contains(models, model) {
+					send(b, conf, fmt.Sprintf(msgNoSuchModel, html.EscapeString(model)), chatID, &messageID)
+					return
+				}
+			} else {
+				log.Printf("failed to list models, not checking availability of %s: %s", model, err)
+			}
+
+			value = name
+		}
+
+		send(b, conf, changeChatModel(conf, db, chatID, value), chatID, &messageID)
+	}
+}
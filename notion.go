@@ -0,0 +1,193 @@
+package main
+
+// notion.go
+//
+// exporting conversations to a Notion database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	notionAPIBaseURL = "https://api.notion.com/v1"
+	notionAPIVersion = "2022-06-28"
+
+	notionTitlePropertyDefault = "Name"
+
+	notionMaxTextLen         = 2000 // max length of a text object
+	notionMaxRichTexts       = 100  // max number of text objects in a block
+	notionMaxBlocksInRequest = 100  // max number of blocks appended in a request
+)
+
+// notionConfig struct for exporting conversations to a Notion database
+type notionConfig struct {
+	APIKey        string `json:"api_key"`
+	DatabaseID    string `json:"database_id"`
+	TitleProperty string `json:"title_property,omitempty"` // name of the database's title property (default: "Name")
+
+	AutoExport bool `json:"auto_export,omitempty"` // export every answered prompt automatically
+}
+
+// notionRichText struct for rich text objects of Notion API
+type notionRichText struct {
+	Type string `json:"type"`
+	Text struct {
+		Content string `json:"content"`
+	} `json:"text"`
+}
+
+// notionBlock struct for block objects of Notion API
+type notionBlock struct {
+	Object string `json:"object"`
+	Type   string `json:"type"`
+
+	Heading3  *notionBlockContent `json:"heading_3,omitempty"`
+	Paragraph *notionBlockContent `json:"paragraph,omitempty"`
+}
+
+// notionBlockContent struct for contents of block objects
+type notionBlockContent struct {
+	RichText []notionRichText `json:"rich_text"`
+}
+
+// export given prompts (and their answers) as a new page of the Notion database
+func exportPromptsToNotion(conf notionConfig, title string, prompts []Prompt) (err error) {
+	blocks := []notionBlock{}
+	for _, prompt := range prompts {
+		answerHeading := "🤖"
+		if prompt.Result.Pinned {
+			answerHeading = "📌 🤖"
+		}
+
+		blocks = append(blocks,
+			newNotionBlock("heading_3", fmt.Sprintf("🙋 %s (%s)", prompt.Username, prompt.CreatedAt.Format(time.RFC3339))),
+			newNotionBlock("paragraph", prompt.Text),
+			newNotionBlock("heading_3", answerHeading),
+			newNotionBlock("paragraph", prompt.Result.Text),
+		)
+	}
+
+	titleProperty := conf.TitleProperty
+	if titleProperty == "" {
+		titleProperty = notionTitlePropertyDefault
+	}
+
+	// create a page with the first chunk of blocks,
+	first := min(len(blocks), notionMaxBlocksInRequest)
+	var page struct {
+		ID string `json:"id"`
+	}
+	if err = requestNotion(conf, http.MethodPost, "/pages", map[string]any{
+		"parent": map[string]any{
+			"database_id": conf.DatabaseID,
+		},
+		"properties": map[string]any{
+			titleProperty: map[string]any{
+				"title": notionRichTexts(title),
+			},
+		},
+		"children": blocks[:first],
+	}, &page); err != nil {
+		return err
+	}
+
+	// then append the remaining ones
+	for i := first; i < len(blocks); i += notionMaxBlocksInRequest {
+		end := min(i+notionMaxBlocksInRequest, len(blocks))
+
+		if err = requestNotion(conf, http.MethodPatch, fmt.Sprintf("/blocks/%s/children", page.ID), map[string]any{
+			"children": blocks[i:end],
+		}, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// export an answered prompt to Notion, if automatic export is enabled
+func autoExportToNotion(conf config, prompt Prompt) {
+	if conf.Notion == nil || !conf.Notion.AutoExport {
+		return
+	}
+
+	if err := exportPromptsToNotion(*conf.Notion, ellipsize(prompt.Text, 50), []Prompt{prompt}); err != nil {
+		log.Printf("failed to export prompt to notion: %s", err)
+	}
+}
+
+// create a block with given type and text
+func newNotionBlock(typ3, text string) notionBlock {
+	block := notionBlock{
+		Object: "block",
+		Type:   typ3,
+	}
+
+	content := &notionBlockContent{RichText: notionRichTexts(text)}
+	switch typ3 {
+	case "heading_3":
+		block.Heading3 = content
+	default:
+		block.Paragraph = content
+	}
+
+	return block
+}
+
+// split given text into rich text objects, respecting the length limits of Notion API
+func notionRichTexts(text string) (texts []notionRichText) {
+	runes := []rune(text)
+	for i := 0; i < len(runes) && len(texts) < notionMaxRichTexts; i += notionMaxTextLen {
+		end := min(i+notionMaxTextLen, len(runes))
+
+		richText := notionRichText{Type: "text"}
+		richText.Text.Content = string(runes[i:end])
+		texts = append(texts, richText)
+	}
+
+	return texts
+}
+
+// send a request to Notion API, will timeout in 30 seconds
+func requestNotion(conf notionConfig, method, path string, body any, result any) (err error) {
+	var data []byte
+	if data, err = json.Marshal(body); err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequest(method, notionAPIBaseURL+path, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+conf.APIKey)
+	req.Header.Set("Notion-Version", notionAPIVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := newHTTPClient(time.Second * 30)
+
+	var resp *http.Response
+	if resp, err = httpClient.Do(req); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if data, err = io.ReadAll(resp.Body); err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notion api error (http %d): %s", resp.StatusCode, string(data))
+	}
+
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+
+	return nil
+}
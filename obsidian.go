@@ -0,0 +1,144 @@
+package main
+
+// obsidian.go
+//
+// exporting logged conversations as markdown notes for an Obsidian vault
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// characters not allowed in obsidian tags
+var obsidianTagInvalidChars = regexp.MustCompile(`[^\p{L}\p{N}_\-/]+`)
+
+// export all logged conversations in the database to `dir`, one markdown note per conversation
+//
+// (prompts without conversations will be exported as notes of their own)
+func exportObsidianVault(db Storage, dir string) (count int, err error) {
+	var prompts []Prompt
+	if prompts, err = db.AllPrompts(); err != nil {
+		return 0, err
+	}
+
+	// group prompts by conversations
+	notes := map[string][]Prompt{}
+	for _, prompt := range prompts {
+		var key string
+		if prompt.ConversationID != nil {
+			key = fmt.Sprintf("conversation-%d", *prompt.ConversationID)
+		} else {
+			key = fmt.Sprintf("prompt-%d", prompt.ID)
+		}
+		notes[key] = append(notes[key], prompt)
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	for key, prompts := range notes {
+		date := prompts[0].CreatedAt.Format(time.DateOnly)
+		fpath := filepath.Join(dir, fmt.Sprintf("%s %s.md", date, key))
+
+		if err = os.WriteFile(fpath, []byte(obsidianNote(prompts, conversationTitle(db, prompts[0].ConversationID))), 0644); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// generate a markdown note with front-matter from given prompts (and the title of their conversation, if any)
+func obsidianNote(prompts []Prompt, title string) string {
+	chatID := prompts[0].ChatID
+	users, models := map[string]bool{}, map[string]bool{}
+	pinned := false
+	for _, prompt := range prompts {
+		users[prompt.Username] = true
+		pinned = pinned || prompt.Result.Pinned
+		if prompt.Result.ChatModel != "" {
+			models[prompt.Result.ChatModel] = true
+		}
+	}
+
+	tags := []string{obsidianTag("chat", fmt.Sprintf("%d", chatID))}
+	for _, user := range sortedKeys(users) {
+		tags = append(tags, obsidianTag("user", user))
+	}
+	for _, model := range sortedKeys(models) {
+		tags = append(tags, obsidianTag("model", model))
+	}
+	if pinned {
+		tags = append(tags, "pinned")
+	}
+
+	var sb strings.Builder
+
+	// front-matter
+	sb.WriteString("---\n")
+	sb.WriteString(fmt.Sprintf("date: %s\n", prompts[0].CreatedAt.Format(time.RFC3339)))
+	sb.WriteString(fmt.Sprintf("chat: %d\n", chatID))
+	if title != "" {
+		sb.WriteString(fmt.Sprintf("title: %q\n", title))
+	}
+	sb.WriteString("tags:\n")
+	for _, tag := range tags {
+		sb.WriteString(fmt.Sprintf("  - %s\n", tag))
+	}
+	sb.WriteString("---\n\n")
+
+	if title != "" {
+		sb.WriteString(fmt.Sprintf("# %s\n\n", title))
+	}
+
+	// prompts and answers
+	for _, prompt := range prompts {
+		sb.WriteString(fmt.Sprintf("## 🙋 %s (%s)\n\n", prompt.Username, prompt.CreatedAt.Format(time.DateTime)))
+		sb.WriteString(prompt.Text + "\n\n")
+		heading := "## 🤖"
+		if prompt.Result.Pinned {
+			heading = "## 📌 🤖"
+		}
+		if prompt.Result.ChatModel != "" {
+			heading += " " + prompt.Result.ChatModel
+		}
+		sb.WriteString(heading + "\n\n")
+		sb.WriteString(prompt.Result.Text + "\n\n")
+
+		// previous versions of the answer, as folded callouts
+		for _, version := range prompt.Result.Versions {
+			sb.WriteString(fmt.Sprintf("> [!note]- Previous answer (v%d)\n", version.Version))
+			for _, line := range strings.Split(version.Text, "\n") {
+				sb.WriteString("> " + line + "\n")
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// generate an obsidian tag like `prefix/value`
+func obsidianTag(prefix, value string) string {
+	value = strings.Trim(obsidianTagInvalidChars.ReplaceAllString(value, "_"), "_")
+	if value == "" {
+		value = "unknown"
+	}
+	return prefix + "/" + value
+}
+
+// get sorted keys of given map
+func sortedKeys(m map[string]bool) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
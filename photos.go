@@ -0,0 +1,119 @@
+package main
+
+// photos.go
+//
+// asking questions about photos with vision-capable models
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	msgPhotoNotSupported = "The model in use (%s) does not support photos. Switch to a vision-capable model (eg. gpt-4o) with /model."
+)
+
+// prefixes of chat models which accept images
+var visionModelPrefixes = []string{
+	"gpt-4o",
+	"chatgpt-4o",
+	"gpt-4-turbo",
+	"gpt-4.1",
+	"gpt-4.5",
+	"gpt-5",
+	"o1",
+	"o3",
+	"o4",
+}
+
+// checks if given model accepts images
+//
+// (`o1-mini` and `o3-mini` are text-only)
+func isVisionModel(model string) bool {
+	if strings.HasPrefix(model, "o1-mini") || strings.HasPrefix(model, "o3-mini") {
+		return false
+	}
+
+	model = strings.TrimPrefix(model, "ft:")
+	for _, prefix := range visionModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checks if given message, or the message which it replied to, has a photo
+func hasPhotoInput(message tg.Message) bool {
+	if message.HasPhoto() {
+		return true
+	}
+	if replyTo := repliedToMessage(message); replyTo != nil && replyTo.HasPhoto() {
+		return true
+	}
+
+	return false
+}
+
+// convert given telegram message with a photo into a multimodal chat message (the largest photo + its caption)
+func photoChatMessage(bot *tg.Bot, message tg.Message) (chatMessage openai.ChatMessage, err error) {
+	// (the largest one)
+	photo := message.Photo[len(message.Photo)-1]
+
+	res := bot.GetFile(photo.FileID)
+	if !res.Ok {
+		return chatMessage, fmt.Errorf("failed to get photo: %s", *res.Description)
+	}
+
+	var data []byte
+	if data, err = readBinaryContentAtURL(bot.GetFileURL(*res.Result), maxPhotoBytes); err != nil {
+		return chatMessage, err
+	}
+
+	contents := []openai.ChatMessageContent{
+		openai.NewChatMessageContentWithBytes(data),
+	}
+
+	// with `screenshot_preprocess`, add texts of screenshots cropped and upscaled
+	if currentConfig().ScreenshotPreprocess {
+		if processed, ok, err := preprocessScreenshot(data); err == nil && ok {
+			contents = append(contents,
+				openai.NewChatMessageContentWithBytes(processed),
+				openai.NewChatMessageContentWithText(msgScreenshotHint),
+			)
+		} else if err != nil {
+			log.Printf("failed to preprocess screenshot: %s", err)
+		}
+	}
+
+	var caption string
+	if message.HasText() {
+		caption = *message.Text
+	} else if message.HasCaption() {
+		caption = *message.Caption
+	}
+	if caption = withForwardAttribution(message, strings.TrimSpace(caption)); caption != "" {
+		contents = append(contents, openai.NewChatMessageContentWithText(caption))
+	}
+
+	return openai.NewChatUserMessage(contents), nil
+}
+
+// describe given multimodal contents as a text, with images as placeholders (for logging)
+func describeContents(contents []openai.ChatMessageContent) string {
+	parts := []string{}
+	for _, content := range contents {
+		if content.Text != nil {
+			parts = append(parts, *content.Text)
+		} else if content.ImageURL != nil {
+			parts = append(parts, "[Photo]")
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,71 @@
+package main
+
+// pin.go
+//
+// pinning answers in chats
+
+import (
+	"log"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdPin = "/pin"
+
+	msgPinUsage     = "Reply to an answer of mine with /pin to pin it."
+	msgPinNotAnswer = "The replied message is not an answer of mine."
+	msgPinFailed    = "Failed to pin the answer. Make sure that I have the right to pin messages in this chat."
+	msgPinned       = "Pinned the answer."
+)
+
+// return a /pin command handler
+func pinCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, _ string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("pin command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		replyTo := repliedToMessage(*message)
+		if replyTo == nil {
+			send(b, conf, msgPinUsage, chatID, &messageID)
+			return
+		}
+
+		if _, err := db.PromptByAnswerMessageID(chatID, replyTo.MessageID); err != nil {
+			send(b, conf, msgPinNotAnswer, chatID, &messageID)
+			return
+		}
+
+		if res := b.PinChatMessage(chatID, replyTo.MessageID, tg.OptionsPinChatMessage{}.
+			SetDisableNotification(true)); !res.Ok {
+			log.Printf("failed to pin message %d in chat %d: %s", replyTo.MessageID, chatID, *res.Description)
+
+			send(b, conf, msgPinFailed, chatID, &messageID)
+			return
+		}
+
+		if err := db.PinAnswer(chatID, replyTo.MessageID); err != nil {
+			log.Printf("failed to mark answer as pinned: %s", err)
+		}
+
+		send(b, conf, msgPinned, chatID, &messageID)
+	}
+}
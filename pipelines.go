@@ -0,0 +1,157 @@
+package main
+
+// pipelines.go
+//
+// multi-stage prompt pipelines defined in config, exposed as custom commands
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	msgPipelineUsage    = "Usage: /%s [input] (or reply to a message with /%s)"
+	msgPipelineProgress = "⏳ /%s: stage %d/%d..."
+	msgPipelineFailed   = "Pipeline /%s failed at stage %d/%d. See the server logs for more information."
+	msgPipelinesHeader  = "\n(pipelines)\n"
+)
+
+// pipelineStage struct for a stage of a pipeline
+type pipelineStage struct {
+	Prompt string `json:"prompt"`          // system prompt of the stage (eg. "Translate the following text into English.")
+	Model  string `json:"model,omitempty"` // model or alias for the stage (default: the model of the chat)
+}
+
+// pipelineConfig struct for a pipeline of stages, where the output of each stage feeds the next one
+type pipelineConfig struct {
+	Description string          `json:"description,omitempty"`
+	Stages      []pipelineStage `json:"stages"`
+}
+
+// pipelines type for pipelines, keyed by their command names (without leading slashes)
+type pipelines map[string]pipelineConfig
+
+// get the pipeline for given command (eg. "/tldr"), if there is one
+func pipelineFor(conf config, cmd string) (name string, pipeline pipelineConfig, exists bool) {
+	name = strings.TrimPrefix(cmd, "/")
+	pipeline, exists = conf.Pipelines[name]
+	return name, pipeline, exists && len(pipeline.Stages) > 0
+}
+
+// describe pipelines of config for the help message
+func describePipelines(conf config) string {
+	if len(conf.Pipelines) == 0 {
+		return ""
+	}
+
+	names := []string{}
+	for name := range conf.Pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{}
+	for _, name := range names {
+		description := conf.Pipelines[name].Description
+		if description == "" {
+			description = fmt.Sprintf("run a pipeline of %d stage(s).", len(conf.Pipelines[name].Stages))
+		}
+		lines = append(lines, fmt.Sprintf("/%s [input] : %s", name, html.EscapeString(description)))
+	}
+
+	return msgPipelinesHeader + strings.Join(lines, "\n") + "\n"
+}
+
+// run a pipeline on the input of given message (its arguments, and/or the replied message)
+func runPipeline(bot *tg.Bot, client *openai.Client, conf config, db Storage, update tg.Update, message tg.Message, name string, pipeline pipelineConfig, args string) {
+	chatID := message.Chat.ID
+	messageID := message.MessageID
+
+	inputs := []string{}
+	if replyTo := repliedToMessage(message); replyTo != nil {
+		if chatMessage := convertMessage(bot, *replyTo); chatMessage != nil {
+			if content, err := chatMessage.ContentString(); err == nil && strings.TrimSpace(content) != "" {
+				inputs = append(inputs, content)
+			}
+		}
+	}
+	if args = strings.TrimSpace(args); args != "" {
+		inputs = append(inputs, args)
+	}
+	if len(inputs) == 0 {
+		send(bot, conf, fmt.Sprintf(msgPipelineUsage, name, name), chatID, &messageID)
+		return
+	}
+	input := strings.Join(inputs, "\n\n")
+
+	queueAnswer(bot, conf, chatID, messageID, func() {
+		stages := len(pipeline.Stages)
+
+		// intermediate stages, with a notice of the progress
+		var noticeID int64
+		for i, stage := range pipeline.Stages[:stages-1] {
+			progress := fmt.Sprintf(msgPipelineProgress, name, i+1, stages)
+			if noticeID == 0 {
+				if res := bot.SendMessage(chatID, progress, tg.OptionsSendMessage{}.
+					SetReplyParameters(tg.ReplyParameters{MessageID: messageID})); res.Ok {
+					noticeID = res.Result.MessageID
+				}
+			} else {
+				_ = bot.EditMessageText(progress, tg.OptionsEditMessageText{}.SetIDs(chatID, noticeID))
+			}
+
+			output, err := runPipelineStage(bot, client, conf, db, chatID, message.From.ID, stage, input)
+			if err != nil {
+				log.Printf("pipeline /%s failed at stage %d/%d: %s", name, i+1, stages, err)
+
+				send(bot, conf, fmt.Sprintf(msgPipelineFailed, name, i+1, stages), chatID, &messageID)
+				return
+			}
+			input = output
+		}
+		if noticeID != 0 {
+			_ = bot.DeleteMessage(chatID, noticeID)
+		}
+
+		// the last stage is answered (and logged) as usual
+		last := pipeline.Stages[stages-1]
+		answer(bot, client, conf, db, pipelineStageModel(conf, db, chatID, last), messageDirectives{}, []openai.ChatMessage{
+			openai.NewChatSystemMessage(last.Prompt),
+			openai.NewChatUserMessage(input),
+		}, chatID, message.From.ID, userNameFromUpdate(update), messageID, threadFor(conf, db, chatID, nil))
+	})
+}
+
+// run an intermediate stage of a pipeline, returning its output
+func runPipelineStage(bot *tg.Bot, client *openai.Client, conf config, db Storage, chatID, userID int64, stage pipelineStage, input string) (output string, err error) {
+	stopTyping := keepChatAction(bot, chatID, responseText)
+	defer stopTyping()
+
+	var response openai.ChatCompletion
+	if response, err = createChatCompletion(client, pipelineStageModel(conf, db, chatID, stage), messageDirectives{}, []openai.ChatMessage{
+		openai.NewChatSystemMessage(stage.Prompt),
+		openai.NewChatUserMessage(input),
+	}, userID); err != nil {
+		return "", err
+	}
+	if len(response.Choices) <= 0 {
+		return "", fmt.Errorf("no output from stage")
+	}
+
+	return response.Choices[0].Message.ContentString()
+}
+
+// get the model for a stage of a pipeline
+func pipelineStageModel(conf config, db Storage, chatID int64, stage pipelineStage) string {
+	if stage.Model != "" {
+		return resolveModelAlias(conf, stage.Model)
+	}
+
+	return chatModel(conf, db, chatID)
+}
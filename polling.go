@@ -0,0 +1,163 @@
+package main
+
+// polling.go
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	pollingTimeoutSecondsDefault = 5
+
+	// NOTE: http client of telegram-bot-go times out in 10 seconds waiting for response headers
+	pollingTimeoutSecondsMax = 9
+)
+
+// update types to receive by default
+//
+// (`message_reaction` is not delivered unless explicitly specified)
+var allowedUpdatesDefault = []tg.AllowedUpdate{
+	tg.AllowMessage,
+	tg.AllowEditedMessage,
+	tg.AllowChannelPost,
+	tg.AllowEditedChannelPost,
+	tg.AllowMessageReaction,
+	tg.AllowMyChatMember,
+	tg.AllowCallbackQuery,
+}
+
+// updateDispatcher struct for dispatching updates to handlers
+type updateDispatcher struct {
+	commandHandlers          map[string]func(b *tg.Bot, update tg.Update, args string)
+	noMatchingCommandHandler func(b *tg.Bot, update tg.Update, cmd, args string)
+	messageHandler           func(b *tg.Bot, update tg.Update, message tg.Message, edited bool)
+	channelPostHandler       func(b *tg.Bot, update tg.Update, channelPost tg.Message, edited bool)
+	updateHandler            func(b *tg.Bot, update tg.Update)
+}
+
+// create a new update dispatcher
+func newUpdateDispatcher() *updateDispatcher {
+	return &updateDispatcher{
+		commandHandlers: map[string]func(b *tg.Bot, update tg.Update, args string){},
+	}
+}
+
+// AddCommandHandler adds a handler for given command.
+func (d *updateDispatcher) AddCommandHandler(command string, handler func(b *tg.Bot, update tg.Update, args string)) {
+	d.commandHandlers[command] = handler
+}
+
+// SetNoMatchingCommandHandler sets a handler for commands with no matching handler.
+func (d *updateDispatcher) SetNoMatchingCommandHandler(handler func(b *tg.Bot, update tg.Update, cmd, args string)) {
+	d.noMatchingCommandHandler = handler
+}
+
+// SetMessageHandler sets a handler for (edited) messages.
+func (d *updateDispatcher) SetMessageHandler(handler func(b *tg.Bot, update tg.Update, message tg.Message, edited bool)) {
+	d.messageHandler = handler
+}
+
+// SetChannelPostHandler sets a handler for (edited) channel posts.
+func (d *updateDispatcher) SetChannelPostHandler(handler func(b *tg.Bot, update tg.Update, channelPost tg.Message, edited bool)) {
+	d.channelPostHandler = handler
+}
+
+// SetUpdateHandler sets a handler for updates not handled by any other handler.
+func (d *updateDispatcher) SetUpdateHandler(handler func(b *tg.Bot, update tg.Update)) {
+	d.updateHandler = handler
+}
+
+// dispatch given update to a matching handler (asynchronously)
+//
+// (already processed updates will be ignored)
+func (d *updateDispatcher) dispatch(bot *tg.Bot, update tg.Update) {
+	if _processedUpdates.checkAndMark(update.UpdateID) {
+		logInfo("ignoring already processed update: %d", update.UpdateID)
+		return
+	}
+
+	// commands
+	if message, _ := update.GetMessage(); message != nil && message.HasText() && strings.HasPrefix(*message.Text, "/") {
+		txt := *message.Text
+		command := strings.Split(txt, " ")[0]
+		args := strings.TrimSpace(strings.TrimPrefix(txt, command))
+
+		if handler, exists := d.commandHandlers[command]; exists {
+			go d.handle(bot, update, func() { handler(bot, update, args) })
+			return
+		} else if d.noMatchingCommandHandler != nil {
+			go d.handle(bot, update, func() { d.noMatchingCommandHandler(bot, update, command, args) })
+			return
+		}
+	}
+
+	// by types
+	if message, edited := update.GetMessage(); message != nil && d.messageHandler != nil {
+		go d.handle(bot, update, func() { d.messageHandler(bot, update, *message, edited) })
+	} else if post, edited := update.GetChannelPost(); post != nil && d.channelPostHandler != nil {
+		go d.handle(bot, update, func() { d.channelPostHandler(bot, update, *post, edited) })
+	} else if d.updateHandler != nil {
+		go d.handle(bot, update, func() { d.updateHandler(bot, update) })
+	}
+}
+
+// run given handler of an update, after caching things needed for handling it
+func (d *updateDispatcher) handle(bot *tg.Bot, update tg.Update, handler func()) {
+	cacheGroupAdmins(bot, update)
+
+	handler()
+}
+
+// poll updates from telegram bot api and dispatch them, until `quit` is closed
+func pollUpdates(bot *tg.Bot, dispatcher *updateDispatcher, conf config, quit <-chan struct{}, onError func(err error)) {
+	timeout := conf.PollingTimeoutSeconds
+	if timeout <= 0 {
+		timeout = pollingTimeoutSecondsDefault
+	} else if timeout > pollingTimeoutSecondsMax {
+		timeout = pollingTimeoutSecondsMax
+	}
+	allowedUpdates := conf.AllowedUpdates
+	if len(allowedUpdates) <= 0 {
+		allowedUpdates = allowedUpdatesDefault
+	}
+
+	options := tg.OptionsGetUpdates{}.
+		SetOffset(0).
+		SetLimit(100).
+		SetTimeout(timeout).
+		SetAllowedUpdates(allowedUpdates)
+
+	var offset int64 = 0
+	for {
+		select {
+		case <-quit:
+			logInfo("stopped polling updates")
+			return
+		default:
+			if updates := bot.GetUpdates(options.SetOffset(offset)); updates.Ok {
+				for _, update := range *updates.Result {
+					if offset <= update.UpdateID {
+						offset = update.UpdateID + 1
+					}
+
+					dispatcher.dispatch(bot, update)
+				}
+			} else {
+				var errStr string
+				if updates.Description != nil {
+					errStr = *updates.Description
+				}
+				log.Printf("failed to poll updates: %s", errStr)
+
+				onError(errors.New(errStr))
+
+				time.Sleep(intervalSeconds * time.Second)
+			}
+		}
+	}
+}
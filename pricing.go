@@ -0,0 +1,311 @@
+package main
+
+// pricing.go
+//
+// estimating costs of chat completions, and limits of models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	pricingRefreshHoursDefault = 24
+	pricingCheckInterval       = 10 * time.Minute
+	pricingFetchTimeout        = 30 * time.Second
+	pricingResponseMaxBytes    = 1024 * 1024 // 1MB
+
+	settingKeyFetchedModelPrices = "fetched_model_prices" // json of the last successfully fetched prices
+
+	contextWindowReservedTokensMax = 4096 // max tokens reserved for completions in context windows
+
+	msgCostFooter          = "\n\n(%s)"
+	msgPricingFetchFailing = "⚠️ Failed to refresh model prices from `pricing_url` (using the last fetched ones from %s): %s"
+)
+
+// modelPrice struct for prices of a model, in USD per 1M tokens
+type modelPrice struct {
+	Input         float64 `json:"input"`
+	Output        float64 `json:"output"`
+	ContextWindow int     `json:"context_window,omitempty"` // max number of tokens in a request and its completion (0 for unknown)
+}
+
+// modelPrices type for prices of models, keyed by model names
+type modelPrices map[string]modelPrice
+
+// built-in prices of models (can be overridden or extended with `model_prices`)
+var modelPricesDefault = modelPrices{
+	"gpt-4o":        {Input: 2.50, Output: 10.00, ContextWindow: 128000},
+	"gpt-4o-mini":   {Input: 0.15, Output: 0.60, ContextWindow: 128000},
+	"gpt-4-turbo":   {Input: 10.00, Output: 30.00, ContextWindow: 128000},
+	"gpt-4":         {Input: 30.00, Output: 60.00, ContextWindow: 8192},
+	"gpt-3.5-turbo": {Input: 0.50, Output: 1.50, ContextWindow: 16385},
+	"o1":            {Input: 15.00, Output: 60.00, ContextWindow: 200000},
+	"o1-mini":       {Input: 3.00, Output: 12.00, ContextWindow: 128000},
+	"o3-mini":       {Input: 1.10, Output: 4.40, ContextWindow: 200000},
+}
+
+// prices of models fetched from `pricing_url` (the last successful ones)
+var _fetchedPrices struct {
+	sync.RWMutex
+
+	prices    modelPrices
+	fetchedAt time.Time
+	failing   bool
+}
+
+// get the sources of model prices, in the order of precedence (lowest first)
+func modelPriceSources(conf config) []modelPrices {
+	_fetchedPrices.RLock()
+	defer _fetchedPrices.RUnlock()
+
+	return []modelPrices{modelPricesDefault, _fetchedPrices.prices, conf.ModelPrices}
+}
+
+// get the price of given model
+//
+// (dated models like "gpt-4o-2024-08-06" are matched with the longest prefix, eg. "gpt-4o")
+func priceOf(conf config, model string) (price modelPrice, found bool) {
+	longest := 0
+	for _, prices := range modelPriceSources(conf) {
+		for name, p := range prices {
+			if matchesModel(model, name) && len(name) >= longest {
+				price, found, longest = p, true, len(name)
+			}
+		}
+	}
+
+	return price, found
+}
+
+// get the context window of given model (0 if unknown)
+//
+// (matched like prices, but only with entries which have context windows)
+func contextWindowOf(conf config, model string) (window int) {
+	longest := 0
+	for _, prices := range modelPriceSources(conf) {
+		for name, p := range prices {
+			if p.ContextWindow > 0 && matchesModel(model, name) && len(name) >= longest {
+				window, longest = p.ContextWindow, len(name)
+			}
+		}
+	}
+
+	return window
+}
+
+// checks if given model is the named one, or its dated version
+func matchesModel(model, name string) bool {
+	return model == name || strings.HasPrefix(model, name+"-")
+}
+
+// drop the oldest messages of given history until the whole request fits in the context window of given model
+//
+// (some tokens are reserved for the completion)
+func fitContextWindow(conf config, model string, history, messages []openai.ChatMessage) []openai.ChatMessage {
+	window := contextWindowOf(conf, model)
+	if window <= 0 {
+		return history
+	}
+	limit := window - min(contextWindowReservedTokensMax, window/4)
+
+	for len(history) > 0 {
+		tokens, err := countRequestTokens(model, append(append([]openai.ChatMessage{}, history...), messages...))
+		if err != nil || tokens <= limit {
+			break
+		}
+
+		// drop the oldest question and answer
+		history = history[min(2, len(history)):]
+
+		if isVerbose() {
+			log.Printf("[verbose] dropped the oldest turn of history for the context window of %s (%d > %d tokens)", model, tokens, limit)
+		}
+	}
+
+	return history
+}
+
+// load the last fetched prices of models from database
+func loadFetchedModelPrices(db Storage) {
+	if db == nil {
+		return
+	}
+
+	value, err := db.GetSetting(settingKeyFetchedModelPrices)
+	if err != nil || value == "" {
+		return
+	}
+
+	var fetched struct {
+		Prices    modelPrices `json:"prices"`
+		FetchedAt time.Time   `json:"fetched_at"`
+	}
+	if err := json.Unmarshal([]byte(value), &fetched); err != nil {
+		log.Printf("failed to parse the last fetched model prices: %s", err)
+		return
+	}
+
+	_fetchedPrices.Lock()
+	_fetchedPrices.prices, _fetchedPrices.fetchedAt = fetched.Prices, fetched.FetchedAt
+	_fetchedPrices.Unlock()
+}
+
+// refresh prices of models from `pricing_url` periodically, every `pricing_refresh_hours`
+//
+// (on failures, the last fetched ones are kept and the admin chat is alerted once until it succeeds again)
+func refreshModelPricesPeriodically(bot *tg.Bot, db Storage) {
+	go func() {
+		ticker := time.NewTicker(pricingCheckInterval)
+		defer ticker.Stop()
+
+		for ; true; <-ticker.C {
+			conf := currentConfig()
+			if conf.PricingURL == "" {
+				continue
+			}
+
+			hours := conf.PricingRefreshHours
+			if hours <= 0 {
+				hours = pricingRefreshHoursDefault
+			}
+
+			_fetchedPrices.RLock()
+			fetchedAt, failing := _fetchedPrices.fetchedAt, _fetchedPrices.failing
+			_fetchedPrices.RUnlock()
+
+			// retry failed ones on every check
+			if !failing && time.Since(fetchedAt) < time.Duration(hours)*time.Hour {
+				continue
+			}
+
+			refreshModelPrices(bot, conf, db, failing)
+		}
+	}()
+}
+
+// fetch prices of models from `pricing_url`, and replace the cached ones with them
+func refreshModelPrices(bot *tg.Bot, conf config, db Storage, failing bool) {
+	prices, err := fetchModelPrices(conf.PricingURL)
+	if err != nil {
+		log.Printf("failed to refresh model prices: %s", err)
+
+		_fetchedPrices.Lock()
+		_fetchedPrices.failing = true
+		fetchedAt := _fetchedPrices.fetchedAt
+		_fetchedPrices.Unlock()
+
+		if !failing {
+			last := "never"
+			if !fetchedAt.IsZero() {
+				last = fetchedAt.Format(time.RFC3339)
+			}
+			notifyAdmin(bot, conf, fmt.Sprintf(msgPricingFetchFailing, last, err))
+		}
+		return
+	}
+
+	now := time.Now()
+
+	_fetchedPrices.Lock()
+	_fetchedPrices.prices, _fetchedPrices.fetchedAt, _fetchedPrices.failing = prices, now, false
+	_fetchedPrices.Unlock()
+
+	logInfo("refreshed prices of %d model(s) from %s", len(prices), conf.PricingURL)
+
+	if db != nil {
+		if bytes, err := json.Marshal(map[string]any{"prices": prices, "fetched_at": now}); err == nil {
+			if err := db.SetSetting(settingKeyFetchedModelPrices, string(bytes)); err != nil {
+				log.Printf("failed to save fetched model prices: %s", err)
+			}
+		}
+	}
+}
+
+// fetch prices of models from given url (in the same format as `model_prices`), and validate them
+func fetchModelPrices(url string) (prices modelPrices, err error) {
+	httpClient := newHTTPClient(pricingFetchTimeout)
+
+	var resp *http.Response
+	if resp, err = httpClient.Get(url); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status %d", resp.StatusCode)
+	}
+
+	var bytes []byte
+	if bytes, err = io.ReadAll(io.LimitReader(resp.Body, pricingResponseMaxBytes)); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(bytes, &prices); err != nil {
+		return nil, fmt.Errorf("invalid prices: %w", err)
+	}
+
+	// reject broken or empty responses, not to replace good ones with them
+	if len(prices) == 0 {
+		return nil, fmt.Errorf("no prices in response")
+	}
+	for name, price := range prices {
+		if price.Input < 0 || price.Output < 0 || price.ContextWindow < 0 {
+			return nil, fmt.Errorf("invalid price of model '%s'", name)
+		}
+	}
+
+	return prices, nil
+}
+
+// estimate the cost (in USD) of a chat completion with given usage
+func estimateCost(conf config, model string, usage openai.Usage) (cost float64, found bool) {
+	var price modelPrice
+	if price, found = priceOf(conf, model); !found {
+		return 0, false
+	}
+
+	return (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1_000_000, true
+}
+
+// generate a footer with the estimated cost, tokens, model, and elapsed time of an answer,
+// eg. "(~$0.0042, 1,250 tokens, gpt-4o, 3.1s)"
+func costFooter(conf config, model string, usage openai.Usage, elapsed time.Duration) string {
+	parts := []string{}
+	if cost, found := estimateCost(conf, model, usage); found {
+		parts = append(parts, fmt.Sprintf("~$%.4f", cost))
+	}
+	parts = append(parts,
+		fmt.Sprintf("%s tokens", formatThousands(usage.TotalTokens)),
+		model,
+		fmt.Sprintf("%.1fs", elapsed.Seconds()))
+
+	return fmt.Sprintf(msgCostFooter, strings.Join(parts, ", "))
+}
+
+// format given number with thousands separators, eg. 1250 => "1,250"
+func formatThousands(n int) string {
+	if n < 0 {
+		return "-" + formatThousands(-n)
+	}
+	str := strconv.Itoa(n)
+
+	var sb strings.Builder
+	for i, r := range str {
+		if i > 0 && (len(str)-i)%3 == 0 {
+			sb.WriteRune(',')
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,88 @@
+package main
+
+// private.go
+//
+// private mode: not saving prompts and answers of a user, like `!nolog` on every message
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdPrivate = "/private"
+
+	privateArgOn  = "on"
+	privateArgOff = "off"
+
+	settingKeyPrefixPrivateMode = "private_mode/" // + user id
+
+	msgPrivateUsage   = "Usage: /private [on|off] (currently: <b>%s</b>)"
+	msgPrivateChanged = "Private mode is turned <b>%s</b>.\n\n(while it is on, your prompts and their answers will not be saved, so replies to them will not continue their conversations)"
+)
+
+// checks if private mode is on for given user
+func isPrivateModeOn(db Storage, userID int64) bool {
+	if db == nil {
+		return false
+	}
+
+	value, err := db.GetSetting(settingKeyPrefixPrivateMode + strconv.FormatInt(userID, 10))
+	return err == nil && value == privateArgOn
+}
+
+// turn private mode on/off for given user
+func setPrivateMode(db Storage, userID int64, on bool) error {
+	value := privateArgOff
+	if on {
+		value = privateArgOn
+	}
+
+	return db.SetSetting(settingKeyPrefixPrivateMode+strconv.FormatInt(userID, 10), value)
+}
+
+// return a /private command handler
+func privateCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("private command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil || message.From == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+		userID := message.From.ID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		switch args {
+		case privateArgOn, privateArgOff:
+			if err := setPrivateMode(db, userID, args == privateArgOn); err != nil {
+				log.Printf("failed to change private mode: %s", err)
+
+				msg = err.Error()
+			} else {
+				msg = fmt.Sprintf(msgPrivateChanged, args)
+			}
+		default:
+			msg = fmt.Sprintf(msgPrivateUsage, onOff(isPrivateModeOn(db, userID)))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+// profile.go
+//
+// setting up the profile of the bot (descriptions and command lists) via telegram bot api
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	botDescriptionMaxLen      = 512
+	botShortDescriptionMaxLen = 120
+	botCommandDescriptionMax  = 256
+)
+
+// (telegram only accepts commands with lowercase letters, digits, and underscores)
+var botCommandRegex = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// botProfileConfig struct for the profile of the bot
+type botProfileConfig struct {
+	Description      string `json:"description,omitempty"`       // shown in empty chats with the bot (max 512 chars)
+	ShortDescription string `json:"short_description,omitempty"` // 'about' text on the bot's profile page (max 120 chars)
+
+	// commands shown in each scope (default: all commands for users in private and group chats, and all commands including admin ones in the admin chat)
+	PrivateCommands []string `json:"private_commands,omitempty"` // eg. ["count", "models", "help"]
+	GroupCommands   []string `json:"group_commands,omitempty"`
+	AdminCommands   []string `json:"admin_commands,omitempty"`
+}
+
+// botCommands struct for commands parsed from a help message
+type botCommands struct {
+	user, admin []tg.BotCommand
+}
+
+// scopedBotCommands struct for commands in a scope
+type scopedBotCommands struct {
+	scope    any
+	commands []tg.BotCommand
+}
+
+// set up the descriptions and command lists of the bot from `bot_profile`
+//
+// (command lists are also set up in the languages of localized help messages)
+func setupBotProfile(bot *tg.Bot, conf config) {
+	if conf.BotProfile == nil {
+		return
+	}
+	profile := *conf.BotProfile
+
+	if profile.Description != "" {
+		if res := bot.SetMyDescription(tg.OptionsSetMyDescription{}.
+			SetDescription(ellipsize(profile.Description, botDescriptionMaxLen-1))); !res.Ok {
+			log.Printf("failed to set bot description: %s", *res.Description)
+		}
+	}
+	if profile.ShortDescription != "" {
+		if res := bot.SetMyShortDescription(tg.OptionsSetMyShortDescription{}.
+			SetDescription(ellipsize(profile.ShortDescription, botShortDescriptionMaxLen-1))); !res.Ok {
+			log.Printf("failed to set bot short description: %s", *res.Description)
+		}
+	}
+
+	// default ones, and localized ones
+	setBotCommands(bot, conf, profile, msgHelp, "")
+	for language, translations := range _translations {
+		if help, exists := translations[msgHelp]; exists {
+			setBotCommands(bot, conf, profile, help, language)
+		}
+	}
+
+	logInfo("set up bot profile")
+}
+
+// set command lists of each scope, parsed from given help message
+//
+// (`languageCode` is empty for the default ones)
+func setBotCommands(bot *tg.Bot, conf config, profile botProfileConfig, help, languageCode string) {
+	commands := parseHelpCommands(help)
+	commands.user = append(commands.user, pipelineCommands(conf)...)
+	all := append(append([]tg.BotCommand{}, commands.user...), commands.admin...)
+
+	scopes := []scopedBotCommands{
+		{
+			scope:    tg.BotCommandScopeAllPrivateChats{Type: tg.BotCommandScopeTypeAllPrivateChats},
+			commands: selectBotCommands(all, profile.PrivateCommands, commands.user),
+		},
+		{
+			scope:    tg.BotCommandScopeAllGroupChats{Type: tg.BotCommandScopeTypeAllGroupChats},
+			commands: selectBotCommands(all, profile.GroupCommands, commands.user),
+		},
+	}
+	if conf.AdminChatID != 0 {
+		scopes = append(scopes, scopedBotCommands{
+			scope: tg.BotCommandScopeChat{
+				BotCommandScopeDefault: tg.BotCommandScopeDefault{Type: tg.BotCommandScopeTypeChat},
+				ChatID:                 conf.AdminChatID,
+			},
+			commands: selectBotCommands(all, profile.AdminCommands, all),
+		})
+	}
+
+	for _, s := range scopes {
+		options := tg.OptionsSetMyCommands{}.SetScope(s.scope)
+		if languageCode != "" {
+			options = options.SetLanguageCode(languageCode)
+		}
+
+		if res := bot.SetMyCommands(s.commands, options); !res.Ok {
+			log.Printf("failed to set bot commands (%+v, language: '%s'): %s", s.scope, languageCode, *res.Description)
+		}
+	}
+}
+
+// parse commands and their descriptions from given help message,
+// eg. "/count [some_text] : count the number of tokens in a given text."
+//
+// (commands after the line of "(for admins)" are admin ones, except /help)
+func parseHelpCommands(help string) (commands botCommands) {
+	admin := false
+	for _, line := range strings.Split(help, "\n") {
+		if strings.HasPrefix(line, "(") {
+			admin = true
+			continue
+		}
+		if !strings.HasPrefix(line, "/") {
+			continue
+		}
+
+		usage, description, found := strings.Cut(line, " : ")
+		if !found {
+			continue
+		}
+		name := strings.TrimPrefix(strings.Fields(usage)[0], "/")
+		if !botCommandRegex.MatchString(name) {
+			continue // eg. "/export-chat"
+		}
+
+		command := tg.BotCommand{
+			Command:     name,
+			Description: ellipsize(strings.TrimRight(strings.TrimSpace(description), ".。"), botCommandDescriptionMax-1),
+		}
+		if admin && "/"+name != cmdHelp {
+			commands.admin = append(commands.admin, command)
+		} else {
+			commands.user = append(commands.user, command)
+		}
+	}
+
+	return commands
+}
+
+// get commands of pipelines
+func pipelineCommands(conf config) (commands []tg.BotCommand) {
+	names := []string{}
+	for name := range conf.Pipelines {
+		if botCommandRegex.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		description := conf.Pipelines[name].Description
+		if description == "" {
+			description = fmt.Sprintf("run a pipeline of %d stage(s)", len(conf.Pipelines[name].Stages))
+		}
+
+		commands = append(commands, tg.BotCommand{
+			Command:     name,
+			Description: ellipsize(description, botCommandDescriptionMax-1),
+		})
+	}
+
+	return commands
+}
+
+// select commands with given names (in the order of them) from all commands, or the default ones if no name is given
+func selectBotCommands(all []tg.BotCommand, names []string, defaults []tg.BotCommand) (selected []tg.BotCommand) {
+	if len(names) == 0 {
+		return defaults
+	}
+
+	for _, name := range names {
+		name = strings.TrimPrefix(name, "/")
+
+		found := false
+		for _, command := range all {
+			if command.Command == name {
+				selected = append(selected, command)
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Printf("no such command for bot profile: %s", name)
+		}
+	}
+
+	return selected
+}
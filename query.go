@@ -0,0 +1,175 @@
+package main
+
+// query.go
+//
+// running read-only SQL queries on the logs database, for admins
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdQuery = "/query"
+
+	queryRowsMax      = 1000
+	queryTimeout      = 10 * time.Second
+	queryTableLenMax  = 3500 // max length of a result table in a message (longer ones are sent as csv files)
+	queryCellRunesMax = 40   // max runes of a cell in a result table
+
+	msgQueryUsage     = "Usage: /query [select statement]\n\n(eg. <code>/query select chat_model, count(*) from generateds group by chat_model</code>)"
+	msgQueryFailed    = "Query failed: %s"
+	msgQueryNoRows    = "No rows."
+	msgQueryResult    = "<b>%d</b> row(s)%s in %s"
+	msgQueryTruncated = " (truncated)"
+)
+
+// return a /query command handler
+func queryCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("query command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if !isAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		query := strings.TrimSpace(args)
+		if query == "" {
+			send(b, conf, msgQueryUsage, chatID, &messageID)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		defer cancel()
+
+		started := time.Now()
+		result, err := db.Query(ctx, query, queryRowsMax)
+		if err != nil {
+			send(b, conf, fmt.Sprintf(msgQueryFailed, html.EscapeString(err.Error())), chatID, &messageID)
+			return
+		}
+		elapsed := time.Since(started).Round(time.Millisecond)
+
+		logInfo("query by %s: %s (%d rows in %s)", userNameFromUpdate(update), query, len(result.Rows), elapsed)
+
+		if len(result.Rows) == 0 {
+			send(b, conf, msgQueryNoRows, chatID, &messageID)
+			return
+		}
+
+		var truncated string
+		if result.Truncated {
+			truncated = msgQueryTruncated
+		}
+		summary := fmt.Sprintf(msgQueryResult, len(result.Rows), truncated, elapsed)
+
+		// send as a table if it is short enough, or as a csv file
+		if table := formatQueryTable(result); len(table) <= queryTableLenMax {
+			send(b, conf, fmt.Sprintf("<pre>%s</pre>\n%s", html.EscapeString(table), summary), chatID, &messageID)
+			return
+		}
+
+		data, err := queryResultCSV(result)
+		if err != nil {
+			send(b, conf, fmt.Sprintf(msgQueryFailed, html.EscapeString(err.Error())), chatID, &messageID)
+			return
+		}
+
+		sendChatAction(b, chatID, responseDocument)
+
+		if res := b.SendDocument(
+			chatID,
+			tg.InputFileFromBytes(data),
+			tg.OptionsSendDocument{}.
+				SetReplyParameters(tg.ReplyParameters{MessageID: messageID}).
+				SetCaption(summary).
+				SetParseMode(tg.ParseModeHTML)); !res.Ok {
+			log.Printf("failed to send query result: %s", *res.Description)
+		}
+	}
+}
+
+// format given query result as a plain-text table
+func formatQueryTable(result QueryResult) string {
+	rows := append([][]string{result.Columns}, result.Rows...)
+
+	widths := make([]int, len(result.Columns))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(queryTableCell(cell)))
+		}
+	}
+
+	var sb strings.Builder
+	for r, row := range rows {
+		for i, cell := range row {
+			cell = queryTableCell(cell)
+			if i > 0 {
+				sb.WriteString(" | ")
+			}
+			sb.WriteString(cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+		}
+		sb.WriteString("\n")
+
+		// separator below the header
+		if r == 0 {
+			for i, width := range widths {
+				if i > 0 {
+					sb.WriteString("-+-")
+				}
+				sb.WriteString(strings.Repeat("-", width))
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// flatten and shorten given cell for a table
+func queryTableCell(cell string) string {
+	return ellipsize(strings.Join(strings.Fields(cell), " "), queryCellRunesMax)
+}
+
+// convert given query result to csv
+func queryResultCSV(result QueryResult) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(result.Columns); err != nil {
+		return nil, err
+	}
+	if err := writer.WriteAll(result.Rows); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
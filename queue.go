@@ -0,0 +1,170 @@
+package main
+
+// queue.go
+//
+// bounded pool of workers for generating answers, with a queue of waiting messages
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	answerQueueSizeDefault = 100
+
+	msgQueueBusy = "I'm busy right now, your message is queued at position %d."
+	msgQueueFull = "I'm too busy right now. Please try again later."
+)
+
+// queuedAnswer struct for a message waiting for a worker
+type queuedAnswer struct {
+	sync.Mutex
+
+	chatID    int64
+	messageID int64
+	run       func()
+
+	noticeID int64 // message id of the notice of its position (0 if not sent)
+	position int   // position in the notice
+}
+
+// answerQueue struct for running answers with a bounded number of workers
+type answerQueue struct {
+	sync.Mutex
+
+	active  int             // number of running workers
+	waiting []*queuedAnswer // messages waiting for a worker, in order
+}
+
+var _answerQueue answerQueue
+
+// run `run` (which generates an answer to the message) when a worker is available
+//
+// (if all workers are busy, the message waits in the queue with a notice of its position,
+// which is updated as the queue drains)
+func queueAnswer(bot *tg.Bot, conf config, chatID, messageID int64, run func()) {
+	if conf.AnswerWorkers <= 0 {
+		run()
+		return
+	}
+
+	q := &_answerQueue
+
+	q.Lock()
+	if q.active < conf.AnswerWorkers {
+		q.active++
+		q.Unlock()
+
+		q.work(bot, run)
+		return
+	}
+
+	size := conf.AnswerQueueSize
+	if size <= 0 {
+		size = answerQueueSizeDefault
+	}
+	if len(q.waiting) >= size {
+		q.Unlock()
+
+		log.Printf("answer queue is full, rejecting message %d in chat %d", messageID, chatID)
+
+		send(bot, conf, msgQueueFull, chatID, &messageID)
+		return
+	}
+
+	queued := &queuedAnswer{
+		chatID:    chatID,
+		messageID: messageID,
+		run:       run,
+	}
+	queued.Lock() // (not to be dequeued before its notice is sent)
+	q.waiting = append(q.waiting, queued)
+	position := len(q.waiting)
+	q.Unlock()
+
+	logInfo("queued message %d in chat %d at position %d", messageID, chatID, position)
+
+	if res := bot.SendMessage(chatID, fmt.Sprintf(msgQueueBusy, position), tg.OptionsSendMessage{}.
+		SetReplyParameters(tg.ReplyParameters{MessageID: messageID})); res.Ok {
+		queued.noticeID = res.Result.MessageID
+		queued.position = position
+	} else {
+		log.Printf("failed to send queue notice: %s", *res.Description)
+	}
+	queued.Unlock()
+}
+
+// run given function, then keep running the waiting ones until the queue is empty
+//
+// (panics of them are recovered, and the worker is released even if it panics elsewhere)
+func (q *answerQueue) work(bot *tg.Bot, run func()) {
+	released := false
+	defer func() {
+		if !released {
+			q.Lock()
+			q.active--
+			q.Unlock()
+		}
+	}()
+
+	for {
+		runRecovering(run)
+
+		q.Lock()
+		if len(q.waiting) <= 0 {
+			q.active--
+			released = true
+			q.Unlock()
+			return
+		}
+		next := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		remaining := append([]*queuedAnswer{}, q.waiting...)
+		q.Unlock()
+
+		next.Lock()
+		if next.noticeID != 0 {
+			if res := bot.DeleteMessage(next.chatID, next.noticeID); !res.Ok {
+				log.Printf("failed to delete queue notice: %s", *res.Description)
+			}
+			next.noticeID = 0
+		}
+		run = next.run
+		next.Unlock()
+
+		go updateQueueNotices(bot, remaining)
+	}
+}
+
+// run given function, recovering from (and logging) its panic
+func runRecovering(run func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("recovered from panic while answering: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	run()
+}
+
+// update the notices of positions of given waiting messages
+func updateQueueNotices(bot *tg.Bot, waiting []*queuedAnswer) {
+	for i, queued := range waiting {
+		position := i + 1
+
+		queued.Lock()
+		if queued.noticeID != 0 && queued.position > position {
+			if res := bot.EditMessageText(fmt.Sprintf(msgQueueBusy, position), tg.OptionsEditMessageText{}.
+				SetIDs(queued.chatID, queued.noticeID)); res.Ok {
+				queued.position = position
+			} else {
+				log.Printf("failed to update queue notice: %s", *res.Description)
+			}
+		}
+		queued.Unlock()
+	}
+}
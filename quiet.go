@@ -0,0 +1,210 @@
+package main
+
+// quiet.go
+//
+// quiet hours of chats, during which messages are sent without notifications
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdQuiet = "/quiet"
+
+	quietArgOff = "off"
+
+	chatSettingKeyQuietHours = "quiet_hours" // eg. "22:00-07:00"
+	chatSettingKeyTimezone   = "timezone"    // eg. "Asia/Seoul"
+
+	quietHoursTimeFormat = "15:04"
+
+	msgQuietUsage   = "Usage: /quiet [HH:MM-HH:MM [timezone]|off]\n\n(currently: <b>%s</b>)"
+	msgQuietInvalid = "Invalid quiet hours or timezone: %s"
+	msgQuietChanged = "Quiet hours of this chat: <b>%s</b>\n\n(answers will be sent without notifications during the hours)"
+	msgQuietOff     = "Quiet hours of this chat are turned off."
+	msgQuietNone    = "<i>(none)</i>"
+)
+
+// quietHours struct for quiet hours of a chat
+type quietHours struct {
+	start, end time.Duration // since midnight
+	location   *time.Location
+}
+
+// parse quiet hours like "22:00-07:00"
+func parseQuietHours(value string, location *time.Location) (hours quietHours, err error) {
+	from, to, found := strings.Cut(value, "-")
+	if !found {
+		return hours, fmt.Errorf("expected HH:MM-HH:MM, got '%s'", value)
+	}
+
+	var start, end time.Time
+	if start, err = time.Parse(quietHoursTimeFormat, strings.TrimSpace(from)); err != nil {
+		return hours, err
+	}
+	if end, err = time.Parse(quietHoursTimeFormat, strings.TrimSpace(to)); err != nil {
+		return hours, err
+	}
+
+	return quietHours{
+		start:    time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		end:      time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute,
+		location: location,
+	}, nil
+}
+
+// checks if given time is within the quiet hours (which may span midnight)
+func (h quietHours) contains(t time.Time) bool {
+	t = t.In(h.location)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+	if h.start <= h.end {
+		return now >= h.start && now < h.end
+	}
+	return now >= h.start || now < h.end
+}
+
+// get the timezone of a chat
+//
+// (falls back to the timezone of the user for private chats, and then the bot's one)
+func chatTimezone(db Storage, chatID int64) *time.Location {
+	if db != nil {
+		if value, err := db.GetChatSetting(chatID, chatSettingKeyTimezone); err == nil && value != "" {
+			if location, err := time.LoadLocation(value); err == nil {
+				return location
+			}
+		}
+	}
+
+	// (ids of private chats are the same as their users' ids)
+	if chatID > 0 {
+		if location := savedUserTimezone(db, chatID); location != nil {
+			return location
+		}
+	}
+
+	return botTimezone(currentConfig())
+}
+
+// checks if it is quiet hours of a chat now
+func isQuietHours(db Storage, chatID int64) bool {
+	if db == nil {
+		return false
+	}
+
+	value, err := db.GetChatSetting(chatID, chatSettingKeyQuietHours)
+	if err != nil || value == "" {
+		return false
+	}
+
+	hours, err := parseQuietHours(value, chatTimezone(db, chatID))
+	if err != nil {
+		log.Printf("invalid quiet hours of chat %d: %s", chatID, err)
+		return false
+	}
+
+	return hours.contains(time.Now())
+}
+
+// describe the quiet hours of a chat
+func describeQuietHours(db Storage, chatID int64) string {
+	if db == nil {
+		return msgQuietNone
+	}
+
+	if value, err := db.GetChatSetting(chatID, chatSettingKeyQuietHours); err == nil && value != "" {
+		return fmt.Sprintf("%s (%s)", html.EscapeString(value), html.EscapeString(chatTimezone(db, chatID).String()))
+	}
+
+	return msgQuietNone
+}
+
+// return a /quiet command handler
+func quietCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("quiet command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		fields := strings.Fields(args)
+		if len(fields) == 0 || len(fields) > 2 {
+			send(b, conf, fmt.Sprintf(msgQuietUsage, describeQuietHours(db, chatID)), chatID, &messageID)
+			return
+		}
+
+		// only admins can change settings of group chats
+		if message.Chat.Type != tg.ChatTypePrivate && !isChatAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		if fields[0] == quietArgOff {
+			var msg string
+			if err := db.SetChatSetting(chatID, chatSettingKeyQuietHours, ""); err != nil {
+				log.Printf("failed to turn off quiet hours: %s", err)
+
+				msg = err.Error()
+			} else {
+				msg = msgQuietOff
+			}
+
+			send(b, conf, msg, chatID, &messageID)
+			return
+		}
+
+		// validate quiet hours and timezone
+		location := chatTimezone(db, chatID)
+		if len(fields) == 2 {
+			var err error
+			if location, err = time.LoadLocation(fields[1]); err != nil {
+				send(b, conf, fmt.Sprintf(msgQuietInvalid, html.EscapeString(err.Error())), chatID, &messageID)
+				return
+			}
+		}
+		if _, err := parseQuietHours(fields[0], location); err != nil {
+			send(b, conf, fmt.Sprintf(msgQuietInvalid, html.EscapeString(err.Error())), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		if err := db.SetChatSetting(chatID, chatSettingKeyQuietHours, fields[0]); err != nil {
+			log.Printf("failed to change quiet hours: %s", err)
+
+			msg = err.Error()
+		} else if len(fields) == 2 {
+			if err := db.SetChatSetting(chatID, chatSettingKeyTimezone, location.String()); err != nil {
+				log.Printf("failed to change timezone: %s", err)
+
+				msg = err.Error()
+			}
+		}
+		if msg == "" {
+			msg = fmt.Sprintf(msgQuietChanged, describeQuietHours(db, chatID))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+// quotes.go
+//
+// using quoted excerpts of replied messages as contexts
+
+import (
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// checks if given message quotes a part of the message which it replies to
+func hasQuote(message tg.Message) bool {
+	return message.Quote != nil && message.Quote.Text != "" && repliedToMessage(message) != nil
+}
+
+// convert the quoted excerpt of the replied message into a chat message
+// (with the role of the replied message), nil if there is no quote
+func quotedChatMessage(bot *tg.Bot, message tg.Message) *openai.ChatMessage {
+	if !hasQuote(message) {
+		return nil
+	}
+
+	role := openai.ChatMessageRoleUser
+	if replied := convertMessage(bot, *repliedToMessage(message)); replied != nil {
+		role = replied.Role
+	}
+
+	chatMessage := openai.ChatMessage{Role: role, Content: message.Quote.Text}
+	return &chatMessage
+}
@@ -0,0 +1,179 @@
+package main
+
+// rawarchive.go
+//
+// archiving requests of chat completions and their responses as JSON lines in rotating files (separate from the database)
+//
+// (archived where chat completions are created, for openai-go does not expose its http client)
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+)
+
+const (
+	rawArchiveMaxMegabytesDefault = 100
+	rawArchiveMaxFilesDefault     = 5
+)
+
+// rawArchiveConfig struct for archiving requests and responses of chat completions
+type rawArchiveConfig struct {
+	Filepath     string `json:"filepath"`                // path of the JSONL file (rotated ones are suffixed with .1, .2, ...)
+	MaxMegabytes int    `json:"max_megabytes,omitempty"` // rotate the file when it exceeds this size (default: 100)
+	MaxFiles     int    `json:"max_files,omitempty"`     // number of rotated files to keep (default: 5)
+}
+
+// rawArchiveEntry struct for a line of the raw archive
+type rawArchiveEntry struct {
+	Time      time.Time `json:"time"`
+	Request   any       `json:"request"`
+	Response  any       `json:"response,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+}
+
+// rawArchiver struct for writing entries to a rotating file
+type rawArchiver struct {
+	sync.Mutex
+
+	fpath    string
+	maxBytes int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+// raw archive, nil if not configured
+var _rawArchive *rawArchiver = nil
+
+// set up the raw archive from config
+func setupRawArchive(conf config) error {
+	if conf.RawArchive == nil || conf.RawArchive.Filepath == "" {
+		return nil
+	}
+
+	maxMegabytes := conf.RawArchive.MaxMegabytes
+	if maxMegabytes <= 0 {
+		maxMegabytes = rawArchiveMaxMegabytesDefault
+	}
+	maxFiles := conf.RawArchive.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = rawArchiveMaxFilesDefault
+	}
+
+	archiver := &rawArchiver{
+		fpath:    conf.RawArchive.Filepath,
+		maxBytes: int64(maxMegabytes) * 1024 * 1024,
+		maxFiles: maxFiles,
+	}
+	if err := archiver.open(); err != nil {
+		return fmt.Errorf("failed to open raw archive: %w", err)
+	}
+
+	_rawArchive = archiver
+
+	return nil
+}
+
+// open (or create) the archive file for appending
+func (a *rawArchiver) open() (err error) {
+	if err = os.MkdirAll(filepath.Dir(a.fpath), 0755); err != nil {
+		return err
+	}
+
+	if a.file, err = os.OpenFile(a.fpath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return err
+	}
+
+	var info os.FileInfo
+	if info, err = a.file.Stat(); err != nil {
+		return err
+	}
+	a.size = info.Size()
+
+	return nil
+}
+
+// rotate the archive file: `file` => `file.1` => `file.2` => ... (the oldest one is removed)
+func (a *rawArchiver) rotate() error {
+	if err := a.file.Close(); err != nil {
+		log.Printf("failed to close raw archive: %s", err)
+	}
+
+	_ = os.Remove(fmt.Sprintf("%s.%d", a.fpath, a.maxFiles))
+	for i := a.maxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", a.fpath, i), fmt.Sprintf("%s.%d", a.fpath, i+1))
+	}
+	if err := os.Rename(a.fpath, a.fpath+".1"); err != nil {
+		return err
+	}
+
+	return a.open()
+}
+
+// write given entry as a line
+func (a *rawArchiver) write(entry rawArchiveEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("failed to marshal raw archive entry: %s", err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.size > 0 && a.size+int64(len(line)) > a.maxBytes {
+		if err := a.rotate(); err != nil {
+			log.Printf("failed to rotate raw archive: %s", err)
+			return
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		log.Printf("failed to write raw archive: %s", err)
+	}
+}
+
+// create a chat completion with given client, and archive its request and response (or error)
+//
+// (just creates a chat completion if `raw_archive` is not configured)
+func archivedChatCompletion(client *openai.Client, model string, messages []openai.ChatMessage, options openai.ChatCompletionOptions) (response openai.ChatCompletion, err error) {
+	if _rawArchive == nil {
+		return client.CreateChatCompletion(model, messages, options)
+	}
+
+	// request body of the chat completion (same as the one which the library sends)
+	request := map[string]any{}
+	for k, v := range options {
+		request[k] = v
+	}
+	request["model"] = model
+	request["messages"] = messages
+
+	entry := rawArchiveEntry{
+		Time:    time.Now(),
+		Request: request,
+	}
+
+	response, err = client.CreateChatCompletion(model, messages, options)
+	entry.ElapsedMs = time.Since(entry.Time).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Response = response
+	}
+	_rawArchive.write(entry)
+
+	return response, err
+}
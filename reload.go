@@ -0,0 +1,71 @@
+package main
+
+// reload.go
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// currently applied config and its filepath
+var (
+	_conf         config
+	_confFilepath string
+	_confLock     sync.RWMutex
+)
+
+// set config (and its filepath) for the running bot
+func setConfig(confFilepath string, conf config) {
+	_confLock.Lock()
+	defer _confLock.Unlock()
+
+	_confFilepath = confFilepath
+	_conf = conf
+}
+
+// get currently applied config
+func currentConfig() config {
+	_confLock.RLock()
+	defer _confLock.RUnlock()
+
+	return _conf
+}
+
+// re-read the config file and apply it
+//
+// NOTE: changes of tokens, api keys, and database path will not be applied until restart
+func reloadConfig() (err error) {
+	_confLock.RLock()
+	confFilepath := _confFilepath
+	_confLock.RUnlock()
+
+	var conf config
+	if conf, err = loadConfig(confFilepath); err != nil {
+		return err
+	}
+
+	setConfig(confFilepath, conf)
+
+	setLogLevelFromConfig(conf)
+
+	logInfo("reloaded config from: %s", confFilepath)
+
+	return nil
+}
+
+// reload config on SIGHUP
+func reloadConfigOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		for range ch {
+			if err := reloadConfig(); err != nil {
+				log.Printf("failed to reload config: %s", err)
+			}
+		}
+	}()
+}
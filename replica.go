@@ -0,0 +1,72 @@
+package main
+
+// replica.go
+//
+// read-only replica of the database for statistics and analytical queries, not to contend with the write path
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// replicaStorage struct which serves statistics and read-only queries from a replica,
+// and everything else from the underlying storage
+type replicaStorage struct {
+	Storage
+
+	replica Storage
+}
+
+// wrap given storage with a read-only replica
+//
+// (returns the storage as it is if there is no replica)
+func newReplicaStorage(storage, replica Storage) Storage {
+	if replica == nil {
+		return storage
+	}
+
+	return &replicaStorage{Storage: storage, replica: replica}
+}
+
+// open the read-only replica in config (nil if not configured, or on errors)
+func openReadReplica(conf config) Storage {
+	if conf.ReadReplicaDBFilepath == "" {
+		return nil
+	}
+
+	replica, err := OpenReadOnlyStorage(conf.DBDriver, conf.ReadReplicaDBFilepath)
+	if err != nil {
+		log.Printf("failed to open read replica db, statistics will be served from the primary one: %s", err)
+		return nil
+	}
+
+	return replica
+}
+
+// Stats returns usage statistics from the replica (or from the primary one on errors).
+func (s *replicaStorage) Stats() (stats Stats, err error) {
+	if stats, err = s.replica.Stats(); err == nil {
+		return stats, nil
+	}
+	log.Printf("failed to get stats from read replica: %s", err)
+
+	return s.Storage.Stats()
+}
+
+// TokensSince returns the number of tokens generated since given time, from the replica (or from the primary one on errors).
+func (s *replicaStorage) TokensSince(since time.Time) (tokens int64, err error) {
+	if tokens, err = s.replica.TokensSince(since); err == nil {
+		return tokens, nil
+	}
+	log.Printf("failed to get tokens from read replica: %s", err)
+
+	return s.Storage.TokensSince(since)
+}
+
+// Query runs a read-only `query` on the replica, returning at most `maxRows` rows.
+//
+// (errors of the query itself are returned as they are, not retried on the primary one)
+func (s *replicaStorage) Query(ctx context.Context, query string, maxRows int) (result QueryResult, err error) {
+	return s.replica.Query(ctx, query, maxRows)
+}
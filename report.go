@@ -0,0 +1,106 @@
+package main
+
+// report.go
+//
+// emailing usage reports via SMTP
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	reportIntervalDaysDefault = 7
+	reportCheckInterval       = 1 * time.Hour
+
+	settingKeyLastUsageReport = "last_usage_report"
+
+	reportSubject = "[telegram-chatgpt-bot] Usage report"
+)
+
+// smtpConfig struct for emailing usage reports
+type smtpConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"` // eg. 587 (STARTTLS)
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+
+	ReportIntervalDays int `json:"report_interval_days,omitempty"` // default: 7
+}
+
+// email usage reports periodically, if SMTP is configured
+//
+// (time of the last report is persisted, so restarts will not reset the schedule)
+func emailUsageReportsPeriodically(db Storage) {
+	if db == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(reportCheckInterval)
+		defer ticker.Stop()
+
+		for ; true; <-ticker.C {
+			conf := currentConfig()
+			if conf.SMTP == nil {
+				continue
+			}
+
+			interval := conf.SMTP.ReportIntervalDays
+			if interval <= 0 {
+				interval = reportIntervalDaysDefault
+			}
+
+			var last time.Time
+			if value, err := db.GetSetting(settingKeyLastUsageReport); err == nil {
+				if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+					last = time.Unix(unix, 0)
+				}
+			}
+			if time.Since(last) < time.Duration(interval)*24*time.Hour {
+				continue
+			}
+
+			if err := emailUsageReport(*conf.SMTP, retrieveStats(db, botTimezone(conf))); err != nil {
+				log.Printf("failed to email usage report: %s", err)
+				continue
+			}
+
+			logInfo("emailed usage report to: %s", strings.Join(conf.SMTP.To, ", "))
+
+			if err := db.SetSetting(settingKeyLastUsageReport, strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+				log.Printf("failed to save time of the last usage report: %s", err)
+			}
+		}
+	}()
+}
+
+// email given stats (in telegram html) as a usage report
+func emailUsageReport(conf smtpConfig, stats string) error {
+	var auth smtp.Auth
+	if conf.Username != "" {
+		auth = smtp.PlainAuth("", conf.Username, conf.Password, conf.Host)
+	}
+
+	body := fmt.Sprintf(`<html><body>%s</body></html>`, strings.ReplaceAll(stats, "\n", "<br>\n"))
+
+	msg := strings.Join([]string{
+		"From: " + conf.From,
+		"To: " + strings.Join(conf.To, ", "),
+		"Subject: " + reportSubject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		`Content-Type: text/html; charset="UTF-8"`,
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port)), auth, conf.From, conf.To, []byte(msg))
+}
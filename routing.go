@@ -0,0 +1,255 @@
+package main
+
+// routing.go
+//
+// routing requests between multiple (OpenAI-compatible) providers, with policies like least-cost, latency-based, or failover-only
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+)
+
+const (
+	routingPolicyCheapest routingPolicy = "cheapest" // capable model with the lowest estimated cost first
+	routingPolicyLatency  routingPolicy = "latency"  // capable model with the lowest average latency first
+	routingPolicyFailover routingPolicy = "failover" // requested model first, then the others in the configured order (default)
+
+	routingCapabilityVision = "vision"
+	routingCapabilityTools  = "tools"
+
+	routingEstimatedCompletionTokens = 500 // estimated tokens of a completion, for comparing costs
+	routingLatencySmoothing          = 0.3 // weight of the latest latency in moving averages
+)
+
+// routingPolicy type for policies of routing
+type routingPolicy string
+
+// routingConfig struct for routing requests between providers
+type routingConfig struct {
+	Policy    routingPolicy    `json:"policy,omitempty"` // "cheapest", "latency", or "failover" (default)
+	Providers []providerConfig `json:"providers"`
+}
+
+// providerConfig struct for a provider of OpenAI-compatible APIs
+type providerConfig struct {
+	Name           string        `json:"name"`
+	BaseURL        string        `json:"base_url,omitempty"`        // NOTE: not supported yet (providers with it are skipped), for openai-go has its base url hard-coded
+	APIKey         string        `json:"api_key,omitempty"`         // (default: `openai_api_key`)
+	OrganizationID string        `json:"organization_id,omitempty"` // (default: `openai_org_id` when `api_key` is not set)
+	Models         []routedModel `json:"models"`
+}
+
+// routedModel struct for a model of a provider, and its capabilities
+type routedModel struct {
+	Model        string   `json:"model"`
+	Capabilities []string `json:"capabilities,omitempty"` // eg. "vision", "tools"
+}
+
+// routeCandidate struct for a model of a provider which a request can be routed to
+type routeCandidate struct {
+	provider string
+	model    string
+	client   *openai.Client
+}
+
+// string of the route, for logging (eg. "openrouter/gpt-4o")
+func (c routeCandidate) String() string {
+	return c.provider + "/" + c.model
+}
+
+// clients of providers, keyed by their names and api keys
+var _providerClients = map[string]*openai.Client{}
+var _providerClientsLock sync.Mutex
+
+// moving averages of latencies, keyed by routes
+var _routeLatencies = map[string]time.Duration{}
+var _routeLatenciesLock sync.Mutex
+
+// get (or create) the client of given provider
+func providerClient(conf config, provider providerConfig) *openai.Client {
+	apiKey, orgID := provider.APIKey, provider.OrganizationID
+	if apiKey == "" {
+		apiKey = conf.OpenAIAPIKey
+		if orgID == "" {
+			orgID = conf.OpenAIOrganizationID
+		}
+	}
+
+	key := strings.Join([]string{provider.Name, apiKey, orgID}, "|")
+
+	_providerClientsLock.Lock()
+	defer _providerClientsLock.Unlock()
+
+	if client, exists := _providerClients[key]; exists {
+		return client
+	}
+
+	client := openai.NewClient(apiKey, orgID)
+	client.Verbose = conf.Verbose
+	_providerClients[key] = client
+
+	return client
+}
+
+// get the capabilities required for given messages
+func requiredCapabilities(messages []openai.ChatMessage) (required []string) {
+	for _, message := range messages {
+		if len(message.ToolCalls) > 0 || message.ToolCallID != nil {
+			if !slices.Contains(required, routingCapabilityTools) {
+				required = append(required, routingCapabilityTools)
+			}
+		}
+		if contents, ok := message.Content.([]openai.ChatMessageContent); ok {
+			for _, content := range contents {
+				if content.ImageURL != nil && !slices.Contains(required, routingCapabilityVision) {
+					required = append(required, routingCapabilityVision)
+				}
+			}
+		}
+	}
+
+	return required
+}
+
+// get the routes for a request of given model and messages, in the order of trying them
+//
+// (models without required capabilities, or with too small context windows, are excluded)
+func planRoutes(conf config, model string, messages []openai.ChatMessage, promptTokens int) (routes []routeCandidate) {
+	required := requiredCapabilities(messages)
+
+	for _, provider := range conf.Routing.Providers {
+		// (requests cannot be sent to other base urls until openai-go supports them)
+		if provider.BaseURL != "" {
+			if isVerbose() {
+				log.Printf("[verbose] skipping provider '%s' with base url which is not supported yet: %s", provider.Name, provider.BaseURL)
+			}
+			continue
+		}
+
+		for _, m := range provider.Models {
+			if !capableOf(m, required) {
+				continue
+			}
+			if window := contextWindowOf(conf, m.Model); window > 0 && promptTokens >= window {
+				continue
+			}
+
+			routes = append(routes, routeCandidate{
+				provider: provider.Name,
+				model:    m.Model,
+				client:   providerClient(conf, provider),
+			})
+		}
+	}
+
+	switch conf.Routing.Policy {
+	case routingPolicyCheapest:
+		slices.SortStableFunc(routes, func(a, b routeCandidate) int {
+			costA, foundA := estimatedCost(conf, a.model, promptTokens)
+			costB, foundB := estimatedCost(conf, b.model, promptTokens)
+			switch {
+			case foundA && !foundB:
+				return -1
+			case !foundA && foundB:
+				return 1
+			}
+			return cmp.Compare(costA, costB)
+		})
+	case routingPolicyLatency:
+		// (routes without measured latencies come first, for measuring them)
+		slices.SortStableFunc(routes, func(a, b routeCandidate) int {
+			return cmp.Compare(averageLatency(a), averageLatency(b))
+		})
+	default:
+		slices.SortStableFunc(routes, func(a, b routeCandidate) int {
+			switch {
+			case a.model == model && b.model != model:
+				return -1
+			case a.model != model && b.model == model:
+				return 1
+			}
+			return 0
+		})
+	}
+
+	return routes
+}
+
+// checks if given model has all of the required capabilities
+func capableOf(model routedModel, required []string) bool {
+	for _, capability := range required {
+		if !slices.Contains(model.Capabilities, capability) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// estimate the cost of a request to given model, in USD
+func estimatedCost(conf config, model string, promptTokens int) (cost float64, found bool) {
+	price, found := priceOf(conf, model)
+	if !found {
+		return 0, false
+	}
+
+	return (price.Input*float64(promptTokens) + price.Output*routingEstimatedCompletionTokens) / 1_000_000, true
+}
+
+// get the moving average of latencies of given route (0 if not measured yet)
+func averageLatency(route routeCandidate) time.Duration {
+	_routeLatenciesLock.Lock()
+	defer _routeLatenciesLock.Unlock()
+
+	return _routeLatencies[route.String()]
+}
+
+// add a measured latency of given route to its moving average
+func recordLatency(route routeCandidate, latency time.Duration) {
+	_routeLatenciesLock.Lock()
+	defer _routeLatenciesLock.Unlock()
+
+	key := route.String()
+	if average, exists := _routeLatencies[key]; exists {
+		_routeLatencies[key] = time.Duration(routingLatencySmoothing*float64(latency) + (1-routingLatencySmoothing)*float64(average))
+	} else {
+		_routeLatencies[key] = latency
+	}
+}
+
+// request a chat completion through the planned routes, falling back to the next one on errors
+//
+// (`routed` is set to the route which served the request; when no route is capable of it,
+// it is requested to the default client with given model and `routed` is left empty)
+func routedChatCompletion(client *openai.Client, conf config, model string, directives messageDirectives, messages []openai.ChatMessage, userID int64, promptTokens int, routed *routeCandidate) (response openai.ChatCompletion, cachedAt *time.Time, err error) {
+	routes := planRoutes(conf, model, messages, promptTokens)
+	if len(routes) <= 0 {
+		log.Printf("no capable route for the request (model: %s, capabilities: %v), using the default client", model, requiredCapabilities(messages))
+
+		return cachedChatCompletion(client, conf, model, directives, messages, userID)
+	}
+
+	for _, route := range routes {
+		started := time.Now()
+		if response, cachedAt, err = cachedChatCompletion(route.client, conf, route.model, directives, messages, userID); err == nil {
+			if cachedAt == nil {
+				recordLatency(route, time.Since(started))
+			}
+			*routed = route
+
+			logInfo("routed request to %s (policy: %s)", route, conf.Routing.Policy)
+
+			return response, cachedAt, nil
+		}
+
+		log.Printf("failed to complete with route %s (%s): %s", route, classifyError(err), err)
+	}
+
+	return response, nil, err
+}
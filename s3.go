@@ -0,0 +1,125 @@
+package main
+
+// s3.go
+//
+// uploading objects to S3 (or S3-compatible) buckets, signed with AWS Signature Version 4
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	s3EndpointFormatDefault = "https://s3.%s.amazonaws.com"
+	s3RegionDefault         = "us-east-1"
+)
+
+// s3Config struct for S3 (or S3-compatible) buckets
+type s3Config struct {
+	Endpoint        string `json:"endpoint,omitempty"` // default: "https://s3.{region}.amazonaws.com"
+	Region          string `json:"region,omitempty"`   // default: "us-east-1"
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix,omitempty"` // prefix of object keys (eg. "telegram-bot/")
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// upload `data` to the bucket with given `key`, will timeout in 60 seconds
+//
+// (returns the url of the uploaded object, eg. "s3://bucket/key")
+func putS3Object(conf s3Config, key string, data []byte, contentType string) (location string, err error) {
+	region := conf.Region
+	if region == "" {
+		region = s3RegionDefault
+	}
+	endpoint := conf.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf(s3EndpointFormatDefault, region)
+	}
+	key = conf.Prefix + key
+
+	// NOTE: path-style urls, for S3-compatible ones too
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), conf.Bucket, key), bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+	signS3Request(conf, region, req, data, contentType, time.Now().UTC())
+
+	httpClient := newHTTPClient(time.Second * 60)
+
+	var resp *http.Response
+	if resp, err = httpClient.Do(req); err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("s3 error (http %d): %s", resp.StatusCode, string(body))
+	}
+
+	return fmt.Sprintf("s3://%s/%s", conf.Bucket, key), nil
+}
+
+// sign given request with AWS Signature Version 4
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signS3Request(conf s3Config, region string, req *http.Request, payload []byte, contentType string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"content-type:" + contentType,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+conf.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", conf.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// hex-encoded sha256 hash of given data
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// hmac-sha256 of given data with `key`
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,254 @@
+package main
+
+// sanitize.go
+//
+// sanitizing model outputs for telegram's HTML parse mode
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	htmlMessageMaxLen = 4096 // telegram's limit of text messages
+
+	systemPromptHTMLAnswers = `Format your answers with these HTML tags only: <b>, <i>, <u>, <s>, <code>, <pre>, <a href="...">, and <blockquote>. Do not use markdown, and escape other '<', '>', and '&' characters as '&lt;', '&gt;', and '&amp;'.`
+)
+
+var (
+	htmlTagRegex    = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[a-zA-Z-]+(?:\s*=\s*"[^"<>]*")?)*)\s*>`)
+	htmlAttrRegex   = regexp.MustCompile(`([a-zA-Z-]+)(?:\s*=\s*"([^"<>]*)")?`)
+	htmlEntityRegex = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#x[0-9a-fA-F]{1,6}|lt|gt|amp|quot);`)
+	anyHTMLTagRegex = regexp.MustCompile(`</?[a-zA-Z][a-zA-Z0-9-]*(?:\s[^<>]*)?>`)
+)
+
+// tags supported by telegram, and their allowed attributes
+//
+// https://core.telegram.org/bots/api#html-style
+var htmlAllowedTags = map[string]map[string]func(value string) bool{
+	"b": nil, "strong": nil,
+	"i": nil, "em": nil,
+	"u": nil, "ins": nil,
+	"s": nil, "strike": nil, "del": nil,
+	"tg-spoiler": nil,
+	"span": {
+		"class": func(value string) bool { return value == "tg-spoiler" },
+	},
+	"a": {
+		"href": func(value string) bool { return value != "" },
+	},
+	"code": {
+		"class": func(value string) bool { return strings.HasPrefix(value, "language-") },
+	},
+	"pre": nil,
+	"blockquote": {
+		"expandable": func(value string) bool { return value == "" },
+	},
+}
+
+// sanitize given text for telegram's HTML parse mode
+//
+// (supported tags are kept, and all other '<', '>', and '&' characters are escaped.
+// unbalanced closing tags are escaped, and unclosed tags are closed at the end)
+func sanitizeHTML(text string) string {
+	var sb strings.Builder
+	open := []string{} // stack of open tags
+
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '<':
+			if match := htmlTagRegex.FindStringSubmatch(text[i:]); match != nil {
+				closing, name, attrs := match[1] == "/", strings.ToLower(match[2]), match[3]
+
+				if allowed, exists := htmlAllowedTags[name]; exists {
+					if !closing && htmlAttrsAllowed(allowed, attrs) {
+						open = append(open, name)
+						sb.WriteString(htmlOpeningTag(name, attrs))
+						i += len(match[0])
+						continue
+					} else if closing && len(open) > 0 && open[len(open)-1] == name {
+						open = open[:len(open)-1]
+						sb.WriteString("</" + name + ">")
+						i += len(match[0])
+						continue
+					}
+				}
+			}
+			sb.WriteString("&lt;")
+		case '>':
+			sb.WriteString("&gt;")
+		case '&':
+			if entity := htmlEntityRegex.FindString(text[i:]); entity != "" {
+				sb.WriteString(entity)
+				i += len(entity)
+				continue
+			}
+			sb.WriteString("&amp;")
+		default:
+			sb.WriteByte(text[i])
+		}
+		i++
+	}
+
+	// close unclosed tags
+	for i := len(open) - 1; i >= 0; i-- {
+		sb.WriteString("</" + open[i] + ">")
+	}
+
+	return sb.String()
+}
+
+// checks if given attributes are allowed for a tag
+func htmlAttrsAllowed(allowed map[string]func(value string) bool, attrs string) bool {
+	for _, attr := range htmlAttrRegex.FindAllStringSubmatch(attrs, -1) {
+		if check, exists := allowed[strings.ToLower(attr[1])]; !exists || !check(attr[2]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rebuild an opening tag with its attributes (and their values escaped)
+func htmlOpeningTag(name, attrs string) string {
+	var sb strings.Builder
+	sb.WriteString("<" + name)
+	for _, attr := range htmlAttrRegex.FindAllStringSubmatch(attrs, -1) {
+		if strings.Contains(attr[0], "=") {
+			sb.WriteString(fmt.Sprintf(` %s="%s"`, strings.ToLower(attr[1]), sanitizeHTML(attr[2])))
+		} else {
+			sb.WriteString(" " + strings.ToLower(attr[1]))
+		}
+	}
+	sb.WriteString(">")
+
+	return sb.String()
+}
+
+// convert given HTML text to a plain text, by stripping tags and unescaping entities
+func htmlToPlainText(text string) string {
+	return html.UnescapeString(anyHTMLTagRegex.ReplaceAllString(text, ""))
+}
+
+// split given sanitized HTML text into chunks of at most `maxLen` bytes,
+// closing tags which are open at the end of a chunk and reopening them at the start of the next one
+//
+// (tags, entities, and multi-byte characters are never split)
+func splitHTML(text string, maxLen int) (chunks []string) {
+	type openTag struct {
+		name, opening string
+	}
+	open := []openTag{}
+
+	closings := func() (closing string) {
+		for i := len(open) - 1; i >= 0; i-- {
+			closing += "</" + open[i].name + ">"
+		}
+		return closing
+	}
+	openings := func() (opening string) {
+		for _, tag := range open {
+			opening += tag.opening
+		}
+		return opening
+	}
+
+	var sb strings.Builder
+	hasContent := false
+	for i := 0; i < len(text); {
+		// next token: a tag, an entity, or a character
+		token := text[i : i+1]
+		var tag []string
+		if text[i] == '<' {
+			if tag = htmlTagRegex.FindStringSubmatch(text[i:]); tag != nil {
+				token = tag[0]
+			}
+		} else if text[i] == '&' {
+			if entity := htmlEntityRegex.FindString(text[i:]); entity != "" {
+				token = entity
+			}
+		} else if _, size := utf8.DecodeRuneInString(text[i:]); size > 1 {
+			token = text[i : i+size]
+		}
+		i += len(token)
+
+		// start a new chunk when the token does not fit (with closing tags) in the current one
+		//
+		// (closing tags always fit, as they are already counted)
+		closing := tag != nil && tag[1] == "/"
+		needed := len(token) + len(closings())
+		if tag != nil && !closing {
+			needed += len("</" + tag[2] + ">")
+		}
+		if !closing && hasContent && sb.Len()+needed > maxLen {
+			sb.WriteString(closings())
+			chunks = append(chunks, sb.String())
+
+			sb.Reset()
+			sb.WriteString(openings())
+			hasContent = false
+		}
+
+		sb.WriteString(token)
+		if tag != nil {
+			if name := strings.ToLower(tag[2]); closing {
+				if len(open) > 0 {
+					open = open[:len(open)-1]
+				}
+			} else {
+				open = append(open, openTag{name: name, opening: token})
+			}
+		} else {
+			hasContent = true
+		}
+	}
+	if hasContent { // (skip the last chunk with reopened tags only)
+		chunks = append(chunks, sb.String())
+	}
+
+	return chunks
+}
+
+// send given text (in HTML) after sanitizing it, or as a plain text if it fails
+//
+// (texts longer than telegram's limit are sent in several messages, and the response of the last one is returned)
+func sendHTML(bot *tg.Bot, chatID int64, text string, options tg.OptionsSendMessage) (res tg.APIResponse[tg.Message]) {
+	for _, chunk := range splitHTML(sanitizeHTML(text), htmlMessageMaxLen) {
+		if res = bot.SendMessage(chatID, chunk, options.SetParseMode(tg.ParseModeHTML)); res.Ok {
+			continue
+		}
+		log.Printf("failed to send text as HTML, sending it as a plain text: %s", failureDescription(res))
+
+		delete(options, "parse_mode")
+		if res = bot.SendMessage(chatID, htmlToPlainText(chunk), options); !res.Ok {
+			return res
+		}
+	}
+
+	return res
+}
+
+// get the description of a failed response (which may not have one)
+func failureDescription[T any](res tg.APIResponse[T]) string {
+	if res.Description != nil {
+		return *res.Description
+	}
+
+	return "no description"
+}
+
+// append an instruction for formatting answers with HTML tags to the system prompt of given messages, if `html_answers` is set
+func withHTMLAnswers(conf config, messages []openai.ChatMessage) []openai.ChatMessage {
+	if !conf.HTMLAnswers {
+		return messages
+	}
+
+	return withSystemInstruction(messages, systemPromptHTMLAnswers)
+}
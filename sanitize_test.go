@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"supported tags", `<b>bold</b> <i>italic</i> <code class="language-go">x</code>`, `<b>bold</b> <i>italic</i> <code class="language-go">x</code>`},
+		{"unclosed tags", `<b>bold <i>and italic`, `<b>bold <i>and italic</i></b>`},
+		{"mis-nested tags", `<b><i>text</b></i>`, `<b><i>text&lt;/b&gt;</i></b>`},
+		{"stray closing tag", `text</b>`, `text&lt;/b&gt;`},
+		{"unsupported tags", `<div>text</div><script>alert(1)</script>`, `&lt;div&gt;text&lt;/div&gt;&lt;script&gt;alert(1)&lt;/script&gt;`},
+		{"disallowed attribute", `<a onclick="x()">link</a>`, `&lt;a onclick="x()"&gt;link&lt;/a&gt;`},
+		{"'<' and '&' in code", `<code>if a < b && c > d {}</code>`, `<code>if a &lt; b &amp;&amp; c &gt; d {}</code>`},
+		{"'<' and '&' in pre", "<pre>x := <-ch\ny &^= z</pre>", "<pre>x := &lt;-ch\ny &amp;^= z</pre>"},
+		{"entities kept", `a &lt; b &amp; c &#39; &#x1F600;`, `a &lt; b &amp; c &#39; &#x1F600;`},
+		{"invalid entity", `&nbsp; &foo;`, `&amp;nbsp; &amp;foo;`},
+		{"link with escaped quotes", `<a href="https://example.com/?q=&quot;x&quot;">link</a>`, `<a href="https://example.com/?q=&quot;x&quot;">link</a>`},
+		{"link with quotes", `<a href="https://example.com/?q="x"">link</a>`, `&lt;a href="https://example.com/?q="x""&gt;link&lt;/a&gt;`},
+		{"link with single quote and ampersand", `<a href="https://example.com/it's?a=1&b=2">link</a>`, `<a href="https://example.com/it's?a=1&amp;b=2">link</a>`},
+		{"uppercase tags", `<B>bold</B>`, `<b>bold</b>`},
+		{"less than not a tag", `1 < 2 and 3<4`, `1 &lt; 2 and 3&lt;4`},
+	}
+
+	for _, test := range tests {
+		if sanitized := sanitizeHTML(test.input); sanitized != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.name, test.expected, sanitized)
+		}
+	}
+}
+
+func TestMarkdownToHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"bold and inline code", "**bold** and `code`", "<b>bold</b> and <code>code</code>"},
+		{"'<' and '&' in code block", "```go\nif a < b && c {}\n```", `<pre><code class="language-go">if a &lt; b &amp;&amp; c {}</code></pre>`},
+		{"'<' and '&' in inline code", "use `a<b && c` here", "use <code>a&lt;b &amp;&amp; c</code> here"},
+		{"'<' and '&' in text", "x < y & z", "x &lt; y &amp; z"},
+		{"code block without language", "```\n<html>\n```", "<pre>&lt;html&gt;</pre>"},
+		{"nested fences", "````md\n```go\nx := 1\n```\n````", "<pre><code class=\"language-md\">```go\nx := 1\n```</code></pre>"},
+		{"consecutive code blocks", "```\na\n```\ntext\n```\nb\n```", "<pre>a</pre>\ntext\n<pre>b</pre>"},
+		{"unclosed fence", "```go\nx := 1", "```go\nx := 1"},
+		{"markdown in code block", "```\n**not bold**\n```", "<pre>**not bold**</pre>"},
+		{"link with quotes", `[a "b"](https://example.com/?q="c")`, `[a &#34;b&#34;](https://example.com/?q=&#34;c&#34;)`},
+	}
+
+	for _, test := range tests {
+		if converted := markdownToHTML(test.input); converted != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.name, test.expected, converted)
+		}
+	}
+}
+
+func TestHTMLToPlainText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"tags stripped", `<b>bold</b> <i>italic</i>`, `bold italic`},
+		{"entities unescaped", `a &lt; b &amp;&amp; c &gt; d`, `a < b && c > d`},
+		{"code block", `<pre><code class="language-go">if a &lt; b {}</code></pre>`, `if a < b {}`},
+		{"link with quotes", `<a href="https://example.com/?q=&quot;x&quot;">link</a> &quot;quoted&quot;`, `link "quoted"`},
+		{"unclosed tags", `<b>bold <i>and italic`, `bold and italic`},
+		{"less than not a tag", `i <3 you`, `i <3 you`},
+	}
+
+	for _, test := range tests {
+		if plain := htmlToPlainText(test.input); plain != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.name, test.expected, plain)
+		}
+	}
+}
+
+func TestSplitHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		maxLen   int
+		expected []string
+	}{
+		{"short text", `<b>short</b>`, 100, []string{`<b>short</b>`}},
+		{"split inside a tag", `<b>aaaaaaaaaa</b>`, 12, []string{`<b>aaaaa</b>`, `<b>aaaaa</b>`}},
+		{"split inside nested tags", `<b><i>aaaaaa</i></b>bb`, 17, []string{`<b><i>aaa</i></b>`, `<b><i>aaa</i></b>`, `bb`}},
+		{"split inside a link", `<a href="https://x.y">aaaaaaaaaa</a>`, 30, []string{`<a href="https://x.y">aaaa</a>`, `<a href="https://x.y">aaaa</a>`, `<a href="https://x.y">aa</a>`}},
+		{"entities not split", `aaa&amp;bbb`, 5, []string{`aaa`, `&amp;`, `bbb`}},
+		{"multi-byte characters not split", `가나다라`, 7, []string{`가나`, `다라`}},
+	}
+
+	for _, test := range tests {
+		chunks := splitHTML(test.input, test.maxLen)
+		if strings.Join(chunks, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, chunks)
+		}
+	}
+
+	// over-long message: chunks should be balanced, within the limit, and have the same text when joined
+	long := sanitizeHTML(strings.Repeat("<b>bold &amp; <i>italic</i></b> <code>a < b</code>\n", 500))
+	chunks := splitHTML(long, htmlMessageMaxLen)
+	if len(chunks) < 2 {
+		t.Errorf("over-long message: expected several chunks, got %d", len(chunks))
+	}
+	var joined strings.Builder
+	for _, chunk := range chunks {
+		if len(chunk) > htmlMessageMaxLen {
+			t.Errorf("over-long message: chunk is too long: %d", len(chunk))
+		}
+		if sanitizeHTML(chunk) != chunk {
+			t.Errorf("over-long message: chunk is not balanced: %s", chunk)
+		}
+		joined.WriteString(htmlToPlainText(chunk))
+	}
+	if joined.String() != htmlToPlainText(long) {
+		t.Errorf("over-long message: texts of chunks are different from the original one")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+// saved.go
+//
+// named prompts saved by users, for re-running them quickly
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdSave  = "/save"
+	cmdUse   = "/use"
+	cmdSaved = "/saved"
+
+	savedPromptInputPlaceholder = "{{input}}"
+	savedPromptPreviewRunes     = 50
+
+	msgSaveUsage        = "Reply to a message with /save [name] to save it as a prompt (name: up to 32 letters, numbers, '_', or '-')."
+	msgSaveEmpty        = "Failed to read the replied message."
+	msgSaved            = "Saved the prompt as <b>%s</b>. Run it with <code>/use %s [input]</code>."
+	msgUseUsage         = "Usage: /use [name] [input]\n\n(see your saved prompts with /saved)"
+	msgUseNotFound      = "No saved prompt named <b>%s</b>. See your saved prompts with /saved."
+	msgSavedPromptsNone = "You have no saved prompts. Reply to a message with /save [name] to save one."
+	msgSavedPrompts     = "Your saved prompts:\n\n%s\n\n(run them with <code>/use [name] [input]</code>)"
+)
+
+var savedPromptNameRegex = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+
+// return a /save command handler
+func saveCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("save command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		name := strings.ToLower(strings.TrimSpace(args))
+		replyTo := repliedToMessage(*message)
+		if replyTo == nil || !savedPromptNameRegex.MatchString(name) {
+			send(b, conf, msgSaveUsage, chatID, &messageID)
+			return
+		}
+
+		var text string
+		if replyTo.HasText() {
+			text = *replyTo.Text
+		} else if chatMessage := convertMessage(b, *replyTo); chatMessage != nil {
+			text, _ = chatMessage.ContentString()
+		}
+		if strings.TrimSpace(text) == "" {
+			send(b, conf, msgSaveEmpty, chatID, &messageID)
+			return
+		}
+
+		var msg string
+		if err := db.SaveUserPrompt(message.From.ID, name, strings.TrimSpace(text)); err != nil {
+			log.Printf("failed to save prompt: %s", err)
+
+			msg = err.Error()
+		} else {
+			msg = fmt.Sprintf(msgSaved, html.EscapeString(name), html.EscapeString(name))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
+
+// return a /use command handler
+func useCommandHandler(client *openai.Client, db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("use command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		if isRefusingAPIRequest(b, conf, update, *message) {
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		name, input, _ := strings.Cut(strings.TrimSpace(args), " ")
+		name = strings.ToLower(name)
+		if name == "" {
+			send(b, conf, msgUseUsage, chatID, &messageID)
+			return
+		}
+
+		saved, err := db.UserPrompt(message.From.ID, name)
+		if err != nil {
+			send(b, conf, fmt.Sprintf(msgUseNotFound, html.EscapeString(name)), chatID, &messageID)
+			return
+		}
+
+		// saved prompts can have directives too (eg. `!fast translate this: {{input}}`)
+		directives, text := parseDirectives(conf, withSavedPromptInput(saved, strings.TrimSpace(input)))
+		if text == "" {
+			send(b, conf, msgDirectivesOnly, chatID, &messageID)
+			return
+		}
+		model := chatModel(conf, db, chatID)
+		if directives.Model != "" {
+			model = directives.Model
+		}
+
+		thread := threadFor(conf, db, chatID, nil)
+		queueAnswer(b, conf, chatID, messageID, func() {
+			messages := condenseLargeMessages(b, client, conf, db, model, directives, []openai.ChatMessage{
+				openai.NewChatUserMessage(text),
+			}, chatID, message.From.ID, userNameFromUpdate(update), messageID)
+
+			answer(b, client, conf, db, model, directives, messages, chatID, message.From.ID, userNameFromUpdate(update), messageID, thread)
+		})
+	}
+}
+
+// fill given input into a saved prompt
+//
+// (replaces `{{input}}` in the prompt, or appends the input to it if there is no placeholder)
+func withSavedPromptInput(prompt, input string) string {
+	if strings.Contains(prompt, savedPromptInputPlaceholder) {
+		return strings.ReplaceAll(prompt, savedPromptInputPlaceholder, input)
+	}
+
+	if input == "" {
+		return prompt
+	}
+	return prompt + "\n\n" + input
+}
+
+// return a /saved command handler
+func savedCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, _ string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("saved command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		if names, err := db.UserPromptNames(message.From.ID); err == nil {
+			if len(names) > 0 {
+				lines := []string{}
+				for _, name := range names {
+					var preview string
+					if text, err := db.UserPrompt(message.From.ID, name); err == nil {
+						preview = ellipsize(strings.Join(strings.Fields(text), " "), savedPromptPreviewRunes)
+					}
+					lines = append(lines, fmt.Sprintf("• <b>%s</b>: %s", html.EscapeString(name), html.EscapeString(preview)))
+				}
+				msg = fmt.Sprintf(msgSavedPrompts, strings.Join(lines, "\n"))
+			} else {
+				msg = msgSavedPromptsNone
+			}
+		} else {
+			log.Printf("failed to list saved prompts: %s", err)
+
+			msg = err.Error()
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
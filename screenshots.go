@@ -0,0 +1,172 @@
+package main
+
+// screenshots.go
+//
+// preprocessing screenshots (cropping around texts and upscaling them) for vision models
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // (photos from telegram are jpegs)
+	"image/png"
+)
+
+const (
+	screenshotFlatColorRatio = 0.25 // screenshots have large areas of exactly the same color (eg. backgrounds of dialogs)
+
+	screenshotCellSize         = 16  // size of cells for finding text regions
+	screenshotEdgeThreshold    = 48  // min difference of luminances between neighboring pixels for an edge
+	screenshotCellEdgeRatio    = 0.1 // min ratio of edge pixels in a cell for a text cell
+	screenshotCropMarginCells  = 2   // margin around text regions, in cells
+	screenshotCropMaxAreaRatio = 0.8 // not cropped when text regions cover more than this ratio of the image
+
+	screenshotTargetWidth = 1536 // upscale until this width
+	screenshotMaxScale    = 3
+
+	msgScreenshotHint = "(The second image is the text region of the first one, cropped and upscaled for reading small texts.)"
+)
+
+// preprocess given photo if it looks like a screenshot, for reading its texts more accurately
+//
+// (returns a png image of the text regions cropped and upscaled, or false if not a screenshot or nothing to improve)
+func preprocessScreenshot(data []byte) (processed []byte, ok bool, err error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode photo: %w", err)
+	}
+
+	if !looksLikeScreenshot(img) {
+		return nil, false, nil
+	}
+
+	bounds := img.Bounds()
+	crop := textRegion(img)
+	scale := min(float64(screenshotTargetWidth)/float64(crop.Dx()), screenshotMaxScale)
+
+	// nothing to improve
+	if crop == bounds && scale <= 1 {
+		return nil, false, nil
+	}
+	if scale < 1 {
+		scale = 1
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, upscale(img, crop, scale)); err != nil {
+		return nil, false, fmt.Errorf("failed to encode screenshot: %w", err)
+	}
+
+	return buf.Bytes(), true, nil
+}
+
+// get the luminance (0-255) of a pixel of given image
+func luminance(img image.Image, x, y int) int {
+	return int(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+}
+
+// checks if given image looks like a screenshot: much of it is filled with the same color
+//
+// (colors are quantized, as photos are lossy-compressed)
+func looksLikeScreenshot(img image.Image) bool {
+	bounds := img.Bounds()
+	if bounds.Dx() <= 0 || bounds.Dy() <= 0 {
+		return false
+	}
+
+	counts := map[uint32]int{}
+	total, maxCount := 0, 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 2 {
+			r, g, b, _ := img.At(x, y).RGBA()
+			key := (r>>12)<<8 | (g>>12)<<4 | (b >> 12)
+			counts[key]++
+			maxCount = max(maxCount, counts[key])
+			total++
+		}
+	}
+
+	return float64(maxCount)/float64(total) >= screenshotFlatColorRatio
+}
+
+// get the region of given image which contains texts (cells dense with edges), with margins
+//
+// (the whole image if no text was found, or texts are spread over most of it)
+func textRegion(img image.Image) image.Rectangle {
+	bounds := img.Bounds()
+	region := image.Rectangle{}
+
+	for cy := bounds.Min.Y; cy < bounds.Max.Y; cy += screenshotCellSize {
+		for cx := bounds.Min.X; cx < bounds.Max.X; cx += screenshotCellSize {
+			cell := image.Rect(cx, cy, cx+screenshotCellSize, cy+screenshotCellSize).Intersect(bounds)
+
+			edges, pixels := 0, 0
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X-1; x++ {
+					diff := luminance(img, x, y) - luminance(img, x+1, y)
+					if diff >= screenshotEdgeThreshold || -diff >= screenshotEdgeThreshold {
+						edges++
+					}
+					pixels++
+				}
+			}
+
+			if pixels > 0 && float64(edges)/float64(pixels) >= screenshotCellEdgeRatio {
+				region = region.Union(cell)
+			}
+		}
+	}
+
+	if region.Empty() {
+		return bounds
+	}
+
+	margin := screenshotCropMarginCells * screenshotCellSize
+	region = image.Rect(region.Min.X-margin, region.Min.Y-margin, region.Max.X+margin, region.Max.Y+margin).Intersect(bounds)
+	if float64(region.Dx()*region.Dy()) > float64(bounds.Dx()*bounds.Dy())*screenshotCropMaxAreaRatio {
+		return bounds
+	}
+
+	return region
+}
+
+// crop given region of an image and upscale it by given scale, with bilinear interpolation
+func upscale(img image.Image, region image.Rectangle, scale float64) *image.RGBA {
+	width, height := int(float64(region.Dx())*scale), int(float64(region.Dy())*scale)
+	upscaled := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		sy := min(float64(y)/scale, float64(region.Dy()-1))
+		y0 := int(sy)
+		y1 := min(y0+1, region.Dy()-1)
+		fy := sy - float64(y0)
+
+		for x := 0; x < width; x++ {
+			sx := min(float64(x)/scale, float64(region.Dx()-1))
+			x0 := int(sx)
+			x1 := min(x0+1, region.Dx()-1)
+			fx := sx - float64(x0)
+
+			c00 := color.RGBAModel.Convert(img.At(region.Min.X+x0, region.Min.Y+y0)).(color.RGBA)
+			c10 := color.RGBAModel.Convert(img.At(region.Min.X+x1, region.Min.Y+y0)).(color.RGBA)
+			c01 := color.RGBAModel.Convert(img.At(region.Min.X+x0, region.Min.Y+y1)).(color.RGBA)
+			c11 := color.RGBAModel.Convert(img.At(region.Min.X+x1, region.Min.Y+y1)).(color.RGBA)
+
+			lerp := func(a, b, c, d uint8) uint8 {
+				top := float64(a)*(1-fx) + float64(b)*fx
+				bottom := float64(c)*(1-fx) + float64(d)*fx
+				return uint8(top*(1-fy) + bottom*fy + 0.5)
+			}
+
+			upscaled.SetRGBA(x, y, color.RGBA{
+				R: lerp(c00.R, c10.R, c01.R, c11.R),
+				G: lerp(c00.G, c10.G, c01.G, c11.G),
+				B: lerp(c00.B, c10.B, c01.B, c11.B),
+				A: lerp(c00.A, c10.A, c01.A, c11.A),
+			})
+		}
+	}
+
+	return upscaled
+}
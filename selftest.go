@@ -0,0 +1,275 @@
+package main
+
+// selftest.go
+//
+// self-testing configured integrations, for verifying deployments before switching traffic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	selfTestTimeout     = 10 * time.Second
+	selfTestSettingKey  = "selftest"
+	selfTestPrompt      = "Reply with OK."
+	selfTestEmbedText   = "self-test"
+	selfTestArchiveFile = ".selftest"
+)
+
+// selfTestResult struct for the result of a self-test
+type selfTestResult struct {
+	name    string
+	detail  string // eg. username of the bot, on success
+	skipped bool
+	err     error
+	elapsed time.Duration
+}
+
+// selfTest struct for a self-test of an integration
+type selfTest struct {
+	name string
+	run  func() (detail string, err error) // (returns `errSelfTestSkipped` if it is not configured)
+}
+
+// error for self-tests of integrations which are not configured
+var errSelfTestSkipped = errors.New("not configured")
+
+// run self-tests of all configured integrations, and return their results
+func runSelfTests(conf config) (results []selfTestResult) {
+	if err := setupTLSConfig(conf); err != nil {
+		return []selfTestResult{{name: "tls", err: err}}
+	}
+
+	bot := tg.NewClient(conf.TelegramBotToken)
+	client := openai.NewClient(conf.OpenAIAPIKey, conf.OpenAIOrganizationID)
+
+	var db Storage
+	tests := []selfTest{
+		{"telegram", func() (string, error) {
+			res := bot.GetMe()
+			if !res.Ok {
+				return "", errors.New(*res.Description)
+			}
+			return userName(res.Result), nil
+		}},
+		{"openai", func() (string, error) {
+			model := chatCompletionModel(conf)
+			response, err := archivedChatCompletion(client, model, []openai.ChatMessage{
+				openai.NewChatUserMessage(selfTestPrompt),
+			}, openai.ChatCompletionOptions{}.SetMaxTokens(5))
+			if err != nil {
+				return "", err
+			}
+			if len(response.Choices) <= 0 {
+				return "", fmt.Errorf("no choice in response")
+			}
+			return fmt.Sprintf("%s, %d tokens", model, response.Usage.TotalTokens), nil
+		}},
+		{"database", func() (string, error) {
+			if conf.RequestLogsDBFilepath == "" {
+				return "", errSelfTestSkipped
+			}
+
+			var err error
+			if db, err = OpenStorage(conf.DBDriver, conf.RequestLogsDBFilepath); err != nil {
+				return "", err
+			}
+
+			// write and read back a setting
+			written := strconv.FormatInt(time.Now().UnixNano(), 10)
+			if err = db.SetSetting(selfTestSettingKey, written); err != nil {
+				return "", fmt.Errorf("write failed: %w", err)
+			}
+			var read string
+			if read, err = db.GetSetting(selfTestSettingKey); err != nil {
+				return "", fmt.Errorf("read failed: %w", err)
+			}
+			if read != written {
+				return "", fmt.Errorf("read '%s' instead of written '%s'", read, written)
+			}
+			return conf.RequestLogsDBFilepath, nil
+		}},
+		{"embeddings", func() (string, error) {
+			if conf.Embeddings == nil {
+				return "", errSelfTestSkipped
+			}
+
+			vector, err := embed(client, *conf.Embeddings, selfTestEmbedText)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s, %d dimensions", embeddingModel(*conf.Embeddings), len(vector)), nil
+		}},
+		{"pricing", func() (string, error) {
+			if conf.PricingURL == "" {
+				return "", errSelfTestSkipped
+			}
+
+			prices, err := fetchModelPrices(conf.PricingURL)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d model(s)", len(prices)), nil
+		}},
+		{"completion webhook", func() (string, error) {
+			if conf.CompletionWebhookURL == "" {
+				return "", errSelfTestSkipped
+			}
+
+			// (only checks reachability, not to post a fake answer)
+			return checkReachable(conf.CompletionWebhookURL)
+		}},
+		{"notion", func() (string, error) {
+			if conf.Notion == nil {
+				return "", errSelfTestSkipped
+			}
+
+			if err := requestNotion(*conf.Notion, http.MethodGet, "/v1/databases/"+conf.Notion.DatabaseID, nil, nil); err != nil {
+				return "", err
+			}
+			return conf.Notion.DatabaseID, nil
+		}},
+		{"telegraph", func() (string, error) {
+			if conf.Telegraph == nil {
+				return "", errSelfTestSkipped
+			}
+
+			accessToken := conf.Telegraph.AccessToken
+			if accessToken == "" && db != nil {
+				accessToken, _ = db.GetSetting(settingKeyTelegraphAccessToken)
+			}
+			if accessToken == "" {
+				// (not to create an account here)
+				return checkReachable(telegraphAPIBaseURL + "/getAccountInfo")
+			}
+
+			var account struct {
+				ShortName string `json:"short_name"`
+			}
+			if err := requestTelegraph("getAccountInfo", url.Values{
+				"access_token": {accessToken},
+			}, &account); err != nil {
+				return "", err
+			}
+			return account.ShortName, nil
+		}},
+		{"image archive", func() (string, error) {
+			if conf.ImageArchive == nil {
+				return "", errSelfTestSkipped
+			}
+			if s3 := conf.ImageArchive.S3; s3 != nil {
+				region := s3.Region
+				if region == "" {
+					region = s3RegionDefault
+				}
+				endpoint := s3.Endpoint
+				if endpoint == "" {
+					endpoint = fmt.Sprintf(s3EndpointFormatDefault, region)
+				}
+
+				// (only checks reachability, not to upload a fake image)
+				return checkReachable(endpoint)
+			}
+
+			// write and remove a file
+			if err := os.MkdirAll(conf.ImageArchive.Directory, 0755); err != nil {
+				return "", err
+			}
+			fpath := filepath.Join(conf.ImageArchive.Directory, selfTestArchiveFile)
+			if err := os.WriteFile(fpath, []byte(selfTestEmbedText), 0644); err != nil {
+				return "", err
+			}
+			if err := os.Remove(fpath); err != nil {
+				return "", err
+			}
+			return conf.ImageArchive.Directory, nil
+		}},
+		{"smtp", func() (string, error) {
+			if conf.SMTP == nil {
+				return "", errSelfTestSkipped
+			}
+
+			address := net.JoinHostPort(conf.SMTP.Host, strconv.Itoa(conf.SMTP.Port))
+			conn, err := net.DialTimeout("tcp", address, selfTestTimeout)
+			if err != nil {
+				return "", err
+			}
+			_ = conn.Close()
+			return address, nil
+		}},
+	}
+
+	for _, test := range tests {
+		started := time.Now()
+		detail, err := test.run()
+
+		result := selfTestResult{
+			name:    test.name,
+			detail:  detail,
+			elapsed: time.Since(started).Round(time.Millisecond),
+		}
+		if errors.Is(err, errSelfTestSkipped) {
+			result.skipped = true
+		} else {
+			result.err = err
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// check if given url is reachable (any response without server errors)
+func checkReachable(target string) (detail string, err error) {
+	var resp *http.Response
+	if resp, err = newHTTPClient(selfTestTimeout).Head(target); err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return "", fmt.Errorf("http %d", resp.StatusCode)
+	}
+	return fmt.Sprintf("reachable (http %d)", resp.StatusCode), nil
+}
+
+// format given self-test result as a line of the report
+func formatSelfTestResult(result selfTestResult) string {
+	switch {
+	case result.skipped:
+		return fmt.Sprintf("[SKIP] %s: %s", result.name, errSelfTestSkipped)
+	case result.err != nil:
+		return fmt.Sprintf("[FAIL] %s (%s): %s", result.name, result.elapsed, result.err)
+	default:
+		return fmt.Sprintf("[PASS] %s (%s): %s", result.name, result.elapsed, result.detail)
+	}
+}
+
+// run `selftest` subcommand, printing a report and exiting with 1 if any of the tests failed
+func runSelfTestCommand(conf config) {
+	failed := 0
+	for _, result := range runSelfTests(conf) {
+		fmt.Println(formatSelfTestResult(result))
+
+		if result.err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d test(s) failed.\n", failed)
+		os.Exit(1)
+	}
+	fmt.Printf("\nAll tests passed.\n")
+}
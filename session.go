@@ -0,0 +1,139 @@
+package main
+
+// session.go
+//
+// sessions of chats: the latest turns of the current conversation attached to messages which are not replies,
+// reset with /new or after `context_ttl_minutes` of inactivity
+
+import (
+	"log"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdNew = "/new"
+
+	msgNewSession       = "Started a new conversation. Previous prompts and answers will not be attached to the next messages."
+	msgNewSessionFailed = "Failed to start a new conversation. See the server logs for more information."
+	msgContextExpired   = "It's been a while, so this is a new conversation. (reply to an earlier answer to continue from it)"
+)
+
+// get the max number of the latest turns attached to messages which are not replies in given chat (0 for none)
+//
+// (also limited by the history depth of the chat)
+func sessionTurns(conf config, db Storage, chatID int64) int {
+	if conf.SessionTurns <= 0 {
+		return 0
+	}
+
+	return min(conf.SessionTurns, historyDepth(conf, db, chatID))
+}
+
+// build chat messages of the latest turns of given conversation, in chronological order
+//
+// (also returns the telegram message id of the latest answer, and whether the conversation has no turns yet)
+func sessionHistory(conf config, db Storage, chatID int64, conversationID uint) (history []openai.ChatMessage, latestAnswerMessageID int64, empty bool) {
+	prompts, err := db.ConversationPrompts(conversationID)
+	if err != nil {
+		log.Printf("failed to retrieve prompts of conversation %d: %s", conversationID, err)
+		return nil, 0, false
+	}
+	if len(prompts) == 0 {
+		return nil, 0, true
+	}
+
+	turns := sessionTurns(conf, db, chatID)
+	for i := len(prompts) - 1; i >= 0 && turns > 0; i-- {
+		prompt := prompts[i]
+		if !prompt.Result.Successful || prompt.Result.Text == "" {
+			continue
+		}
+
+		if latestAnswerMessageID == 0 {
+			latestAnswerMessageID = prompt.Result.MessageID
+		}
+		history = append([]openai.ChatMessage{
+			openai.NewChatUserMessage(questionOf(prompt)),
+			openai.NewChatAssistantMessage(prompt.Result.Text),
+		}, history...)
+		turns--
+	}
+
+	return history, latestAnswerMessageID, false
+}
+
+// checks if the context of given chat has expired after `context_ttl_minutes` of inactivity
+//
+// (inactive since its latest prompt, or the beginning of its latest conversation)
+func isContextExpired(conf config, db Storage, chatID int64) bool {
+	if conf.ContextTTLMinutes <= 0 {
+		return false
+	}
+
+	var lastActive time.Time
+	if prompts, err := db.RecentPrompts(chatID, 1); err == nil && len(prompts) > 0 {
+		lastActive = prompts[len(prompts)-1].CreatedAt
+	}
+	if conversation, err := db.LatestConversation(chatID); err == nil && conversation.CreatedAt.After(lastActive) {
+		lastActive = conversation.CreatedAt
+	}
+	if lastActive.IsZero() {
+		return false
+	}
+
+	return time.Since(lastActive) > time.Duration(conf.ContextTTLMinutes)*time.Minute
+}
+
+// get the history of the session which the next message (not a reply) will belong to,
+// without creating any conversation (nil if sessions are not enabled, or expired)
+func currentSessionHistory(conf config, db Storage, chatID int64) []openai.ChatMessage {
+	if db == nil || sessionTurns(conf, db, chatID) <= 0 || isContextExpired(conf, db, chatID) {
+		return nil
+	}
+
+	conversation, err := db.LatestConversation(chatID)
+	if err != nil {
+		return nil
+	}
+	history, _, _ := sessionHistory(conf, db, chatID, conversation.ID)
+
+	return history
+}
+
+// return a /new command handler
+func newCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, _ string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("new command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		msg := msgNewSession
+		if _, err := db.NewConversation(chatID, nil); err != nil {
+			log.Printf("failed to start a new conversation: %s", err)
+
+			msg = msgNewSessionFailed
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
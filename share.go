@@ -0,0 +1,259 @@
+package main
+
+// share.go
+//
+// sharing conversations as read-only pages on Telegraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdShare = "/share"
+
+	telegraphAPIBaseURL = "https://api.telegra.ph"
+
+	telegraphAuthorNameDefault = "telegram-chatgpt-bot"
+	telegraphShortName         = "chatgpt-bot"
+	telegraphTitleMaxLen       = 256
+	telegraphContentMaxBytes   = 60 * 1024 // telegraph's limit: 64KB
+
+	settingKeyTelegraphAccessToken = "telegraph_access_token"
+
+	msgShareNotConfigured = "Sharing is not configured."
+	msgShareEmpty         = "No conversation to share in this chat."
+	msgShareFailed        = "Failed to share the conversation. See the server logs for more information."
+	msgShared             = "Shared %d prompts of the conversation (read-only):\n\n%s"
+	msgShareTruncated     = "(The rest of the conversation is omitted, for it is too long.)"
+)
+
+// telegraphConfig struct for sharing conversations on Telegraph
+type telegraphConfig struct {
+	AccessToken string `json:"access_token,omitempty"` // (an account will be created and saved in the database if not given)
+	AuthorName  string `json:"author_name,omitempty"`  // default: "telegram-chatgpt-bot"
+}
+
+// telegraphNode struct for content nodes of Telegraph pages
+type telegraphNode struct {
+	Tag      string `json:"tag"`
+	Children []any  `json:"children,omitempty"` // strings or nodes
+}
+
+// return a /share command handler
+func shareCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("share command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+		if conf.Telegraph == nil {
+			send(b, conf, msgShareNotConfigured, chatID, &messageID)
+			return
+		}
+
+		conversation, err := db.LatestConversation(chatID)
+		if err != nil {
+			send(b, conf, msgShareEmpty, chatID, &messageID)
+			return
+		}
+		prompts, err := db.ConversationPrompts(conversation.ID)
+		if err != nil || len(prompts) <= 0 {
+			send(b, conf, msgShareEmpty, chatID, &messageID)
+			return
+		}
+
+		title := conversation.Title
+		if title == "" {
+			title = ellipsize(questionOf(prompts[0]), 50)
+		}
+		title = fmt.Sprintf("%s - %s", title, conversation.CreatedAt.Format(time.DateOnly))
+
+		sendChatAction(b, chatID, responseText)
+
+		pageURL, err := shareOnTelegraph(*conf.Telegraph, db, title, prompts)
+		if err != nil {
+			log.Printf("failed to share conversation on telegraph: %s", err)
+
+			send(b, conf, msgShareFailed, chatID, &messageID)
+			return
+		}
+
+		logInfo("shared conversation %d of chat %d: %s", conversation.ID, chatID, pageURL)
+
+		send(b, conf, fmt.Sprintf(msgShared, len(prompts), pageURL), chatID, &messageID)
+	}
+}
+
+// publish given prompts (and their answers) as a new Telegraph page, and return its url
+//
+// (usernames are not included, so pages can be shared with people outside the chat)
+func shareOnTelegraph(conf telegraphConfig, db Storage, title string, prompts []Prompt) (pageURL string, err error) {
+	var accessToken string
+	if accessToken, err = telegraphAccessToken(conf, db); err != nil {
+		return "", err
+	}
+
+	var content []byte
+	if content, err = json.Marshal(telegraphContent(prompts)); err != nil {
+		return "", err
+	}
+
+	var page struct {
+		URL string `json:"url"`
+	}
+	if err = requestTelegraph("createPage", url.Values{
+		"access_token": {accessToken},
+		"title":        {ellipsize(title, telegraphTitleMaxLen-3)},
+		"author_name":  {telegraphAuthorName(conf)},
+		"content":      {string(content)},
+	}, &page); err != nil {
+		return "", err
+	}
+
+	return page.URL, nil
+}
+
+// get the access token of Telegraph from config, or from the database
+//
+// (a new account is created and saved if there is none)
+func telegraphAccessToken(conf telegraphConfig, db Storage) (accessToken string, err error) {
+	if conf.AccessToken != "" {
+		return conf.AccessToken, nil
+	}
+
+	if accessToken, err = db.GetSetting(settingKeyTelegraphAccessToken); err == nil && accessToken != "" {
+		return accessToken, nil
+	}
+
+	var account struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = requestTelegraph("createAccount", url.Values{
+		"short_name":  {telegraphShortName},
+		"author_name": {telegraphAuthorName(conf)},
+	}, &account); err != nil {
+		return "", fmt.Errorf("failed to create telegraph account: %w", err)
+	}
+
+	if err = db.SetSetting(settingKeyTelegraphAccessToken, account.AccessToken); err != nil {
+		log.Printf("failed to save telegraph access token: %s", err)
+	}
+
+	return account.AccessToken, nil
+}
+
+// get the author name of Telegraph pages from config, or the default one
+func telegraphAuthorName(conf telegraphConfig) string {
+	if conf.AuthorName != "" {
+		return conf.AuthorName
+	}
+
+	return telegraphAuthorNameDefault
+}
+
+// convert given prompts (and their answers) to Telegraph content nodes
+//
+// (truncated if the content is too large for a page)
+func telegraphContent(prompts []Prompt) (nodes []any) {
+	size := 0
+	for _, prompt := range prompts {
+		turn := []any{
+			telegraphNode{Tag: "h4", Children: []any{"🙋"}},
+		}
+		turn = append(turn, telegraphParagraphs(questionOf(prompt))...)
+		turn = append(turn, telegraphNode{Tag: "h4", Children: []any{"🤖"}})
+		turn = append(turn, telegraphParagraphs(prompt.Result.Text)...)
+
+		if bytes, err := json.Marshal(turn); err == nil {
+			if size+len(bytes) > telegraphContentMaxBytes {
+				nodes = append(nodes, telegraphNode{Tag: "p", Children: []any{
+					telegraphNode{Tag: "i", Children: []any{msgShareTruncated}},
+				}})
+				break
+			}
+			size += len(bytes)
+		}
+
+		nodes = append(nodes, turn...)
+	}
+
+	return nodes
+}
+
+// convert given (markdown) text to Telegraph paragraphs, with fenced code blocks as preformatted ones
+func telegraphParagraphs(text string) (nodes []any) {
+	appendParagraphs := func(text string) {
+		for _, paragraph := range strings.Split(text, "\n\n") {
+			if paragraph = strings.Trim(paragraph, "\n"); paragraph != "" {
+				nodes = append(nodes, telegraphNode{Tag: "p", Children: []any{paragraph}})
+			}
+		}
+	}
+
+	last := 0
+	for _, match := range findFencedCodeBlocks(text) {
+		appendParagraphs(text[last:match[0]])
+
+		nodes = append(nodes, telegraphNode{Tag: "pre", Children: []any{strings.TrimRight(text[match[4]:match[5]], "\n")}})
+
+		last = match[1]
+	}
+	appendParagraphs(text[last:])
+
+	return nodes
+}
+
+// send a request to Telegraph API, will timeout in 30 seconds
+func requestTelegraph(method string, params url.Values, result any) (err error) {
+	httpClient := newHTTPClient(time.Second * 30)
+
+	var resp *http.Response
+	if resp, err = httpClient.PostForm(telegraphAPIBaseURL+"/"+method, params); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var data []byte
+	if data, err = io.ReadAll(resp.Body); err != nil {
+		return err
+	}
+
+	var res struct {
+		OK     bool            `json:"ok"`
+		Error  string          `json:"error,omitempty"`
+		Result json.RawMessage `json:"result,omitempty"`
+	}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return fmt.Errorf("telegraph api error (http %d): %s", resp.StatusCode, string(data))
+	}
+	if !res.OK {
+		return fmt.Errorf("telegraph api error: %s", res.Error)
+	}
+
+	return json.Unmarshal(res.Result, result)
+}
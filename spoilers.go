@@ -0,0 +1,45 @@
+package main
+
+// spoilers.go
+//
+// hiding answers about sensitive content in spoilers
+
+import (
+	"log"
+	"unicode/utf16"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// checks if given answer is about sensitive content, with the moderation api
+//
+// (always false if `spoiler_sensitive_answers` is not enabled)
+func isSensitive(client *openai.Client, conf config, text string) bool {
+	if !conf.SpoilerSensitiveAnswers || text == "" {
+		return false
+	}
+
+	moderation, err := client.CreateModeration(text, nil)
+	if err != nil {
+		log.Printf("failed to moderate answer: %s", err)
+		return false
+	}
+
+	for _, result := range moderation.Results {
+		if result.Flagged {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generate message entities for hiding the whole text in a spoiler
+func spoilerEntities(text string) []tg.MessageEntity {
+	return []tg.MessageEntity{{
+		Type:   tg.MessageEntityTypeSpoiler,
+		Offset: 0,
+		Length: len(utf16.Encode([]rune(text))), // (offsets and lengths are in UTF-16 code units)
+	}}
+}
@@ -0,0 +1,103 @@
+package main
+
+// stickers.go
+//
+// converting stickers into textual descriptions
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	stickerVisionModelDefault = "gpt-4o-mini"
+
+	maxStickerBytes = 1024 * 1024 // 1MB
+
+	systemPromptDescribeSticker = "Describe the given sticker in one short sentence, focusing on the emotion or reaction which it expresses."
+)
+
+// convert given sticker into a textual description, eg. `[Sticker 😂 from "Cute Cats": a cat rolling on the floor laughing]`
+//
+// (with `describe_stickers`, the image of the sticker is described with a vision model)
+func describeSticker(bot *tg.Bot, client *openai.Client, conf config, sticker tg.Sticker) string {
+	var sb strings.Builder
+	sb.WriteString("[Sticker")
+	if sticker.Emoji != nil {
+		sb.WriteString(" " + *sticker.Emoji)
+	}
+	if title := stickerSetTitle(bot, sticker); title != "" {
+		sb.WriteString(fmt.Sprintf(" from %q", title))
+	}
+	if conf.DescribeStickers {
+		if description, err := describeStickerImage(bot, client, sticker); err == nil {
+			sb.WriteString(": " + description)
+		} else {
+			log.Printf("failed to describe sticker image: %s", err)
+		}
+	}
+	sb.WriteString("]")
+
+	return sb.String()
+}
+
+// get the title of the set which given sticker belongs to (or its name, if the set could not be fetched)
+func stickerSetTitle(bot *tg.Bot, sticker tg.Sticker) string {
+	if sticker.SetName == nil {
+		return ""
+	}
+
+	if res := bot.GetStickerSet(*sticker.SetName); res.Ok {
+		return res.Result.Title
+	} else {
+		log.Printf("failed to get sticker set '%s': %s", *sticker.SetName, *res.Description)
+	}
+
+	return *sticker.SetName
+}
+
+// describe the image of given sticker with a vision model
+//
+// (for animated or video stickers, their thumbnails are used)
+func describeStickerImage(bot *tg.Bot, client *openai.Client, sticker tg.Sticker) (description string, err error) {
+	fileID := sticker.FileID
+	if sticker.IsAnimated || sticker.IsVideo {
+		if sticker.Thumbnail == nil {
+			return "", fmt.Errorf("no thumbnail for animated or video sticker")
+		}
+		fileID = sticker.Thumbnail.FileID
+	}
+
+	res := bot.GetFile(fileID)
+	if !res.Ok {
+		return "", fmt.Errorf("failed to get sticker file: %s", *res.Description)
+	}
+
+	var data []byte
+	if data, err = readBinaryContentAtURL(bot.GetFileURL(*res.Result), maxStickerBytes); err != nil {
+		return "", err
+	}
+
+	var response openai.ChatCompletion
+	if response, err = archivedChatCompletion(client, stickerVisionModelDefault, []openai.ChatMessage{
+		openai.NewChatSystemMessage(systemPromptDescribeSticker),
+		openai.NewChatUserMessage([]openai.ChatMessageContent{
+			openai.NewChatMessageContentWithBytes(data),
+		}),
+	}, openai.ChatCompletionOptions{}); err != nil {
+		return "", err
+	}
+	if len(response.Choices) <= 0 {
+		return "", fmt.Errorf("no description of sticker")
+	}
+
+	if description, err = response.Choices[0].Message.ContentString(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(description), nil
+}
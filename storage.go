@@ -0,0 +1,224 @@
+package main
+
+// storage.go
+//
+// storage interface for logging prompts, results, and settings
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// storageDriver type for choosing storage implementations
+type storageDriver string
+
+// storageDriver constants
+const (
+	storageDriverGorm storageDriver = "gorm" // default
+	storageDriverSQL  storageDriver = "sql"  // hand-written statements on database/sql, for high-volume deployments
+)
+
+// Storage interface for logging prompts, results, and settings
+type Storage interface {
+	SavePrompt(prompt Prompt) (err error)
+	SaveAnswerVersion(prompt Prompt) (err error)
+	SaveFeedback(feedback Feedback) (err error)
+	DeleteFeedback(chatID, messageID, userID int64) (err error)
+	SaveReferral(referral Referral) (err error)
+	SaveArchivedImage(image ArchivedImage) (err error)
+	PinAnswer(chatID, messageID int64) (err error)
+
+	RecentPrompts(chatID int64, n int) (prompts []Prompt, err error)
+	AllPrompts() (prompts []Prompt, err error)
+	AllFeedbacks() (feedbacks []Feedback, err error)
+	PromptByAnswerMessageID(chatID, messageID int64) (prompt Prompt, err error)
+	ChatIDs() (chatIDs []int64, err error)
+
+	NewConversation(chatID int64, parentID *uint) (conversation Conversation, err error)
+	LatestConversation(chatID int64) (conversation Conversation, err error)
+	ConversationByID(conversationID uint) (conversation Conversation, err error)
+	SetConversationTitle(conversationID uint, title string) (err error)
+	ConversationPrompts(conversationID uint) (prompts []Prompt, err error)
+
+	GetSetting(key string) (value string, err error)
+	SetSetting(key, value string) (err error)
+
+	GetChatSetting(chatID int64, key string) (value string, err error)
+	SetChatSetting(chatID int64, key, value string) (err error)
+
+	SaveUserPrompt(userID int64, name, text string) (err error)
+	UserPrompt(userID int64, name string) (text string, err error)
+	UserPromptNames(userID int64) (names []string, err error)
+
+	Stats() (stats Stats, err error)
+	TokensSince(since time.Time) (tokens int64, err error)
+
+	Query(ctx context.Context, query string, maxRows int) (result QueryResult, err error)
+}
+
+// Stats struct for usage statistics
+type Stats struct {
+	Since *time.Time // time of the first prompt
+
+	Chats int64
+
+	Prompts      int64
+	PromptTokens int64
+
+	Completions      int64
+	CompletionTokens int64
+
+	Errors int64
+
+	PositiveFeedbacks int64
+	NegativeFeedbacks int64
+}
+
+// QueryResult struct for results of read-only queries
+type QueryResult struct {
+	Columns   []string
+	Rows      [][]string
+	Truncated bool // true if there were more rows than the limit
+}
+
+// run given read-only `query` on `db`, returning at most `maxRows` rows (as strings)
+//
+// (only `select` statements are allowed, and they run with `PRAGMA query_only` on a dedicated connection,
+// in a transaction which is always rolled back. sqlite ignores read-only options of transactions)
+func queryReadOnly(ctx context.Context, db *sql.DB, query string, maxRows int) (result QueryResult, err error) {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if fields := strings.Fields(strings.ToLower(query)); len(fields) == 0 || (fields[0] != "select" && fields[0] != "with") {
+		return result, fmt.Errorf("only select statements are allowed")
+	}
+	if strings.Contains(query, ";") {
+		return result, fmt.Errorf("only a single statement is allowed")
+	}
+
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		return result, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err = conn.ExecContext(ctx, `PRAGMA query_only = ON`); err != nil {
+		return result, fmt.Errorf("failed to make connection read-only: %w", err)
+	}
+	defer func() {
+		// (discard the connection instead of returning it to the pool, if it cannot be writable again)
+		if _, err := conn.ExecContext(context.Background(), `PRAGMA query_only = OFF`); err != nil {
+			log.Printf("failed to make connection writable again, discarding it: %s", err)
+
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	var tx *sql.Tx
+	if tx, err = conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		return result, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var rows *sql.Rows
+	if rows, err = tx.QueryContext(ctx, query); err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	if result.Columns, err = rows.Columns(); err != nil {
+		return result, err
+	}
+
+	values := make([]any, len(result.Columns))
+	pointers := make([]any, len(values))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if len(result.Rows) >= maxRows {
+			result.Truncated = true
+			break
+		}
+
+		if err = rows.Scan(pointers...); err != nil {
+			return result, err
+		}
+
+		row := make([]string, len(values))
+		for i, value := range values {
+			switch v := value.(type) {
+			case nil:
+				row[i] = "NULL"
+			case []byte:
+				row[i] = string(v)
+			case time.Time:
+				row[i] = v.Format(time.RFC3339)
+			default:
+				row[i] = fmt.Sprint(v)
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result, rows.Err()
+}
+
+// OpenStorage opens and returns a storage at given path: `dbPath`, with given `driver`.
+//
+// (returns a nil interface on errors)
+func OpenStorage(driver storageDriver, dbPath string) (storage Storage, err error) {
+	switch driver {
+	case storageDriverGorm, "":
+		var db *Database
+		if db, err = OpenDatabase(dbPath); err == nil {
+			return db, nil
+		}
+	case storageDriverSQL:
+		var db *SQLDatabase
+		if db, err = OpenSQLDatabase(dbPath); err == nil {
+			return db, nil
+		}
+	default:
+		err = fmt.Errorf("not a supported storage driver: %s", driver)
+	}
+
+	return nil, err
+}
+
+// OpenReadOnlyStorage opens and returns a storage at given path: `dbPath` in read-only mode, with given `driver`.
+//
+// (returns a nil interface on errors)
+func OpenReadOnlyStorage(driver storageDriver, dbPath string) (storage Storage, err error) {
+	switch driver {
+	case storageDriverGorm, "":
+		var db *Database
+		if db, err = OpenReadOnlyDatabase(dbPath); err == nil {
+			return db, nil
+		}
+	case storageDriverSQL:
+		var db *SQLDatabase
+		if db, err = OpenReadOnlySQLDatabase(dbPath); err == nil {
+			return db, nil
+		}
+	default:
+		err = fmt.Errorf("not a supported storage driver: %s", driver)
+	}
+
+	return nil, err
+}
+
+// convert given path (or `file:` uri) of a SQLite3 database into a read-only one
+func readOnlyDSN(dbPath string) string {
+	if !strings.HasPrefix(dbPath, "file:") {
+		dbPath = "file:" + dbPath
+	}
+	if strings.Contains(dbPath, "?") {
+		return dbPath + "&mode=ro"
+	}
+
+	return dbPath + "?mode=ro"
+}
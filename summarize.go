@@ -0,0 +1,162 @@
+package main
+
+// summarize.go
+//
+// summarizing logged conversations of a chat since a replied message, for catching up on active groups
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdSummarizeFrom = "/summarize-from"
+
+	summarizeFromMaxPrompts = 200  // max number of logged prompts & answers to summarize
+	summarizeFromMaxRunes   = 4000 // max length of a summary message
+
+	systemPromptSummarizeFrom = `Summarize the following conversation of a group chat for someone who has been away.
+
+Group the summary by topics with concise bullet points, mentioning who asked or said what when it matters, and finish with open questions or action items if there are any. Answer in the language of the conversation.`
+
+	msgSummarizeFromUsage  = "Reply to an older message with /summarize-from for summarizing the conversations in this chat since then."
+	msgSummarizeFromEmpty  = "No logged conversation since the replied message."
+	msgSummarizeFromFailed = "Failed to summarize the conversations. See the server logs for more information."
+	msgSummarizeFromHeader = "<b>Summary of %d prompt(s) since %s</b>\n\n%s"
+)
+
+// get logged prompts (and their answers) of given chat since given time, in chronological order
+//
+// (extractions of large documents are excluded, for they are not a part of the conversations)
+func promptsSince(db Storage, chatID int64, since time.Time) (prompts []Prompt, err error) {
+	var recent []Prompt
+	if recent, err = db.RecentPrompts(chatID, summarizeFromMaxPrompts); err != nil {
+		return nil, err
+	}
+
+	for _, prompt := range recent {
+		if !prompt.CreatedAt.Before(since) && prompt.Kind != promptKindExtraction {
+			prompts = append(prompts, prompt)
+		}
+	}
+
+	return prompts, nil
+}
+
+// generate a transcript of given replied message and logged prompts & answers
+func summarizeFromTranscript(replyTo tg.Message, prompts []Prompt) string {
+	var sb strings.Builder
+
+	// (the replied message itself may not be logged, eg. a message to others)
+	text := replyTo.Text
+	if text == nil {
+		text = replyTo.Caption
+	}
+	if text != nil {
+		sb.WriteString(fmt.Sprintf("%s: %s\n\n", userName(replyTo.From), *text))
+	}
+
+	for _, prompt := range prompts {
+		question := prompt.Question
+		if question == "" {
+			question = prompt.Text
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s\n\n", prompt.Username, question))
+		if prompt.Result.Successful && prompt.Result.Text != "" {
+			sb.WriteString(fmt.Sprintf("assistant: %s\n\n", prompt.Result.Text))
+		}
+	}
+
+	return strings.TrimSpace(sb.String())
+}
+
+// return a /summarize-from command handler
+func summarizeFromCommandHandler(client *openai.Client, db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("summarize-from command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		if isRefusingAPIRequest(b, conf, update, *message) {
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		replyTo := repliedToMessage(*message)
+		if replyTo == nil {
+			send(b, conf, msgSummarizeFromUsage, chatID, &messageID)
+			return
+		}
+		since := time.Unix(int64(replyTo.Date), 0)
+
+		prompts, err := promptsSince(db, chatID, since)
+		if err != nil {
+			log.Printf("failed to get prompts for summary: %s", err)
+
+			send(b, conf, msgSummarizeFromFailed, chatID, &messageID)
+			return
+		}
+		if len(prompts) == 0 {
+			send(b, conf, msgSummarizeFromEmpty, chatID, &messageID)
+			return
+		}
+
+		model := chatModel(conf, db, chatID)
+
+		queueAnswer(b, conf, chatID, messageID, func() {
+			// (large transcripts are condensed in chunks first)
+			messages := condenseLargeMessages(b, client, conf, db, model, messageDirectives{}, []openai.ChatMessage{
+				openai.NewChatUserMessage(summarizeFromTranscript(*replyTo, prompts)),
+			}, chatID, message.From.ID, userNameFromUpdate(update), messageID)
+			messages = withResponseLanguage(conf, db, chatID, append([]openai.ChatMessage{
+				openai.NewChatSystemMessage(systemPromptSummarizeFrom),
+			}, messages...))
+
+			stopTyping := keepChatAction(b, chatID, responseText)
+			response, err := createChatCompletion(client, model, messageDirectives{}, messages, message.From.ID)
+			stopTyping()
+			if err != nil || len(response.Choices) <= 0 {
+				log.Printf("failed to summarize conversations: %v", err)
+
+				send(b, conf, msgSummarizeFromFailed, chatID, &messageID)
+				return
+			}
+
+			summary, err := response.Choices[0].Message.ContentString()
+			if err != nil {
+				log.Printf("failed to read summary: %s", err)
+
+				send(b, conf, msgSummarizeFromFailed, chatID, &messageID)
+				return
+			}
+
+			send(b, conf, fmt.Sprintf(msgSummarizeFromHeader,
+				len(prompts),
+				since.In(chatTimezone(db, chatID)).Format(time.DateTime),
+				html.EscapeString(ellipsize(strings.TrimSpace(summary), summarizeFromMaxRunes)),
+			), chatID, &messageID)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+// system.go
+//
+// per-chat system prompts
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdSystem = "/system"
+
+	systemArgReset = "reset"
+
+	chatSettingKeySystemPrompt = "system_prompt"
+
+	systemPromptMaxRunes = 2000
+
+	msgSystemUsage   = "Usage: /system [prompt|reset]"
+	msgSystemNone    = "No system prompt for this chat.\n\n" + msgSystemUsage
+	msgSystemCurrent = "System prompt of this chat:\n\n<pre>%s</pre>\n\n" + msgSystemUsage
+	msgSystemTooLong = "System prompt is too long (max: %d chars)."
+	msgSystemChanged = "System prompt of this chat is now:\n\n<pre>%s</pre>"
+	msgSystemReset   = "System prompt of this chat is cleared."
+)
+
+// get the system prompt of given chat (empty if not set)
+func chatSystemPrompt(db Storage, chatID int64) string {
+	if db == nil {
+		return ""
+	}
+
+	if value, err := db.GetChatSetting(chatID, chatSettingKeySystemPrompt); err == nil {
+		return value
+	}
+
+	return ""
+}
+
+// prepend the system prompt of given chat to given messages, if it has one
+func withChatSystemPrompt(db Storage, chatID int64, messages []openai.ChatMessage) []openai.ChatMessage {
+	if prompt := chatSystemPrompt(db, chatID); prompt != "" {
+		return withSystemInstruction(messages, prompt)
+	}
+
+	return messages
+}
+
+// return a /system command handler
+func systemCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("system command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		prompt := strings.TrimSpace(args)
+		if prompt == "" {
+			if current := chatSystemPrompt(db, chatID); current != "" {
+				send(b, conf, fmt.Sprintf(msgSystemCurrent, html.EscapeString(current)), chatID, &messageID)
+			} else {
+				send(b, conf, msgSystemNone, chatID, &messageID)
+			}
+			return
+		}
+
+		// only admins can change settings of group chats
+		if message.Chat.Type != tg.ChatTypePrivate && !isChatAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+
+		var value string
+		if prompt != systemArgReset {
+			if len([]rune(prompt)) > systemPromptMaxRunes {
+				send(b, conf, fmt.Sprintf(msgSystemTooLong, systemPromptMaxRunes), chatID, &messageID)
+				return
+			}
+
+			value = prompt
+		}
+
+		var msg string
+		if err := db.SetChatSetting(chatID, chatSettingKeySystemPrompt, value); err != nil {
+			log.Printf("failed to change system prompt: %s", err)
+
+			msg = err.Error()
+		} else if value == "" {
+			msg = msgSystemReset
+		} else {
+			msg = fmt.Sprintf(msgSystemChanged, html.EscapeString(value))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+// timezone.go
+//
+// timezones of the bot and users, for schedules and dates instead of the server's local time
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdTimezone = "/timezone"
+
+	timezoneArgReset = "reset"
+
+	settingKeyPrefixTimezone = "timezone/" // + user id
+
+	msgTimezoneUsage   = "Usage: /timezone [timezone|reset] (eg. <code>Asia/Seoul</code>)\n\n(currently: <b>%s</b>)"
+	msgTimezoneInvalid = "Invalid timezone: %s"
+	msgTimezoneChanged = "Your timezone is now: <b>%s</b> (current time: %s)"
+	msgTimezoneReset   = "Your timezone is reset to: <b>%s</b> (current time: %s)"
+)
+
+// get the timezone of the bot from `timezone` of config (local timezone of the server if not set or invalid)
+func botTimezone(conf config) *time.Location {
+	if conf.Timezone == "" {
+		return time.Local
+	}
+
+	location, err := time.LoadLocation(conf.Timezone)
+	if err != nil {
+		log.Printf("invalid timezone in config '%s': %s", conf.Timezone, err)
+		return time.Local
+	}
+
+	return location
+}
+
+// get the timezone set by given user (nil if not set)
+func savedUserTimezone(db Storage, userID int64) *time.Location {
+	if db == nil {
+		return nil
+	}
+
+	if value, err := db.GetSetting(settingKeyPrefixTimezone + strconv.FormatInt(userID, 10)); err == nil && value != "" {
+		if location, err := time.LoadLocation(value); err == nil {
+			return location
+		}
+	}
+
+	return nil
+}
+
+// get the timezone of given user (the bot's timezone if not set)
+func userTimezone(conf config, db Storage, userID int64) *time.Location {
+	if location := savedUserTimezone(db, userID); location != nil {
+		return location
+	}
+
+	return botTimezone(conf)
+}
+
+// return a /timezone command handler
+func timezoneCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("timezone command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil || message.From == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+		userID := message.From.ID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		args = strings.TrimSpace(args)
+		if args == "" {
+			send(b, conf, fmt.Sprintf(msgTimezoneUsage, html.EscapeString(userTimezone(conf, db, userID).String())), chatID, &messageID)
+			return
+		}
+
+		// value to save (empty for resetting it)
+		var value string
+		if args != timezoneArgReset {
+			location, err := time.LoadLocation(args)
+			if err != nil {
+				send(b, conf, fmt.Sprintf(msgTimezoneInvalid, html.EscapeString(args)), chatID, &messageID)
+				return
+			}
+			value = location.String()
+		}
+
+		var msg string
+		if err := db.SetSetting(settingKeyPrefixTimezone+strconv.FormatInt(userID, 10), value); err != nil {
+			log.Printf("failed to change timezone: %s", err)
+
+			msg = err.Error()
+		} else {
+			location := userTimezone(conf, db, userID)
+			now := time.Now().In(location).Format(time.DateTime)
+
+			if value == "" {
+				msg = fmt.Sprintf(msgTimezoneReset, html.EscapeString(location.String()), now)
+			} else {
+				msg = fmt.Sprintf(msgTimezoneChanged, html.EscapeString(location.String()), now)
+			}
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+// titles.go
+//
+// generating short titles of conversations
+
+import (
+	"log"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+)
+
+const (
+	titleModelDefault = "gpt-4o-mini"
+
+	titleMaxRunes         = 60
+	titleQuestionMaxRunes = 2000
+
+	systemPromptTitle = "Generate a short title (no more than 6 words) for a conversation which begins with the following message. Answer with the title only, without quotes or punctuation at the end, in the language of the message."
+)
+
+// generate a short title of a conversation from its first question, and save it
+//
+// (does nothing if `conversation_titles` is not enabled)
+func generateConversationTitle(client *openai.Client, conf config, db Storage, conversationID uint, question string) {
+	if !conf.ConversationTitles || db == nil || strings.TrimSpace(question) == "" {
+		return
+	}
+
+	model := titleModelDefault
+	if conf.TitleModel != "" {
+		model = resolveModelAlias(conf, conf.TitleModel)
+	}
+
+	response, err := archivedChatCompletion(client, model, []openai.ChatMessage{
+		openai.NewChatSystemMessage(systemPromptTitle),
+		openai.NewChatUserMessage(ellipsize(question, titleQuestionMaxRunes)),
+	}, openai.ChatCompletionOptions{})
+	if err != nil {
+		log.Printf("failed to generate a title of conversation %d: %s", conversationID, err)
+		return
+	}
+	if len(response.Choices) <= 0 {
+		return
+	}
+
+	title, err := response.Choices[0].Message.ContentString()
+	if err != nil {
+		log.Printf("failed to read the title of conversation %d: %s", conversationID, err)
+		return
+	}
+	title = ellipsize(strings.Trim(strings.TrimSpace(title), `"'`), titleMaxRunes)
+	if title == "" {
+		return
+	}
+
+	if err := db.SetConversationTitle(conversationID, title); err != nil {
+		log.Printf("failed to save the title of conversation %d: %s", conversationID, err)
+		return
+	}
+
+	logInfo("generated title of conversation %d: %s", conversationID, title)
+}
+
+// get the title of a conversation (empty if there is none)
+func conversationTitle(db Storage, conversationID *uint) string {
+	if db == nil || conversationID == nil {
+		return ""
+	}
+
+	if conversation, err := db.ConversationByID(*conversationID); err == nil {
+		return conversation.Title
+	}
+
+	return ""
+}
@@ -0,0 +1,76 @@
+package main
+
+// tls.go
+//
+// custom TLS configurations for http clients (eg. behind TLS-intercepting proxies)
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// custom TLS configuration, nil if not configured
+var _tlsConfig *tls.Config = nil
+
+// set up the custom TLS configuration from config
+//
+// (should be called before creating any http client)
+//
+// NOTE: it is not applied to the api clients of telegram-bot-go and openai-go,
+// for they do not expose their http clients (nor any option for setting them).
+func setupTLSConfig(conf config) error {
+	if conf.CABundleFilepath == "" && !conf.TLSInsecureSkipVerify {
+		return nil
+	}
+
+	log.Printf("custom TLS configuration is not applied to the api clients of telegram-bot-go and openai-go (use `SSL_CERT_FILE` or `SSL_CERT_DIR` for them)")
+
+	cfg := &tls.Config{}
+
+	if conf.CABundleFilepath != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		var pem []byte
+		if pem, err = os.ReadFile(conf.CABundleFilepath); err != nil {
+			return fmt.Errorf("failed to read ca bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate was appended from ca bundle: %s", conf.CABundleFilepath)
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if conf.TLSInsecureSkipVerify {
+		log.Printf("!!! WARNING !!! TLS certificate verification is DISABLED (`tls_insecure_skip_verify`): all HTTPS connections are vulnerable to man-in-the-middle attacks !!!")
+
+		cfg.InsecureSkipVerify = true
+	}
+
+	_tlsConfig = cfg
+
+	return nil
+}
+
+// create a new http client with given timeout, applying the custom TLS configuration
+func newHTTPClient(timeout time.Duration) *http.Client {
+	client := &http.Client{
+		Timeout: timeout,
+	}
+
+	if _tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = _tlsConfig.Clone()
+		client.Transport = transport
+	}
+
+	return client
+}
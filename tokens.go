@@ -0,0 +1,134 @@
+package main
+
+// tokens.go
+//
+// counting tokens with encodings of models
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/meinside/geektoken"
+	openai "github.com/meinside/openai-go"
+)
+
+const (
+	encodingO200kBase geektoken.Encoding = "o200k_base" // NOTE: falls back to cl100k_base if not supported by geektoken
+
+	// https://cookbook.openai.com/examples/how_to_count_tokens_with_tiktoken
+	tokensPerMessage = 3 // <|start|>{role/name}\n{content}<|end|>\n
+	tokensPerReply   = 3 // every reply is primed with <|start|>assistant<|message|>
+
+	tokensPerImage = 85 // base tokens of an image (low detail), so counts with images are approximate
+)
+
+// encodings of models, by model name prefixes (longer prefixes first)
+var modelEncodings = []struct {
+	prefix   string
+	encoding geektoken.Encoding
+}{
+	{"gpt-4o", encodingO200kBase},
+	{"chatgpt-4o", encodingO200kBase},
+	{"o1", encodingO200kBase},
+	{"o3", encodingO200kBase},
+	{"gpt-4", geektoken.EncodingCl100kBase},
+	{"gpt-3.5", geektoken.EncodingCl100kBase},
+	{"text-embedding-", geektoken.EncodingCl100kBase},
+	{"text-davinci-", geektoken.EncodingP50kBase},
+	{"code-", geektoken.EncodingP50kBase},
+	{"davinci", geektoken.EncodingR50kBase},
+	{"curie", geektoken.EncodingR50kBase},
+	{"babbage", geektoken.EncodingR50kBase},
+	{"ada", geektoken.EncodingR50kBase},
+}
+
+// lazy-loaded tokenizers, keyed by encodings
+var _tokenizers = map[geektoken.Encoding]*geektoken.Tokenizer{}
+var _tokenizersLock sync.Mutex
+
+// get the encoding of given model (cl100k_base for unknown ones)
+func encodingForModel(model string) geektoken.Encoding {
+	for _, e := range modelEncodings {
+		if strings.HasPrefix(model, e.prefix) {
+			return e.encoding
+		}
+	}
+
+	return geektoken.EncodingCl100kBase
+}
+
+// get a tokenizer for given encoding, falling back to cl100k_base
+func tokenizerFor(encoding geektoken.Encoding) (*geektoken.Tokenizer, error) {
+	_tokenizersLock.Lock()
+	defer _tokenizersLock.Unlock()
+
+	if tokenizer, exists := _tokenizers[encoding]; exists {
+		return tokenizer, nil
+	}
+
+	tokenizer, err := geektoken.GetTokenizerWithEncoding(encoding)
+	if err != nil && encoding != geektoken.EncodingCl100kBase {
+		log.Printf("failed to get tokenizer for %s, falling back to %s: %s", encoding, geektoken.EncodingCl100kBase, err)
+
+		tokenizer, err = geektoken.GetTokenizerWithEncoding(geektoken.EncodingCl100kBase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tokenizer is not initialized: %w", err)
+	}
+
+	_tokenizers[encoding] = &tokenizer
+	return &tokenizer, nil
+}
+
+// count BPE tokens for given `text` with `encoding`
+func countTokensWithEncoding(text string, encoding geektoken.Encoding) (result int, err error) {
+	var tokenizer *geektoken.Tokenizer
+	if tokenizer, err = tokenizerFor(encoding); err != nil {
+		return 0, err
+	}
+
+	var tokens []int
+	if tokens, err = tokenizer.Encode(text, nil, nil); err != nil {
+		return 0, err
+	}
+
+	return len(tokens), nil
+}
+
+// count tokens of a whole chat completion request (system, history, and attachments) for given model
+func countRequestTokens(model string, messages []openai.ChatMessage) (result int, err error) {
+	encoding := encodingForModel(model)
+
+	for _, message := range messages {
+		result += tokensPerMessage
+
+		var tokens int
+		if tokens, err = countTokensWithEncoding(string(message.Role), encoding); err != nil {
+			return 0, err
+		}
+		result += tokens
+
+		switch content := message.Content.(type) {
+		case string:
+			if tokens, err = countTokensWithEncoding(content, encoding); err != nil {
+				return 0, err
+			}
+			result += tokens
+		case []openai.ChatMessageContent:
+			for _, part := range content {
+				if part.Text != nil {
+					if tokens, err = countTokensWithEncoding(*part.Text, encoding); err != nil {
+						return 0, err
+					}
+					result += tokens
+				} else if part.ImageURL != nil {
+					result += tokensPerImage
+				}
+			}
+		}
+	}
+
+	return result + tokensPerReply, nil
+}
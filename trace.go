@@ -0,0 +1,236 @@
+package main
+
+// trace.go
+//
+// verbose traces of requests in chats being investigated, sent to the admin chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdTrace = "/trace"
+
+	traceArgOn  = "on"
+	traceArgOff = "off"
+
+	msgTraceUsage         = "Usage: /trace [chat_id] [on|off]\n\n(without chat_id, for this chat)\n\nTraced chats: %s"
+	msgTraceChanged       = "Tracing of chat <code>%d</code> is now: <b>%s</b>"
+	msgTraceNoAdminChat   = "<code>admin_chat_id</code> is not configured, so traces cannot be sent."
+	msgTraceNone          = "<i>(none)</i>"
+	msgTraceCaption       = "🔍 <b>chat</b>: %d, <b>message</b>: %d, <b>model</b>: %s%s\n\n%s"
+	msgTraceCaptionFailed = " <i>(failed)</i>"
+)
+
+// chats being traced
+var _tracedChats = map[int64]bool{}
+var _tracedChatsLock sync.RWMutex
+
+// requestTrace struct for a verbose trace of a request
+type requestTrace struct {
+	ChatID      int64                  `json:"chat_id"`
+	MessageID   int64                  `json:"message_id"`
+	Model       string                 `json:"model"`
+	Temperature *float64               `json:"temperature,omitempty"`
+	Request     []openai.ChatMessage   `json:"request"`
+	Response    *openai.ChatCompletion `json:"response,omitempty"` // (including tool calls, if any)
+	Error       string                 `json:"error,omitempty"`
+	Timings     []traceTiming          `json:"timings"`
+
+	started time.Time
+	last    time.Time
+}
+
+// traceTiming struct for the elapsed time of a step in a request
+type traceTiming struct {
+	Step         string `json:"step"`
+	Milliseconds int64  `json:"ms"`
+}
+
+// checks if given chat is being traced
+func isTraced(chatID int64) bool {
+	_tracedChatsLock.RLock()
+	defer _tracedChatsLock.RUnlock()
+
+	return _tracedChats[chatID]
+}
+
+// turn tracing of given chat on/off
+func setTraced(chatID int64, on bool) {
+	_tracedChatsLock.Lock()
+	defer _tracedChatsLock.Unlock()
+
+	if on {
+		_tracedChats[chatID] = true
+	} else {
+		delete(_tracedChats, chatID)
+	}
+}
+
+// list chats being traced
+func tracedChats() (chatIDs []int64) {
+	_tracedChatsLock.RLock()
+	defer _tracedChatsLock.RUnlock()
+
+	for chatID := range _tracedChats {
+		chatIDs = append(chatIDs, chatID)
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+
+	return chatIDs
+}
+
+// start a trace of a request in given chat
+//
+// (returns nil if the chat is not traced, or there is no admin chat)
+func startTrace(conf config, chatID, messageID int64, model string, directives messageDirectives, request []openai.ChatMessage) *requestTrace {
+	if conf.AdminChatID == 0 || !isTraced(chatID) {
+		return nil
+	}
+
+	now := time.Now()
+	return &requestTrace{
+		ChatID:      chatID,
+		MessageID:   messageID,
+		Model:       model,
+		Temperature: directives.Temperature,
+		Request:     request,
+		Timings:     []traceTiming{},
+		started:     now,
+		last:        now,
+	}
+}
+
+// record the elapsed time of a step, since the previous one
+func (t *requestTrace) mark(step string) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+	t.Timings = append(t.Timings, traceTiming{Step: step, Milliseconds: now.Sub(t.last).Milliseconds()})
+	t.last = now
+}
+
+// record the response (or error) of the chat completion
+func (t *requestTrace) completed(response openai.ChatCompletion, err error) {
+	if t == nil {
+		return
+	}
+
+	t.mark("chat completion")
+	if err == nil {
+		t.Response = &response
+	}
+}
+
+// finish the trace with the result of given prompt, and send it to the admin chat
+// as a json file, with the timing breakdown as its caption
+func (t *requestTrace) finish(bot *tg.Bot, conf config, prompt Prompt) {
+	if t == nil {
+		return
+	}
+
+	if !prompt.Result.Successful {
+		t.Error = prompt.Result.Text
+	}
+	t.Timings = append(t.Timings, traceTiming{Step: "total", Milliseconds: time.Since(t.started).Milliseconds()})
+
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		log.Printf("failed to marshal trace: %s", err)
+		return
+	}
+
+	timings := []string{}
+	for _, timing := range t.Timings {
+		timings = append(timings, fmt.Sprintf("* %s: <b>%d</b>ms", html.EscapeString(timing.Step), timing.Milliseconds))
+	}
+	var failed string
+	if t.Error != "" {
+		failed = msgTraceCaptionFailed
+	}
+
+	sendChatAction(bot, conf.AdminChatID, responseDocument)
+
+	if res := bot.SendDocument(
+		conf.AdminChatID,
+		tg.InputFileFromBytes(data),
+		tg.OptionsSendDocument{}.
+			SetCaption(fmt.Sprintf(msgTraceCaption, t.ChatID, t.MessageID, html.EscapeString(t.Model), failed, strings.Join(timings, "\n"))).
+			SetParseMode(tg.ParseModeHTML)); !res.Ok {
+		log.Printf("failed to send trace: %s", *res.Description)
+	}
+}
+
+// return a /trace command handler
+func traceCommandHandler() func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("trace command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if !isAdmin(update, conf) {
+			send(b, conf, localize(conf, *message, msgNotAdmin), chatID, &messageID)
+			return
+		}
+		if conf.AdminChatID == 0 {
+			send(b, conf, msgTraceNoAdminChat, chatID, &messageID)
+			return
+		}
+
+		// `[chat_id] on|off`
+		target := chatID
+		fields := strings.Fields(args)
+		if len(fields) == 2 {
+			if id, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				target = id
+				fields = fields[1:]
+			}
+		}
+
+		var msg string
+		if len(fields) == 1 && (fields[0] == traceArgOn || fields[0] == traceArgOff) {
+			setTraced(target, fields[0] == traceArgOn)
+
+			logInfo("tracing of chat %d changed by %s: %s", target, userNameFromUpdate(update), fields[0])
+
+			msg = fmt.Sprintf(msgTraceChanged, target, fields[0])
+		} else {
+			traced := []string{}
+			for _, id := range tracedChats() {
+				traced = append(traced, fmt.Sprintf("<code>%d</code>", id))
+			}
+			if len(traced) == 0 {
+				traced = append(traced, msgTraceNone)
+			}
+
+			msg = fmt.Sprintf(msgTraceUsage, strings.Join(traced, ", "))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
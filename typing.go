@@ -0,0 +1,85 @@
+package main
+
+// typing.go
+//
+// chat actions (eg. "typing...") matching the kinds of planned replies
+
+import (
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// telegram clears a chat action after 5 seconds (or when a message is sent)
+const chatActionKeepAliveInterval = 4500 * time.Millisecond
+
+// responseKind type for the kinds of planned replies
+type responseKind int
+
+// responseKind constants
+const (
+	responseText     responseKind = iota // text message
+	responseDocument                     // file (eg. an answer too long for a text message)
+	responsePhoto                        // image
+	responseVoice                        // voice message
+)
+
+// get the chat action for given kind of reply
+func (k responseKind) chatAction() tg.ChatAction {
+	switch k {
+	case responseDocument:
+		return tg.ChatActionUploadDocument
+	case responsePhoto:
+		return tg.ChatActionUploadPhoto
+	case responseVoice:
+		return tg.ChatActionRecordVoice
+	default:
+		return tg.ChatActionTyping
+	}
+}
+
+// get the kind of reply for given answer
+//
+// (answers too long for a text message are sent as text files)
+func responseKindOf(answer string) responseKind {
+	if len(answer) > 4096 {
+		return responseDocument
+	}
+
+	return responseText
+}
+
+// send the chat action for given kind of reply
+func sendChatAction(bot *tg.Bot, chatID int64, kind responseKind) {
+	_ = bot.SendChatAction(chatID, kind.chatAction(), nil)
+}
+
+// send the chat action for given kind of reply now, and repeatedly until the returned function is called
+//
+// (the returned function is safe to call more than once)
+func keepChatAction(bot *tg.Bot, chatID int64, kind responseKind) (stop func()) {
+	sendChatAction(bot, chatID, kind)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(chatActionKeepAliveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				sendChatAction(bot, chatID, kind)
+			}
+		}
+	}()
+
+	stopped := false
+	return func() {
+		if !stopped {
+			stopped = true
+			close(done)
+		}
+	}
+}
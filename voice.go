@@ -0,0 +1,137 @@
+package main
+
+// voice.go
+//
+// voice mode: transcribing incoming voices, and answering with voices
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	cmdVoice = "/voice"
+
+	voiceArgOn  = "on"
+	voiceArgOff = "off"
+
+	settingKeyPrefixVoiceMode = "voice_mode/" // + user id
+
+	transcriptionModel = "whisper-1"
+	speechModel        = "tts-1"
+	speechVoiceDefault = openai.SpeechVoiceAlloy
+
+	maxVoiceBytes  = 20 * 1024 * 1024 // 20MB (telegram bot api's limit for downloading files)
+	speechMaxInput = 4096             // max length of texts for speech
+
+	msgVoiceUsage          = "Usage: /voice [on|off] (currently: <b>%s</b>)"
+	msgVoiceChanged        = "Voice mode is turned <b>%s</b>."
+	msgTranscriptionFailed = "Failed to transcribe your voice. See the server logs for more information."
+)
+
+// checks if voice mode is on for given user
+func isVoiceModeOn(db Storage, userID int64) bool {
+	if db == nil {
+		return false
+	}
+
+	value, err := db.GetSetting(settingKeyPrefixVoiceMode + strconv.FormatInt(userID, 10))
+	return err == nil && value == voiceArgOn
+}
+
+// turn voice mode on/off for given user
+func setVoiceMode(db Storage, userID int64, on bool) error {
+	return db.SetSetting(settingKeyPrefixVoiceMode+strconv.FormatInt(userID, 10), onOff(on))
+}
+
+// transcribe given voice into a text
+func transcribeVoice(bot *tg.Bot, client *openai.Client, voice tg.Voice, options openai.TranscriptionOptions) (text string, err error) {
+	res := bot.GetFile(voice.FileID)
+	if !res.Ok {
+		return "", fmt.Errorf("failed to get voice: %s", *res.Description)
+	}
+
+	var bytes []byte
+	if bytes, err = readBinaryContentAtURL(bot.GetFileURL(*res.Result), maxVoiceBytes); err != nil {
+		return "", err
+	}
+
+	var transcription openai.Transcription
+	if transcription, err = client.CreateTranscription(openai.NewFileParamFromBytes(bytes), transcriptionModel, options); err != nil {
+		return "", err
+	}
+	if transcription.Text == nil {
+		return "", fmt.Errorf("no text in transcription")
+	}
+
+	return *transcription.Text, nil
+}
+
+// send given answer as a voice, replying to `messageID`
+func sendVoiceAnswer(bot *tg.Bot, client *openai.Client, conf config, chatID, messageID int64, answer string) {
+	voice := conf.SpeechVoice
+	if voice == "" {
+		voice = speechVoiceDefault
+	}
+
+	sendChatAction(bot, chatID, responseVoice)
+
+	audio, err := client.CreateSpeech(speechModel, ellipsize(answer, speechMaxInput-1), voice, openai.SpeechOptions{}.
+		SetResponseFormat(openai.SpeechResponseFormatOpus))
+	if err != nil {
+		log.Printf("failed to create speech: %s", err)
+		return
+	}
+
+	if res := bot.SendVoice(chatID, tg.InputFileFromBytes(audio), tg.OptionsSendVoice{}.
+		SetReplyParameters(tg.ReplyParameters{MessageID: messageID})); !res.Ok {
+		log.Printf("failed to send voice: %s", *res.Description)
+	}
+}
+
+// return a /voice command handler
+func voiceCommandHandler(db Storage) func(b *tg.Bot, update tg.Update, args string) {
+	return func(b *tg.Bot, update tg.Update, args string) {
+		conf := currentConfig()
+
+		if !isAllowed(update, conf) {
+			log.Printf("voice command not allowed: %s", userNameFromUpdate(update))
+			return
+		}
+
+		message := usableMessageFromUpdate(update)
+		if message == nil || message.From == nil {
+			log.Printf("no usable message from update.")
+			return
+		}
+
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+		userID := message.From.ID
+
+		if db == nil {
+			send(b, conf, localize(conf, *message, msgDatabaseNotConfigured), chatID, &messageID)
+			return
+		}
+
+		var msg string
+		switch args {
+		case voiceArgOn, voiceArgOff:
+			if err := setVoiceMode(db, userID, args == voiceArgOn); err != nil {
+				log.Printf("failed to change voice mode: %s", err)
+
+				msg = err.Error()
+			} else {
+				msg = fmt.Sprintf(msgVoiceChanged, args)
+			}
+		default:
+			msg = fmt.Sprintf(msgVoiceUsage, onOff(isVoiceModeOn(db, userID)))
+		}
+
+		send(b, conf, msg, chatID, &messageID)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+// watchdog.go
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	watchdogMaxPollErrorsDefault   = 30
+	watchdogIntervalMinutesDefault = 5
+)
+
+// pollingWatchdog struct for monitoring the polling loop
+type pollingWatchdog struct {
+	stopPolling func()
+
+	errors  atomic.Int32
+	restart atomic.Bool
+
+	quit chan struct{}
+}
+
+// start a watchdog which stops polling (with `stopPolling`) when it gets stuck:
+//
+//   - too many poll errors occur in an interval, or
+//   - pending updates are not consumed for two consecutive intervals while telegram is reachable.
+func startPollingWatchdog(token string, conf config, stopPolling func()) *pollingWatchdog {
+	maxErrors := conf.WatchdogMaxPollErrors
+	if maxErrors <= 0 {
+		maxErrors = watchdogMaxPollErrorsDefault
+	}
+	interval := conf.WatchdogIntervalMinutes
+	if interval <= 0 {
+		interval = watchdogIntervalMinutesDefault
+	}
+
+	w := &pollingWatchdog{
+		stopPolling: stopPolling,
+		quit:        make(chan struct{}),
+	}
+
+	// a separate client for probing
+	probe := tg.NewClient(token)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+		defer ticker.Stop()
+
+		stuck := 0
+		for {
+			select {
+			case <-w.quit:
+				return
+			case <-ticker.C:
+				if errors := w.errors.Swap(0); int(errors) >= maxErrors {
+					log.Printf("watchdog: %d poll errors in %d minute(s), restarting polling...", errors, interval)
+
+					w.requestRestart()
+					return
+				}
+
+				if info := probe.GetWebhookInfo(); info.Ok && info.Result.PendingUpdateCount > 0 {
+					stuck++
+				} else {
+					stuck = 0
+				}
+				if stuck >= 2 {
+					log.Printf("watchdog: pending updates are not consumed for %d minute(s), restarting polling...", stuck*interval)
+
+					w.requestRestart()
+					return
+				}
+			}
+		}
+	}()
+
+	return w
+}
+
+// count a poll error
+func (w *pollingWatchdog) pollFailed() {
+	w.errors.Add(1)
+}
+
+// mark restart requested, and stop the polling loop
+func (w *pollingWatchdog) requestRestart() {
+	w.restart.Store(true)
+
+	w.stopPolling()
+}
+
+// checks if restart was requested
+func (w *pollingWatchdog) restartRequested() bool {
+	return w.restart.Load()
+}
+
+// stop the watchdog
+func (w *pollingWatchdog) stop() {
+	select {
+	case <-w.quit:
+	default:
+		close(w.quit)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+// webhook.go
+//
+// receiving updates with a webhook, as an alternative to long polling
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	webhookListenPortDefault = 8443
+	webhookRequestTimeout    = 30 * time.Second
+	webhookMaxBodyBytes      = 10 * 1024 * 1024 // 10MB
+	webhookSecretTokenBytes  = 32
+
+	telegramAPIBaseURL = "https://api.telegram.org"
+
+	headerTelegramSecretToken = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// webhookConfig struct for receiving updates with a webhook
+type webhookConfig struct {
+	URL          string `json:"url"`                     // public url of the webhook (its path is also served locally), eg. "https://bot.example.com/telegram"
+	ListenPort   int    `json:"listen_port,omitempty"`   // port of the local server (default: 8443)
+	CertFilepath string `json:"cert_filepath,omitempty"` // TLS certificate served by the local server
+	KeyFilepath  string `json:"key_filepath,omitempty"`  // TLS private key of the certificate
+	SelfSigned   bool   `json:"self_signed,omitempty"`   // upload the certificate to telegram, as it is self-signed
+	BehindProxy  bool   `json:"behind_proxy,omitempty"`  // serve plain HTTP, as TLS is terminated by a reverse proxy
+	SecretToken  string `json:"secret_token,omitempty"`  // for verifying requests from telegram (default: randomly generated on each launch)
+}
+
+// register the webhook to telegram, and serve updates from it until the server fails
+func serveWebhook(bot *tg.Bot, dispatcher *updateDispatcher, token string, conf config) error {
+	webhook := *conf.Webhook
+
+	public, err := url.Parse(webhook.URL)
+	if err != nil || public.Scheme != "https" || public.Host == "" {
+		return fmt.Errorf("`url` of webhook should be an absolute https url: %s", webhook.URL)
+	}
+	if !webhook.BehindProxy && (webhook.CertFilepath == "" || webhook.KeyFilepath == "") {
+		return fmt.Errorf("`cert_filepath` and `key_filepath` of webhook are needed, unless `behind_proxy` is set")
+	}
+
+	secretToken := webhook.SecretToken
+	if secretToken == "" {
+		random := make([]byte, webhookSecretTokenBytes)
+		if _, err := rand.Read(random); err != nil {
+			return fmt.Errorf("failed to generate secret token: %w", err)
+		}
+		secretToken = hex.EncodeToString(random)
+	}
+
+	path := public.Path
+	if path == "" {
+		path = "/"
+	}
+	port := webhook.ListenPort
+	if port <= 0 {
+		port = webhookListenPortDefault
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(headerTelegramSecretToken)), []byte(secretToken)) != 1 {
+			log.Printf("rejected webhook request with a wrong secret token from: %s", r.RemoteAddr)
+
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		var update tg.Update
+		if err := json.NewDecoder(io.LimitReader(r.Body, webhookMaxBodyBytes)).Decode(&update); err != nil {
+			log.Printf("failed to decode update from webhook: %s", err)
+
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		// (handled asynchronously, so telegram does not wait for answers)
+		dispatcher.dispatch(bot, update)
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: webhookRequestTimeout,
+		ReadTimeout:       webhookRequestTimeout,
+		WriteTimeout:      webhookRequestTimeout,
+	}
+
+	// start serving before registering the webhook, so that no update is missed
+	errs := make(chan error, 1)
+	go func() {
+		if webhook.BehindProxy {
+			errs <- server.ListenAndServe()
+		} else {
+			errs <- server.ListenAndServeTLS(webhook.CertFilepath, webhook.KeyFilepath)
+		}
+	}()
+
+	if err := setWebhook(token, conf, webhook.URL, secretToken); err != nil {
+		_ = server.Close()
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+	log.Printf("receiving updates with webhook: %s (listening on port %d)", webhook.URL, port)
+
+	return <-errs
+}
+
+// register given url as the webhook of the bot
+//
+// NOTE: `SetWebhook` of telegram-bot-go builds urls from hosts and ports only, and does not send secret tokens,
+// so the bot api is requested here directly.
+func setWebhook(token string, conf config, webhookURL, secretToken string) (err error) {
+	allowedUpdates := conf.AllowedUpdates
+	if len(allowedUpdates) <= 0 {
+		allowedUpdates = allowedUpdatesDefault
+	}
+
+	var allowed []byte
+	if allowed, err = json.Marshal(allowedUpdates); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("url", webhookURL)
+	_ = writer.WriteField("secret_token", secretToken)
+	_ = writer.WriteField("allowed_updates", string(allowed))
+	if conf.Webhook.SelfSigned && conf.Webhook.CertFilepath != "" {
+		var cert []byte
+		if cert, err = os.ReadFile(conf.Webhook.CertFilepath); err != nil {
+			return fmt.Errorf("failed to read certificate: %w", err)
+		}
+
+		var part io.Writer
+		if part, err = writer.CreateFormFile("certificate", filepath.Base(conf.Webhook.CertFilepath)); err != nil {
+			return err
+		}
+		if _, err = part.Write(cert); err != nil {
+			return err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	var resp *http.Response
+	if resp, err = newHTTPClient(webhookRequestTimeout).Post(fmt.Sprintf("%s/bot%s/setWebhook", telegramAPIBaseURL, token), writer.FormDataContentType(), &body); err != nil {
+		// (not to leak the bot token in logs)
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description,omitempty"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("telegram bot api error (http %d): %w", resp.StatusCode, err)
+	}
+	if !res.OK {
+		return fmt.Errorf("telegram bot api error: %s", res.Description)
+	}
+
+	return nil
+}
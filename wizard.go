@@ -0,0 +1,264 @@
+package main
+
+// wizard.go
+//
+// settings wizard on /start: language, persona, voice replies, and model, with inline keyboards
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+
+	openai "github.com/meinside/openai-go"
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+const (
+	callbackDataPrefixWizard = "wizard:" // + step + ":" + value
+
+	wizardStepLanguage = "language"
+	wizardStepPersona  = "persona"
+	wizardStepVoice    = "voice"
+	wizardStepModel    = "model"
+
+	wizardValueSkip    = "skip"
+	wizardValueDefault = "default"
+
+	wizardModelsMax = 6 // max number of models (including aliases) shown in the wizard
+
+	settingKeyPrefixPersona = "persona/" // + user id
+
+	msgWizardLanguage   = "Which language should I answer in?"
+	msgWizardPersona    = "How should I answer?"
+	msgWizardVoice      = "Should I also answer with voices?"
+	msgWizardModel      = "Which model should I use?"
+	msgWizardDone       = "All set! Send me a message to begin.\n\n%s\n\n(change them anytime with /language, /voice, and /model, or with /start again)"
+	msgWizardNotStarter = "Only the user who started this can answer it."
+	msgWizardButtonSkip = "Skip"
+	msgWizardButtonAuto = "Auto"
+	msgWizardButtonOn   = "On"
+	msgWizardButtonOff  = "Off"
+)
+
+// persona struct for a preset of answering styles
+type persona struct {
+	Name        string
+	Instruction string // appended to the system prompt (empty for none)
+}
+
+// selectable personas in the wizard
+var wizardPersonas = []persona{
+	{Name: wizardValueDefault},
+	{Name: "concise", Instruction: "Answer as concisely as possible, without preambles or unnecessary explanations."},
+	{Name: "friendly", Instruction: "Answer in a warm and friendly tone, like a helpful friend."},
+	{Name: "teacher", Instruction: "Answer like a patient teacher: explain step by step with simple examples."},
+	{Name: "expert", Instruction: "Answer like a domain expert: be precise and technical, and mention caveats."},
+}
+
+// selectable languages of answers in the wizard
+var wizardLanguages = []string{"English", "Korean", "Japanese", "Spanish"}
+
+// get the persona of given user (nil if not set)
+func userPersona(db Storage, userID int64) *persona {
+	if db == nil {
+		return nil
+	}
+
+	if value, err := db.GetSetting(settingKeyPrefixPersona + strconv.FormatInt(userID, 10)); err == nil && value != "" {
+		for _, p := range wizardPersonas {
+			if p.Name == value && p.Instruction != "" {
+				return &p
+			}
+		}
+	}
+
+	return nil
+}
+
+// append the instruction of the persona of given user to the system prompt of given messages
+func withPersona(db Storage, userID int64, messages []openai.ChatMessage) []openai.ChatMessage {
+	if p := userPersona(db, userID); p != nil {
+		return withSystemInstruction(messages, p.Instruction)
+	}
+
+	return messages
+}
+
+// get the models selectable in the wizard: the default one, and selectable ones (same as /model)
+func wizardModels(conf config) (models []string) {
+	models = []string{wizardValueDefault}
+
+	selectable := selectableModels(conf)
+
+	return append(models, selectable[:min(len(selectable), wizardModelsMax-1)]...)
+}
+
+// generate callback data of given step and value
+func wizardCallbackData(step, value string) *string {
+	data := callbackDataPrefixWizard + step + ":" + value
+	return &data
+}
+
+// generate the question and the inline keyboard of given step
+func wizardStep(conf config, step string) (question string, keyboard [][]tg.InlineKeyboardButton) {
+	var buttons []tg.InlineKeyboardButton
+	switch step {
+	case wizardStepLanguage:
+		question = msgWizardLanguage
+		buttons = append(buttons, tg.InlineKeyboardButton{Text: msgWizardButtonAuto, CallbackData: wizardCallbackData(step, wizardValueDefault)})
+		for i, language := range wizardLanguages {
+			buttons = append(buttons, tg.InlineKeyboardButton{Text: language, CallbackData: wizardCallbackData(step, strconv.Itoa(i))})
+		}
+	case wizardStepPersona:
+		question = msgWizardPersona
+		for i, p := range wizardPersonas {
+			buttons = append(buttons, tg.InlineKeyboardButton{Text: p.Name, CallbackData: wizardCallbackData(step, strconv.Itoa(i))})
+		}
+	case wizardStepVoice:
+		question = msgWizardVoice
+		buttons = append(buttons,
+			tg.InlineKeyboardButton{Text: msgWizardButtonOn, CallbackData: wizardCallbackData(step, voiceArgOn)},
+			tg.InlineKeyboardButton{Text: msgWizardButtonOff, CallbackData: wizardCallbackData(step, voiceArgOff)},
+		)
+	case wizardStepModel:
+		question = msgWizardModel
+		for i, model := range wizardModels(conf) {
+			if model == wizardValueDefault {
+				model = chatCompletionModel(conf)
+			}
+			buttons = append(buttons, tg.InlineKeyboardButton{Text: model, CallbackData: wizardCallbackData(step, strconv.Itoa(i))})
+		}
+	}
+
+	// (3 buttons per row)
+	for i := 0; i < len(buttons); i += 3 {
+		keyboard = append(keyboard, buttons[i:min(i+3, len(buttons))])
+	}
+	keyboard = append(keyboard, []tg.InlineKeyboardButton{
+		{Text: msgWizardButtonSkip, CallbackData: wizardCallbackData(step, wizardValueSkip)},
+	})
+
+	return question, keyboard
+}
+
+// get the step after given one (empty when done)
+func nextWizardStep(step string) string {
+	switch step {
+	case wizardStepLanguage:
+		return wizardStepPersona
+	case wizardStepPersona:
+		return wizardStepVoice
+	case wizardStepVoice:
+		return wizardStepModel
+	}
+
+	return ""
+}
+
+// start the settings wizard in given private chat, with a greeting
+func startWizard(bot *tg.Bot, conf config, chatID int64, greeting string) {
+	question, keyboard := wizardStep(conf, wizardStepLanguage)
+
+	if res := bot.SendMessage(chatID, greeting+"\n\n"+question, tg.OptionsSendMessage{}.
+		SetReplyMarkup(tg.InlineKeyboardMarkup{InlineKeyboard: keyboard})); !res.Ok {
+		log.Printf("failed to start wizard: %s", *res.Description)
+	}
+}
+
+// save the answer of given wizard step for given user (in a private chat with the same id)
+func saveWizardAnswer(conf config, db Storage, userID int64, step, value string) (err error) {
+	if value == wizardValueSkip {
+		return nil
+	}
+
+	switch step {
+	case wizardStepLanguage:
+		language := ""
+		if i, err := strconv.Atoi(value); err == nil && i >= 0 && i < len(wizardLanguages) {
+			language = wizardLanguages[i]
+		}
+		return db.SetChatSetting(userID, chatSettingKeyResponseLanguage, language)
+	case wizardStepPersona:
+		name := ""
+		if i, err := strconv.Atoi(value); err == nil && i >= 0 && i < len(wizardPersonas) && wizardPersonas[i].Instruction != "" {
+			name = wizardPersonas[i].Name
+		}
+		return db.SetSetting(settingKeyPrefixPersona+strconv.FormatInt(userID, 10), name)
+	case wizardStepVoice:
+		return setVoiceMode(db, userID, value == voiceArgOn)
+	case wizardStepModel:
+		model := ""
+		models := wizardModels(conf)
+		if i, err := strconv.Atoi(value); err == nil && i >= 0 && i < len(models) && models[i] != wizardValueDefault {
+			model = models[i]
+		}
+		return db.SetChatSetting(userID, chatSettingKeyModel, model)
+	}
+
+	return fmt.Errorf("unknown wizard step: %s", step)
+}
+
+// describe the persona of given user
+func describePersona(db Storage, userID int64) string {
+	if p := userPersona(db, userID); p != nil {
+		return p.Name
+	}
+
+	return wizardValueDefault
+}
+
+// describe the settings chosen in the wizard for given user
+func describeWizardSettings(conf config, db Storage, userID int64) string {
+	return strings.Join([]string{
+		fmt.Sprintf("* Language: <b>%s</b>", describeResponseLanguage(conf, db, userID)),
+		fmt.Sprintf("* Persona: <b>%s</b>", html.EscapeString(describePersona(db, userID))),
+		fmt.Sprintf("* Voice mode: <b>%s</b>", onOff(isVoiceModeOn(db, userID))),
+		fmt.Sprintf("* Model: <b>%s</b>", html.EscapeString(chatModel(conf, db, userID))),
+	}, "\n")
+}
+
+// handle a callback query from the inline keyboard of the wizard
+func handleWizardCallback(bot *tg.Bot, db Storage, query tg.CallbackQuery, data string) {
+	conf := currentConfig()
+
+	step, value, _ := strings.Cut(data, ":")
+
+	// (the wizard runs only in private chats, so only the user of the chat can answer it)
+	if query.Message == nil || query.Message.Chat.ID != query.From.ID {
+		answerCallbackQuery(bot, query, msgWizardNotStarter)
+		return
+	}
+	if db == nil {
+		answerCallbackQuery(bot, query, msgDatabaseNotConfigured)
+		return
+	}
+
+	userID := query.From.ID
+	if err := saveWizardAnswer(conf, db, userID, step, value); err != nil {
+		log.Printf("failed to save wizard answer: %s", err)
+
+		answerCallbackQuery(bot, query, err.Error())
+		return
+	}
+	answerCallbackQuery(bot, query, "")
+
+	// show the next step, or the chosen settings when done
+	options := tg.OptionsEditMessageText{}.
+		SetIDs(query.Message.Chat.ID, query.Message.MessageID).
+		SetParseMode(tg.ParseModeHTML)
+
+	var text string
+	if next := nextWizardStep(step); next != "" {
+		var keyboard [][]tg.InlineKeyboardButton
+		text, keyboard = wizardStep(conf, next)
+		options = options.SetReplyMarkup(tg.InlineKeyboardMarkup{InlineKeyboard: keyboard})
+	} else {
+		text = fmt.Sprintf(msgWizardDone, describeWizardSettings(conf, db, userID))
+	}
+
+	if res := bot.EditMessageText(text, options); !res.Ok {
+		log.Printf("failed to edit wizard message: %s", *res.Description)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+// youtube.go
+//
+// fetching transcripts of YouTube videos linked in messages
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	youTubeWatchURLFormat = "https://www.youtube.com/watch?v=%s"
+
+	maxYouTubeVideosInMessage = 3
+	maxYouTubePageBytes       = 10 * 1024 * 1024 // 10MB
+	maxYouTubeTranscriptBytes = 5 * 1024 * 1024  // 5MB
+
+	youTubeCaptionKindASR = "asr" // auto-generated captions
+
+	promptSummarizeYouTube = "Summarize the following YouTube video."
+
+	msgYouTubeTranscript             = "[Transcript of YouTube video: %s]\n%s"
+	msgYouTubeTranscriptNotAvailable = "[Transcript of YouTube video: %s is not available]"
+)
+
+// regular expression for YouTube urls (submatch: video id)
+var youTubeURLRegex = regexp.MustCompile(`(?:https?://)?(?:www\.|m\.)?(?:youtube\.com/(?:watch\?(?:\S*?&)?v=|shorts/|embed/|live/)|youtu\.be/)([A-Za-z0-9_-]{11})`)
+
+// caption track in the player response of a YouTube video page
+type youTubeCaptionTrack struct {
+	BaseURL      string `json:"baseUrl"`
+	LanguageCode string `json:"languageCode"`
+	Kind         string `json:"kind,omitempty"`
+}
+
+// append transcripts of YouTube videos linked in given text
+//
+// (when the text has nothing but links, it will be a request for summarizing them)
+func withYouTubeTranscripts(text string) string {
+	matches := youTubeURLRegex.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+
+	if strings.TrimSpace(youTubeURLRegex.ReplaceAllString(text, "")) == "" {
+		text = promptSummarizeYouTube + "\n\n" + text
+	}
+
+	videoIDs := []string{}
+	for _, match := range matches {
+		if len(videoIDs) >= maxYouTubeVideosInMessage {
+			break
+		}
+		if videoID := match[1]; !slices.Contains(videoIDs, videoID) {
+			videoIDs = append(videoIDs, videoID)
+		}
+	}
+
+	for _, videoID := range videoIDs {
+		url := fmt.Sprintf(youTubeWatchURLFormat, videoID)
+
+		if transcript, err := fetchYouTubeTranscript(videoID); err == nil {
+			text += "\n\n" + fmt.Sprintf(msgYouTubeTranscript, url, transcript)
+		} else {
+			log.Printf("failed to fetch transcript of youtube video %s: %s", videoID, err)
+
+			text += "\n\n" + fmt.Sprintf(msgYouTubeTranscriptNotAvailable, url)
+		}
+	}
+
+	return text
+}
+
+// fetch the transcript of a YouTube video with given id
+//
+// (manually written captions are preferred over auto-generated ones)
+func fetchYouTubeTranscript(videoID string) (transcript string, err error) {
+	var page []byte
+	if page, err = readBinaryContentAtURL(fmt.Sprintf(youTubeWatchURLFormat, videoID), maxYouTubePageBytes); err != nil {
+		return "", err
+	}
+
+	var tracks []youTubeCaptionTrack
+	if tracks, err = youTubeCaptionTracks(string(page)); err != nil {
+		return "", err
+	}
+
+	track := tracks[0]
+	for _, t := range tracks {
+		if t.Kind != youTubeCaptionKindASR {
+			track = t
+			break
+		}
+	}
+
+	var captions []byte
+	if captions, err = readBinaryContentAtURL(track.BaseURL, maxYouTubeTranscriptBytes); err != nil {
+		return "", err
+	}
+
+	var timedText struct {
+		Texts []string `xml:"text"`
+	}
+	if err = xml.Unmarshal(captions, &timedText); err != nil {
+		return "", fmt.Errorf("failed to parse captions: %w", err)
+	}
+
+	lines := []string{}
+	for _, text := range timedText.Texts {
+		// NOTE: texts are escaped twice (eg. `&amp;#39;`)
+		if line := strings.TrimSpace(html.UnescapeString(text)); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("no captions in track: %s", track.LanguageCode)
+	}
+
+	return strings.Join(lines, " "), nil
+}
+
+// extract caption tracks from the html of a YouTube video page
+func youTubeCaptionTracks(page string) (tracks []youTubeCaptionTrack, err error) {
+	const key = `"captionTracks":`
+
+	index := strings.Index(page, key)
+	if index < 0 {
+		return nil, fmt.Errorf("no captions in video page")
+	}
+
+	// decode only the json array following the key
+	if err = json.NewDecoder(strings.NewReader(page[index+len(key):])).Decode(&tracks); err != nil {
+		return nil, fmt.Errorf("failed to parse caption tracks: %w", err)
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no caption tracks in video page")
+	}
+
+	return tracks, nil
+}